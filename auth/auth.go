@@ -0,0 +1,201 @@
+// Package auth authenticates API and UI requests against named service API
+// keys and checks the scopes they grant.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+)
+
+// Scopes granted to API keys. ScopeAdmin implies every other scope.
+const (
+	ScopeAdmin     = "admin"
+	ScopeLogsRead  = "logs:read"
+	scopeRunPrefix = "run:"
+)
+
+// RunScope returns the scope required to run a pipeline, e.g. "run:inbound".
+func RunScope(pipeline string) string {
+	return scopeRunPrefix + pipeline
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name   string
+	Scopes []string
+}
+
+// Has reports whether the principal was granted scope (directly or via admin).
+func (p *Principal) Has(scope string) bool {
+	if p == nil {
+		return false
+	}
+	return slices.Contains(p.Scopes, ScopeAdmin) || slices.Contains(p.Scopes, scope)
+}
+
+// anonymous is used for every request when authentication is disabled.
+var anonymous = &Principal{Name: "anonymous", Scopes: []string{ScopeAdmin}}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the authenticated caller, or nil.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Keyring holds the configured API keys. Keys are compared by SHA-256 digest
+// in constant time.
+type Keyring struct {
+	disabled bool
+	keys     []keyEntry
+}
+
+type keyEntry struct {
+	digest    [sha256.Size]byte
+	principal *Principal
+}
+
+// NewKeyring validates the configured keys. With disabled set, every request
+// is treated as an anonymous admin.
+func NewKeyring(keys []configs.APIKey, disabled bool) (*Keyring, error) {
+	k := &Keyring{disabled: disabled}
+	for _, key := range keys {
+		for _, scope := range key.Scopes {
+			if !validScope(scope) {
+				return nil, fmt.Errorf("key %q: unknown scope %q", key.Name, scope)
+			}
+		}
+		k.keys = append(k.keys, keyEntry{
+			digest:    sha256.Sum256([]byte(key.Key)),
+			principal: &Principal{Name: key.Name, Scopes: key.Scopes},
+		})
+	}
+	return k, nil
+}
+
+func validScope(scope string) bool {
+	if scope == ScopeAdmin || scope == ScopeLogsRead {
+		return true
+	}
+	return strings.HasPrefix(scope, scopeRunPrefix) && len(scope) > len(scopeRunPrefix)
+}
+
+// Disabled reports whether authentication is turned off.
+func (k *Keyring) Disabled() bool {
+	return k.disabled
+}
+
+// Lookup returns the principal for a secret key.
+func (k *Keyring) Lookup(secret string) (*Principal, bool) {
+	if secret == "" {
+		return nil, false
+	}
+	digest := sha256.Sum256([]byte(secret))
+	var found *Principal
+	for _, entry := range k.keys {
+		if subtle.ConstantTimeCompare(digest[:], entry.digest[:]) == 1 {
+			found = entry.principal
+		}
+	}
+	return found, found != nil
+}
+
+// Authenticate identifies the caller from "Authorization: Bearer <key>",
+// "X-API-Key: <key>" or HTTP basic auth with the key as password (the
+// username is ignored, so browsers can log in as any name). Browsers resend
+// basic credentials automatically, so basic-auth requests that change state
+// must come from this origin.
+func (k *Keyring) Authenticate(r *http.Request) (*Principal, bool) {
+	if k.disabled {
+		return anonymous, true
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return k.Lookup(token)
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		if !safeMethod(r.Method) && crossOrigin(r) {
+			return nil, false
+		}
+		return k.Lookup(password)
+	}
+	return k.Lookup(r.Header.Get("X-API-Key"))
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// crossOrigin reports whether a browser sent r from another site, using
+// Sec-Fetch-Site when present and falling back to Origin.
+func crossOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// Require authenticates API requests and checks scope (empty scope accepts
+// any valid key). Failures are JSON 401/403 responses.
+func (k *Keyring) Require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := k.Authenticate(r)
+		if !ok {
+			writeError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if scope != "" && !p.Has(scope) {
+			Forbidden(w, scope)
+			return
+		}
+		next(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	}
+}
+
+// RequireUI authenticates browser requests with HTTP basic auth, prompting
+// for credentials when they are missing or wrong.
+func (k *Keyring) RequireUI(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := k.Authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="HudSci Pipelines", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	}
+}
+
+// Forbidden writes a JSON 403 naming the missing scope.
+func Forbidden(w http.ResponseWriter, scope string) {
+	writeError(w, fmt.Sprintf("forbidden: requires scope %s", scope), http.StatusForbidden)
+}
+
+func writeError(w http.ResponseWriter, msg string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
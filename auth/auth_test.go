@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+)
+
+func testKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	k, err := NewKeyring([]configs.APIKey{
+		{Name: "ops", Key: "admin-key", Scopes: []string{ScopeAdmin}},
+		{Name: "ci", Key: "ci-key", Scopes: []string{RunScope("outbound"), ScopeLogsRead}},
+	}, false)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	return k
+}
+
+func TestNewKeyringRejectsUnknownScope(t *testing.T) {
+	_, err := NewKeyring([]configs.APIKey{{Name: "x", Key: "k", Scopes: []string{"run"}}}, false)
+	if err == nil {
+		t.Fatal("Expected error for unknown scope")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	k := testKeyring(t)
+
+	tests := []struct {
+		name   string
+		setup  func(r *http.Request)
+		want   string
+		wantOK bool
+	}{
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer ci-key") }, "ci", true},
+		{"x-api-key", func(r *http.Request) { r.Header.Set("X-API-Key", "admin-key") }, "ops", true},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("someone", "ci-key") }, "ci", true},
+		{"wrong key", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, "", false},
+		{"missing", func(r *http.Request) {}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.setup(r)
+			p, ok := k.Authenticate(r)
+			if ok != tt.wantOK {
+				t.Fatalf("Authenticate() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && p.Name != tt.want {
+				t.Errorf("Authenticate() principal = %s, want %s", p.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalHas(t *testing.T) {
+	ci := &Principal{Name: "ci", Scopes: []string{RunScope("outbound")}}
+	if !ci.Has("run:outbound") || ci.Has("run:inbound") || ci.Has(ScopeLogsRead) {
+		t.Errorf("unexpected scopes for %+v", ci)
+	}
+	admin := &Principal{Name: "ops", Scopes: []string{ScopeAdmin}}
+	if !admin.Has("run:inbound") || !admin.Has(ScopeLogsRead) {
+		t.Error("admin should imply every scope")
+	}
+	var none *Principal
+	if none.Has(ScopeAdmin) {
+		t.Error("nil principal should have no scopes")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	k := testKeyring(t)
+	var caller string
+	handler := k.Require(ScopeLogsRead, func(w http.ResponseWriter, r *http.Request) {
+		caller = FromContext(r.Context()).Name
+	})
+
+	tests := []struct {
+		key    string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"ci-key", http.StatusOK},
+		{"admin-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		caller = ""
+		r := httptest.NewRequest(http.MethodGet, "/logs", nil)
+		if tt.key != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("key %q: status = %d, want %d", tt.key, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && caller == "" {
+			t.Errorf("key %q: principal not set in context", tt.key)
+		}
+	}
+
+	locks := k.Require(ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest(http.MethodGet, "/locks", nil)
+	r.Header.Set("Authorization", "Bearer ci-key")
+	rec := httptest.NewRecorder()
+	locks(rec, r)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestRequireUIPromptsForBasicAuth(t *testing.T) {
+	k := testKeyring(t)
+	handler := k.RequireUI(func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/ui/", nil))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("status = %d, WWW-Authenticate = %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	r.SetBasicAuth("operator", "admin-key")
+	rec = httptest.NewRecorder()
+	handler(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestAuthenticateRejectsCrossSiteBasicAuthPost(t *testing.T) {
+	k := testKeyring(t)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		wantOK bool
+	}{
+		{"same origin", "Sec-Fetch-Site", "same-origin", true},
+		{"cross site", "Sec-Fetch-Site", "cross-site", false},
+		{"matching origin", "Origin", "http://example.com", true},
+		{"foreign origin", "Origin", "https://evil.test", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://example.com/run/inbound", nil)
+			r.SetBasicAuth("operator", "admin-key")
+			r.Header.Set(tt.header, tt.value)
+			if _, ok := k.Authenticate(r); ok != tt.wantOK {
+				t.Errorf("Authenticate() ok = %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDisabledKeyring(t *testing.T) {
+	k, _ := NewKeyring(nil, true)
+	p, ok := k.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+	if !ok || !p.Has(RunScope("inbound")) {
+		t.Errorf("Authenticate() = %+v, %v; want anonymous admin", p, ok)
+	}
+}
@@ -3,7 +3,9 @@ package configs
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/trackvision/tv-shared-go/env"
 	"github.com/trackvision/tv-shared-go/logger"
@@ -13,8 +15,12 @@ import (
 // Config holds all configuration for HudSci pipelines
 type Config struct {
 	// Server
-	Port   string
-	APIKey string // API key for authenticating requests
+	Port string
+	// APIKeys are the service credentials accepted by the API and UI
+	// (SERVICE_API_KEYS secret). They are separate from the Directus key.
+	APIKeys []APIKey
+	// AuthDisabled skips authentication entirely (local development only)
+	AuthDisabled bool
 
 	// Directus CMS
 	CMSBaseURL        string
@@ -54,6 +60,45 @@ type Config struct {
 	DispatchBatchSize  int
 	DispatchMaxRetries int
 	FailureThreshold   float64
+	RunHistoryLimit    int // runs kept in memory for GET /runs
+	StepConcurrency    int // steps of one run that may execute at once
+
+	// Inbound polling
+	InboundWatermarkOverlap int // seconds each poll re-searches before the watermark, for clock skew
+	InboundRetryMaxAttempts int // downloads of a failed inbound file before it is left in the retry queue
+
+	// Inbound sources
+	InboundSources         []string // sources the inbound pipeline polls, in order (INBOUND_SOURCES)
+	InboundDirectusFolder  string   // Directus folder ID partners upload XML files to
+	InboundDir             string   // local or mounted directory of XML files
+	InboundBucketEndpoint  string   // S3-compatible endpoint, e.g. https://storage.googleapis.com
+	InboundBucket          string
+	InboundBucketPrefix    string
+	InboundBucketRegion    string
+	InboundBucketAccessKey string
+	InboundBucketSecretKey string
+
+	// Schedules maps pipeline name to cron expression (PIPELINE_SCHEDULES)
+	Schedules map[string]string
+	// RetryPolicies maps "pipeline" or "pipeline.step" to a retry policy
+	// override such as "attempts:5,delay:10s" (STEP_RETRY_POLICIES)
+	RetryPolicies map[string]string
+	// Coordination selects where schedule claims and pipeline locks live:
+	// "db" (default, safe with multiple instances) or "local" (single instance only)
+	Coordination string
+	// RunStore selects where run history is recorded: "db" (default,
+	// pipeline_runs tables in TiDB) or "memory" (lost on restart)
+	RunStore string
+	// PipelineLockTTL is the lease duration in seconds; running pipelines
+	// renew it every third of the TTL
+	PipelineLockTTL int
+	// PipelineDefinitionsDir holds YAML pipeline definitions loaded at
+	// startup alongside the built-in pipelines; empty loads none
+	PipelineDefinitionsDir string
+
+	// Readiness
+	CertExpiryWarnDays int  // /ready warns when the TrustMed client cert expires sooner
+	StartupReadyCheck  bool // exit at startup if any /ready check fails
 
 	// Default GLNs for SBDH fallback
 	DefaultSenderGLN   string
@@ -62,6 +107,17 @@ type Config struct {
 	// GCP Configuration (for logs viewer)
 	GCPProjectID    string
 	CloudRunService string
+
+	// Tracing
+	TracingExporter    string // "none" (default), "otlp" or "stdout"
+	TracingServiceName string // service.name of exported spans
+}
+
+// APIKey is a named service credential with the scopes it grants.
+type APIKey struct {
+	Name   string
+	Key    string
+	Scopes []string
 }
 
 // Load loads configuration from environment variables
@@ -85,19 +141,38 @@ func Load() (*Config, error) {
 	// Database password (optional for local dev)
 	dbPassword, _ := env.GetSecret("DB_PASSWORD")
 
+	// Bucket secret (only needed for the bucket inbound source)
+	bucketSecretKey, _ := env.GetSecret("INBOUND_BUCKET_SECRET_KEY")
+
+	inboundSources, err := parseInboundSources(getEnv("INBOUND_SOURCES", "trustmed"))
+	if err != nil {
+		return nil, fmt.Errorf("INBOUND_SOURCES: %w", err)
+	}
+
 	// TrustMed config - environment determines demo vs prod via terraform
 	trustmedEndpoint := getEnv("TRUSTMED_ENDPOINT", "https://demo.partner.trust.med/v1/client/storage")
 	trustmedCertFile := getEnv("TRUSTMED_CERTFILE", "/TRUSTMED_CLIENT_CERT/value")
 	trustmedKeyFile := getEnv("TRUSTMED_KEYFILE", "/TRUSTMED_CLIENT_KEY/value")
 	trustmedCAFile := getEnv("TRUSTMED_CAFILE", "/TRUSTMED_CA_CERT/value")
 
-	// API key for auth - reuse DIRECTUS_CMS_API_KEY (already loaded and mounted in Cloud Run)
-	cmsAPIKey := apiKey
+	// Service API keys, e.g. "ops:s3cret:admin;ci:t0ken:run:outbound,logs:read"
+	var apiKeys []APIKey
+	if serviceKeys, err := env.GetSecret("SERVICE_API_KEYS"); err == nil {
+		apiKeys, err = parseAPIKeys(serviceKeys)
+		if err != nil {
+			return nil, fmt.Errorf("SERVICE_API_KEYS: %w", err)
+		}
+	}
+	authDisabled := getEnvBool("AUTH_DISABLED", false)
+	if len(apiKeys) == 0 && !authDisabled {
+		logger.Warn("SERVICE_API_KEYS not set, all authenticated endpoints will reject requests")
+	}
 
 	cfg := &Config{
 		// Server
-		Port:   getEnv("PORT", "8080"),
-		APIKey: cmsAPIKey,
+		Port:         getEnv("PORT", "8080"),
+		APIKeys:      apiKeys,
+		AuthDisabled: authDisabled,
 
 		// Directus
 		CMSBaseURL:        os.Getenv("CMS_BASE_URL"),
@@ -137,6 +212,39 @@ func Load() (*Config, error) {
 		DispatchBatchSize:  getEnvInt("DISPATCH_BATCH_SIZE", 10),
 		DispatchMaxRetries: getEnvInt("DISPATCH_MAX_RETRIES", 3),
 		FailureThreshold:   getEnvFloat("FAILURE_THRESHOLD", 0.5),
+		RunHistoryLimit:    getEnvInt("RUN_HISTORY_LIMIT", 100),
+		StepConcurrency:    getEnvInt("STEP_CONCURRENCY", 4),
+
+		// Inbound polling
+		InboundWatermarkOverlap: getEnvInt("INBOUND_WATERMARK_OVERLAP", 300),
+		InboundRetryMaxAttempts: getEnvInt("INBOUND_RETRY_MAX_ATTEMPTS", 5),
+
+		// Inbound sources, e.g. "trustmed,bucket"
+		InboundSources:         inboundSources,
+		InboundDirectusFolder:  os.Getenv("INBOUND_DIRECTUS_FOLDER"),
+		InboundDir:             os.Getenv("INBOUND_DIR"),
+		InboundBucketEndpoint:  getEnv("INBOUND_BUCKET_ENDPOINT", "https://storage.googleapis.com"),
+		InboundBucket:          os.Getenv("INBOUND_BUCKET"),
+		InboundBucketPrefix:    os.Getenv("INBOUND_BUCKET_PREFIX"),
+		InboundBucketRegion:    getEnv("INBOUND_BUCKET_REGION", "auto"),
+		InboundBucketAccessKey: os.Getenv("INBOUND_BUCKET_ACCESS_KEY"),
+		InboundBucketSecretKey: bucketSecretKey,
+
+		// Schedules, e.g. "inbound=*/15 * * * *;outbound=*/10 * * * *"
+		Schedules:       parseSpecs(os.Getenv("PIPELINE_SCHEDULES")),
+		Coordination:    getEnv("COORDINATION_BACKEND", "db"),
+		PipelineLockTTL: getEnvInt("PIPELINE_LOCK_TTL", 120),
+		RunStore:        getEnv("RUN_STORE", "db"),
+
+		// YAML pipeline definitions, e.g. "/etc/pipelines"
+		PipelineDefinitionsDir: os.Getenv("PIPELINE_DEFINITIONS_DIR"),
+
+		// Retry overrides, e.g. "outbound.dispatch_via_trustmed=attempts:5,delay:30s"
+		RetryPolicies: parseSpecs(os.Getenv("STEP_RETRY_POLICIES")),
+
+		// Readiness
+		CertExpiryWarnDays: getEnvInt("CERT_EXPIRY_WARN_DAYS", 30),
+		StartupReadyCheck:  getEnvBool("STARTUP_READY_CHECK", false),
 
 		// Default GLNs (fallback if not in events)
 		DefaultSenderGLN:   getEnv("DEFAULT_SENDER_GLN", "1234567.89012"), // 7+5 format (company prefix + location ref)
@@ -145,6 +253,10 @@ func Load() (*Config, error) {
 		// GCP Configuration
 		GCPProjectID:    os.Getenv("GCP_PROJECT_ID"),
 		CloudRunService: os.Getenv("CLOUD_RUN_SERVICE"),
+
+		// Tracing (OTLP endpoint and headers come from OTEL_EXPORTER_OTLP_*)
+		TracingExporter:    getEnv("TRACING_EXPORTER", "none"),
+		TracingServiceName: getEnv("OTEL_SERVICE_NAME", "tv-pipelines-hudsci"),
 	}
 
 	// Validate required fields
@@ -155,6 +267,99 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// Schedule returns the cron expression configured for a pipeline, else
+// fallback (its default), else "@manual".
+func (c *Config) Schedule(pipeline, fallback string) string {
+	if spec := c.Schedules[pipeline]; spec != "" {
+		return spec
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return "@manual"
+}
+
+// parseSpecs parses "name=spec;name=spec" into a map. Entries without a
+// name or spec are ignored.
+func parseSpecs(value string) map[string]string {
+	specs := make(map[string]string)
+	for _, entry := range strings.Split(value, ";") {
+		name, spec, ok := strings.Cut(entry, "=")
+		name, spec = strings.TrimSpace(name), strings.TrimSpace(spec)
+		if !ok || name == "" || spec == "" {
+			continue
+		}
+		specs[name] = spec
+	}
+	return specs
+}
+
+// InboundSourceNames are the sources INBOUND_SOURCES may list.
+var InboundSourceNames = []string{"trustmed", "directus", "directory", "bucket"}
+
+// parseInboundSources parses a comma-separated list of inbound source names.
+func parseInboundSources(value string) ([]string, error) {
+	var sources []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(InboundSourceNames, name) {
+			return nil, fmt.Errorf("unknown source %q (want %s)", name, strings.Join(InboundSourceNames, ", "))
+		}
+		if slices.Contains(sources, name) {
+			return nil, fmt.Errorf("source %q listed twice", name)
+		}
+		sources = append(sources, name)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no sources listed")
+	}
+	return sources, nil
+}
+
+// parseAPIKeys parses "name:key:scope,scope" entries separated by ";" or
+// newlines. Scopes may themselves contain ":" (e.g. "run:inbound"), so only
+// the first two colons split an entry.
+func parseAPIKeys(value string) ([]APIKey, error) {
+	var keys []APIKey
+	seen := make(map[string]bool)
+	position := 0
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// Malformed entries are reported by position; their text may be a key.
+		position++
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("entry %d: want name:key:scopes", position)
+		}
+		name, key := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if name == "" || key == "" {
+			return nil, fmt.Errorf("entry %d: name and key are required", position)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate key name %q", name)
+		}
+		seen[name] = true
+
+		var scopes []string
+		for _, scope := range strings.Split(parts[2], ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+		if len(scopes) == 0 {
+			return nil, fmt.Errorf("key %q has no scopes", name)
+		}
+		keys = append(keys, APIKey{Name: name, Key: key, Scopes: scopes})
+	}
+	return keys, nil
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
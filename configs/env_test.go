@@ -76,3 +76,72 @@ func TestGetEnvBool(t *testing.T) {
 		t.Errorf("getEnvBool() default = %v, want %v", val, false)
 	}
 }
+
+func TestParseSchedules(t *testing.T) {
+	schedules := parseSpecs("inbound=*/15 * * * *; outbound = @every 10m ;bad;=@hourly")
+
+	if len(schedules) != 2 {
+		t.Fatalf("parseSpecs() returned %d entries, want 2: %v", len(schedules), schedules)
+	}
+	if schedules["inbound"] != "*/15 * * * *" {
+		t.Errorf("inbound schedule = %q, want %q", schedules["inbound"], "*/15 * * * *")
+	}
+	if schedules["outbound"] != "@every 10m" {
+		t.Errorf("outbound schedule = %q, want %q", schedules["outbound"], "@every 10m")
+	}
+
+	cfg := &Config{Schedules: schedules}
+	if cfg.Schedule("inbound", "@hourly") != "*/15 * * * *" {
+		t.Errorf("Schedule(inbound) = %q", cfg.Schedule("inbound", "@hourly"))
+	}
+	if cfg.Schedule("other", "@hourly") != "@hourly" {
+		t.Errorf("Schedule(other) = %q, want the default @hourly", cfg.Schedule("other", "@hourly"))
+	}
+	if cfg.Schedule("other", "") != "@manual" {
+		t.Errorf("Schedule(other) = %q, want @manual", cfg.Schedule("other", ""))
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys("ops:s3cret:admin;\nci:t0ken: run:outbound, logs:read \n")
+	if err != nil {
+		t.Fatalf("parseAPIKeys() error = %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("parseAPIKeys() returned %d keys, want 2", len(keys))
+	}
+	if keys[0].Name != "ops" || keys[0].Key != "s3cret" || len(keys[0].Scopes) != 1 {
+		t.Errorf("keys[0] = %+v", keys[0])
+	}
+	if got := keys[1].Scopes; len(got) != 2 || got[0] != "run:outbound" || got[1] != "logs:read" {
+		t.Errorf("keys[1].Scopes = %v", got)
+	}
+
+	for _, bad := range []string{"ops:s3cret", "ops::admin", "ops:k:", "a:k:admin;a:k2:admin"} {
+		if _, err := parseAPIKeys(bad); err == nil {
+			t.Errorf("parseAPIKeys(%q) expected error", bad)
+		}
+	}
+
+	// A pasted key without a name must not be echoed in the error
+	_, err = parseAPIKeys("ops:s3cret:admin;t0psecretkey")
+	if err == nil || err.Error() != "entry 2: want name:key:scopes" {
+		t.Errorf("parseAPIKeys() error = %v, want entry 2 reported", err)
+	}
+}
+
+func TestParseInboundSources(t *testing.T) {
+	sources, err := parseInboundSources(" trustmed, bucket ,")
+	if err != nil {
+		t.Fatalf("parseInboundSources() error = %v", err)
+	}
+	if len(sources) != 2 || sources[0] != "trustmed" || sources[1] != "bucket" {
+		t.Errorf("parseInboundSources() = %v, want [trustmed bucket]", sources)
+	}
+
+	for _, bad := range []string{"", "trustmed,ftp", "bucket,bucket"} {
+		if _, err := parseInboundSources(bad); err == nil {
+			t.Errorf("parseInboundSources(%q) expected error", bad)
+		}
+	}
+}
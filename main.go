@@ -4,23 +4,30 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
-	"sort"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/trackvision/tv-pipelines-hudsci/auth"
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/metrics"
 	"github.com/trackvision/tv-pipelines-hudsci/pipelines"
-	"github.com/trackvision/tv-pipelines-hudsci/pipelines/inbound"
-	"github.com/trackvision/tv-pipelines-hudsci/pipelines/outbound"
+	_ "github.com/trackvision/tv-pipelines-hudsci/pipelines/inbound"  // registers "inbound"
+	_ "github.com/trackvision/tv-pipelines-hudsci/pipelines/outbound" // registers "outbound"
 	"github.com/trackvision/tv-pipelines-hudsci/tasks"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
 	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 )
@@ -28,79 +35,98 @@ import (
 //go:embed templates/*.html
 var templatesFS embed.FS
 
-// PipelineFunc is the signature all pipelines must implement
-type PipelineFunc func(ctx context.Context, db *sqlx.DB, cms *tasks.DirectusClient, cfg *configs.Config, id string) error
-
-// Register your pipelines here
-var pipelineRegistry = map[string]PipelineFunc{
-	"inbound":  inbound.Run,
-	"outbound": outbound.Run,
+// API response types
+type jobListResponse struct {
+	Jobs      []string     `json:"jobs"`
+	Pipelines []jobSummary `json:"pipelines"`
 }
 
-// pipelineSteps maps pipeline names to their step names (for API discovery)
-var pipelineSteps = map[string][]string{
-	"inbound":  inbound.Steps,
-	"outbound": outbound.Steps,
+type jobSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Schedule    string `json:"schedule"`
 }
 
-// API response types
-type jobListResponse struct {
-	Jobs []string `json:"jobs"`
+type jobInfoResponse struct {
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description"`
+	Tasks        []string               `json:"tasks"`
+	Params       []pipelines.ParamSpec  `json:"params"`
+	Dependencies []pipelines.Dependency `json:"dependencies"`
+	Schedule     string                 `json:"schedule"`
+	NextRun      *time.Time             `json:"next_run,omitempty"`
+	LastRun      *time.Time             `json:"last_run,omitempty"`
+	LastRunID    string                 `json:"last_run_id,omitempty"`
 }
 
-type jobInfoResponse struct {
-	Name     string   `json:"name"`
-	Tasks    []string `json:"tasks"`
-	Schedule string   `json:"schedule"`
+type graphResponse struct {
+	pipelines.Graph
+	LastRunID string `json:"last_run_id,omitempty"` // run the node statuses come from
 }
 
 type runRequest struct {
-	ID        string   `json:"id"`
-	SkipSteps []string `json:"skip_steps"`
+	ID        string                     `json:"id"`
+	SkipSteps []string                   `json:"skip_steps"`
+	Inputs    map[string]json.RawMessage `json:"inputs"` // outputs of skipped steps, by Output name
+	Params    map[string]json.RawMessage `json:"params"` // validated against the pipeline's schema
 }
 
 type runResponse struct {
-	Success  bool   `json:"success"`
-	Pipeline string `json:"pipeline"`
-	ID       string `json:"id"`
-	Error    string `json:"error,omitempty"`
+	Success     bool                `json:"success"`
+	Pipeline    string              `json:"pipeline"`
+	ID          string              `json:"id"`
+	Status      pipelines.RunStatus `json:"status,omitempty"`
+	StatusURL   string              `json:"status_url,omitempty"`
+	HolderRunID string              `json:"holder_run_id,omitempty"`
+	Error       string              `json:"error,omitempty"`
 }
 
-type logsResponse struct {
-	Runs  []tasks.PipelineRun `json:"runs"`
-	Count int                 `json:"count"`
-	Query map[string]any      `json:"query"`
+type runListResponse struct {
+	Runs  []pipelines.Run `json:"runs"`
+	Count int             `json:"count"`
 }
 
-// authMiddleware checks for valid API key in Authorization header or X-API-Key header
-func authMiddleware(apiKey string, next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// If no API key configured, skip auth
-		if apiKey == "" {
-			next(w, r)
-			return
-		}
+type taskListResponse struct {
+	Tasks []pipelines.CatalogTask `json:"tasks"`
+	Count int                     `json:"count"`
+}
 
-		// Check Authorization: Bearer <key>
-		authHeader := r.Header.Get("Authorization")
-		if strings.HasPrefix(authHeader, "Bearer ") {
-			token := strings.TrimPrefix(authHeader, "Bearer ")
-			if token == apiKey {
-				next(w, r)
-				return
-			}
-		}
+type lockListResponse struct {
+	Locks []pipelines.Lease `json:"locks"`
+	Count int               `json:"count"`
+}
 
-		// Check X-API-Key header
-		if r.Header.Get("X-API-Key") == apiKey {
-			next(w, r)
-			return
-		}
+type lockReleaseResponse struct {
+	Success  bool             `json:"success"`
+	Pipeline string           `json:"pipeline"`
+	Released *pipelines.Lease `json:"released,omitempty"`
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
-	}
+// runHistoryEntry is a recorded run with a link to its Cloud Logging entries.
+type runHistoryEntry struct {
+	pipelines.Run
+	LogsURL string `json:"logs_url,omitempty"`
+}
+
+// readyCheck is the result of checking one downstream dependency.
+type readyCheck struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"` // "ok", "warn" or "error"
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	Details   any     `json:"details,omitempty"`
+}
+
+type readyResponse struct {
+	Status    string       `json:"status"` // "ready" or "not_ready"
+	Checks    []readyCheck `json:"checks"`
+	CheckedAt time.Time    `json:"checked_at"`
+}
+
+type logsResponse struct {
+	Runs  []runHistoryEntry `json:"runs"`
+	Count int               `json:"count"`
+	Query map[string]any    `json:"query"`
 }
 
 func main() {
@@ -110,41 +136,137 @@ func main() {
 		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 
+	// Traces for runs, steps and external calls
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingExporter, cfg.TracingServiceName)
+	if err != nil {
+		logger.Fatal("Invalid TRACING_EXPORTER", zap.Error(err))
+	}
+
+	// YAML pipelines composed from catalogue tasks, alongside the built-in ones
+	if cfg.PipelineDefinitionsDir != "" {
+		defined, err := pipelines.LoadDefinitions(cfg.PipelineDefinitionsDir)
+		if err != nil {
+			logger.Fatal("Invalid pipeline definitions", zap.String("dir", cfg.PipelineDefinitionsDir), zap.Error(err))
+		}
+		for _, p := range defined {
+			if _, ok := pipelines.Lookup(p.Name); ok {
+				logger.Fatal("Pipeline definition reuses a registered name", zap.String("pipeline", p.Name))
+			}
+			pipelines.Register(p)
+		}
+		logger.Info("Loaded pipeline definitions", zap.String("dir", cfg.PipelineDefinitionsDir), zap.Int("count", len(defined)))
+	}
+
 	port := cfg.Port
 	if port == "" {
 		port = "8080"
 	}
 
+	// Service API keys and their scopes
+	keyring, err := auth.NewKeyring(cfg.APIKeys, cfg.AuthDisabled)
+	if err != nil {
+		logger.Fatal("Invalid SERVICE_API_KEYS", zap.Error(err))
+	}
+
 	// Parse templates
 	tmpl, err := template.ParseFS(templatesFS, "templates/*.html")
 	if err != nil {
 		logger.Fatal("Failed to parse templates", zap.Error(err))
 	}
 
+	// Shared TiDB handle for coordination and run history
+	var stateDB *sqlx.DB
+	if cfg.Coordination != "local" || cfg.RunStore != "memory" {
+		stateDB = openStateDB(cfg)
+	}
+
+	// Dependency checks for /ready
+	readyDB := stateDB
+	if readyDB == nil {
+		readyDB = openStateDB(cfg)
+	}
+	readiness := newReadinessChecker(cfg, readyDB)
+	if cfg.StartupReadyCheck {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		result := readiness.Check(ctx)
+		cancel()
+		if result.Status != "ready" {
+			for _, check := range result.Checks {
+				if check.Status == "error" {
+					logger.Error("Readiness check failed", zap.String("check", check.Name), zap.String("error", check.Error))
+				}
+			}
+			logger.Fatal("Startup readiness check failed")
+		}
+		logger.Info("Startup readiness check passed")
+	}
+
+	// Background run executor
+	runs := pipelines.NewRunManager(cfg.RunHistoryLimit, newRunStore(cfg, stateDB))
+
+	// Schedule claims and pipeline locks shared across instances
+	claimer, lockStore := newCoordination(cfg, stateDB)
+	locker := pipelines.NewPipelineLocker(lockStore, time.Duration(cfg.PipelineLockTTL)*time.Second)
+
+	// Cron scheduler for pipelines with a configured schedule
+	scheduler := pipelines.NewScheduler(func(ctx context.Context, name, id string) error {
+		_, err := submitRun(ctx, cfg, runs, locker, pipelines.TriggerSchedule, "scheduler", name, id)
+		return err
+	}, claimer)
+	steps := make(map[string][]string)
+	for _, p := range pipelines.Registered() {
+		flow := p.Discover()
+		if err := flow.Validate(); err != nil {
+			logger.Fatal("Invalid pipeline flow", zap.String("pipeline", p.Name), zap.Error(err))
+		}
+		steps[p.Name] = flow.Steps()
+	}
+	if err := pipelines.ValidateRetryPolicies(cfg.RetryPolicies, steps); err != nil {
+		logger.Fatal("Invalid STEP_RETRY_POLICIES", zap.Error(err))
+	}
+	for _, p := range pipelines.Registered() {
+		if err := scheduler.Add(p.Name, p.ScheduleFor(cfg)); err != nil {
+			logger.Fatal("Invalid pipeline schedule", zap.String("pipeline", p.Name), zap.Error(err))
+		}
+	}
+	scheduler.Start()
+
 	mux := http.NewServeMux()
 
 	// Health check (no auth required)
 	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/ready", makeReadyHandler(readiness))
 
-	// API endpoints (auth required)
-	mux.HandleFunc("/jobs", authMiddleware(cfg.APIKey, jobsHandler))
-	mux.HandleFunc("/jobs/", authMiddleware(cfg.APIKey, jobInfoHandler))
-	mux.HandleFunc("/run/", authMiddleware(cfg.APIKey, makeRunHandler(cfg)))
+	// API endpoints (any valid key; /run/{name} and run cancellation and
+	// resume check run:{name}, the /runs list checks logs:read, and a run's
+	// details, events and artifacts check logs:read or run:{name} in the
+	// handler)
+	mux.HandleFunc("/jobs", keyring.Require("", makeJobsHandler(scheduler)))
+	mux.HandleFunc("/jobs/", keyring.Require("", makeJobInfoHandler(scheduler, runs)))
+	mux.HandleFunc("/tasks", keyring.Require("", tasksHandler))
+	mux.HandleFunc("/run/", keyring.Require("", makeRunHandler(cfg, runs, locker)))
+	mux.HandleFunc("/runs", keyring.Require("", makeRunsHandler(cfg, runs, locker, lockStore)))
+	mux.HandleFunc("/runs/", keyring.Require("", makeRunsHandler(cfg, runs, locker, lockStore)))
 
-	// Logs endpoint (auth required)
-	mux.HandleFunc("/logs", authMiddleware(cfg.APIKey, makeLogsHandler(cfg)))
+	// Pipeline locks (admin)
+	mux.HandleFunc("/locks", keyring.Require(auth.ScopeAdmin, makeLocksHandler(lockStore)))
+	mux.HandleFunc("/locks/", keyring.Require(auth.ScopeAdmin, makeLocksHandler(lockStore)))
 
-	// UI endpoints (no auth - for browser access)
+	// Run history and metrics (logs:read; scrape metrics with a bearer token)
+	mux.HandleFunc("/logs", keyring.Require(auth.ScopeLogsRead, makeLogsHandler(cfg, runs)))
+	mux.HandleFunc("/metrics", keyring.Require(auth.ScopeLogsRead, metrics.Handler()))
+
+	// UI endpoints (HTTP basic auth with a service API key as the password)
 	mux.HandleFunc("/", redirectToUI)
-	mux.HandleFunc("/ui/", makeUIIndexHandler(tmpl))
-	mux.HandleFunc("/ui/jobs/", makeUIJobHandler(tmpl))
-	mux.HandleFunc("/ui/logs", makeUILogsHandler(tmpl, cfg))
+	mux.HandleFunc("/ui/", keyring.RequireUI(makeUIIndexHandler(tmpl, scheduler)))
+	mux.HandleFunc("/ui/jobs/", keyring.RequireUI(makeUIJobHandler(tmpl, scheduler)))
+	mux.HandleFunc("/ui/logs", keyring.RequireUI(makeUILogsHandler(tmpl, cfg)))
 
 	server := &http.Server{
 		Addr:         ":" + port,
 		Handler:      mux,
 		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 60 * time.Minute,
+		WriteTimeout: 60 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
 
@@ -155,18 +277,26 @@ func main() {
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		<-sigCh
 		logger.Info("Shutting down server...")
+		scheduler.Stop()
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		if err := server.Shutdown(ctx); err != nil {
 			logger.Error("Server shutdown error", zap.Error(err))
 		}
+		if err := runs.Shutdown(ctx); err != nil {
+			logger.Error("Pipeline runs did not stop before shutdown", zap.Error(err))
+		}
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("Failed to flush traces", zap.Error(err))
+		}
 		close(done)
 	}()
 
 	logger.Info("Starting HudSci pipeline service",
 		zap.String("port", port),
 		zap.Strings("pipelines", getPipelineNames()),
-		zap.Bool("auth_enabled", cfg.APIKey != ""))
+		zap.Bool("auth_enabled", !keyring.Disabled()),
+		zap.Int("api_keys", len(cfg.APIKeys)))
 
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		logger.Fatal("Server failed", zap.Error(err))
@@ -179,44 +309,260 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
 
-// jobsHandler returns list of all pipeline names (GET /jobs)
-func jobsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// readinessCheckTimeout bounds each dependency check.
+const readinessCheckTimeout = 5 * time.Second
+
+// readinessChecker checks every downstream dependency. Results are cached
+// briefly so probes and the UI banner don't hit TrustMed and Directus on
+// every request.
+type readinessChecker struct {
+	cfg       *configs.Config
+	db        *sqlx.DB
+	cms       *tasks.DirectusClient
+	converter *tasks.EPCISConverterClient
+	dashboard *tasks.TrustMedDashboardClient
+	cacheTTL  time.Duration
+
+	mu   sync.Mutex
+	last *readyResponse
+}
+
+func newReadinessChecker(cfg *configs.Config, db *sqlx.DB) *readinessChecker {
+	return &readinessChecker{
+		cfg:       cfg,
+		db:        db,
+		cms:       tasks.NewDirectusClient(cfg.CMSBaseURL, cfg.DirectusCMSAPIKey),
+		converter: tasks.NewEPCISConverterClient(cfg.EPCISConverterURL),
+		dashboard: tasks.NewTrustMedDashboardClient(cfg),
+		cacheTTL:  15 * time.Second,
+	}
+}
+
+// Check runs all dependency checks concurrently, or returns the cached
+// result if it is recent enough.
+func (rc *readinessChecker) Check(ctx context.Context) readyResponse {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.last != nil && time.Since(rc.last.CheckedAt) < rc.cacheTTL {
+		return *rc.last
+	}
+
+	checks := []struct {
+		name string
+		fn   func(ctx context.Context) (any, error)
+	}{
+		{"directus", func(ctx context.Context) (any, error) { return nil, rc.cms.CheckAuth(ctx) }},
+		{"tidb", func(ctx context.Context) (any, error) { return nil, rc.db.PingContext(ctx) }},
+		{"epcis_converter", func(ctx context.Context) (any, error) { return nil, rc.converter.HealthCheck(ctx) }},
+		{"trustmed_dashboard", func(ctx context.Context) (any, error) { return nil, rc.dashboard.CheckAuth(ctx) }},
+		{"trustmed_certs", func(ctx context.Context) (any, error) {
+			info, err := tasks.InspectTrustMedCerts(rc.cfg, time.Now())
+			if info == nil {
+				return nil, err
+			}
+			return info, err
+		}},
+	}
+
+	result := readyResponse{Status: "ready", Checks: make([]readyCheck, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := c.fn(checkCtx)
+			check := readyCheck{
+				Name:      c.name,
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				Details:   details,
+			}
+			if err != nil {
+				check.Status = "error"
+				check.Error = err.Error()
+			} else if info, ok := details.(*tasks.CertInfo); ok && info.DaysUntilExpiry < rc.cfg.CertExpiryWarnDays {
+				check.Status = "warn"
+				check.Error = fmt.Sprintf("client certificate expires in %d days", info.DaysUntilExpiry)
+			}
+			result.Checks[i] = check
+		}()
+	}
+	wg.Wait()
+
+	for _, check := range result.Checks {
+		if check.Status == "error" {
+			result.Status = "not_ready"
+			break
+		}
+	}
+	result.CheckedAt = time.Now()
+	rc.last = &result
+	return result
+}
+
+// makeReadyHandler reports per-dependency status (GET /ready). It returns
+// 503 when any check fails so startup probes hold back a broken revision.
+func makeReadyHandler(readiness *readinessChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result := readiness.Check(context.WithoutCancel(r.Context()))
+		w.Header().Set("Content-Type", "application/json")
+		if result.Status != "ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(result)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(jobListResponse{Jobs: getPipelineNames()})
 }
 
-// jobInfoHandler returns pipeline details (GET /jobs/{name})
-func jobInfoHandler(w http.ResponseWriter, r *http.Request) {
+// makeJobsHandler lists the registered pipelines (GET /jobs)
+func makeJobsHandler(scheduler *pipelines.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resp := jobListResponse{Jobs: getPipelineNames(), Pipelines: []jobSummary{}}
+		for _, p := range pipelines.Registered() {
+			schedule, _ := scheduler.Info(p.Name)
+			resp.Pipelines = append(resp.Pipelines, jobSummary{
+				Name:        p.Name,
+				Description: p.Description,
+				Schedule:    schedule.Schedule,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// tasksHandler lists the catalogue tasks YAML pipeline definitions can use
+// (GET /tasks)
+func tasksHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	catalog := pipelines.Catalog()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(taskListResponse{Tasks: catalog, Count: len(catalog)})
+}
 
-	name := strings.TrimPrefix(r.URL.Path, "/jobs/")
-	if name == "" {
-		http.Error(w, "pipeline name required", http.StatusBadRequest)
-		return
+// makeJobInfoHandler returns pipeline details (GET /jobs/{name}) and the
+// pipeline's DAG (GET /jobs/{name}/graph)
+func makeJobInfoHandler(scheduler *pipelines.Scheduler, runs *pipelines.RunManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		if name == "" {
+			http.Error(w, "pipeline name required", http.StatusBadRequest)
+			return
+		}
+		if pipeline, ok := strings.CutSuffix(name, "/graph"); ok {
+			serveGraph(w, r, runs, pipeline)
+			return
+		}
+
+		p, ok := pipelines.Lookup(name)
+		if !ok {
+			http.Error(w, "unknown pipeline: "+name, http.StatusNotFound)
+			return
+		}
+
+		schedule, _ := scheduler.Info(name)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(jobInfoResponse{
+			Name:         name,
+			Description:  p.Description,
+			Tasks:        p.Steps(),
+			Params:       p.Params,
+			Dependencies: p.Dependencies,
+			Schedule:     schedule.Schedule,
+			NextRun:      schedule.NextRun,
+			LastRun:      schedule.LastRun,
+			LastRunID:    schedule.LastRunID,
+		})
 	}
+}
 
-	steps, ok := pipelineSteps[name]
+// serveGraph writes a pipeline's DAG, with each step's status in the latest
+// run, as JSON or, with ?format=mermaid or ?format=dot, as Mermaid or
+// Graphviz DOT.
+func serveGraph(w http.ResponseWriter, r *http.Request, runs *pipelines.RunManager, name string) {
+	p, ok := pipelines.Lookup(name)
 	if !ok {
 		http.Error(w, "unknown pipeline: "+name, http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(jobInfoResponse{
-		Name:     name,
-		Tasks:    steps,
-		Schedule: "@manual",
-	})
+	graph := p.Discover().Graph()
+	var lastRunID string
+	latest, err := runs.History(r.Context(), pipelines.RunFilter{Pipeline: name, Limit: 1})
+	if err != nil {
+		logger.Warn("Failed to load latest run for graph", zap.String("pipeline", name), zap.Error(err))
+	} else if len(latest) > 0 {
+		graph = graph.WithRun(latest[0])
+		lastRunID = latest[0].ID
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(graphResponse{Graph: graph, LastRunID: lastRunID})
+	case "mermaid":
+		w.Header().Set("Content-Type", "text/vnd.mermaid; charset=utf-8")
+		_, _ = io.WriteString(w, graph.Mermaid())
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		_, _ = io.WriteString(w, graph.DOT())
+	default:
+		http.Error(w, "format must be json, mermaid or dot", http.StatusBadRequest)
+	}
+}
+
+// openStateDB opens the TiDB handle shared by schedule claims, pipeline
+// locks and run history. sqlx.Open does not connect, so startup does not
+// depend on the database; claims and locks fail (and runs are not started)
+// while it is unreachable.
+func openStateDB(cfg *configs.Config) *sqlx.DB {
+	db, err := sqlx.Open("mysql", buildDSN(cfg))
+	if err != nil {
+		logger.Fatal("Failed to open state database", zap.Error(err))
+	}
+	return db
+}
+
+// newCoordination returns the tick claimer and lock store that keep
+// scheduled ticks and pipeline runs from overlapping across instances.
+// "local" is only safe with a single instance.
+func newCoordination(cfg *configs.Config, db *sqlx.DB) (pipelines.TickClaimer, pipelines.LockStore) {
+	if cfg.Coordination == "local" {
+		return pipelines.LocalTickClaimer{}, pipelines.NewMemoryLockStore()
+	}
+	return pipelines.NewSQLTickClaimer(db, 7*24*time.Hour), pipelines.NewSQLLockStore(db)
+}
+
+// newRunStore returns where run history is recorded: TiDB by default, or
+// memory for local development.
+func newRunStore(cfg *configs.Config, db *sqlx.DB) pipelines.RunStore {
+	if cfg.RunStore == "memory" {
+		return pipelines.NewMemoryRunStore(cfg.RunHistoryLimit)
+	}
+	return pipelines.NewSQLRunStore(db)
 }
 
-func makeRunHandler(cfg *configs.Config) http.HandlerFunc {
+func makeRunHandler(cfg *configs.Config, runs *pipelines.RunManager, locker *pipelines.PipelineLocker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -230,83 +576,578 @@ func makeRunHandler(cfg *configs.Config) http.HandlerFunc {
 			return
 		}
 
-		pipelineFn, ok := pipelineRegistry[name]
+		p, ok := pipelines.Lookup(name)
 		if !ok {
 			respondError(w, "unknown pipeline: "+name, http.StatusNotFound)
 			return
 		}
 
+		caller := auth.FromContext(r.Context())
+		if !caller.Has(auth.RunScope(name)) {
+			auth.Forbidden(w, auth.RunScope(name))
+			return
+		}
+
 		var req runRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err.Error() != "EOF" {
 			respondError(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
+		steps := p.Steps()
+		for _, step := range req.SkipSteps {
+			if !slices.Contains(steps, step) {
+				respondError(w, fmt.Sprintf("skip_steps: unknown step %q", step), http.StatusBadRequest)
+				return
+			}
+		}
+		params, err := pipelines.ParseParams(p.Params, steps, req.Params)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if req.ID == "" {
-			req.ID = fmt.Sprintf("ID-%s", time.Now().Format("020106150405"))
-		}
-
-		// Initialize clients
-		cms := tasks.NewDirectusClient(cfg.CMSBaseURL, cfg.DirectusCMSAPIKey)
-
-		// Initialize database connection (if needed for this pipeline)
-		var db *sqlx.DB
-		if cfg.DBHost != "" {
-			// Build DSN for MySQL/TiDB
-			dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
-				cfg.DBUser,
-				cfg.DBPassword,
-				cfg.DBHost,
-				cfg.DBPort,
-				cfg.DBName,
-			)
-			if cfg.DBSSL {
-				dsn += "&tls=skip-verify"
+			req.ID = fmt.Sprintf("ID-%s-%s", time.Now().Format("020106150405"), uuid.NewString()[:8])
+		}
+
+		// Build context with skip steps, input overrides and run parameters
+		ctx := r.Context()
+		if len(req.SkipSteps) > 0 {
+			ctx = context.WithValue(ctx, pipelines.SkipStepsKey, req.SkipSteps)
+		}
+		if len(req.Inputs) > 0 {
+			ctx = context.WithValue(ctx, pipelines.InputsKey, req.Inputs)
+		}
+		if len(params) > 0 {
+			ctx = context.WithValue(ctx, pipelines.ParamsKey, params)
+		}
+
+		run, err := submitRun(ctx, cfg, runs, locker, pipelines.TriggerAPI, caller.Name, name, req.ID)
+		respondSubmitted(w, name, req.ID, run, err)
+	}
+}
+
+// respondSubmitted writes the response to a run submission: 202 with the
+// run's status URL, or 409 if the pipeline is locked by another run or the
+// ID is taken.
+func respondSubmitted(w http.ResponseWriter, name, id string, run pipelines.Run, err error) {
+	var held *pipelines.LockHeldError
+	if errors.As(err, &held) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(runResponse{
+			Success:     false,
+			Pipeline:    name,
+			ID:          id,
+			HolderRunID: held.Lease.RunID,
+			StatusURL:   "/runs/" + held.Lease.RunID,
+			Error:       err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, pipelines.ErrRunExists) {
+		respondError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/runs/"+run.ID)
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(runResponse{
+		Success:   true,
+		Pipeline:  name,
+		ID:        run.ID,
+		Status:    run.Status,
+		StatusURL: "/runs/" + run.ID,
+	})
+}
+
+// submitRun takes the pipeline lock and queues the run on the run manager.
+// It returns *pipelines.LockHeldError if another run of the pipeline is
+// active. ctx carries run options such as skip steps; its cancellation does
+// not affect the run.
+func submitRun(ctx context.Context, cfg *configs.Config, runs *pipelines.RunManager, locker *pipelines.PipelineLocker, trigger, calledBy, name, id string) (pipelines.Run, error) {
+	p, ok := pipelines.Lookup(name)
+	if !ok {
+		return pipelines.Run{}, fmt.Errorf("unknown pipeline: %s", name)
+	}
+
+	skipSteps, _ := ctx.Value(pipelines.SkipStepsKey).([]string)
+	inputs, _ := ctx.Value(pipelines.InputsKey).(map[string]json.RawMessage)
+	runParams := pipelines.ParamsFromContext(ctx)
+	resume, _ := ctx.Value(pipelines.ResumeKey).(*pipelines.Resume)
+	logger.Info("Queueing pipeline execution",
+		zap.String("pipeline", name),
+		zap.String("run_id", id),
+		zap.String("called_by", calledBy),
+		zap.Strings("skip_steps", skipSteps),
+		zap.Any("params", runParams))
+
+	lease, err := locker.Acquire(ctx, name, id)
+	if err != nil {
+		return pipelines.Run{}, err
+	}
+
+	var params map[string]any
+	if len(skipSteps) > 0 || len(inputs) > 0 || len(runParams) > 0 || resume != nil {
+		params = make(map[string]any, len(runParams)+3)
+		for k, v := range runParams {
+			params[k] = v
+		}
+		if len(skipSteps) > 0 {
+			params["skip_steps"] = skipSteps
+		}
+		if len(inputs) > 0 {
+			params["inputs"] = inputs
+		}
+		if resume != nil {
+			params[pipelines.ResumedFromParam] = resume.RunID
+		}
+	}
+	spec := pipelines.RunSpec{
+		Pipeline: name,
+		ID:       id,
+		Trigger:  trigger,
+		CalledBy: calledBy,
+		Params:   params,
+		Steps:    p.Steps(),
+	}
+
+	run, err := runs.Submit(ctx, spec, func(ctx context.Context) error {
+		defer lease.Release()
+
+		// Cancel the run if the lease is lost (e.g. force-released or
+		// released by a cancel request on another instance)
+		ctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		go lease.Keepalive(ctx, func() {
+			cancel(fmt.Errorf("%w: pipeline lock released", pipelines.ErrRunCancelled))
+		})
+
+		return executePipeline(ctx, cfg, p, id)
+	})
+	if err != nil {
+		lease.Release()
+		return pipelines.Run{}, err
+	}
+	return run, nil
+}
+
+// executePipeline opens the pipeline's dependencies and runs it. It is called
+// from the run manager's background goroutine.
+func executePipeline(ctx context.Context, cfg *configs.Config, p pipelines.Pipeline, id string) error {
+	env := pipelines.Env{
+		ID:     id,
+		Config: cfg,
+		CMS:    tasks.NewDirectusClient(cfg.CMSBaseURL, cfg.DirectusCMSAPIKey),
+	}
+
+	// Open the database only for pipelines that declare it
+	if p.Needs(pipelines.DependencyDB) {
+		db, err := openDB(cfg)
+		if err != nil {
+			logger.Error("Database connection failed", zap.Error(err))
+			return err
+		}
+		defer db.Close()
+		env.DB = db
+	}
+
+	logger.Info("Starting pipeline execution",
+		zap.String("pipeline", p.Name),
+		zap.String("run_id", id))
+
+	return p.Run(ctx, env)
+}
+
+// buildDSN builds the MySQL/TiDB connection string from config.
+func buildDSN(cfg *configs.Config) string {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
+		cfg.DBUser,
+		cfg.DBPassword,
+		cfg.DBHost,
+		cfg.DBPort,
+		cfg.DBName,
+	)
+	if cfg.DBSSL {
+		dsn += "&tls=skip-verify"
+	}
+	return dsn
+}
+
+// openDB connects to MySQL/TiDB and verifies the connection.
+func openDB(cfg *configs.Config) (*sqlx.DB, error) {
+	db, err := sqlx.Open("mysql", buildDSN(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("database connection failed: %w", err)
+	}
+
+	// Test connection
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("database ping failed: %w", err)
+	}
+
+	return db, nil
+}
+
+// makeRunsHandler lists runs (GET /runs?pipeline=), returns a single run
+// (GET /runs/{id}), serves run artifacts (GET /runs/{id}/artifacts/{name}),
+// streams run events (GET /runs/{id}/events), cancels runs (POST
+// /runs/{id}/cancel or DELETE /runs/{id}) and resumes failed runs (POST
+// /runs/{id}/resume).
+func makeRunsHandler(cfg *configs.Config, runs *pipelines.RunManager, locker *pipelines.PipelineLocker, locks pipelines.LockStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs"), "/")
+
+		if runID, ok := strings.CutSuffix(id, "/resume"); ok && r.Method == http.MethodPost {
+			resumeRun(w, r, cfg, runs, locker, runID)
+			return
+		}
+		if runID, ok := strings.CutSuffix(id, "/cancel"); ok && r.Method == http.MethodPost {
+			cancelRun(w, r, runs, locks, runID)
+			return
+		}
+		if r.Method == http.MethodDelete && id != "" && !strings.Contains(id, "/") {
+			cancelRun(w, r, runs, locks, id)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if id == "" {
+			if !auth.FromContext(r.Context()).Has(auth.ScopeLogsRead) {
+				auth.Forbidden(w, auth.ScopeLogsRead)
+				return
 			}
+			list := runs.List(r.URL.Query().Get("pipeline"))
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(runListResponse{Runs: list, Count: len(list)})
+			return
+		}
+
+		runID, name, isArtifact := strings.Cut(id, "/artifacts/")
+		if !isArtifact {
+			runID = strings.TrimSuffix(id, "/events")
+		}
+		run, ok, err := runs.Lookup(r.Context(), runID)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			respondError(w, "unknown run: "+runID, http.StatusNotFound)
+			return
+		}
+		// A run's details, events and artifacts need logs:read or the scope
+		// to run its pipeline
+		caller := auth.FromContext(r.Context())
+		if !caller.Has(auth.ScopeLogsRead) && !caller.Has(auth.RunScope(run.Pipeline)) {
+			auth.Forbidden(w, auth.ScopeLogsRead+" or "+auth.RunScope(run.Pipeline))
+			return
+		}
 
-			var err error
-			db, err = sqlx.Open("mysql", dsn)
+		if isArtifact {
+			artifact, found, err := runs.Artifact(r.Context(), runID, name)
 			if err != nil {
-				logger.Error("Database connection failed", zap.Error(err))
-				respondError(w, fmt.Sprintf("database connection failed: %v", err), http.StatusInternalServerError)
+				respondError(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			defer db.Close()
-
-			// Test connection
-			if err := db.Ping(); err != nil {
-				logger.Error("Database ping failed", zap.Error(err))
-				respondError(w, fmt.Sprintf("database ping failed: %v", err), http.StatusInternalServerError)
+			if !found {
+				respondError(w, "unknown artifact: "+name, http.StatusNotFound)
 				return
 			}
+			w.Header().Set("Content-Type", artifact.ContentType)
+			w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", artifact.Name))
+			_, _ = w.Write(artifact.Content)
+			return
 		}
 
-		// Build context with skip steps
-		ctx := r.Context()
-		if len(req.SkipSteps) > 0 {
-			ctx = context.WithValue(ctx, pipelines.SkipStepsKey, req.SkipSteps)
+		if strings.HasSuffix(id, "/events") {
+			streamRunEvents(w, r, runs, runID)
+			return
 		}
 
-		// Run pipeline
-		logger.Info("Starting pipeline execution",
-			zap.String("pipeline", name),
-			zap.String("id", req.ID),
-			zap.Strings("skip_steps", req.SkipSteps))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(run)
+	}
+}
 
-		if err := pipelineFn(ctx, db, cms, cfg, req.ID); err != nil {
-			logger.Error("Pipeline failed",
-				zap.String("pipeline", name),
-				zap.String("id", req.ID),
-				zap.Error(err))
+// sseKeepalive is how often an idle event stream sends a comment so proxies
+// keep the connection open.
+const sseKeepalive = 15 * time.Second
+
+// streamRunEvents streams a run's events as Server-Sent Events (GET
+// /runs/{id}/events). The first event, "snapshot", carries the run as it is
+// now; step and progress events follow until "run_finished". Runs that are
+// not executing on this instance get the snapshot only, and clients fall back
+// to polling GET /runs/{id}.
+func streamRunEvents(w http.ResponseWriter, r *http.Request, runs *pipelines.RunManager, id string) {
+	run, events, unsubscribe, ok := runs.Subscribe(id)
+	defer unsubscribe()
+	if !ok {
+		stored, found, err := runs.Lookup(r.Context(), id)
+		if err != nil {
 			respondError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !found {
+			respondError(w, "unknown run: "+id, http.StatusNotFound)
+			return
+		}
+		run = stored
+	}
 
-		logger.Info("Pipeline completed",
-			zap.String("pipeline", name),
-			zap.String("id", req.ID))
+	// Streams outlive the server's write timeout.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(runResponse{Success: true, Pipeline: name, ID: req.ID})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, v any) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !send("snapshot", run) || !ok {
+		return
+	}
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, open := <-events:
+			if !open || !send(ev.Type, ev) {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// cancelRun cancels an active run. A run executing on another instance is
+// cancelled by releasing its pipeline lock; the holder notices on its next
+// lock renewal (within a third of PIPELINE_LOCK_TTL) and stops.
+func cancelRun(w http.ResponseWriter, r *http.Request, runs *pipelines.RunManager, locks pipelines.LockStore, id string) {
+	run, ok, err := runs.Lookup(r.Context(), id)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		respondError(w, "unknown run: "+id, http.StatusNotFound)
+		return
+	}
+	caller := auth.FromContext(r.Context())
+	if !caller.Has(auth.RunScope(run.Pipeline)) {
+		auth.Forbidden(w, auth.RunScope(run.Pipeline))
+		return
+	}
+
+	cancelled, err := runs.Cancel(id)
+	if err == nil {
+		run = cancelled
+	}
+	if errors.Is(err, pipelines.ErrRunNotActive) {
+		err = releaseRunLock(r.Context(), locks, run.Pipeline, id)
+	}
+	if errors.Is(err, pipelines.ErrRunFinished) || errors.Is(err, pipelines.ErrRunNotActive) {
+		respondError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Warn("Pipeline run cancel requested",
+		zap.String("run_id", id),
+		zap.String("called_by", caller.Name))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(runResponse{
+		Success:   true,
+		Pipeline:  run.Pipeline,
+		ID:        id,
+		Status:    run.Status,
+		StatusURL: "/runs/" + id,
+	})
+}
+
+// releaseRunLock releases pipeline's lock if run id holds it, which cancels
+// the run on whichever instance is executing it. It returns
+// pipelines.ErrRunNotActive if the run holds no lock.
+func releaseRunLock(ctx context.Context, locks pipelines.LockStore, pipeline, id string) error {
+	leases, err := locks.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, lease := range leases {
+		if lease.Pipeline == pipeline && lease.RunID == id {
+			return locks.Release(ctx, pipeline, id)
+		}
+	}
+	return fmt.Errorf("%w: %s", pipelines.ErrRunNotActive, id)
+}
+
+// resumeRun starts a new run that continues a failed or cancelled run
+// (POST /runs/{id}/resume). Steps the earlier run completed are restored from
+// their checkpoints instead of running again, and the earlier run's skip
+// steps and parameters are reused. The body may set the new run's "id".
+func resumeRun(w http.ResponseWriter, r *http.Request, cfg *configs.Config, runs *pipelines.RunManager, locker *pipelines.PipelineLocker, id string) {
+	run, ok, err := runs.Lookup(r.Context(), id)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		respondError(w, "unknown run: "+id, http.StatusNotFound)
+		return
+	}
+	caller := auth.FromContext(r.Context())
+	if !caller.Has(auth.RunScope(run.Pipeline)) {
+		auth.Forbidden(w, auth.RunScope(run.Pipeline))
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err.Error() != "EOF" {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		req.ID = fmt.Sprintf("%s-resume-%s", id, uuid.NewString()[:8])
+	}
+
+	resume, err := runs.NewResume(r.Context(), run)
+	if errors.Is(err, pipelines.ErrNotResumable) {
+		respondError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	skipSteps, inputs, params, err := runOptions(run)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), pipelines.ResumeKey, resume)
+	if len(skipSteps) > 0 {
+		ctx = context.WithValue(ctx, pipelines.SkipStepsKey, skipSteps)
+	}
+	if len(inputs) > 0 {
+		ctx = context.WithValue(ctx, pipelines.InputsKey, inputs)
+	}
+	if len(params) > 0 {
+		ctx = context.WithValue(ctx, pipelines.ParamsKey, params)
+	}
+
+	logger.Info("Resuming pipeline run",
+		zap.String("pipeline", run.Pipeline),
+		zap.String("resumed_from", id),
+		zap.String("run_id", req.ID),
+		zap.Strings("completed_steps", resume.Completed))
+	resumed, err := submitRun(ctx, cfg, runs, locker, pipelines.TriggerAPI, caller.Name, run.Pipeline, req.ID)
+	respondSubmitted(w, run.Pipeline, req.ID, resumed, err)
+}
+
+// runOptions recovers the skip steps, input overrides and validated
+// parameters a run was started with from its recorded params.
+func runOptions(run pipelines.Run) ([]string, map[string]json.RawMessage, pipelines.Params, error) {
+	var skipSteps []string
+	var inputs map[string]json.RawMessage
+	raw := make(map[string]json.RawMessage, len(run.Params))
+	for name, value := range run.Params {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("encoding parameter %q: %w", name, err)
+		}
+		switch name {
+		case "skip_steps":
+			if err := json.Unmarshal(data, &skipSteps); err != nil {
+				return nil, nil, nil, fmt.Errorf("decoding skip steps: %w", err)
+			}
+		case "inputs":
+			if err := json.Unmarshal(data, &inputs); err != nil {
+				return nil, nil, nil, fmt.Errorf("decoding inputs: %w", err)
+			}
+		case pipelines.ResumedFromParam:
+		default:
+			raw[name] = data
+		}
+	}
+	p, ok := pipelines.Lookup(run.Pipeline)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unknown pipeline: %s", run.Pipeline)
+	}
+	params, err := pipelines.ParseParams(p.Params, p.Steps(), raw)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("restoring parameters of run %s: %w", run.ID, err)
+	}
+	return skipSteps, inputs, params, nil
+}
+
+// makeLocksHandler lists active pipeline locks (GET /locks) and force-releases
+// a stale lock (DELETE /locks/{pipeline}). If the holder is still running it
+// notices on its next renewal and cancels itself.
+func makeLocksHandler(store pipelines.LockStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/locks"), "/")
+
+		switch {
+		case r.Method == http.MethodGet && name == "":
+			locks, err := store.List(r.Context())
+			if err != nil {
+				respondError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(lockListResponse{Locks: locks, Count: len(locks)})
+
+		case r.Method == http.MethodDelete && name != "":
+			lease, ok, err := store.ForceRelease(r.Context(), name)
+			if err != nil {
+				respondError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				respondError(w, "no lock held for pipeline: "+name, http.StatusNotFound)
+				return
+			}
+			logger.Warn("Pipeline lock force-released",
+				zap.String("pipeline", name),
+				zap.String("run_id", lease.RunID),
+				zap.String("holder", lease.Holder))
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(lockReleaseResponse{Success: true, Pipeline: name, Released: &lease})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
 	}
 }
 
@@ -320,21 +1161,27 @@ func redirectToUI(w http.ResponseWriter, r *http.Request) {
 }
 
 // makeUIIndexHandler returns UI index page showing all pipelines
-func makeUIIndexHandler(tmpl *template.Template) http.HandlerFunc {
+func makeUIIndexHandler(tmpl *template.Template, scheduler *pipelines.Scheduler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/ui/" {
 			http.NotFound(w, r)
 			return
 		}
+		registered := pipelines.Registered()
+		schedules := make(map[string]pipelines.ScheduleInfo, len(registered))
+		for _, p := range registered {
+			schedules[p.Name], _ = scheduler.Info(p.Name)
+		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		_ = tmpl.ExecuteTemplate(w, "index.html", map[string]any{
-			"Jobs": getPipelineNames(),
+			"Jobs":      registered,
+			"Schedules": schedules,
 		})
 	}
 }
 
 // makeUIJobHandler returns UI page for a specific pipeline
-func makeUIJobHandler(tmpl *template.Template) http.HandlerFunc {
+func makeUIJobHandler(tmpl *template.Template, scheduler *pipelines.Scheduler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		name := strings.TrimPrefix(r.URL.Path, "/ui/jobs/")
 		if name == "" {
@@ -342,16 +1189,22 @@ func makeUIJobHandler(tmpl *template.Template) http.HandlerFunc {
 			return
 		}
 
-		steps, ok := pipelineSteps[name]
+		p, ok := pipelines.Lookup(name)
 		if !ok {
 			http.NotFound(w, r)
 			return
 		}
 
+		schedule, _ := scheduler.Info(name)
+
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		_ = tmpl.ExecuteTemplate(w, "job.html", map[string]any{
-			"Name":  name,
-			"Tasks": steps,
+			"Name":         name,
+			"Description":  p.Description,
+			"Tasks":        p.Steps(),
+			"Params":       p.Params,
+			"Dependencies": p.Dependencies,
+			"Schedule":     schedule,
 		})
 	}
 }
@@ -363,45 +1216,41 @@ func respondError(w http.ResponseWriter, msg string, code int) {
 }
 
 func getPipelineNames() []string {
-	names := make([]string, 0, len(pipelineRegistry))
-	for name := range pipelineRegistry {
-		names = append(names, name)
+	registered := pipelines.Registered()
+	names := make([]string, len(registered))
+	for i, p := range registered {
+		names[i] = p.Name
 	}
-	sort.Strings(names)
 	return names
 }
 
-// makeLogsHandler returns logs from GCP Cloud Logging
-func makeLogsHandler(cfg *configs.Config) http.HandlerFunc {
+// makeLogsHandler returns run history from the run store (GET /logs).
+// When Cloud Logging is configured each run links to its logs in the console.
+func makeLogsHandler(cfg *configs.Config, runs *pipelines.RunManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		// Check if logging is configured
-		if cfg.GCPProjectID == "" || cfg.CloudRunService == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusServiceUnavailable)
-			_ = json.NewEncoder(w).Encode(map[string]string{
-				"error": "logs not configured: set GCP_PROJECT_ID and CLOUD_RUN_SERVICE",
-			})
-			return
-		}
-
 		// Parse query parameters
 		query := r.URL.Query()
+		runID := query.Get("run_id")
 		pipeline := query.Get("pipeline")
-		severity := query.Get("severity")
+		status := query.Get("status")
 		sinceStr := query.Get("since")
 		limitStr := query.Get("limit")
 
-		// Parse since duration
-		since := time.Hour
-		if sinceStr != "" {
-			if d, err := time.ParseDuration(sinceStr); err == nil {
-				since = d
+		// Parse since duration; a run ID looks up the run whenever it was queued
+		var sinceTime time.Time
+		if runID == "" || sinceStr != "" {
+			since := time.Hour
+			if sinceStr != "" {
+				if d, err := time.ParseDuration(sinceStr); err == nil {
+					since = d
+				}
 			}
+			sinceTime = time.Now().Add(-since)
 		}
 
 		// Parse limit
@@ -414,45 +1263,42 @@ func makeLogsHandler(cfg *configs.Config) http.HandlerFunc {
 			}
 		}
 
-		// Create log client
-		ctx := r.Context()
-		logClient, err := tasks.NewLogClient(ctx, cfg.GCPProjectID, cfg.CloudRunService)
-		if err != nil {
-			logger.Error("failed to create log client", zap.Error(err))
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-			return
-		}
-		defer func() { _ = logClient.Close() }()
-
-		// Query logs
-		logs, err := logClient.QueryLogs(ctx, tasks.LogQuery{
-			ProjectID:   cfg.GCPProjectID,
-			ServiceName: cfg.CloudRunService,
-			Pipeline:    pipeline,
-			Severity:    severity,
-			Since:       since,
-			Limit:       limit,
+		history, err := runs.History(r.Context(), pipelines.RunFilter{
+			ID:       runID,
+			Pipeline: pipeline,
+			Status:   pipelines.RunStatus(status),
+			Since:    sinceTime,
+			Limit:    limit,
 		})
 		if err != nil {
-			logger.Error("failed to query logs", zap.Error(err))
+			logger.Error("failed to query run history", zap.Error(err))
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 			return
 		}
 
-		// Group logs by pipeline run
-		runs := tasks.GroupByRun(logs, cfg.GCPProjectID, cfg.CloudRunService)
+		// Deep link each run to Cloud Logging, if configured
+		result := make([]runHistoryEntry, len(history))
+		for i, run := range history {
+			result[i] = runHistoryEntry{Run: run}
+			if cfg.GCPProjectID != "" && cfg.CloudRunService != "" {
+				start := run.QueuedAt
+				if run.StartedAt != nil {
+					start = *run.StartedAt
+				}
+				result[i].LogsURL = tasks.BuildLogsURL(cfg.GCPProjectID, cfg.CloudRunService, run.ID, start)
+			}
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(logsResponse{
-			Runs:  runs,
-			Count: len(runs),
+			Runs:  result,
+			Count: len(result),
 			Query: map[string]any{
+				"run_id":   runID,
 				"pipeline": pipeline,
-				"severity": severity,
+				"status":   status,
 				"since":    sinceStr,
 				"limit":    limit,
 			},
@@ -465,7 +1311,7 @@ func makeUILogsHandler(tmpl *template.Template, cfg *configs.Config) http.Handle
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		_ = tmpl.ExecuteTemplate(w, "logs.html", map[string]any{
-			"Configured":  cfg.GCPProjectID != "" && cfg.CloudRunService != "",
+			"GCPLinks":    cfg.GCPProjectID != "" && cfg.CloudRunService != "",
 			"ProjectID":   cfg.GCPProjectID,
 			"ServiceName": cfg.CloudRunService,
 			"Pipelines":   getPipelineNames(),
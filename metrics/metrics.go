@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Pipeline execution metrics, recorded by pipelines.Flow.
+var (
+	FlowRuns = NewCounterVec("hudsci_flow_runs_total",
+		"Pipeline flow runs by outcome (succeeded, failed, cancelled).",
+		"pipeline", "outcome")
+	FlowDuration = NewHistogramVec("hudsci_flow_duration_seconds",
+		"Pipeline flow run duration in seconds.",
+		nil, "pipeline", "outcome")
+	StepRuns = NewCounterVec("hudsci_step_runs_total",
+		"Pipeline step runs by outcome (succeeded, failed, cancelled, skipped, restored).",
+		"pipeline", "step", "outcome")
+	StepDuration = NewHistogramVec("hudsci_step_duration_seconds",
+		"Pipeline step duration in seconds, including retries.",
+		nil, "pipeline", "step", "outcome")
+	StepRetries = NewCounterVec("hudsci_step_retries_total",
+		"Step attempts after the first.",
+		"pipeline", "step")
+)
+
+// Integration metrics, recorded by the tasks package.
+var (
+	TrustMedSubmissions = NewCounterVec("hudsci_trustmed_submissions_total",
+		"TrustMed EPCIS submissions by HTTP status (\"error\" when no response).",
+		"status")
+	TrustMedSubmitDuration = NewHistogramVec("hudsci_trustmed_submit_duration_seconds",
+		"TrustMed EPCIS submission latency in seconds.",
+		nil)
+	HTTPClientDuration = NewHistogramVec("hudsci_http_client_request_duration_seconds",
+		"Outbound HTTP request latency in seconds by service, route and status.",
+		nil, "service", "method", "route", "status")
+	HTTPClientErrors = NewCounterVec("hudsci_http_client_errors_total",
+		"Outbound HTTP requests that failed without a response or returned 5xx.",
+		"service", "method", "route")
+)
+
+// Business outcome metrics.
+var (
+	InboundFiles = NewCounterVec("hudsci_inbound_files_total",
+		"Inbound EPCIS files by outcome (converted, conversion_failed, uploaded).",
+		"outcome")
+	DispatchOutcomes = NewCounterVec("hudsci_dispatch_outcomes_total",
+		"Outbound dispatch results by outcome (sent, retrying, failed).",
+		"outcome")
+)
+
+// RouteFunc maps a request to a low-cardinality route label.
+type RouteFunc func(*http.Request) string
+
+// InstrumentTransport wraps base (http.DefaultTransport if nil) so every
+// request records HTTPClientDuration and HTTPClientErrors for service.
+func InstrumentTransport(service string, base http.RoundTripper, route RouteFunc) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &instrumentedTransport{service: service, base: base, route: route}
+}
+
+type instrumentedTransport struct {
+	service string
+	base    http.RoundTripper
+	route   RouteFunc
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := req.URL.Path
+	if t.route != nil {
+		route = t.route(req)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start).Seconds()
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	HTTPClientDuration.Observe(elapsed, t.service, req.Method, route, status)
+	if err != nil || resp.StatusCode >= 500 {
+		HTTPClientErrors.Inc(t.service, req.Method, route)
+	}
+	return resp, err
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test counter.", "pipeline", "outcome")
+	c.Inc("outbound", "failed")
+	c.Add(2, "inbound", "succeeded")
+	c.Inc("inbound", "succeeded")
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{pipeline="inbound",outcome="succeeded"} 3
+test_total{pipeline="outbound",outcome="failed"} 1
+`
+	if b.String() != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestHistogramText(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("test_seconds", "Test histogram.", []float64{1, 0.1}, "step")
+	h.Observe(0.05, "poll")
+	h.Observe(0.5, "poll")
+	h.Observe(5, "poll")
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+
+	want := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{step="poll",le="0.1"} 1
+test_seconds_bucket{step="poll",le="1"} 2
+test_seconds_bucket{step="poll",le="+Inf"} 3
+test_seconds_sum{step="poll"} 5.55
+test_seconds_count{step="poll"} 3
+`
+	if b.String() != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestUnlabelledHistogramText(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{1})
+	h.Observe(0.5)
+
+	var b strings.Builder
+	_ = r.WriteText(&b)
+	if !strings.Contains(b.String(), `latency_seconds_bucket{le="1"} 1`) ||
+		!strings.Contains(b.String(), "latency_seconds_count 1\n") {
+		t.Errorf("WriteText() =\n%s", b.String())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("escape_total", "Line one\nline two.", "error")
+	c.Inc("say \"hi\"\\\n")
+
+	var b strings.Builder
+	_ = r.WriteText(&b)
+	if !strings.Contains(b.String(), `# HELP escape_total Line one\nline two.`) {
+		t.Errorf("help not escaped:\n%s", b.String())
+	}
+	if !strings.Contains(b.String(), `escape_total{error="say \"hi\"\\\n"} 1`) {
+		t.Errorf("label not escaped:\n%s", b.String())
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("dup_total", "First.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	r.NewCounterVec("dup_total", "Second.")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("handler_total", "Handler test.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler()(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "handler_total 1\n") {
+		t.Errorf("body =\n%s", rec.Body.String())
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestInstrumentTransport(t *testing.T) {
+	transport := InstrumentTransport("test_service", roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/ok":
+			return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+		case "/broken":
+			return &http.Response{StatusCode: 503, Body: http.NoBody}, nil
+		}
+		return nil, errors.New("connection refused")
+	}), nil)
+	client := &http.Client{Transport: transport}
+
+	for _, path := range []string{"/ok", "/broken", "/down"} {
+		resp, err := client.Get("http://example.test" + path)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	if got := HTTPClientDuration.Count("test_service", "GET", "/ok", "200"); got != 1 {
+		t.Errorf("/ok observations = %d, want 1", got)
+	}
+	if got := HTTPClientErrors.Value("test_service", "GET", "/ok"); got != 0 {
+		t.Errorf("/ok errors = %v, want 0", got)
+	}
+	if got := HTTPClientErrors.Value("test_service", "GET", "/broken"); got != 1 {
+		t.Errorf("/broken errors = %v, want 1", got)
+	}
+	if got := HTTPClientDuration.Count("test_service", "GET", "/down", "error"); got != 1 {
+		t.Errorf("/down observations = %d, want 1", got)
+	}
+	if got := HTTPClientErrors.Value("test_service", "GET", "/down"); got != 1 {
+		t.Errorf("/down errors = %v, want 1", got)
+	}
+}
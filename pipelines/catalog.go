@@ -0,0 +1,126 @@
+package pipelines
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// CatalogTask is a task function registered for use as a step of pipelines
+// defined in YAML (see LoadDefinitions). Input and Output name the Go types
+// it consumes and produces; a step's input must come from an earlier step
+// producing the same type.
+type CatalogTask struct {
+	Name         string       `json:"name"`
+	Description  string       `json:"description"`
+	Input        string       `json:"input,omitempty"`  // empty for sources
+	Output       string       `json:"output,omitempty"` // empty for sinks
+	Dependencies []Dependency `json:"dependencies,omitempty"`
+
+	in, out reflect.Type
+	// add adds the task to f as step, reading its input from and storing
+	// its output in outputs (Output name -> *Output[T]).
+	add func(f *Flow, env Env, step string, outputs map[string]any, in, out string, after []string)
+}
+
+var (
+	catalogMu sync.RWMutex
+	catalog   = make(map[string]CatalogTask)
+)
+
+// RegisterSource adds a task with no input that produces an Out.
+func RegisterSource[Out any](name, description string, fn func(ctx context.Context, env Env) (Out, error), deps ...Dependency) {
+	registerTask(CatalogTask{
+		Name:         name,
+		Description:  description,
+		Dependencies: deps,
+		out:          reflect.TypeFor[Out](),
+		add: func(f *Flow, env Env, step string, outputs map[string]any, _, out string, after []string) {
+			o := NewOutput[Out](out)
+			outputs[out] = o
+			Source(f, step, o, func(ctx context.Context) (Out, error) { return fn(ctx, env) }, after...)
+		},
+	})
+}
+
+// RegisterTask adds a task that computes an Out from an In.
+func RegisterTask[In, Out any](name, description string, fn func(ctx context.Context, env Env, in In) (Out, error), deps ...Dependency) {
+	registerTask(CatalogTask{
+		Name:         name,
+		Description:  description,
+		Dependencies: deps,
+		in:           reflect.TypeFor[In](),
+		out:          reflect.TypeFor[Out](),
+		add: func(f *Flow, env Env, step string, outputs map[string]any, in, out string, after []string) {
+			o := NewOutput[Out](out)
+			i := inputOf[In](f, step, outputs, in)
+			outputs[out] = o
+			Task(f, step, i, o, func(ctx context.Context, v In) (Out, error) { return fn(ctx, env, v) }, after...)
+		},
+	})
+}
+
+// RegisterSink adds a task that consumes an In without producing an output.
+func RegisterSink[In any](name, description string, fn func(ctx context.Context, env Env, in In) error, deps ...Dependency) {
+	registerTask(CatalogTask{
+		Name:         name,
+		Description:  description,
+		Dependencies: deps,
+		in:           reflect.TypeFor[In](),
+		add: func(f *Flow, env Env, step string, outputs map[string]any, in, _ string, after []string) {
+			i := inputOf[In](f, step, outputs, in)
+			Sink(f, step, i, func(ctx context.Context, v In) error { return fn(ctx, env, v) }, after...)
+		},
+	})
+}
+
+// inputOf returns the Output named in, or an unproduced placeholder that
+// Validate reports if no earlier step produces an In under that name.
+func inputOf[In any](f *Flow, step string, outputs map[string]any, in string) *Output[In] {
+	if o, ok := outputs[in].(*Output[In]); ok {
+		return o
+	}
+	if _, ok := outputs[in]; ok {
+		f.errs = append(f.errs, fmt.Errorf("step %q: input %q is not a %s", step, in, reflect.TypeFor[In]()))
+	}
+	return NewOutput[In](in)
+}
+
+// registerTask adds t to the catalogue. Tasks register themselves from init,
+// so it panics on a name registered twice.
+func registerTask(t CatalogTask) {
+	if t.in != nil {
+		t.Input = t.in.String()
+	}
+	if t.out != nil {
+		t.Output = t.out.String()
+	}
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	if _, ok := catalog[t.Name]; ok {
+		panic(fmt.Sprintf("pipelines: task %s registered twice", t.Name))
+	}
+	catalog[t.Name] = t
+}
+
+// LookupTask returns the catalogue task called name.
+func LookupTask(name string) (CatalogTask, bool) {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	t, ok := catalog[name]
+	return t, ok
+}
+
+// Catalog returns the registered tasks sorted by name.
+func Catalog() []CatalogTask {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	ts := make([]CatalogTask, 0, len(catalog))
+	for _, t := range catalog {
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Name < ts[j].Name })
+	return ts
+}
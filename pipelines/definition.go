@@ -0,0 +1,191 @@
+package pipelines
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"gopkg.in/yaml.v3"
+)
+
+// Definition is a pipeline declared in YAML and composed from catalogue
+// tasks (see RegisterSource, RegisterTask and RegisterSink):
+//
+//	name: inbound_no_archive
+//	description: Receive EPCIS files into the inbox without archiving JSON
+//	schedule: "*/15 * * * *"
+//	retry: attempts:4
+//	params:
+//	  - {name: since, type: time, description: Poll files received from this time}
+//	steps:
+//	  - {name: poll, task: poll_trustmed_files, output: xml_files}
+//	  - {name: extract, task: extract_shipment_data, input: xml_files, output: inbox_items}
+//	  - {name: insert, task: insert_epcis_inbox, input: inbox_items, retry: "attempts:5,delay:10s"}
+type Definition struct {
+	Name        string           `yaml:"name"`
+	Description string           `yaml:"description"`
+	Schedule    string           `yaml:"schedule"` // default schedule
+	Retry       string           `yaml:"retry"`    // ParseRetryPolicy spec for every step
+	Params      []ParamSpec      `yaml:"params"`   // only_steps is always accepted
+	Steps       []StepDefinition `yaml:"steps"`
+}
+
+// StepDefinition is one step of a Definition.
+type StepDefinition struct {
+	Name   string   `yaml:"name"`
+	Task   string   `yaml:"task"`   // catalogue task
+	Input  string   `yaml:"input"`  // output of an earlier step; required unless the task is a source
+	Output string   `yaml:"output"` // required unless the task is a sink
+	After  []string `yaml:"after"`  // extra steps to wait for
+	Retry  string   `yaml:"retry"`  // ParseRetryPolicy spec on top of the pipeline's
+}
+
+// paramTypes are the ParamSpec types a definition may use.
+var paramTypes = []string{ParamString, ParamBool, ParamStringList, ParamTime}
+
+// LoadDefinitions reads the *.yaml and *.yml files in dir, in name order,
+// and returns their pipelines, ready to Register. Any invalid file fails the
+// whole load.
+func LoadDefinitions(dir string) ([]Pipeline, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	var ps []Pipeline
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		p, err := ParseDefinition(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if i := slices.IndexFunc(ps, func(q Pipeline) bool { return q.Name == p.Name }); i >= 0 {
+			return nil, fmt.Errorf("%s: pipeline %q is defined twice", file, p.Name)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// ParseDefinition decodes and validates one YAML definition. Unknown fields
+// are errors, so typos don't silently drop settings.
+func ParseDefinition(data []byte) (Pipeline, error) {
+	var d Definition
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&d); err != nil {
+		return Pipeline{}, fmt.Errorf("decoding definition: %w", err)
+	}
+	return d.Pipeline()
+}
+
+// Pipeline validates the definition and returns it as a Pipeline whose flow
+// is built from the catalogue tasks. Its dependencies are those of its
+// tasks.
+func (d Definition) Pipeline() (Pipeline, error) {
+	if d.Name == "" {
+		return Pipeline{}, errors.New("name is required")
+	}
+	if len(d.Steps) == 0 {
+		return Pipeline{}, fmt.Errorf("pipeline %q has no steps", d.Name)
+	}
+	if d.Schedule != "" && d.Schedule != ManualSchedule {
+		if _, err := ParseSchedule(d.Schedule); err != nil {
+			return Pipeline{}, fmt.Errorf("pipeline %q: schedule: %w", d.Name, err)
+		}
+	}
+	base, err := ParseRetryPolicy(DefaultRetryPolicy, d.Retry)
+	if err != nil {
+		return Pipeline{}, fmt.Errorf("pipeline %q: retry: %w", d.Name, err)
+	}
+
+	params := slices.Clone(d.Params)
+	for _, spec := range params {
+		if spec.Name == "" || !slices.Contains(paramTypes, spec.Type) {
+			return Pipeline{}, fmt.Errorf("pipeline %q: param %q: type must be one of %v", d.Name, spec.Name, paramTypes)
+		}
+		if spec.Requires != "" && !slices.ContainsFunc(params, func(s ParamSpec) bool { return s.Name == spec.Requires }) {
+			return Pipeline{}, fmt.Errorf("pipeline %q: param %q requires undeclared param %q", d.Name, spec.Name, spec.Requires)
+		}
+	}
+	if !slices.ContainsFunc(params, func(s ParamSpec) bool { return s.Name == OnlyStepsParam }) {
+		params = append(params, OnlyStepsSpec)
+	}
+
+	var deps []Dependency
+	policies := make(map[string]RetryPolicy, len(d.Steps))
+	for _, step := range d.Steps {
+		task, ok := LookupTask(step.Task)
+		if step.Name == "" || !ok {
+			return Pipeline{}, fmt.Errorf("pipeline %q: step %q: unknown task %q", d.Name, step.Name, step.Task)
+		}
+		if (task.in == nil) != (step.Input == "") {
+			return Pipeline{}, fmt.Errorf("pipeline %q: step %q: task %s takes %s", d.Name, step.Name, task.Name, describeType(task.Input))
+		}
+		if (task.out == nil) != (step.Output == "") {
+			return Pipeline{}, fmt.Errorf("pipeline %q: step %q: task %s produces %s", d.Name, step.Name, task.Name, describeType(task.Output))
+		}
+		policy, err := ParseRetryPolicy(base, step.Retry)
+		if err != nil {
+			return Pipeline{}, fmt.Errorf("pipeline %q: step %q: retry: %w", d.Name, step.Name, err)
+		}
+		policies[step.Name] = policy
+		for _, dep := range task.Dependencies {
+			if !slices.Contains(deps, dep) {
+				deps = append(deps, dep)
+			}
+		}
+	}
+
+	p := Pipeline{
+		Name:        d.Name,
+		Description: d.Description,
+		Schedule:    d.Schedule,
+		Params:      params,
+		Flow: func(_ context.Context, env Env) *Flow {
+			return d.flow(env, policies)
+		},
+		Dependencies: deps,
+	}
+	if err := d.flow(Env{Config: &configs.Config{}}, policies).Validate(); err != nil {
+		return Pipeline{}, err
+	}
+	return p, nil
+}
+
+// flow builds the definition's flow. Steps were checked against the
+// catalogue by Pipeline; Validate reports the graph errors.
+func (d Definition) flow(env Env, policies map[string]RetryPolicy) *Flow {
+	flow := NewFlow(d.Name).
+		WithConcurrency(env.Config.StepConcurrency).
+		WithRetryOverrides(env.Config.RetryPolicies)
+	outputs := make(map[string]any)
+	for _, step := range d.Steps {
+		task, _ := LookupTask(step.Task)
+		task.add(flow, env, step.Name, outputs, step.Input, step.Output, step.After)
+		flow.WithRetry(step.Name, policies[step.Name])
+	}
+	return flow
+}
+
+// describeType describes a task's input or output type for errors.
+func describeType(t string) string {
+	if t == "" {
+		return "nothing"
+	}
+	return t
+}
@@ -0,0 +1,147 @@
+package pipelines
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+)
+
+var (
+	registerTestTasks sync.Once
+	testTaskResults   = make(map[string]int) // step -> value received by def_test_record
+	testTaskMu        sync.Mutex
+)
+
+// testCatalog registers the tasks the definition tests compose.
+func testCatalog() {
+	registerTestTasks.Do(func() {
+		RegisterSource("def_test_numbers", "Produce 1, 2, 3", func(context.Context, Env) ([]int, error) {
+			return []int{1, 2, 3}, nil
+		})
+		RegisterTask("def_test_sum", "Sum numbers", func(_ context.Context, _ Env, in []int) (int, error) {
+			sum := 0
+			for _, n := range in {
+				sum += n
+			}
+			return sum, nil
+		}, DependencyDB)
+		RegisterSink("def_test_record", "Record a number under the step name", func(ctx context.Context, _ Env, in int) error {
+			testTaskMu.Lock()
+			defer testTaskMu.Unlock()
+			testTaskResults[StepName(ctx)] = in
+			return nil
+		}, DependencyDB, DependencyTrustMed)
+	})
+}
+
+func TestParseDefinition(t *testing.T) {
+	testCatalog()
+
+	p, err := ParseDefinition([]byte(`
+name: def-test
+description: Sum and record twice
+schedule: "@hourly"
+retry: attempts:2
+params:
+  - {name: since, type: time, description: Start of the window}
+steps:
+  - {name: numbers, task: def_test_numbers, output: nums}
+  - {name: sum, task: def_test_sum, input: nums, output: total}
+  - {name: record_a, task: def_test_record, input: total}
+  - {name: record_b, task: def_test_record, input: total, after: [record_a], retry: "attempts:5"}
+`))
+	if err != nil {
+		t.Fatalf("ParseDefinition() error = %v", err)
+	}
+
+	if p.Name != "def-test" || p.Description != "Sum and record twice" || p.Schedule != "@hourly" {
+		t.Errorf("ParseDefinition() = %+v", p)
+	}
+	if !slices.Equal(p.Dependencies, []Dependency{DependencyDB, DependencyTrustMed}) {
+		t.Errorf("Dependencies = %v, want the union of the tasks'", p.Dependencies)
+	}
+	if !slices.ContainsFunc(p.Params, func(s ParamSpec) bool { return s.Name == OnlyStepsParam }) {
+		t.Errorf("Params = %v, want only_steps added", p.Params)
+	}
+	if steps := p.Steps(); !slices.Equal(steps, []string{"numbers", "sum", "record_a", "record_b"}) {
+		t.Errorf("Steps() = %v", steps)
+	}
+
+	if err := p.Run(context.Background(), Env{Config: &configs.Config{}}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	testTaskMu.Lock()
+	defer testTaskMu.Unlock()
+	if testTaskResults["record_a"] != 6 || testTaskResults["record_b"] != 6 {
+		t.Errorf("recorded %v, want 6 from both sink steps", testTaskResults)
+	}
+}
+
+func TestParseDefinitionErrors(t *testing.T) {
+	testCatalog()
+
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"unknown field", "name: x\nschedul: '@hourly'\nsteps: [{name: a, task: def_test_numbers, output: n}]", "field schedul not found"},
+		{"no name", "steps: [{name: a, task: def_test_numbers, output: n}]", "name is required"},
+		{"no steps", "name: x", "has no steps"},
+		{"unknown task", "name: x\nsteps: [{name: a, task: nope, output: n}]", `unknown task "nope"`},
+		{"source with input", "name: x\nsteps: [{name: a, task: def_test_numbers, input: n, output: m}]", "takes nothing"},
+		{"sink with output", "name: x\nsteps: [{name: a, task: def_test_numbers, output: n}, {name: b, task: def_test_sum, input: n, output: t}, {name: c, task: def_test_record, input: t, output: u}]", "produces nothing"},
+		{"type mismatch", "name: x\nsteps: [{name: a, task: def_test_numbers, output: n}, {name: b, task: def_test_record, input: n}]", `input "n" is not a int`},
+		{"unproduced input", "name: x\nsteps: [{name: a, task: def_test_numbers, output: n}, {name: b, task: def_test_sum, input: m, output: t}]", `"m"`},
+		{"bad schedule", "name: x\nschedule: soon\nsteps: [{name: a, task: def_test_numbers, output: n}]", "schedule"},
+		{"bad retry", "name: x\nsteps: [{name: a, task: def_test_numbers, output: n, retry: 'tries:3'}]", "retry"},
+		{"bad param type", "name: x\nparams: [{name: p, type: int}]\nsteps: [{name: a, task: def_test_numbers, output: n}]", "type must be one of"},
+		{"undeclared requires", "name: x\nparams: [{name: p, type: string, requires: q}]\nsteps: [{name: a, task: def_test_numbers, output: n}]", `undeclared param "q"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDefinition([]byte(tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseDefinition() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadDefinitions(t *testing.T) {
+	testCatalog()
+
+	dir := t.TempDir()
+	write := func(file, pipeline string) {
+		data := "name: " + pipeline + "\nsteps: [{name: a, task: def_test_numbers, output: n}]\n"
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("b.yml", "second")
+	write("a.yaml", "first")
+	write("notes.txt", "ignored")
+
+	ps, err := LoadDefinitions(dir)
+	if err != nil {
+		t.Fatalf("LoadDefinitions() error = %v", err)
+	}
+	var names []string
+	for _, p := range ps {
+		names = append(names, p.Name)
+	}
+	if !slices.Equal(names, []string{"first", "second"}) {
+		t.Errorf("LoadDefinitions() = %v, want first, second", names)
+	}
+
+	write("c.yaml", "first")
+	if _, err := LoadDefinitions(dir); err == nil || !strings.Contains(err.Error(), "defined twice") {
+		t.Errorf("LoadDefinitions() error = %v, want a duplicate name error", err)
+	}
+}
@@ -0,0 +1,101 @@
+package pipelines
+
+import "time"
+
+// Run event types streamed to subscribers while a run executes.
+const (
+	EventRunStarted    = "run_started"
+	EventStepStarted   = "step_started"
+	EventStepRetrying  = "step_retrying"
+	EventStepProgress  = "step_progress"
+	EventStepCompleted = "step_completed"
+	EventStepFailed    = "step_failed"
+	EventStepSkipped   = "step_skipped"
+	EventStepCancelled = "step_cancelled"
+	EventStepRestored  = "step_restored"
+	EventRunFinished   = "run_finished"
+)
+
+// RunEvent is a single change to a run, published as it happens.
+type RunEvent struct {
+	Type    string    `json:"type"`
+	RunID   string    `json:"run_id"`
+	Step    string    `json:"step,omitempty"`
+	Attempt int       `json:"attempt,omitempty"`
+	Done    int       `json:"done,omitempty"`  // progress: items handled so far
+	Total   int       `json:"total,omitempty"` // progress: items in total, 0 if unknown
+	Message string    `json:"message,omitempty"`
+	Status  RunStatus `json:"status,omitempty"` // run status, for run events
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// further events are dropped for it.
+const subscriberBuffer = 64
+
+// publishLocked sends ev to every subscriber without blocking; a subscriber whose
+// buffer is full misses the event. Caller holds mu.
+func (s *runState) publishLocked(ev RunEvent) {
+	ev.RunID = s.run.ID
+	ev.Time = time.Now()
+	for _, ch := range s.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// closeSubscribersLocked ends every subscription. Caller holds mu.
+func (s *runState) closeSubscribersLocked() {
+	for _, ch := range s.subs {
+		close(ch)
+	}
+	s.subs = nil
+}
+
+// stepProgress publishes item-level progress for a running step.
+func (s *runState) stepProgress(name string, done, total int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publishLocked(RunEvent{Type: EventStepProgress, Step: name, Done: done, Total: total, Message: message})
+}
+
+// Subscribe returns a snapshot of an in-memory run and a channel of the
+// events that follow it. The channel is closed after the run_finished event,
+// or immediately if the run has already finished. Call unsubscribe when done
+// reading. Subscribers that fall behind miss events rather than slowing the
+// run. ok is false if the run is not in memory on this instance.
+func (m *RunManager) Subscribe(id string) (run Run, events <-chan RunEvent, unsubscribe func(), ok bool) {
+	m.mu.Lock()
+	state, ok := m.runs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Run{}, nil, func() {}, false
+	}
+
+	// Snapshot and subscribe together so no event falls between them.
+	ch := make(chan RunEvent, subscriberBuffer)
+	state.mu.Lock()
+	run = state.snapshotLocked()
+	if run.Done() {
+		close(ch)
+	} else {
+		state.subs = append(state.subs, ch)
+	}
+	state.mu.Unlock()
+
+	unsubscribe = func() {
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		for i, sub := range state.subs {
+			if sub == ch {
+				state.subs = append(state.subs[:i], state.subs[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}
+	return run, ch, unsubscribe, true
+}
@@ -0,0 +1,93 @@
+package pipelines
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/trackvision/tv-pipelines-hudsci/progress"
+)
+
+func TestRunManagerSubscribe(t *testing.T) {
+	m := NewRunManager(10, nil)
+	release := make(chan struct{})
+
+	ctx := context.WithValue(context.Background(), SkipStepsKey, []string{"notify"})
+	_, err := m.Submit(ctx, RunSpec{Pipeline: "test", ID: "run-1", Steps: []string{"dispatch", "notify"}}, func(ctx context.Context) error {
+		<-release
+		flow := NewFlow("test")
+		flow.AddTask("dispatch", func(ctx context.Context) error {
+			progress.Report(ctx, 1, 2, "dispatching")
+			progress.Report(ctx, 2, 2, "dispatching")
+			return nil
+		})
+		flow.AddTask("notify", func(context.Context) error { return nil }, "dispatch")
+		return flow.Run(ctx)
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	snapshot, events, unsubscribe, ok := m.Subscribe("run-1")
+	defer unsubscribe()
+	if !ok {
+		t.Fatal("Subscribe() ok = false, want true")
+	}
+	if snapshot.ID != "run-1" || len(snapshot.Steps) != 2 {
+		t.Errorf("snapshot = %+v, want run-1 with 2 steps", snapshot)
+	}
+	close(release)
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case ev, open := <-events:
+			if !open {
+				done = true
+				break
+			}
+			if ev.RunID != "run-1" {
+				t.Errorf("event run ID = %q, want run-1", ev.RunID)
+			}
+			got = append(got, ev.Type+" "+ev.Step)
+			if ev.Type == EventStepProgress && (ev.Total != 2 || ev.Message != "dispatching") {
+				t.Errorf("progress event = %+v, want total 2, message dispatching", ev)
+			}
+			if ev.Type == EventRunFinished && ev.Status != StatusSucceeded {
+				t.Errorf("run_finished status = %s, want %s", ev.Status, StatusSucceeded)
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for events; got %v", got)
+		}
+	}
+
+	want := []string{
+		"step_started dispatch",
+		"step_progress dispatch",
+		"step_progress dispatch",
+		"step_completed dispatch",
+		"step_skipped notify",
+		"run_finished ",
+	}
+	// run_started may precede the subscription.
+	got = slices.DeleteFunc(got, func(s string) bool { return s == "run_started " })
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	// A finished run yields its final state and a closed channel.
+	final, events, unsubscribe2, ok := m.Subscribe("run-1")
+	defer unsubscribe2()
+	if !ok || final.Status != StatusSucceeded {
+		t.Errorf("Subscribe() finished run = %s, %v, want succeeded, true", final.Status, ok)
+	}
+	if _, open := <-events; open {
+		t.Error("events channel of finished run is open, want closed")
+	}
+
+	if _, _, _, ok := m.Subscribe("missing"); ok {
+		t.Error("Subscribe() unknown run ok = true, want false")
+	}
+}
@@ -2,10 +2,18 @@ package pipelines
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/fieldryand/goflow/v2"
+	"github.com/trackvision/tv-pipelines-hudsci/progress"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
 	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 )
@@ -16,12 +24,43 @@ type ContextKey string
 // SkipStepsKey is the context key for skip steps.
 const SkipStepsKey ContextKey = "skip_steps"
 
+// InputsKey is the context key for input overrides: a
+// map[string]json.RawMessage from Output name to the value to use when the
+// step producing it is skipped.
+const InputsKey ContextKey = "inputs"
+
+// stepKey is the context key for the name of the running step.
+const stepKey ContextKey = "step"
+
+// DefaultConcurrency is how many steps of a flow may run at once unless
+// WithConcurrency says otherwise.
+const DefaultConcurrency = 4
+
+// errStepFailed is the cause a flow cancels its context with when a step
+// fails, so steps still running are recorded as cancelled.
+var errStepFailed = errors.New("another step failed")
+
+// TaskFunc is the body of a step. ctx is cancelled when the run is cancelled
+// or another step fails; it also carries the step's progress reporter.
+type TaskFunc func(ctx context.Context) error
+
 // Flow provides a fluent API for building and running pipelines.
 type Flow struct {
-	job       *goflow.Job
-	taskOrder []string
-	tasks     map[string]*goflow.Task
-	name      string
+	job         *goflow.Job
+	taskOrder   []string
+	tasks       map[string]*goflow.Task
+	deps        map[string][]string
+	checkpoints map[string]any      // step -> pointer to the step's output
+	inputs      map[string][]string // step -> names of the Outputs it consumes
+	outputs     map[string]string   // step -> name of the Output it produces
+	producers   map[string]string   // Output name -> step
+	values      map[string]any      // Output name -> pointer to its value
+	errs        []error             // graph errors, reported by Validate
+	policies    map[string]RetryPolicy
+	overrides   map[string]string // retry overrides by "pipeline" or "pipeline.step"
+	listeners   []Listener
+	concurrency int
+	name        string
 }
 
 // NewFlow creates a new pipeline flow.
@@ -29,22 +68,59 @@ func NewFlow(name string) *Flow {
 	return &Flow{
 		job: &goflow.Job{
 			Name:     name,
-			Schedule: "@manual",
+			Schedule: ManualSchedule,
 			Active:   true,
 		},
-		tasks: make(map[string]*goflow.Task),
-		name:  name,
+		tasks:       make(map[string]*goflow.Task),
+		deps:        make(map[string][]string),
+		checkpoints: make(map[string]any),
+		inputs:      make(map[string][]string),
+		outputs:     make(map[string]string),
+		producers:   make(map[string]string),
+		values:      make(map[string]any),
+		policies:    make(map[string]RetryPolicy),
+		listeners:   []Listener{LoggingListener{}, MetricsListener{}, historyListener{}, tracingListener{}},
+		concurrency: DefaultConcurrency,
+		name:        name,
+	}
+}
+
+// WithSchedule sets the cron schedule reported on the underlying goflow Job.
+// Empty specs leave the flow as "@manual".
+func (f *Flow) WithSchedule(spec string) *Flow {
+	if spec != "" {
+		f.job.Schedule = spec
 	}
+	return f
 }
 
-// AddTask adds a task to the flow. Dependencies are specified by name.
+// WithConcurrency limits how many independent steps run at once. Values
+// below 1 keep the default.
+func (f *Flow) WithConcurrency(n int) *Flow {
+	if n > 0 {
+		f.concurrency = n
+	}
+	return f
+}
+
+// WithListener adds a listener for the flow's lifecycle events, after the
+// logging, metrics, run history and tracing listeners every flow has.
+func (f *Flow) WithListener(l Listener) *Flow {
+	f.listeners = append(f.listeners, l)
+	return f
+}
+
+// AddTask adds a task to the flow. Dependencies are specified by name and
+// must already have been added; Validate reports unknown names and steps
+// added twice.
 // Example: flow.AddTask("process", processFunc, "fetch1", "fetch2")
-func (f *Flow) AddTask(name string, fn func() error, deps ...string) *Flow {
+func (f *Flow) AddTask(name string, fn TaskFunc, deps ...string) *Flow {
+	if _, ok := f.tasks[name]; ok {
+		f.errs = append(f.errs, fmt.Errorf("step %q is added twice", name))
+	}
 	task := &goflow.Task{
-		Name:       name,
-		Operator:   taskFunc(fn),
-		Retries:    2,
-		RetryDelay: goflow.ConstantDelay{Period: 5},
+		Name:     name,
+		Operator: taskFunc(fn),
 	}
 
 	f.job.Add(task)
@@ -53,94 +129,409 @@ func (f *Flow) AddTask(name string, fn func() error, deps ...string) *Flow {
 
 	// Set up dependencies
 	for _, dep := range deps {
-		if depTask, ok := f.tasks[dep]; ok {
-			f.job.SetDownstream(depTask, task)
+		depTask, ok := f.tasks[dep]
+		if !ok {
+			f.errs = append(f.errs, fmt.Errorf("step %q: depends on unknown step %q", name, dep))
+			continue
 		}
+		f.job.SetDownstream(depTask, task)
+		f.deps[name] = append(f.deps[name], dep)
 	}
 
 	return f
 }
 
-// Run executes the pipeline synchronously with comprehensive logging.
-func (f *Flow) Run(ctx context.Context) error {
-	startTime := time.Now()
+// WithRetry sets the retry policy for step, replacing DefaultRetryPolicy.
+func (f *Flow) WithRetry(step string, policy RetryPolicy) *Flow {
+	f.policies[step] = policy
+	return f
+}
 
-	// Build task name list for logging
-	taskNames := append([]string{}, f.taskOrder...)
+// WithRetryOverrides applies operator overrides (STEP_RETRY_POLICIES) on top
+// of the flow's policies. Keys are the flow name, which applies to every
+// step, or "flow.step"; values are ParseRetryPolicy specs. The step-level
+// override wins.
+func (f *Flow) WithRetryOverrides(overrides map[string]string) *Flow {
+	f.overrides = overrides
+	return f
+}
 
-	// Get skip steps from context
-	skipSteps := getSkipStepsFromContext(ctx)
+// retryPolicy resolves step's policy: DefaultRetryPolicy or the step's own,
+// then the flow-wide override, then the step override.
+func (f *Flow) retryPolicy(step string) RetryPolicy {
+	policy, ok := f.policies[step]
+	if !ok {
+		policy = DefaultRetryPolicy
+	}
+	for _, key := range []string{f.name, f.name + "." + step} {
+		spec, ok := f.overrides[key]
+		if !ok {
+			continue
+		}
+		p, err := ParseRetryPolicy(policy, spec)
+		if err != nil {
+			logger.Warn("Ignoring invalid retry policy override",
+				zap.String("key", key),
+				zap.Error(err))
+			continue
+		}
+		policy = p
+	}
+	policy.MaxAttempts = max(policy.MaxAttempts, 1)
+	return policy
+}
 
-	logger.Info("flow started",
-		zap.String("pipeline", f.name),
-		zap.Int("task_count", len(f.taskOrder)),
-		zap.Strings("steps", taskNames),
-		zap.Int("skip_count", len(skipSteps)))
+// Checkpoint saves the value v points to when step succeeds, so a run that
+// resumes this one restores it instead of running step again. v must
+// round-trip through JSON. Completed steps without a checkpoint are assumed
+// to have no output later steps need. Steps added with Source or Task
+// checkpoint their Output already.
+// Example: flow.Checkpoint("poll", &files)
+func (f *Flow) Checkpoint(step string, v any) *Flow {
+	f.checkpoints[step] = v
+	return f
+}
 
-	completedCount := 0
-	skippedCount := 0
+// Validate reports errors in the flow's graph: unknown dependencies, steps
+// added twice, dependency cycles, typed steps whose inputs have no earlier
+// producer and outputs produced twice. Run does not start a flow that fails
+// validation.
+func (f *Flow) Validate() error {
+	errs := f.errs
+	if cycle := f.cycle(); cycle != nil {
+		errs = append(errs, fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> ")))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid %s flow: %w", f.name, errors.Join(errs...))
+}
 
+// cycle returns a dependency cycle, starting and ending with the same step,
+// or nil. Steps can only depend on steps added before them, so a cycle needs
+// a step that was added twice.
+func (f *Flow) cycle() []string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			i := slices.Index(path, name)
+			return append(slices.Clone(path[i:]), name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range f.deps[name] {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
 	for _, name := range f.taskOrder {
-		task := f.tasks[name]
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// Steps returns the step names in the order they were added.
+func (f *Flow) Steps() []string {
+	return slices.Clone(f.taskOrder)
+}
 
-		if err := ctx.Err(); err != nil {
-			return fmt.Errorf("cancelled before %s: %w", name, err)
+// Run executes the pipeline synchronously, notifying the flow's listeners
+// and those in ctx (see WithListeners).
+func (f *Flow) Run(ctx context.Context) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	ctx = runlog.With(ctx, zap.String("pipeline", f.name))
+	ctx, span := tracing.Start(ctx, "flow "+f.name, tracing.Pipeline.String(f.name))
+
+	ls := f.listenersFor(ctx)
+	ls.FlowStarted(ctx, FlowEvent{Pipeline: f.name, Steps: f.Steps()})
+
+	startTime := time.Now()
+	counts := make(map[string]int)
+	err := f.run(ctx, ls, counts)
+	ls.FlowFinished(ctx, FlowEvent{
+		Pipeline: f.name,
+		Outcome:  outcomeOf(ctx, err),
+		Duration: time.Since(startTime),
+		Counts:   counts,
+		Err:      err,
+	})
+	tracing.End(span, err)
+	return err
+}
+
+// listenersFor returns the flow's listeners followed by those in ctx.
+func (f *Flow) listenersFor(ctx context.Context) listeners {
+	extra, _ := ctx.Value(listenersKey{}).([]Listener)
+	return append(slices.Clone(f.listeners), extra...)
+}
+
+// stepResult is a finished step reported back to the scheduler.
+type stepResult struct {
+	name string
+	err  error
+}
+
+// run executes the tasks as a DAG: a task starts once all of its
+// dependencies have succeeded, been skipped or been restored, and up to
+// f.concurrency tasks run at once. The first failure cancels the steps still
+// running, no further steps start, and that failure is returned. counts
+// collects the steps by outcome.
+func (f *Flow) run(ctx context.Context, ls listeners, counts map[string]int) error {
+	// Get skip steps from context; only_steps skips everything not listed
+	skipSteps := getSkipStepsFromContext(ctx)
+	if only := ParamsFromContext(ctx).Strings(OnlyStepsParam); len(only) > 0 {
+		for _, name := range f.taskOrder {
+			if !slices.Contains(only, name) {
+				skipSteps[name] = true
+			}
 		}
+	}
+	provided, err := f.applyInputs(ctx, skipSteps)
+	if err != nil {
+		return err
+	}
+	state := runStateFromContext(ctx)
+	resume := resumeFromContext(ctx)
+	if state != nil {
+		for _, name := range f.taskOrder {
+			if len(f.inputs[name]) > 0 || f.outputs[name] != "" {
+				state.stepData(name, f.inputs[name], f.outputs[name])
+			}
+		}
+	}
 
-		// Check if this step should be skipped
-		if skipSteps[name] {
-			logger.Info("step skipped",
-				zap.String("pipeline", f.name),
-				zap.String("step", name))
-			skippedCount++
+	flowCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	finished := make(map[string]bool) // succeeded, skipped or restored
+	skipped := make(map[string]bool)
+	started := make(map[string]bool)
+	results := make(chan stepResult)
+	running := 0
+	var firstErr error
+
+	ready := func(name string) bool {
+		for _, dep := range f.deps[name] {
+			if !finished[dep] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		// Start every ready task, in declaration order, up to the limit.
+		// Skipping or restoring a task can make others ready, so rescan
+		// until stable.
+		for scan := true; scan && firstErr == nil && flowCtx.Err() == nil; {
+			scan = false
+			for _, name := range f.taskOrder {
+				if started[name] || running >= f.concurrency || !ready(name) {
+					continue
+				}
+				started[name] = true
+
+				// Steps the resumed run completed are not run again
+				if f.restore(ctx, ls, state, resume, name) {
+					counts["restored"]++
+					finished[name] = true
+					scan = true
+					continue
+				}
+
+				// Skip requested steps, and steps whose input a skipped
+				// step would have produced
+				reason := ""
+				if skipSteps[name] {
+					reason = "requested"
+				} else if missing := f.missingInput(name, skipped, provided); missing != "" {
+					reason = fmt.Sprintf("input %s missing, %s was skipped", missing, f.producers[missing])
+				}
+				if reason != "" {
+					ls.StepSkipped(ctx, StepEvent{Pipeline: f.name, Step: name, Reason: reason})
+					counts["skipped"]++
+					skipped[name] = true
+					finished[name] = true
+					scan = true
+					continue
+				}
+
+				running++
+				go func(task *goflow.Task) {
+					err := f.runTask(flowCtx, ls, task)
+					if err == nil {
+						f.saveCheckpoint(state, task.Name)
+					}
+					results <- stepResult{name: task.Name, err: err}
+				}(f.tasks[name])
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+		res := <-results
+		running--
+		if res.err != nil {
+			counts[outcomeOf(flowCtx, res.err)]++
+			if firstErr == nil {
+				firstErr = res.err
+				cancel(fmt.Errorf("%w: %s", errStepFailed, res.name))
+			}
 			continue
 		}
+		finished[res.name] = true
+		counts["succeeded"]++
+	}
 
-		if err := f.runTaskWithLogging(ctx, task); err != nil {
-			return err
+	if firstErr != nil {
+		return firstErr
+	}
+	for _, name := range f.taskOrder {
+		if !started[name] {
+			return fmt.Errorf("cancelled before %s: %w", name, context.Cause(ctx))
 		}
-		completedCount++
 	}
+	return nil
+}
 
-	logger.Info("flow completed",
-		zap.String("pipeline", f.name),
-		zap.Duration("duration", time.Since(startTime)),
-		zap.Int("steps_completed", completedCount),
-		zap.Int("steps_skipped", skippedCount))
+// applyInputs decodes the input overrides in ctx (InputsKey) into the
+// Outputs of skipped steps, returning the Output names it set. An override
+// for an Output no step produces, or whose step is not skipped, is an error.
+func (f *Flow) applyInputs(ctx context.Context, skip map[string]bool) (map[string]bool, error) {
+	inputs, _ := ctx.Value(InputsKey).(map[string]json.RawMessage)
+	provided := make(map[string]bool, len(inputs))
+	for _, name := range slices.Sorted(maps.Keys(inputs)) {
+		producer, ok := f.producers[name]
+		if !ok {
+			return nil, fmt.Errorf("input %q: no step produces it", name)
+		}
+		if !skip[producer] {
+			return nil, fmt.Errorf("input %q: %s is not skipped", name, producer)
+		}
+		if err := json.Unmarshal(inputs[name], f.values[name]); err != nil {
+			return nil, fmt.Errorf("input %q: %w", name, err)
+		}
+		provided[name] = true
+	}
+	return provided, nil
+}
 
-	return nil
+// missingInput returns the first input of step that a skipped step produces
+// and no override provides, or "".
+func (f *Flow) missingInput(step string, skipped, provided map[string]bool) string {
+	for _, in := range f.inputs[step] {
+		if skipped[f.producers[in]] && !provided[in] {
+			return in
+		}
+	}
+	return ""
 }
 
-// runTaskWithLogging executes a single task with detailed logging
-func (f *Flow) runTaskWithLogging(ctx context.Context, t *goflow.Task) error {
-	taskStart := time.Now()
+// restore marks a step the resumed run completed as restored, loading its
+// checkpointed output. It returns false if the step must run: it was not
+// completed, or its output was not saved or cannot be decoded.
+func (f *Flow) restore(ctx context.Context, ls listeners, state *runState, resume *Resume, name string) bool {
+	if !resume.completed(name) {
+		return false
+	}
+	if target, ok := f.checkpoints[name]; ok {
+		data, saved := resume.Checkpoints[name]
+		if !saved {
+			runlog.Warn(ctx, "no checkpoint for completed step, running it again",
+				zap.String("step", name),
+				zap.String("resumed_from", resume.RunID))
+			return false
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			runlog.Warn(ctx, "invalid checkpoint for completed step, running it again",
+				zap.String("step", name),
+				zap.String("resumed_from", resume.RunID),
+				zap.Error(err))
+			return false
+		}
+		// Carry the checkpoint over so this run can be resumed too
+		if state != nil {
+			state.saveCheckpoint(name, data)
+		}
+	}
 
-	logger.Info("step started",
-		zap.String("pipeline", f.name),
-		zap.String("step", t.Name))
+	ls.StepRestored(ctx, StepEvent{Pipeline: f.name, Step: name, Reason: resume.RunID})
+	return true
+}
 
-	if err := runWithRetry(ctx, t); err != nil {
-		logger.Error("step failed",
+// saveCheckpoint saves a succeeded step's output, if it has a checkpoint.
+func (f *Flow) saveCheckpoint(state *runState, name string) {
+	target, ok := f.checkpoints[name]
+	if !ok || state == nil {
+		return
+	}
+	data, err := json.Marshal(target)
+	if err != nil {
+		logger.Warn("Failed to encode step checkpoint",
 			zap.String("pipeline", f.name),
-			zap.String("step", t.Name),
-			zap.Error(err),
-			zap.Duration("duration", time.Since(taskStart)))
-		return err
+			zap.String("step", name),
+			zap.Error(err))
+		return
 	}
+	state.saveCheckpoint(name, data)
+}
 
-	logger.Info("step completed",
-		zap.String("pipeline", f.name),
-		zap.String("step", t.Name),
-		zap.Duration("duration", time.Since(taskStart)))
-
-	return nil
+// runTask executes a single task, notifying ls as it starts, reports
+// progress and finishes.
+func (f *Flow) runTask(ctx context.Context, ls listeners, t *goflow.Task) error {
+	taskStart := time.Now()
+	ctx = context.WithValue(ctx, stepKey, t.Name)
+	ctx = runlog.With(ctx, zap.String("step", t.Name))
+	ctx, span := tracing.Start(ctx, "step "+t.Name, tracing.Pipeline.String(f.name), tracing.Step.String(t.Name))
+	ls.StepStarted(ctx, StepEvent{Pipeline: f.name, Step: t.Name, Attempt: 1})
+
+	stepCtx := progress.WithReporter(ctx, func(done, total int, message string) {
+		ls.StepProgress(ctx, StepEvent{Pipeline: f.name, Step: t.Name, Done: done, Total: total, Message: message})
+	})
+	err := runWithRetry(stepCtx, ls, f.name, t, f.retryPolicy(t.Name))
+
+	ls.StepFinished(ctx, StepEvent{
+		Pipeline: f.name,
+		Step:     t.Name,
+		Outcome:  outcomeOf(ctx, err),
+		Duration: time.Since(taskStart),
+		Err:      err,
+	})
+	tracing.End(span, err)
+	return err
 }
 
-// Job returns the underlying goflow Job for visualization.
+// Job returns the underlying goflow Job. Graph describes the flow for
+// visualization.
 func (f *Flow) Job() *goflow.Job {
 	return f.job
 }
 
+// StepName returns the name of the step running in ctx, e.g. to record its
+// item count from a task function that several steps may use.
+func StepName(ctx context.Context) string {
+	name, _ := ctx.Value(stepKey).(string)
+	return name
+}
+
 // getSkipStepsFromContext extracts the skip steps set from context.
 func getSkipStepsFromContext(ctx context.Context) map[string]bool {
 	m := make(map[string]bool)
@@ -152,38 +543,61 @@ func getSkipStepsFromContext(ctx context.Context) map[string]bool {
 	return m
 }
 
-// taskFunc wraps a simple function as a goflow Operator
-type taskFunc func() error
+// outcomeOf classifies a flow or step result for metrics and run records.
+// A failure after the run was cancelled on request, or while another step
+// failed, counts as cancelled.
+func outcomeOf(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "succeeded"
+	case isCancelled(ctx), errors.Is(context.Cause(ctx), errStepFailed):
+		return "cancelled"
+	default:
+		return "failed"
+	}
+}
+
+// taskFunc wraps a TaskFunc as a goflow Operator. Flow calls the function
+// directly with the step context; Run exists for goflow.
+type taskFunc TaskFunc
 
 func (fn taskFunc) Run() (any, error) {
-	return nil, fn()
+	return nil, fn(context.Background())
 }
 
-func runWithRetry(ctx context.Context, t *goflow.Task) error {
-	maxAttempts := max(t.Retries+1, 1)
-	retryDelay := 5 * time.Second
-	if delay, ok := t.RetryDelay.(goflow.ConstantDelay); ok {
-		retryDelay = time.Duration(delay.Period) * time.Second
-	}
+// runWithRetry attempts a task until it succeeds, fails with a permanent
+// error (see IsPermanent) or runs out of attempts, waiting between attempts
+// as policy says. Attempts after the first are reported to ls.
+func runWithRetry(ctx context.Context, ls listeners, pipeline string, t *goflow.Task, policy RetryPolicy) error {
+	fn := TaskFunc(t.Operator.(taskFunc))
 
 	var lastErr error
-	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		if err := ctx.Err(); err != nil {
-			return fmt.Errorf("%s cancelled: %w", t.Name, err)
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s cancelled: %w", t.Name, context.Cause(ctx))
 		}
 
 		if attempt > 1 {
-			logger.Info("Retrying task", zap.String("task", t.Name), zap.Int("attempt", attempt))
-			time.Sleep(retryDelay)
+			delay := policy.delay(attempt - 1)
+			ls.StepRetrying(ctx, StepEvent{Pipeline: pipeline, Step: t.Name, Attempt: attempt, Delay: delay, Err: lastErr})
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%s cancelled: %w", t.Name, context.Cause(ctx))
+			case <-time.After(delay):
+			}
 		}
 
-		if _, err := t.Operator.Run(); err != nil {
-			lastErr = err
-			logger.Warn("Task failed", zap.String("task", t.Name), zap.Error(err))
-			continue
+		err := policy.attempt(ctx, fn)
+		if err == nil {
+			return nil
 		}
-		return nil
+		if IsPermanent(err) {
+			runlog.Warn(ctx, "Task failed with a permanent error, not retrying", zap.Error(err))
+			return fmt.Errorf("%s failed: %w", t.Name, err)
+		}
+		lastErr = err
+		runlog.Warn(ctx, "Task failed", zap.Error(err))
 	}
 
-	return fmt.Errorf("%s failed after %d attempts: %w", t.Name, maxAttempts, lastErr)
+	return fmt.Errorf("%s failed after %d attempts: %w", t.Name, policy.MaxAttempts, lastErr)
 }
@@ -3,18 +3,22 @@ package pipelines
 import (
 	"context"
 	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestFlowBasic(t *testing.T) {
 	executed := []string{}
 
 	flow := NewFlow("test")
-	flow.AddTask("task1", func() error {
+	flow.AddTask("task1", func(context.Context) error {
 		executed = append(executed, "task1")
 		return nil
 	})
-	flow.AddTask("task2", func() error {
+	flow.AddTask("task2", func(context.Context) error {
 		executed = append(executed, "task2")
 		return nil
 	}, "task1")
@@ -38,13 +42,14 @@ func TestFlowError(t *testing.T) {
 	expectedErr := errors.New("task failed")
 
 	flow := NewFlow("test")
-	flow.AddTask("task1", func() error {
+	flow.AddTask("task1", func(context.Context) error {
 		return expectedErr
 	})
-	flow.AddTask("task2", func() error {
+	flow.AddTask("task2", func(context.Context) error {
 		t.Error("task2 should not execute after task1 fails")
 		return nil
 	}, "task1")
+	flow.WithRetry("task1", RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond})
 
 	err := flow.Run(context.Background())
 	if err == nil {
@@ -57,7 +62,7 @@ func TestFlowContextCancellation(t *testing.T) {
 	cancel() // Cancel immediately
 
 	flow := NewFlow("test")
-	flow.AddTask("task1", func() error {
+	flow.AddTask("task1", func(context.Context) error {
 		return nil
 	})
 
@@ -71,15 +76,15 @@ func TestFlowSkipSteps(t *testing.T) {
 	executed := []string{}
 
 	flow := NewFlow("test")
-	flow.AddTask("task1", func() error {
+	flow.AddTask("task1", func(context.Context) error {
 		executed = append(executed, "task1")
 		return nil
 	})
-	flow.AddTask("task2", func() error {
+	flow.AddTask("task2", func(context.Context) error {
 		executed = append(executed, "task2")
 		return nil
 	}, "task1")
-	flow.AddTask("task3", func() error {
+	flow.AddTask("task3", func(context.Context) error {
 		executed = append(executed, "task3")
 		return nil
 	}, "task2")
@@ -107,19 +112,19 @@ func TestFlowSkipMultipleSteps(t *testing.T) {
 	executed := []string{}
 
 	flow := NewFlow("test")
-	flow.AddTask("task1", func() error {
+	flow.AddTask("task1", func(context.Context) error {
 		executed = append(executed, "task1")
 		return nil
 	})
-	flow.AddTask("task2", func() error {
+	flow.AddTask("task2", func(context.Context) error {
 		executed = append(executed, "task2")
 		return nil
 	}, "task1")
-	flow.AddTask("task3", func() error {
+	flow.AddTask("task3", func(context.Context) error {
 		executed = append(executed, "task3")
 		return nil
 	}, "task2")
-	flow.AddTask("task4", func() error {
+	flow.AddTask("task4", func(context.Context) error {
 		executed = append(executed, "task4")
 		return nil
 	}, "task3")
@@ -147,11 +152,11 @@ func TestFlowNoSkipSteps(t *testing.T) {
 	executed := []string{}
 
 	flow := NewFlow("test")
-	flow.AddTask("task1", func() error {
+	flow.AddTask("task1", func(context.Context) error {
 		executed = append(executed, "task1")
 		return nil
 	})
-	flow.AddTask("task2", func() error {
+	flow.AddTask("task2", func(context.Context) error {
 		executed = append(executed, "task2")
 		return nil
 	}, "task1")
@@ -165,3 +170,168 @@ func TestFlowNoSkipSteps(t *testing.T) {
 		t.Errorf("Expected 2 tasks executed, got %d: %v", len(executed), executed)
 	}
 }
+
+func TestFlowOnlySteps(t *testing.T) {
+	executed := []string{}
+
+	flow := NewFlow("test")
+	for _, name := range []string{"task1", "task2", "task3"} {
+		flow.AddTask(name, func(context.Context) error {
+			executed = append(executed, name)
+			return nil
+		})
+	}
+
+	ctx := context.WithValue(context.Background(), ParamsKey, Params{OnlyStepsParam: []string{"task2"}})
+
+	if err := flow.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(executed) != 1 || executed[0] != "task2" {
+		t.Errorf("Expected only task2 executed, got %v", executed)
+	}
+}
+
+func TestFlowValidateGraph(t *testing.T) {
+	noop := func(context.Context) error { return nil }
+
+	flow := NewFlow("test")
+	flow.AddTask("a", noop)
+	flow.AddTask("b", noop, "a", "missing")
+	if err := flow.Validate(); err == nil || !strings.Contains(err.Error(), `step "b": depends on unknown step "missing"`) {
+		t.Errorf("Validate() error = %v, want unknown step", err)
+	}
+
+	flow = NewFlow("test")
+	flow.AddTask("a", noop)
+	flow.AddTask("b", noop, "a")
+	flow.AddTask("a", noop, "b")
+	err := flow.Validate()
+	if err == nil || !strings.Contains(err.Error(), `step "a" is added twice`) ||
+		!strings.Contains(err.Error(), "dependency cycle: a -> b -> a") {
+		t.Errorf("Validate() error = %v, want duplicate step and cycle", err)
+	}
+	if err := flow.Run(context.Background()); err == nil {
+		t.Error("Run() expected validation error")
+	}
+}
+
+func TestRunWithRetryStopsSleepingOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	flow := NewFlow("test")
+	flow.AddTask("flaky", func(context.Context) error {
+		cancel()
+		return errors.New("boom")
+	})
+
+	start := time.Now()
+	err := flow.Run(ctx)
+	if err == nil {
+		t.Fatal("Run() expected error for cancelled context")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run() took %s, want it to stop without waiting for the retry delay", elapsed)
+	}
+}
+
+func TestFlowParallelBranches(t *testing.T) {
+	var mu sync.Mutex
+	executed := []string{}
+	record := func(name string) {
+		mu.Lock()
+		executed = append(executed, name)
+		mu.Unlock()
+	}
+
+	// b and c each wait for the other to start, so the flow only finishes
+	// if they run at the same time.
+	bStarted, cStarted := make(chan struct{}), make(chan struct{})
+	wait := func(ch chan struct{}) error {
+		select {
+		case <-ch:
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("sibling step did not run concurrently")
+		}
+	}
+
+	flow := NewFlow("test")
+	flow.AddTask("a", func(context.Context) error { record("a"); return nil })
+	flow.AddTask("b", func(context.Context) error { close(bStarted); record("b"); return wait(cStarted) }, "a")
+	flow.AddTask("c", func(context.Context) error { close(cStarted); record("c"); return wait(bStarted) }, "a")
+	flow.AddTask("d", func(context.Context) error { record("d"); return nil }, "b", "c")
+	flow.WithRetry("b", RetryPolicy{MaxAttempts: 1}).WithRetry("c", RetryPolicy{MaxAttempts: 1})
+
+	if err := flow.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(executed) != 4 || executed[0] != "a" || executed[3] != "d" {
+		t.Errorf("executed = %v, want a, then b and c, then d", executed)
+	}
+}
+
+func TestFlowConcurrencyLimit(t *testing.T) {
+	var running, peak atomic.Int32
+
+	flow := NewFlow("test").WithConcurrency(2)
+	for _, name := range []string{"task1", "task2", "task3", "task4"} {
+		flow.AddTask(name, func(context.Context) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		})
+	}
+
+	if err := flow.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := peak.Load(); got != 2 {
+		t.Errorf("peak concurrency = %d, want 2", got)
+	}
+}
+
+func TestFlowFailureCancelsRunningSteps(t *testing.T) {
+	m := NewRunManager(10, nil)
+	slowStarted := make(chan struct{})
+
+	_, err := m.Submit(context.Background(), RunSpec{Pipeline: "test", ID: "run-1", Steps: []string{"slow", "fail", "after"}}, func(ctx context.Context) error {
+		flow := NewFlow("test")
+		flow.AddTask("slow", func(ctx context.Context) error {
+			close(slowStarted)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		flow.AddTask("fail", func(context.Context) error {
+			<-slowStarted
+			return errors.New("boom")
+		})
+		flow.AddTask("after", func(context.Context) error {
+			t.Error("after should not run once fail has failed")
+			return nil
+		}, "slow", "fail")
+		flow.WithRetry("fail", RetryPolicy{MaxAttempts: 1})
+		return flow.Run(ctx)
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	done := waitForRun(t, m, "run-1")
+	if done.Status != StatusFailed || !strings.Contains(done.Error, "boom") {
+		t.Errorf("run = %s (%s), want failed with the fail step's error", done.Status, done.Error)
+	}
+	want := []RunStatus{StatusCancelled, StatusFailed, StatusPending}
+	for i, step := range done.Steps {
+		if step.Status != want[i] {
+			t.Errorf("step %s = %s, want %s", step.Name, step.Status, want[i])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package pipelines
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Graph is a flow's DAG for discovery and visualization: its steps in the
+// order they were added and the dependencies between them.
+type Graph struct {
+	Pipeline string      `json:"pipeline"`
+	Nodes    []GraphNode `json:"nodes"`
+	Edges    []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a step. Status is the step's status in a run, if one was
+// applied with WithRun.
+type GraphNode struct {
+	Name   string    `json:"name"`
+	Inputs []string  `json:"inputs,omitempty"`
+	Output string    `json:"output,omitempty"`
+	Status RunStatus `json:"status,omitempty"`
+}
+
+// GraphEdge is a dependency: To runs after From. Output names the Output
+// that From produces and To consumes; it is empty for an ordering-only
+// dependency.
+type GraphEdge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Output string `json:"output,omitempty"`
+}
+
+// Graph returns the flow's DAG.
+func (f *Flow) Graph() Graph {
+	g := Graph{Pipeline: f.name, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	for _, name := range f.taskOrder {
+		g.Nodes = append(g.Nodes, GraphNode{Name: name, Inputs: f.inputs[name], Output: f.outputs[name]})
+		for _, dep := range f.deps[name] {
+			edge := GraphEdge{From: dep, To: name}
+			if out := f.outputs[dep]; out != "" && slices.Contains(f.inputs[name], out) {
+				edge.Output = out
+			}
+			g.Edges = append(g.Edges, edge)
+		}
+	}
+	return g
+}
+
+// WithRun returns a copy of g with each node's status taken from run.
+func (g Graph) WithRun(run Run) Graph {
+	g.Nodes = slices.Clone(g.Nodes)
+	for i := range g.Nodes {
+		for _, st := range run.Steps {
+			if st.Name == g.Nodes[i].Name {
+				g.Nodes[i].Status = st.Status
+			}
+		}
+	}
+	return g
+}
+
+// graphColors are the node fill colours for each step status, matching the
+// UI.
+var graphColors = map[RunStatus]string{
+	StatusPending:   "#e9ecef",
+	StatusRunning:   "#fff3cd",
+	StatusSucceeded: "#d4edda",
+	StatusFailed:    "#f8d7da",
+	StatusCancelled: "#f8d7da",
+	StatusSkipped:   "#f1f3f5",
+	StatusRestored:  "#d1ecf1",
+}
+
+// Mermaid renders g as a Mermaid flowchart, with nodes styled by status.
+func (g Graph) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "    %s[%q]\n", n.Name, n.Name)
+	}
+	for _, e := range g.Edges {
+		if e.Output != "" {
+			fmt.Fprintf(&b, "    %s -->|%s| %s\n", e.From, e.Output, e.To)
+		} else {
+			fmt.Fprintf(&b, "    %s -.-> %s\n", e.From, e.To)
+		}
+	}
+	for _, n := range g.Nodes {
+		if color, ok := graphColors[n.Status]; ok {
+			fmt.Fprintf(&b, "    style %s fill:%s\n", n.Name, color)
+		}
+	}
+	return b.String()
+}
+
+// DOT renders g in Graphviz DOT, with nodes filled by status.
+func (g Graph) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", g.Pipeline)
+	b.WriteString("    rankdir=TB;\n    node [shape=box, style=\"rounded,filled\", fillcolor=\"#ffffff\"];\n")
+	for _, n := range g.Nodes {
+		if color, ok := graphColors[n.Status]; ok {
+			fmt.Fprintf(&b, "    %q [fillcolor=%q, tooltip=%q];\n", n.Name, color, string(n.Status))
+		} else {
+			fmt.Fprintf(&b, "    %q;\n", n.Name)
+		}
+	}
+	for _, e := range g.Edges {
+		if e.Output != "" {
+			fmt.Fprintf(&b, "    %q -> %q [label=%q];\n", e.From, e.To, e.Output)
+		} else {
+			fmt.Fprintf(&b, "    %q -> %q [style=dashed];\n", e.From, e.To)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,84 @@
+package pipelines
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newGraphFlow() *Flow {
+	files := NewOutput[[]string]("files")
+	converted := NewOutput[[]string]("converted")
+
+	flow := NewFlow("inbound")
+	Source(flow, "poll", files, func(context.Context) ([]string, error) { return nil, nil })
+	Task(flow, "convert", files, converted, func(_ context.Context, in []string) ([]string, error) { return in, nil })
+	flow.AddTask("insert", func(context.Context) error { return nil }, "poll")
+	Sink(flow, "upload", converted, func(context.Context, []string) error { return nil }, "insert")
+	return flow
+}
+
+func TestFlowGraph(t *testing.T) {
+	g := newGraphFlow().Graph()
+
+	names := make([]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		names[i] = n.Name
+	}
+	if !slices.Equal(names, []string{"poll", "convert", "insert", "upload"}) {
+		t.Errorf("nodes = %v", names)
+	}
+	if n := g.Nodes[1]; !slices.Equal(n.Inputs, []string{"files"}) || n.Output != "converted" {
+		t.Errorf("convert node = %+v, want files -> converted", n)
+	}
+
+	want := []GraphEdge{
+		{From: "poll", To: "convert", Output: "files"},
+		{From: "poll", To: "insert"},
+		{From: "insert", To: "upload"},
+		{From: "convert", To: "upload", Output: "converted"},
+	}
+	if !slices.Equal(g.Edges, want) {
+		t.Errorf("edges = %+v, want %+v", g.Edges, want)
+	}
+}
+
+func TestGraphRender(t *testing.T) {
+	run := Run{Steps: []StepRun{
+		{Name: "poll", Status: StatusSucceeded},
+		{Name: "convert", Status: StatusFailed},
+	}}
+	g := newGraphFlow().Graph().WithRun(run)
+	if g.Nodes[0].Status != StatusSucceeded || g.Nodes[1].Status != StatusFailed || g.Nodes[2].Status != "" {
+		t.Errorf("statuses = %s %s %s", g.Nodes[0].Status, g.Nodes[1].Status, g.Nodes[2].Status)
+	}
+
+	mermaid := g.Mermaid()
+	for _, want := range []string{
+		"flowchart TD\n",
+		"    poll -->|files| convert\n",
+		"    poll -.-> insert\n",
+		"    style convert fill:#f8d7da\n",
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("Mermaid() missing %q:\n%s", want, mermaid)
+		}
+	}
+	if strings.Contains(mermaid, "style insert") {
+		t.Errorf("Mermaid() styles a step without status:\n%s", mermaid)
+	}
+
+	dot := g.DOT()
+	for _, want := range []string{
+		"digraph \"inbound\" {\n",
+		"    \"poll\" [fillcolor=\"#d4edda\", tooltip=\"succeeded\"];\n",
+		"    \"insert\";\n",
+		"    \"poll\" -> \"convert\" [label=\"files\"];\n",
+		"    \"insert\" -> \"upload\" [style=dashed];\n",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT() missing %q:\n%s", want, dot)
+		}
+	}
+}
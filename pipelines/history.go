@@ -0,0 +1,632 @@
+package pipelines
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// RunFilter selects runs from a RunStore. Zero values match everything.
+type RunFilter struct {
+	ID       string
+	Pipeline string
+	Status   RunStatus
+	Since    time.Time // runs queued at or after Since
+	Limit    int       // default 100
+}
+
+// RunStore persists run and step records so history survives restarts and
+// is shared by all instances.
+type RunStore interface {
+	// Create inserts a new run and its steps, returning ErrRunExists if the
+	// ID is already taken.
+	Create(ctx context.Context, run Run) error
+	// Save inserts or replaces a run and its steps.
+	Save(ctx context.Context, run Run) error
+	// Get returns a run by ID; ok is false if it does not exist.
+	Get(ctx context.Context, id string) (Run, bool, error)
+	// List returns matching runs, newest first.
+	List(ctx context.Context, filter RunFilter) ([]Run, error)
+	// SaveArtifact inserts or replaces a run artifact's content.
+	SaveArtifact(ctx context.Context, runID string, artifact Artifact) error
+	// GetArtifact returns a run artifact with its content; ok is false if it
+	// does not exist.
+	GetArtifact(ctx context.Context, runID, name string) (Artifact, bool, error)
+	// SaveCheckpoint inserts or replaces the saved output of a run step.
+	SaveCheckpoint(ctx context.Context, runID, step string, data []byte) error
+	// GetCheckpoints returns a run's saved step outputs keyed by step name.
+	GetCheckpoints(ctx context.Context, runID string) (map[string][]byte, error)
+}
+
+func (f RunFilter) limit() int {
+	if f.Limit <= 0 {
+		return 100
+	}
+	return f.Limit
+}
+
+func (f RunFilter) matches(run Run) bool {
+	if f.ID != "" && run.ID != f.ID {
+		return false
+	}
+	if f.Pipeline != "" && run.Pipeline != f.Pipeline {
+		return false
+	}
+	if f.Status != "" && run.Status != f.Status {
+		return false
+	}
+	if !f.Since.IsZero() && run.QueuedAt.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+// MemoryRunStore keeps the most recent runs in process memory. History is
+// lost on restart; use it for local development.
+type MemoryRunStore struct {
+	mu          sync.Mutex
+	runs        map[string]Run
+	artifacts   map[string]map[string]Artifact // run ID -> name -> artifact
+	checkpoints map[string]map[string][]byte   // run ID -> step -> output
+	order       []string                       // run IDs, oldest first
+	limit       int
+}
+
+// NewMemoryRunStore creates a store that keeps up to limit runs.
+func NewMemoryRunStore(limit int) *MemoryRunStore {
+	if limit <= 0 {
+		limit = 100
+	}
+	return &MemoryRunStore{
+		runs:        make(map[string]Run),
+		artifacts:   make(map[string]map[string]Artifact),
+		checkpoints: make(map[string]map[string][]byte),
+		limit:       limit,
+	}
+}
+
+// Create inserts a new run, returning ErrRunExists if the ID is taken.
+func (s *MemoryRunStore) Create(ctx context.Context, run Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.runs[run.ID]; ok {
+		return fmt.Errorf("%w: %s", ErrRunExists, run.ID)
+	}
+	s.saveLocked(run)
+	return nil
+}
+
+// Save inserts or replaces a run, dropping the oldest runs beyond the limit.
+func (s *MemoryRunStore) Save(ctx context.Context, run Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saveLocked(run)
+	return nil
+}
+
+// saveLocked stores run, dropping the oldest runs beyond the limit. Caller
+// holds mu.
+func (s *MemoryRunStore) saveLocked(run Run) {
+	if _, ok := s.runs[run.ID]; !ok {
+		s.order = append(s.order, run.ID)
+	}
+	run.Steps = append([]StepRun(nil), run.Steps...)
+	run.Artifacts = append([]Artifact(nil), run.Artifacts...)
+	s.runs[run.ID] = run
+	for len(s.order) > s.limit {
+		delete(s.runs, s.order[0])
+		delete(s.artifacts, s.order[0])
+		delete(s.checkpoints, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// SaveArtifact inserts or replaces a run artifact.
+func (s *MemoryRunStore) SaveArtifact(ctx context.Context, runID string, artifact Artifact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.artifacts[runID] == nil {
+		s.artifacts[runID] = make(map[string]Artifact)
+	}
+	artifact.Content = append([]byte(nil), artifact.Content...)
+	artifact.Size = len(artifact.Content)
+	s.artifacts[runID][artifact.Name] = artifact
+	return nil
+}
+
+// GetArtifact returns a run artifact with its content.
+func (s *MemoryRunStore) GetArtifact(ctx context.Context, runID, name string) (Artifact, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	artifact, ok := s.artifacts[runID][name]
+	return artifact, ok, nil
+}
+
+// SaveCheckpoint inserts or replaces a step's saved output.
+func (s *MemoryRunStore) SaveCheckpoint(ctx context.Context, runID, step string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoints[runID] == nil {
+		s.checkpoints[runID] = make(map[string][]byte)
+	}
+	s.checkpoints[runID][step] = append([]byte(nil), data...)
+	return nil
+}
+
+// GetCheckpoints returns a run's saved step outputs.
+func (s *MemoryRunStore) GetCheckpoints(ctx context.Context, runID string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string][]byte, len(s.checkpoints[runID]))
+	for step, data := range s.checkpoints[runID] {
+		out[step] = data
+	}
+	return out, nil
+}
+
+// Get returns a run by ID.
+func (s *MemoryRunStore) Get(ctx context.Context, id string) (Run, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	return run, ok, nil
+}
+
+// List returns matching runs, newest first.
+func (s *MemoryRunStore) List(ctx context.Context, filter RunFilter) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make([]Run, 0, len(s.runs))
+	for _, run := range s.runs {
+		if filter.matches(run) {
+			runs = append(runs, run)
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].QueuedAt.After(runs[j].QueuedAt)
+	})
+	if len(runs) > filter.limit() {
+		runs = runs[:filter.limit()]
+	}
+	return runs, nil
+}
+
+// Run history tables.
+const (
+	pipelineRunsTable           = "pipeline_runs"
+	pipelineRunStepsTable       = "pipeline_run_steps"
+	pipelineRunArtifactsTable   = "pipeline_run_artifacts"
+	pipelineRunCheckpointsTable = "pipeline_run_checkpoints"
+)
+
+// SQLRunStore keeps run history in TiDB/MySQL: one row per run in
+// pipeline_runs, one row per step in pipeline_run_steps, one row per
+// artifact in pipeline_run_artifacts and one row per step checkpoint in
+// pipeline_run_checkpoints. Artifact metadata is also stored as JSON on the
+// run row so listing runs does not read artifact content.
+type SQLRunStore struct {
+	db *sqlx.DB
+
+	mu      sync.Mutex
+	ensured bool
+}
+
+// NewSQLRunStore creates a run store backed by db.
+func NewSQLRunStore(db *sqlx.DB) *SQLRunStore {
+	return &SQLRunStore{db: db}
+}
+
+// runRow is a pipeline_runs row.
+type runRow struct {
+	ID         string         `db:"id"`
+	Pipeline   string         `db:"pipeline"`
+	Trigger    string         `db:"trigger_type"`
+	CalledBy   string         `db:"called_by"`
+	Params     sql.NullString `db:"params"`
+	Artifacts  sql.NullString `db:"artifacts"`
+	Status     string         `db:"status"`
+	QueuedAt   time.Time      `db:"queued_at"`
+	StartedAt  sql.NullTime   `db:"started_at"`
+	FinishedAt sql.NullTime   `db:"finished_at"`
+	Duration   float64        `db:"duration"`
+	Error      string         `db:"error"`
+}
+
+// stepRow is a pipeline_run_steps row.
+type stepRow struct {
+	RunID      string        `db:"run_id"`
+	Position   int           `db:"position"`
+	Name       string        `db:"name"`
+	Status     string        `db:"status"`
+	StartedAt  sql.NullTime  `db:"started_at"`
+	FinishedAt sql.NullTime  `db:"finished_at"`
+	Duration   float64       `db:"duration"`
+	Attempts   int           `db:"attempts"`
+	Items      sql.NullInt64 `db:"items"`
+	Inputs     string        `db:"inputs"` // comma-separated
+	Output     string        `db:"output"`
+	Error      string        `db:"error"`
+}
+
+// ensureTables creates the history tables on first use.
+func (s *SQLRunStore) ensureTables(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ensured {
+		return nil
+	}
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+pipelineRunsTable+` (
+		id VARCHAR(255) NOT NULL PRIMARY KEY,
+		pipeline VARCHAR(100) NOT NULL,
+		trigger_type VARCHAR(50) NOT NULL,
+		called_by VARCHAR(255) NOT NULL DEFAULT '',
+		params TEXT NULL,
+		artifacts TEXT NULL,
+		status VARCHAR(20) NOT NULL,
+		queued_at DATETIME(6) NOT NULL,
+		started_at DATETIME(6) NULL,
+		finished_at DATETIME(6) NULL,
+		duration DOUBLE NOT NULL DEFAULT 0,
+		error TEXT NOT NULL,
+		INDEX idx_pipeline_runs_queued (pipeline, queued_at)
+	)`); err != nil {
+		return fmt.Errorf("creating %s table: %w", pipelineRunsTable, err)
+	}
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+pipelineRunStepsTable+` (
+		run_id VARCHAR(255) NOT NULL,
+		position INT NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		started_at DATETIME(6) NULL,
+		finished_at DATETIME(6) NULL,
+		duration DOUBLE NOT NULL DEFAULT 0,
+		attempts INT NOT NULL DEFAULT 0,
+		items INT NULL,
+		inputs VARCHAR(1000) NOT NULL DEFAULT '',
+		output VARCHAR(100) NOT NULL DEFAULT '',
+		error TEXT NOT NULL,
+		PRIMARY KEY (run_id, name)
+	)`); err != nil {
+		return fmt.Errorf("creating %s table: %w", pipelineRunStepsTable, err)
+	}
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+pipelineRunArtifactsTable+` (
+		run_id VARCHAR(255) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		content_type VARCHAR(100) NOT NULL,
+		content LONGBLOB NOT NULL,
+		created_at DATETIME(6) NOT NULL,
+		PRIMARY KEY (run_id, name)
+	)`); err != nil {
+		return fmt.Errorf("creating %s table: %w", pipelineRunArtifactsTable, err)
+	}
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+pipelineRunCheckpointsTable+` (
+		run_id VARCHAR(255) NOT NULL,
+		step VARCHAR(100) NOT NULL,
+		data LONGBLOB NOT NULL,
+		created_at DATETIME(6) NOT NULL,
+		PRIMARY KEY (run_id, step)
+	)`); err != nil {
+		return fmt.Errorf("creating %s table: %w", pipelineRunCheckpointsTable, err)
+	}
+	s.ensured = true
+	return nil
+}
+
+// mysqlDuplicateEntry is the MySQL error number for a duplicate key.
+const mysqlDuplicateEntry = 1062
+
+// Create inserts the run row and all step rows in one transaction,
+// returning ErrRunExists if a run with the same ID is already stored.
+func (s *SQLRunStore) Create(ctx context.Context, run Run) error {
+	return s.save(ctx, run, false)
+}
+
+// Save upserts the run row and all step rows in one transaction.
+func (s *SQLRunStore) Save(ctx context.Context, run Run) error {
+	return s.save(ctx, run, true)
+}
+
+// save writes the run row and all step rows in one transaction. Unless
+// upsert is set, an existing run row fails the insert.
+func (s *SQLRunStore) save(ctx context.Context, run Run, upsert bool) error {
+	if err := s.ensureTables(ctx); err != nil {
+		return err
+	}
+
+	var params sql.NullString
+	if len(run.Params) > 0 {
+		data, err := json.Marshal(run.Params)
+		if err != nil {
+			return fmt.Errorf("encoding run params: %w", err)
+		}
+		params = sql.NullString{String: string(data), Valid: true}
+	}
+	var artifacts sql.NullString
+	if len(run.Artifacts) > 0 {
+		data, err := json.Marshal(run.Artifacts)
+		if err != nil {
+			return fmt.Errorf("encoding run artifacts: %w", err)
+		}
+		artifacts = sql.NullString{String: string(data), Valid: true}
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `INSERT INTO ` + pipelineRunsTable + `
+		(id, pipeline, trigger_type, called_by, params, artifacts, status, queued_at, started_at, finished_at, duration, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if upsert {
+		query += `
+		ON DUPLICATE KEY UPDATE artifacts = VALUES(artifacts), status = VALUES(status), started_at = VALUES(started_at),
+			finished_at = VALUES(finished_at), duration = VALUES(duration), error = VALUES(error)`
+	}
+	if _, err := tx.ExecContext(ctx, query,
+		run.ID, run.Pipeline, run.Trigger, run.CalledBy, params, artifacts, string(run.Status), run.QueuedAt.UTC(),
+		nullTime(run.StartedAt), nullTime(run.FinishedAt), run.Duration, run.Error); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			return fmt.Errorf("%w: %s", ErrRunExists, run.ID)
+		}
+		return fmt.Errorf("saving run: %w", err)
+	}
+
+	for i, step := range run.Steps {
+		var items sql.NullInt64
+		if step.Items != nil {
+			items = sql.NullInt64{Int64: int64(*step.Items), Valid: true}
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO `+pipelineRunStepsTable+`
+			(run_id, position, name, status, started_at, finished_at, duration, attempts, items, inputs, output, error)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE position = VALUES(position), status = VALUES(status),
+				started_at = VALUES(started_at), finished_at = VALUES(finished_at), duration = VALUES(duration),
+				attempts = VALUES(attempts), items = VALUES(items), inputs = VALUES(inputs), output = VALUES(output),
+				error = VALUES(error)`,
+			run.ID, i, step.Name, string(step.Status), nullTime(step.StartedAt), nullTime(step.FinishedAt),
+			step.Duration, step.Attempts, items, strings.Join(step.Inputs, ","), step.Output, step.Error); err != nil {
+			return fmt.Errorf("saving step %s: %w", step.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing run: %w", err)
+	}
+	return nil
+}
+
+// Get returns a run by ID with its steps.
+func (s *SQLRunStore) Get(ctx context.Context, id string) (Run, bool, error) {
+	if err := s.ensureTables(ctx); err != nil {
+		return Run{}, false, err
+	}
+
+	var row runRow
+	err := s.db.GetContext(ctx, &row, `SELECT id, pipeline, trigger_type, called_by, params, artifacts, status,
+		queued_at, started_at, finished_at, duration, error FROM `+pipelineRunsTable+` WHERE id = ?`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Run{}, false, nil
+	}
+	if err != nil {
+		return Run{}, false, fmt.Errorf("querying run: %w", err)
+	}
+
+	runs, err := s.withSteps(ctx, []runRow{row})
+	if err != nil {
+		return Run{}, false, err
+	}
+	return runs[0], true, nil
+}
+
+// List returns matching runs with their steps, newest first.
+func (s *SQLRunStore) List(ctx context.Context, filter RunFilter) ([]Run, error) {
+	if err := s.ensureTables(ctx); err != nil {
+		return nil, err
+	}
+
+	var where []string
+	var args []any
+	if filter.ID != "" {
+		where = append(where, "id = ?")
+		args = append(args, filter.ID)
+	}
+	if filter.Pipeline != "" {
+		where = append(where, "pipeline = ?")
+		args = append(args, filter.Pipeline)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, string(filter.Status))
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "queued_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+
+	query := `SELECT id, pipeline, trigger_type, called_by, params, artifacts, status, queued_at,
+		started_at, finished_at, duration, error FROM ` + pipelineRunsTable
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY queued_at DESC LIMIT ?"
+	args = append(args, filter.limit())
+
+	var rows []runRow
+	if err := s.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, fmt.Errorf("querying runs: %w", err)
+	}
+	if len(rows) == 0 {
+		return []Run{}, nil
+	}
+	return s.withSteps(ctx, rows)
+}
+
+// withSteps loads the steps for rows and converts them to runs.
+func (s *SQLRunStore) withSteps(ctx context.Context, rows []runRow) ([]Run, error) {
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+
+	query, args, err := sqlx.In(`SELECT run_id, position, name, status, started_at, finished_at,
+		duration, attempts, items, inputs, output, error FROM `+pipelineRunStepsTable+` WHERE run_id IN (?) ORDER BY run_id, position`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("building steps query: %w", err)
+	}
+	var steps []stepRow
+	if err := s.db.SelectContext(ctx, &steps, s.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("querying steps: %w", err)
+	}
+
+	stepsByRun := make(map[string][]StepRun, len(rows))
+	for _, st := range steps {
+		step := StepRun{
+			Name:       st.Name,
+			Status:     RunStatus(st.Status),
+			StartedAt:  timePtr(st.StartedAt),
+			FinishedAt: timePtr(st.FinishedAt),
+			Duration:   st.Duration,
+			Attempts:   st.Attempts,
+			Output:     st.Output,
+			Error:      st.Error,
+		}
+		if st.Inputs != "" {
+			step.Inputs = strings.Split(st.Inputs, ",")
+		}
+		if st.Items.Valid {
+			n := int(st.Items.Int64)
+			step.Items = &n
+		}
+		stepsByRun[st.RunID] = append(stepsByRun[st.RunID], step)
+	}
+
+	runs := make([]Run, 0, len(rows))
+	for _, row := range rows {
+		run := Run{
+			ID:         row.ID,
+			Pipeline:   row.Pipeline,
+			Trigger:    row.Trigger,
+			CalledBy:   row.CalledBy,
+			Status:     RunStatus(row.Status),
+			Steps:      stepsByRun[row.ID],
+			QueuedAt:   row.QueuedAt,
+			StartedAt:  timePtr(row.StartedAt),
+			FinishedAt: timePtr(row.FinishedAt),
+			Duration:   row.Duration,
+			Error:      row.Error,
+		}
+		if run.Steps == nil {
+			run.Steps = []StepRun{}
+		}
+		if row.Params.Valid && row.Params.String != "" {
+			if err := json.Unmarshal([]byte(row.Params.String), &run.Params); err != nil {
+				return nil, fmt.Errorf("decoding params for run %s: %w", row.ID, err)
+			}
+		}
+		if row.Artifacts.Valid && row.Artifacts.String != "" {
+			if err := json.Unmarshal([]byte(row.Artifacts.String), &run.Artifacts); err != nil {
+				return nil, fmt.Errorf("decoding artifacts for run %s: %w", row.ID, err)
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// SaveArtifact upserts a run artifact's content.
+func (s *SQLRunStore) SaveArtifact(ctx context.Context, runID string, artifact Artifact) error {
+	if err := s.ensureTables(ctx); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO `+pipelineRunArtifactsTable+`
+		(run_id, name, content_type, content, created_at) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE content_type = VALUES(content_type), content = VALUES(content),
+			created_at = VALUES(created_at)`,
+		runID, artifact.Name, artifact.ContentType, artifact.Content, time.Now().UTC()); err != nil {
+		return fmt.Errorf("saving artifact %s: %w", artifact.Name, err)
+	}
+	return nil
+}
+
+// GetArtifact returns a run artifact with its content.
+func (s *SQLRunStore) GetArtifact(ctx context.Context, runID, name string) (Artifact, bool, error) {
+	if err := s.ensureTables(ctx); err != nil {
+		return Artifact{}, false, err
+	}
+
+	var row struct {
+		ContentType string `db:"content_type"`
+		Content     []byte `db:"content"`
+	}
+	err := s.db.GetContext(ctx, &row, `SELECT content_type, content FROM `+pipelineRunArtifactsTable+`
+		WHERE run_id = ? AND name = ?`, runID, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Artifact{}, false, nil
+	}
+	if err != nil {
+		return Artifact{}, false, fmt.Errorf("querying artifact: %w", err)
+	}
+	return Artifact{Name: name, ContentType: row.ContentType, Size: len(row.Content), Content: row.Content}, true, nil
+}
+
+// SaveCheckpoint upserts a step's saved output.
+func (s *SQLRunStore) SaveCheckpoint(ctx context.Context, runID, step string, data []byte) error {
+	if err := s.ensureTables(ctx); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO `+pipelineRunCheckpointsTable+`
+		(run_id, step, data, created_at) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE data = VALUES(data), created_at = VALUES(created_at)`,
+		runID, step, data, time.Now().UTC()); err != nil {
+		return fmt.Errorf("saving checkpoint %s: %w", step, err)
+	}
+	return nil
+}
+
+// GetCheckpoints returns a run's saved step outputs.
+func (s *SQLRunStore) GetCheckpoints(ctx context.Context, runID string) (map[string][]byte, error) {
+	if err := s.ensureTables(ctx); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Step string `db:"step"`
+		Data []byte `db:"data"`
+	}
+	if err := s.db.SelectContext(ctx, &rows, `SELECT step, data FROM `+pipelineRunCheckpointsTable+`
+		WHERE run_id = ?`, runID); err != nil {
+		return nil, fmt.Errorf("loading checkpoints: %w", err)
+	}
+	out := make(map[string][]byte, len(rows))
+	for _, row := range rows {
+		out[row.Step] = row.Data
+	}
+	return out, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Time
+	return &v
+}
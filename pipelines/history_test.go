@@ -0,0 +1,341 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestMemoryRunStoreList(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRunStore(3)
+	now := time.Now()
+
+	runs := []Run{
+		{ID: "run-1", Pipeline: "inbound", Status: StatusSucceeded, QueuedAt: now.Add(-3 * time.Hour)},
+		{ID: "run-2", Pipeline: "outbound", Status: StatusFailed, QueuedAt: now.Add(-2 * time.Hour)},
+		{ID: "run-3", Pipeline: "outbound", Status: StatusSucceeded, QueuedAt: now.Add(-time.Hour)},
+		{ID: "run-4", Pipeline: "outbound", Status: StatusRunning, QueuedAt: now},
+	}
+	for _, run := range runs {
+		if err := store.Save(ctx, run); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	if _, ok, _ := store.Get(ctx, "run-1"); ok {
+		t.Error("Expected oldest run to be dropped beyond the limit")
+	}
+
+	tests := []struct {
+		name   string
+		filter RunFilter
+		want   []string
+	}{
+		{"all", RunFilter{}, []string{"run-4", "run-3", "run-2"}},
+		{"id", RunFilter{ID: "run-3"}, []string{"run-3"}},
+		{"status", RunFilter{Status: StatusFailed}, []string{"run-2"}},
+		{"since", RunFilter{Since: now.Add(-90 * time.Minute)}, []string{"run-4", "run-3"}},
+		{"limit", RunFilter{Pipeline: "outbound", Limit: 1}, []string{"run-4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.List(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("List() returned %d runs, want %d", len(got), len(tt.want))
+			}
+			for i, run := range got {
+				if run.ID != tt.want[i] {
+					t.Errorf("List()[%d] = %s, want %s", i, run.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRunManagerPersistsRuns(t *testing.T) {
+	store := NewMemoryRunStore(10)
+	m := NewRunManager(1, store)
+
+	_, err := m.Submit(context.Background(), RunSpec{
+		Pipeline: "outbound",
+		ID:       "run-1",
+		Trigger:  TriggerAPI,
+		Params:   map[string]any{"skip_steps": []string{"notify"}},
+		Steps:    []string{"poll"},
+	}, func(ctx context.Context) error {
+		flow := NewFlow("outbound")
+		flow.AddTask("poll", func(context.Context) error {
+			RecordItems(ctx, "poll", 3)
+			return nil
+		})
+		return flow.Run(ctx)
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitForRun(t, m, "run-1")
+
+	saved, ok, err := store.Get(context.Background(), "run-1")
+	if err != nil || !ok {
+		t.Fatalf("store.Get() = %v, %v", ok, err)
+	}
+	if saved.Status != StatusSucceeded || saved.Trigger != TriggerAPI {
+		t.Errorf("saved run = %s/%s, want succeeded/api", saved.Status, saved.Trigger)
+	}
+	if saved.Steps[0].Items == nil || *saved.Steps[0].Items != 3 {
+		t.Errorf("poll items = %v, want 3", saved.Steps[0].Items)
+	}
+
+	// Once pruned from memory the run is still found in the store
+	noop := func(ctx context.Context) error { return nil }
+	if _, err := m.Submit(context.Background(), RunSpec{Pipeline: "outbound", ID: "run-2"}, noop); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitForRun(t, m, "run-2")
+	if _, ok := m.Get("run-1"); ok {
+		t.Fatal("Expected run-1 to be pruned from memory")
+	}
+	if _, ok, _ := m.Lookup(context.Background(), "run-1"); !ok {
+		t.Error("Lookup() did not fall back to the store")
+	}
+
+	// Reusing the ID of a run only in the store is rejected, not overwritten
+	if _, err := m.Submit(context.Background(), RunSpec{Pipeline: "outbound", ID: "run-1"}, noop); !errors.Is(err, ErrRunExists) {
+		t.Fatalf("Submit() duplicate error = %v, want ErrRunExists", err)
+	}
+	if _, ok := m.Get("run-1"); ok {
+		t.Error("Expected the rejected run not to be tracked")
+	}
+	if saved, _, _ := store.Get(context.Background(), "run-1"); saved.Status != StatusSucceeded {
+		t.Errorf("stored run status = %s, want succeeded", saved.Status)
+	}
+	if err := store.Create(context.Background(), Run{ID: "run-2"}); !errors.Is(err, ErrRunExists) {
+		t.Errorf("Create() duplicate error = %v, want ErrRunExists", err)
+	}
+}
+
+func TestRunManagerRecordsArtifacts(t *testing.T) {
+	store := NewMemoryRunStore(10)
+	m := NewRunManager(10, store)
+
+	_, err := m.Submit(context.Background(), RunSpec{Pipeline: "outbound", ID: "run-1"}, func(ctx context.Context) error {
+		RecordArtifact(ctx, "CAP-1.xml", "application/xml", []byte("<old/>"))
+		RecordArtifact(ctx, "CAP-1.xml", "application/xml", []byte("<epcis/>"))
+		RecordArtifact(ctx, "CAP-1.json", "application/ld+json", []byte("{}"))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	run := waitForRun(t, m, "run-1")
+
+	if len(run.Artifacts) != 2 || run.Artifacts[0].Name != "CAP-1.xml" || run.Artifacts[0].Size != len("<epcis/>") {
+		t.Errorf("run.Artifacts = %+v", run.Artifacts)
+	}
+	artifact, ok, err := m.Artifact(context.Background(), "run-1", "CAP-1.xml")
+	if err != nil || !ok {
+		t.Fatalf("Artifact() = %v, %v", ok, err)
+	}
+	if string(artifact.Content) != "<epcis/>" || artifact.ContentType != "application/xml" {
+		t.Errorf("artifact = %s %q", artifact.ContentType, artifact.Content)
+	}
+	if _, ok, _ := m.Artifact(context.Background(), "run-1", "missing"); ok {
+		t.Error("Expected missing artifact to be absent")
+	}
+}
+
+func TestSQLRunStoreGetArtifact(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLRunStore(sqlx.NewDb(db, "sqlmock"))
+	store.ensured = true
+
+	mock.ExpectQuery("SELECT content_type, content FROM pipeline_run_artifacts").
+		WithArgs("run-1", "CAP-1.xml").
+		WillReturnRows(sqlmock.NewRows([]string{"content_type", "content"}).AddRow("application/xml", []byte("<epcis/>")))
+
+	artifact, ok, err := store.GetArtifact(context.Background(), "run-1", "CAP-1.xml")
+	if err != nil || !ok {
+		t.Fatalf("GetArtifact() = %v, %v", ok, err)
+	}
+	if artifact.Size != 8 || string(artifact.Content) != "<epcis/>" {
+		t.Errorf("artifact = %+v", artifact)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLRunStoreCheckpoints(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLRunStore(sqlx.NewDb(db, "sqlmock"))
+	store.ensured = true
+
+	mock.ExpectExec("INSERT INTO pipeline_run_checkpoints").
+		WithArgs("run-1", "poll", []byte(`["a"]`), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT step, data FROM pipeline_run_checkpoints").
+		WithArgs("run-1").
+		WillReturnRows(sqlmock.NewRows([]string{"step", "data"}).AddRow("poll", []byte(`["a"]`)))
+
+	if err := store.SaveCheckpoint(context.Background(), "run-1", "poll", []byte(`["a"]`)); err != nil {
+		t.Fatalf("SaveCheckpoint() error = %v", err)
+	}
+	checkpoints, err := store.GetCheckpoints(context.Background(), "run-1")
+	if err != nil {
+		t.Fatalf("GetCheckpoints() error = %v", err)
+	}
+	if string(checkpoints["poll"]) != `["a"]` {
+		t.Errorf("checkpoints = %q", checkpoints)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLRunStoreSave(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLRunStore(sqlx.NewDb(db, "sqlmock"))
+	items := 2
+	run := Run{
+		ID:       "run-1",
+		Pipeline: "inbound",
+		Trigger:  TriggerSchedule,
+		CalledBy: "scheduler",
+		Status:   StatusRunning,
+		QueuedAt: time.Now(),
+		Steps: []StepRun{
+			{Name: "poll_trustmed_files", Status: StatusSucceeded, Attempts: 1, Items: &items, Output: "xml_files"},
+			{Name: "convert_xml_to_json", Status: StatusPending, Inputs: []string{"xml_files"}, Output: "converted_files"},
+		},
+	}
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS pipeline_runs").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS pipeline_run_steps").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS pipeline_run_artifacts").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS pipeline_run_checkpoints").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO pipeline_runs").
+		WithArgs("run-1", "inbound", TriggerSchedule, "scheduler", nil, nil, "running", sqlmock.AnyArg(), nil, nil, 0.0, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO pipeline_run_steps").
+		WithArgs("run-1", 0, "poll_trustmed_files", "succeeded", nil, nil, 0.0, 1, int64(2), "", "xml_files", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO pipeline_run_steps").
+		WithArgs("run-1", 1, "convert_xml_to_json", "pending", nil, nil, 0.0, 0, nil, "xml_files", "converted_files", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := store.Save(context.Background(), run); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLRunStoreCreateDuplicate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLRunStore(sqlx.NewDb(db, "sqlmock"))
+	store.ensured = true
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO pipeline_runs \(.+\) VALUES \([?, ]+\)$`).
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'run-1' for key 'PRIMARY'"})
+	mock.ExpectRollback()
+
+	err = store.Create(context.Background(), Run{ID: "run-1", Pipeline: "inbound", Status: StatusQueued, QueuedAt: time.Now()})
+	if !errors.Is(err, ErrRunExists) {
+		t.Errorf("Create() error = %v, want ErrRunExists", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLRunStoreGet(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLRunStore(sqlx.NewDb(db, "sqlmock"))
+	store.ensured = true
+	queued := time.Now().UTC()
+
+	mock.ExpectQuery("SELECT (.+) FROM pipeline_runs WHERE id = ?").
+		WithArgs("run-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "pipeline", "trigger_type", "called_by", "params", "artifacts", "status",
+			"queued_at", "started_at", "finished_at", "duration", "error"}).
+			AddRow("run-1", "outbound", "api", "ops-bot", `{"skip_steps":["notify_on_errors"]}`,
+				`[{"name":"CAP-1.xml","content_type":"application/xml","size":42}]`, "failed",
+				queued, queued, queued.Add(time.Second), 1.0, "boom"))
+	mock.ExpectQuery("SELECT (.+) FROM pipeline_run_steps WHERE run_id IN").
+		WithArgs("run-1").
+		WillReturnRows(sqlmock.NewRows([]string{"run_id", "position", "name", "status", "started_at",
+			"finished_at", "duration", "attempts", "items", "inputs", "output", "error"}).
+			AddRow("run-1", 0, "poll_approved_shipments", "succeeded", queued, queued, 0.1, 1, 4, "", "approved_shipments", "").
+			AddRow("run-1", 1, "query_shipment_events", "failed", queued, queued, 0.9, 3, nil, "approved_shipments", "shipments_with_events", "boom"))
+
+	run, ok, err := store.Get(context.Background(), "run-1")
+	if err != nil || !ok {
+		t.Fatalf("Get() = %v, %v", ok, err)
+	}
+	if run.Status != StatusFailed || run.Error != "boom" {
+		t.Errorf("run = %s %q, want failed boom", run.Status, run.Error)
+	}
+	if run.CalledBy != "ops-bot" {
+		t.Errorf("run.CalledBy = %q, want ops-bot", run.CalledBy)
+	}
+	if run.Params["skip_steps"] == nil {
+		t.Error("Expected params to be decoded")
+	}
+	if len(run.Artifacts) != 1 || run.Artifacts[0].Name != "CAP-1.xml" || run.Artifacts[0].Size != 42 {
+		t.Errorf("run.Artifacts = %+v, want CAP-1.xml (42 bytes)", run.Artifacts)
+	}
+	if len(run.Steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(run.Steps))
+	}
+	if run.Steps[0].Items == nil || *run.Steps[0].Items != 4 {
+		t.Errorf("step items = %v, want 4", run.Steps[0].Items)
+	}
+	if run.Steps[1].Items != nil || run.Steps[1].Attempts != 3 {
+		t.Errorf("step 2 = %+v, want no items and 3 attempts", run.Steps[1])
+	}
+	if run.Steps[0].Inputs != nil || len(run.Steps[1].Inputs) != 1 || run.Steps[1].Inputs[0] != "approved_shipments" ||
+		run.Steps[1].Output != "shipments_with_events" {
+		t.Errorf("step data = %v -> %q, want approved_shipments -> shipments_with_events", run.Steps[1].Inputs, run.Steps[1].Output)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -2,109 +2,210 @@ package inbound
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
-	"github.com/jmoiron/sqlx"
-	"github.com/trackvision/tv-pipelines-hudsci/configs"
 	"github.com/trackvision/tv-pipelines-hudsci/pipelines"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
 	"github.com/trackvision/tv-pipelines-hudsci/tasks"
 	"github.com/trackvision/tv-pipelines-hudsci/types"
-	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 )
 
-// Steps lists all task names in this pipeline (for API discovery).
-var Steps = []string{
-	"poll_trustmed_files",
-	"extract_shipment_data",
-	"convert_xml_to_json",
-	"insert_epcis_inbox",
-	"upload_json_files",
+// Pipeline polls XML files from the configured inbound sources (TrustMed
+// Dashboard files sent TO us by default), converts them to JSON, extracts
+// shipping data, and inserts to epcis_inbox.
+var Pipeline = pipelines.Pipeline{
+	Name:        "inbound",
+	Description: "Receive EPCIS files from TrustMed and other inbound sources into the Directus inbox",
+	Flow:        newFlow,
+	Params: []pipelines.ParamSpec{
+		{Name: "since", Type: pipelines.ParamTime, Description: "Poll files received from this time instead of the watermarks; the watermarks are not advanced"},
+		{Name: "until", Type: pipelines.ParamTime, Description: "End of the since window (default now)", Requires: "since"},
+		pipelines.OnlyStepsSpec,
+	},
+	Dependencies: []pipelines.Dependency{pipelines.DependencyTrustMed, pipelines.DependencyConverter},
 }
 
-// Run executes the inbound shipments pipeline.
-// This pipeline polls XML files from TrustMed Dashboard (files sent TO us),
-// converts them to JSON, extracts shipping data, and inserts to epcis_inbox.
-func Run(ctx context.Context, db *sqlx.DB, cms *tasks.DirectusClient, cfg *configs.Config, id string) error {
-	// Shared state via closures
-	var xmlFiles []types.XMLFile
-	var convertedFiles []types.ConvertedFile
-	var extractedShipments []tasks.EPCISInboxItem
+func init() {
+	pipelines.Register(Pipeline)
 
-	// Initialize TrustMed Dashboard client
-	dashboard := tasks.NewTrustMedDashboardClient(cfg)
+	pipelines.RegisterSource("poll_inbound_files", "Poll every source in INBOUND_SOURCES since its watermark (or the since/until params) and merge their files", pollInboundFiles, pipelines.DependencyTrustMed)
+	pipelines.RegisterSource("poll_trustmed_files", "Poll files received in TrustMed Dashboard since the watermark (or the since/until params)", pollTrustMedFiles, pipelines.DependencyTrustMed)
+	pipelines.RegisterTask("extract_shipment_data", "Extract shipping events, products and containers from EPCIS XML", extractShipmentData)
+	pipelines.RegisterTask("convert_xml_to_json", "Convert EPCIS XML to JSON via the converter service", convertXMLToJSON, pipelines.DependencyConverter)
+	pipelines.RegisterSink("insert_epcis_inbox", "Insert extracted shipments into the epcis_inbox collection", insertEPCISInbox)
+	pipelines.RegisterSink("upload_json_files", "Archive converted JSON files in Directus", uploadJSONFiles)
+}
 
-	flow := pipelines.NewFlow("inbound")
+// newFlow builds the inbound flow. Extract and convert both consume the
+// polled files and run in parallel.
+func newFlow(ctx context.Context, env pipelines.Env) *pipelines.Flow {
+	xmlFiles := pipelines.NewOutput[[]types.XMLFile]("xml_files")
+	extractedShipments := pipelines.NewOutput[[]tasks.EPCISInboxItem]("inbox_items")
+	convertedFiles := pipelines.NewOutput[[]types.ConvertedFile]("converted_files")
 
-	// Task 1: Poll XML files from TrustMed Dashboard (received files)
-	flow.AddTask("poll_trustmed_files", func() error {
-		var err error
-		xmlFiles, err = tasks.PollTrustMedFiles(ctx, dashboard, cms, cfg)
-		if err != nil {
-			return err
-		}
-		logger.Info("Polled TrustMed files", zap.Int("count", len(xmlFiles)))
-		return nil
+	flow := pipelines.NewFlow("inbound").
+		WithConcurrency(env.Config.StepConcurrency).
+		WithRetryOverrides(env.Config.RetryPolicies)
+
+	// Task 1: Poll XML files from the inbound sources (TrustMed received files by default)
+	pipelines.Source(flow, "poll_inbound_files", xmlFiles, func(ctx context.Context) ([]types.XMLFile, error) {
+		return pollInboundFiles(ctx, env)
 	})
 
 	// Task 2: Extract shipping data from XML (parallel with convert)
-	flow.AddTask("extract_shipment_data", func() error {
-		if len(xmlFiles) == 0 {
-			logger.Info("No XML files to extract, skipping")
-			return nil
-		}
-		var err error
-		extractedShipments, err = tasks.ExtractEPCISInboxData(ctx, cms, xmlFiles)
-		if err != nil {
-			return err
-		}
-		logger.Info("Extracted shipment data", zap.Int("count", len(extractedShipments)))
-		return nil
-	}, "poll_trustmed_files")
+	pipelines.Task(flow, "extract_shipment_data", xmlFiles, extractedShipments, func(ctx context.Context, files []types.XMLFile) ([]tasks.EPCISInboxItem, error) {
+		return extractShipmentData(ctx, env, files)
+	})
 
 	// Task 3: Convert XML to JSON via EPCIS Converter service (parallel with extract)
-	flow.AddTask("convert_xml_to_json", func() error {
-		if len(xmlFiles) == 0 {
-			logger.Info("No files to convert, skipping")
-			return nil
-		}
-		var err error
-		convertedFiles, err = tasks.ConvertXMLToJSON(ctx, cfg, xmlFiles)
-		if err != nil {
-			return err
-		}
-		logger.Info("Converted XML to JSON", zap.Int("count", len(convertedFiles)))
-		return nil
-	}, "poll_trustmed_files")
+	pipelines.Task(flow, "convert_xml_to_json", xmlFiles, convertedFiles, func(ctx context.Context, files []types.XMLFile) ([]types.ConvertedFile, error) {
+		return convertXMLToJSON(ctx, env, files)
+	})
 
 	// Task 4: Insert to epcis_inbox collection
-	flow.AddTask("insert_epcis_inbox", func() error {
-		if len(extractedShipments) == 0 {
-			logger.Info("No shipments to insert, skipping")
-			return nil
-		}
-		if err := tasks.InsertEPCISInbox(ctx, cms, extractedShipments); err != nil {
-			return err
-		}
-		logger.Info("Inserted to epcis_inbox", zap.Int("count", len(extractedShipments)))
-		return nil
-	}, "extract_shipment_data")
+	pipelines.Sink(flow, "insert_epcis_inbox", extractedShipments, func(ctx context.Context, items []tasks.EPCISInboxItem) error {
+		return insertEPCISInbox(ctx, env, items)
+	})
+
+	// Task 5: Upload JSON files to Directus (once their shipments are in the inbox)
+	pipelines.Sink(flow, "upload_json_files", convertedFiles, func(ctx context.Context, converted []types.ConvertedFile) error {
+		return uploadJSONFiles(ctx, env, converted)
+	}, "insert_epcis_inbox")
 
-	// Task 5: Upload JSON files to Directus
-	flow.AddTask("upload_json_files", func() error {
-		if len(convertedFiles) == 0 {
-			logger.Info("No JSON files to upload, skipping")
-			return nil
+	return flow
+}
+
+// pollInboundFiles polls every source in INBOUND_SOURCES and merges their
+// files.
+func pollInboundFiles(ctx context.Context, env pipelines.Env) ([]types.XMLFile, error) {
+	sources, err := tasks.NewInboundSources(env.Config, env.CMS)
+	if err != nil {
+		return nil, err
+	}
+	return pollSources(ctx, sources)
+}
+
+// pollTrustMedFiles polls received files from TrustMed Dashboard only.
+func pollTrustMedFiles(ctx context.Context, env pipelines.Env) ([]types.XMLFile, error) {
+	return pollSources(ctx, []tasks.InboundSource{tasks.NewTrustMedSource(env.Config, env.CMS)})
+}
+
+// pollSources polls each source in turn. An explicit since/until window in
+// the run params overrides the watermarks. A document found by more than one
+// source is kept once. A source that fails, or whose search stops at its page
+// limit, marks the run partial and keeps its watermark for the next run; the
+// step only fails if every source does.
+func pollSources(ctx context.Context, sources []tasks.InboundSource) ([]types.XMLFile, error) {
+	params := pipelines.ParamsFromContext(ctx)
+	since, window := params.Time("since")
+	until, ok := params.Time("until")
+	if !ok {
+		until = time.Now()
+	}
+
+	var files []types.XMLFile
+	var errs []error
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		ctx := runlog.With(ctx, zap.String("source", source.Name()))
+
+		var polled []types.XMLFile
+		var err error
+		if window {
+			polled, err = source.PollWindow(ctx, since, until)
+		} else {
+			polled, err = source.Poll(ctx)
+		}
+		if errors.Is(err, tasks.ErrSearchTruncated) {
+			pipelines.MarkPartial(ctx, fmt.Sprintf("inbound source %s: %v", source.Name(), err))
+			err = nil
 		}
-		fileIDMap, err := tasks.UploadJSONFiles(ctx, cms, cfg, convertedFiles)
 		if err != nil {
-			return err
+			runlog.Error(ctx, "Failed to poll inbound source", zap.Error(err))
+			errs = append(errs, fmt.Errorf("inbound source %s: %w", source.Name(), err))
+			continue
 		}
-		logger.Info("Uploaded JSON files to Directus", zap.Int("count", len(fileIDMap)))
-		return nil
-	}, "convert_xml_to_json", "insert_epcis_inbox")
 
-	// Suppress unused warnings
-	_ = db
+		for _, f := range polled {
+			if f.ContentHash == "" {
+				f.ContentHash = tasks.ContentHash(f.Content)
+			}
+			if seen[f.ContentHash] {
+				runlog.Info(ctx, "Skipping file already polled from another source", zap.String("filename", f.Filename))
+				continue
+			}
+			seen[f.ContentHash] = true
+			files = append(files, f)
+		}
+		runlog.Info(ctx, "Polled inbound source", zap.Int("count", len(polled)))
+	}
+
+	if len(errs) == len(sources) {
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		pipelines.MarkPartial(ctx, err.Error())
+	}
+	runlog.Info(ctx, "Polled inbound files", zap.Int("count", len(files)))
+	return files, nil
+}
 
-	return flow.Run(ctx)
+// extractShipmentData extracts inbox items from the polled XML files.
+func extractShipmentData(ctx context.Context, env pipelines.Env, files []types.XMLFile) ([]tasks.EPCISInboxItem, error) {
+	if len(files) == 0 {
+		runlog.Info(ctx, "No XML files to extract, skipping")
+		return nil, nil
+	}
+	items, err := tasks.ExtractEPCISInboxData(ctx, env.CMS, files)
+	if err != nil {
+		return nil, err
+	}
+	runlog.Info(ctx, "Extracted shipment data", zap.Int("count", len(items)))
+	return items, nil
+}
+
+// convertXMLToJSON converts the polled XML files to EPCIS JSON.
+func convertXMLToJSON(ctx context.Context, env pipelines.Env, files []types.XMLFile) ([]types.ConvertedFile, error) {
+	if len(files) == 0 {
+		runlog.Info(ctx, "No files to convert, skipping")
+		return nil, nil
+	}
+	converted, err := tasks.ConvertXMLToJSON(ctx, env.Config, files)
+	if err != nil {
+		return nil, err
+	}
+	runlog.Info(ctx, "Converted XML to JSON", zap.Int("count", len(converted)))
+	return converted, nil
+}
+
+// insertEPCISInbox inserts the extracted items into the epcis_inbox collection.
+func insertEPCISInbox(ctx context.Context, env pipelines.Env, items []tasks.EPCISInboxItem) error {
+	if len(items) == 0 {
+		runlog.Info(ctx, "No shipments to insert, skipping")
+		return nil
+	}
+	if err := tasks.InsertEPCISInbox(ctx, env.CMS, items); err != nil {
+		return err
+	}
+	pipelines.RecordItems(ctx, pipelines.StepName(ctx), len(items))
+	runlog.Info(ctx, "Inserted to epcis_inbox", zap.Int("count", len(items)))
+	return nil
+}
+
+// uploadJSONFiles archives the converted JSON files in Directus.
+func uploadJSONFiles(ctx context.Context, env pipelines.Env, converted []types.ConvertedFile) error {
+	if len(converted) == 0 {
+		runlog.Info(ctx, "No JSON files to upload, skipping")
+		return nil
+	}
+	fileIDMap, err := tasks.UploadJSONFiles(ctx, env.CMS, env.Config, converted)
+	if err != nil {
+		return err
+	}
+	pipelines.RecordItems(ctx, pipelines.StepName(ctx), len(fileIDMap))
+	runlog.Info(ctx, "Uploaded JSON files to Directus", zap.Int("count", len(fileIDMap)))
+	return nil
 }
@@ -3,13 +3,18 @@ package inbound
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/pipelines"
 	"github.com/trackvision/tv-pipelines-hudsci/tasks"
+	"github.com/trackvision/tv-pipelines-hudsci/types"
 )
 
 func TestRun(t *testing.T) {
@@ -71,7 +76,7 @@ func TestRun(t *testing.T) {
 	}
 
 	// Run pipeline with empty file list
-	err := Run(ctx, nil, cms, cfg, "test-id")
+	err := Pipeline.Run(ctx, pipelines.Env{ID: "test-id", Config: cfg, CMS: cms})
 	if err != nil {
 		t.Fatalf("Run() error = %v", err)
 	}
@@ -84,3 +89,74 @@ func mustMarshal(v interface{}) json.RawMessage {
 	}
 	return data
 }
+
+func TestFlow(t *testing.T) {
+	flow := Pipeline.Discover()
+	if err := flow.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	want := []string{"poll_inbound_files", "extract_shipment_data", "convert_xml_to_json", "insert_epcis_inbox", "upload_json_files"}
+	if got := flow.Steps(); !slices.Equal(got, want) {
+		t.Errorf("flow steps = %v, want %v", got, want)
+	}
+}
+
+// fakeSource returns fixed files or an error.
+type fakeSource struct {
+	name  string
+	files []types.XMLFile
+	err   error
+}
+
+func (s fakeSource) Name() string { return s.name }
+
+func (s fakeSource) Poll(context.Context) ([]types.XMLFile, error) { return s.files, s.err }
+
+func (s fakeSource) PollWindow(context.Context, time.Time, time.Time) ([]types.XMLFile, error) {
+	return s.files, s.err
+}
+
+func TestPollSources(t *testing.T) {
+	ctx := context.Background()
+	a := fakeSource{name: "a", files: []types.XMLFile{{ID: "a1", Content: []byte("<one/>")}, {ID: "a2", Content: []byte("<two/>")}}}
+	b := fakeSource{name: "b", files: []types.XMLFile{{ID: "b1", Content: []byte("<two/>")}, {ID: "b2", Content: []byte("<three/>")}}}
+	broken := fakeSource{name: "broken", err: errors.New("unreachable")}
+
+	files, err := pollSources(ctx, []tasks.InboundSource{a, broken, b})
+	if err != nil {
+		t.Fatalf("pollSources() error = %v, want other sources' files despite one failing", err)
+	}
+	var ids []string
+	for _, f := range files {
+		ids = append(ids, f.ID)
+	}
+	if want := []string{"a1", "a2", "b2"}; !slices.Equal(ids, want) {
+		t.Errorf("pollSources() files = %v, want %v (b1 repeats a2)", ids, want)
+	}
+
+	_, err = pollSources(ctx, []tasks.InboundSource{broken})
+	if err == nil || !strings.Contains(err.Error(), "inbound source broken: unreachable") {
+		t.Errorf("pollSources() error = %v, want the failure when every source fails", err)
+	}
+}
+
+// TestDefinitions checks the example definitions against the tasks this
+// package registers.
+func TestDefinitions(t *testing.T) {
+	defined, err := pipelines.LoadDefinitions("../../definitions")
+	if err != nil {
+		t.Fatalf("LoadDefinitions() error = %v", err)
+	}
+	i := slices.IndexFunc(defined, func(p pipelines.Pipeline) bool { return p.Name == "inbound_no_archive" })
+	if i < 0 {
+		t.Fatal("inbound_no_archive not defined")
+	}
+	p := defined[i]
+	want := []string{"poll_trustmed_files", "extract_shipment_data", "insert_epcis_inbox"}
+	if got := p.Steps(); !slices.Equal(got, want) {
+		t.Errorf("steps = %v, want %v", got, want)
+	}
+	if p.Needs(pipelines.DependencyConverter) || !p.Needs(pipelines.DependencyTrustMed) {
+		t.Errorf("dependencies = %v, want TrustMed without the converter", p.Dependencies)
+	}
+}
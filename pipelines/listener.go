@@ -0,0 +1,302 @@
+package pipelines
+
+import (
+	"context"
+	"time"
+
+	"github.com/trackvision/tv-pipelines-hudsci/metrics"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// FlowEvent describes a flow starting or finishing.
+type FlowEvent struct {
+	Pipeline string
+	Steps    []string       // FlowStarted: every step, in order
+	Outcome  string         // FlowFinished: "succeeded", "failed" or "cancelled"
+	Duration time.Duration  // FlowFinished
+	Counts   map[string]int // FlowFinished: steps by outcome, including "skipped" and "restored"
+	Err      error          // FlowFinished
+}
+
+// StepEvent describes a step changing state. Fields not listed for a
+// callback are zero.
+type StepEvent struct {
+	Pipeline string
+	Step     string
+	Attempt  int           // StepStarted, StepRetrying
+	Delay    time.Duration // StepRetrying: wait before the attempt
+	Done     int           // StepProgress
+	Total    int           // StepProgress: 0 if unknown
+	Message  string        // StepProgress
+	Reason   string        // StepSkipped; StepRestored: the resumed run's ID
+	Outcome  string        // StepFinished: "succeeded", "failed" or "cancelled"
+	Duration time.Duration // StepFinished
+	Err      error         // StepRetrying: the failed attempt; StepFinished
+}
+
+// Listener receives a flow's lifecycle events: logging, metrics, run
+// history and tracing are listeners, and notifications can be added with
+// Flow.WithListener or WithListeners. Steps run concurrently, so step
+// callbacks must be safe for concurrent use. Embed BaseListener to implement
+// only some callbacks.
+type Listener interface {
+	FlowStarted(ctx context.Context, e FlowEvent)
+	FlowFinished(ctx context.Context, e FlowEvent)
+	StepStarted(ctx context.Context, e StepEvent)
+	StepRetrying(ctx context.Context, e StepEvent)
+	StepProgress(ctx context.Context, e StepEvent)
+	StepFinished(ctx context.Context, e StepEvent)
+	StepSkipped(ctx context.Context, e StepEvent)
+	StepRestored(ctx context.Context, e StepEvent)
+}
+
+// BaseListener implements Listener with no-ops.
+type BaseListener struct{}
+
+func (BaseListener) FlowStarted(context.Context, FlowEvent)  {}
+func (BaseListener) FlowFinished(context.Context, FlowEvent) {}
+func (BaseListener) StepStarted(context.Context, StepEvent)  {}
+func (BaseListener) StepRetrying(context.Context, StepEvent) {}
+func (BaseListener) StepProgress(context.Context, StepEvent) {}
+func (BaseListener) StepFinished(context.Context, StepEvent) {}
+func (BaseListener) StepSkipped(context.Context, StepEvent)  {}
+func (BaseListener) StepRestored(context.Context, StepEvent) {}
+
+// listenersKey is the context key for listeners added with WithListeners.
+type listenersKey struct{}
+
+// WithListeners returns a context whose flows also notify ls, after the
+// flow's own listeners. Use it to attach a listener to every pipeline
+// without changing them.
+func WithListeners(ctx context.Context, ls ...Listener) context.Context {
+	existing, _ := ctx.Value(listenersKey{}).([]Listener)
+	return context.WithValue(ctx, listenersKey{}, append(append([]Listener{}, existing...), ls...))
+}
+
+// listeners fans events out to each listener in order.
+type listeners []Listener
+
+func (ls listeners) FlowStarted(ctx context.Context, e FlowEvent) {
+	for _, l := range ls {
+		l.FlowStarted(ctx, e)
+	}
+}
+
+func (ls listeners) FlowFinished(ctx context.Context, e FlowEvent) {
+	for _, l := range ls {
+		l.FlowFinished(ctx, e)
+	}
+}
+
+func (ls listeners) StepStarted(ctx context.Context, e StepEvent) {
+	for _, l := range ls {
+		l.StepStarted(ctx, e)
+	}
+}
+
+func (ls listeners) StepRetrying(ctx context.Context, e StepEvent) {
+	for _, l := range ls {
+		l.StepRetrying(ctx, e)
+	}
+}
+
+func (ls listeners) StepProgress(ctx context.Context, e StepEvent) {
+	for _, l := range ls {
+		l.StepProgress(ctx, e)
+	}
+}
+
+func (ls listeners) StepFinished(ctx context.Context, e StepEvent) {
+	for _, l := range ls {
+		l.StepFinished(ctx, e)
+	}
+}
+
+func (ls listeners) StepSkipped(ctx context.Context, e StepEvent) {
+	for _, l := range ls {
+		l.StepSkipped(ctx, e)
+	}
+}
+
+func (ls listeners) StepRestored(ctx context.Context, e StepEvent) {
+	for _, l := range ls {
+		l.StepRestored(ctx, e)
+	}
+}
+
+// LoggingListener logs flow and step events.
+type LoggingListener struct{}
+
+func (LoggingListener) FlowStarted(ctx context.Context, e FlowEvent) {
+	runlog.Info(ctx, "flow started",
+		zap.String("pipeline", e.Pipeline),
+		zap.Int("task_count", len(e.Steps)),
+		zap.Strings("steps", e.Steps))
+}
+
+func (LoggingListener) FlowFinished(ctx context.Context, e FlowEvent) {
+	fields := []zap.Field{
+		zap.String("pipeline", e.Pipeline),
+		zap.Duration("duration", e.Duration),
+		zap.Int("steps_completed", e.Counts["succeeded"]),
+		zap.Int("steps_skipped", e.Counts["skipped"]),
+		zap.Int("steps_restored", e.Counts["restored"]),
+	}
+	if e.Err != nil {
+		runlog.Warn(ctx, "flow "+e.Outcome, append(fields, zap.Error(e.Err))...)
+		return
+	}
+	runlog.Info(ctx, "flow completed", fields...)
+}
+
+func (LoggingListener) StepStarted(ctx context.Context, e StepEvent) {
+	runlog.Info(ctx, "step started",
+		zap.String("pipeline", e.Pipeline),
+		zap.String("step", e.Step))
+}
+
+func (LoggingListener) StepRetrying(ctx context.Context, e StepEvent) {
+	runlog.Info(ctx, "Retrying task",
+		zap.String("step", e.Step),
+		zap.Int("attempt", e.Attempt),
+		zap.Duration("delay", e.Delay),
+		zap.Error(e.Err))
+}
+
+func (LoggingListener) StepProgress(context.Context, StepEvent) {}
+
+func (LoggingListener) StepFinished(ctx context.Context, e StepEvent) {
+	switch e.Outcome {
+	case "succeeded":
+		runlog.Info(ctx, "step completed",
+			zap.String("pipeline", e.Pipeline),
+			zap.String("step", e.Step),
+			zap.Duration("duration", e.Duration))
+	case "cancelled":
+		runlog.Warn(ctx, "step cancelled",
+			zap.String("pipeline", e.Pipeline),
+			zap.String("step", e.Step),
+			zap.Error(e.Err),
+			zap.Duration("duration", e.Duration))
+	default:
+		runlog.Error(ctx, "step failed",
+			zap.String("pipeline", e.Pipeline),
+			zap.String("step", e.Step),
+			zap.Error(e.Err),
+			zap.Duration("duration", e.Duration))
+	}
+}
+
+func (LoggingListener) StepSkipped(ctx context.Context, e StepEvent) {
+	runlog.Info(ctx, "step skipped",
+		zap.String("pipeline", e.Pipeline),
+		zap.String("step", e.Step),
+		zap.String("reason", e.Reason))
+}
+
+func (LoggingListener) StepRestored(ctx context.Context, e StepEvent) {
+	runlog.Info(ctx, "step restored",
+		zap.String("pipeline", e.Pipeline),
+		zap.String("step", e.Step),
+		zap.String("resumed_from", e.Reason))
+}
+
+// MetricsListener records flow and step counts, durations and retries (see
+// the metrics package).
+type MetricsListener struct{ BaseListener }
+
+func (MetricsListener) FlowFinished(_ context.Context, e FlowEvent) {
+	metrics.FlowRuns.Inc(e.Pipeline, e.Outcome)
+	metrics.FlowDuration.Observe(e.Duration.Seconds(), e.Pipeline, e.Outcome)
+}
+
+func (MetricsListener) StepRetrying(_ context.Context, e StepEvent) {
+	metrics.StepRetries.Inc(e.Pipeline, e.Step)
+}
+
+func (MetricsListener) StepFinished(_ context.Context, e StepEvent) {
+	metrics.StepRuns.Inc(e.Pipeline, e.Step, e.Outcome)
+	metrics.StepDuration.Observe(e.Duration.Seconds(), e.Pipeline, e.Step, e.Outcome)
+}
+
+func (MetricsListener) StepSkipped(_ context.Context, e StepEvent) {
+	metrics.StepRuns.Inc(e.Pipeline, e.Step, "skipped")
+}
+
+func (MetricsListener) StepRestored(_ context.Context, e StepEvent) {
+	metrics.StepRuns.Inc(e.Pipeline, e.Step, "restored")
+}
+
+// historyListener updates the run record and event stream of a run started
+// by a RunManager. Outside a managed run it does nothing.
+type historyListener struct{ BaseListener }
+
+func (historyListener) StepStarted(ctx context.Context, e StepEvent) {
+	if state := runStateFromContext(ctx); state != nil {
+		state.stepStarted(e.Step)
+		state.stepAttempt(e.Step, 1)
+	}
+}
+
+func (historyListener) StepRetrying(ctx context.Context, e StepEvent) {
+	if state := runStateFromContext(ctx); state != nil {
+		state.stepAttempt(e.Step, e.Attempt)
+	}
+}
+
+func (historyListener) StepProgress(ctx context.Context, e StepEvent) {
+	if state := runStateFromContext(ctx); state != nil {
+		state.stepProgress(e.Step, e.Done, e.Total, e.Message)
+	}
+}
+
+func (historyListener) StepFinished(ctx context.Context, e StepEvent) {
+	state := runStateFromContext(ctx)
+	switch {
+	case state == nil:
+	case e.Outcome == "cancelled":
+		state.stepCancelled(e.Step, e.Err)
+	default:
+		state.stepFinished(e.Step, e.Err)
+	}
+}
+
+func (historyListener) StepSkipped(ctx context.Context, e StepEvent) {
+	if state := runStateFromContext(ctx); state != nil {
+		state.stepSkipped(e.Step, e.Reason)
+	}
+}
+
+func (historyListener) StepRestored(ctx context.Context, e StepEvent) {
+	if state := runStateFromContext(ctx); state != nil {
+		state.stepRestored(e.Step, e.Reason)
+	}
+}
+
+// tracingListener adds retries, skips and restores as events on the span in
+// ctx: the step's span for retries and the run's for the others. Flow starts
+// and ends the spans itself, as steps need the span in their context.
+type tracingListener struct{ BaseListener }
+
+func (tracingListener) StepRetrying(ctx context.Context, e StepEvent) {
+	trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+		attribute.Int("attempt", e.Attempt),
+		attribute.String("delay", e.Delay.String()),
+		attribute.String("error", e.Err.Error())))
+}
+
+func (tracingListener) StepSkipped(ctx context.Context, e StepEvent) {
+	trace.SpanFromContext(ctx).AddEvent("step skipped", trace.WithAttributes(
+		tracing.Step.String(e.Step),
+		attribute.String("reason", e.Reason)))
+}
+
+func (tracingListener) StepRestored(ctx context.Context, e StepEvent) {
+	trace.SpanFromContext(ctx).AddEvent("step restored", trace.WithAttributes(
+		tracing.Step.String(e.Step),
+		attribute.String("resumed_from", e.Reason)))
+}
@@ -0,0 +1,219 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trackvision/tv-pipelines-hudsci/progress"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// recordingListener records events as "callback step detail".
+type recordingListener struct {
+	mu     sync.Mutex
+	events []string
+	counts map[string]int
+}
+
+func (r *recordingListener) add(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingListener) FlowStarted(_ context.Context, e FlowEvent) {
+	r.add("flow_started %v", e.Steps)
+}
+
+func (r *recordingListener) FlowFinished(_ context.Context, e FlowEvent) {
+	r.counts = e.Counts
+	r.add("flow_finished %s", e.Outcome)
+}
+
+func (r *recordingListener) StepStarted(_ context.Context, e StepEvent) {
+	r.add("started %s", e.Step)
+}
+
+func (r *recordingListener) StepRetrying(_ context.Context, e StepEvent) {
+	r.add("retrying %s %d %v", e.Step, e.Attempt, e.Err)
+}
+
+func (r *recordingListener) StepProgress(_ context.Context, e StepEvent) {
+	r.add("progress %s %d/%d", e.Step, e.Done, e.Total)
+}
+
+func (r *recordingListener) StepFinished(_ context.Context, e StepEvent) {
+	r.add("finished %s %s", e.Step, e.Outcome)
+}
+
+func (r *recordingListener) StepSkipped(_ context.Context, e StepEvent) {
+	r.add("skipped %s %s", e.Step, e.Reason)
+}
+
+func (r *recordingListener) StepRestored(_ context.Context, e StepEvent) {
+	r.add("restored %s %s", e.Step, e.Reason)
+}
+
+func TestFlowListener(t *testing.T) {
+	rec := &recordingListener{}
+	attempts := 0
+
+	flow := NewFlow("test").WithListener(rec).
+		WithRetry("fetch", RetryPolicy{MaxAttempts: 2})
+	flow.AddTask("fetch", func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("boom")
+		}
+		progress.Report(ctx, 1, 2, "first")
+		return nil
+	})
+	flow.AddTask("notify", func(context.Context) error { return nil }, "fetch")
+
+	ctx := context.WithValue(context.Background(), SkipStepsKey, []string{"notify"})
+	if err := flow.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []string{
+		"flow_started [fetch notify]",
+		"started fetch",
+		"retrying fetch 2 boom",
+		"progress fetch 1/2",
+		"finished fetch succeeded",
+		"skipped notify requested",
+		"flow_finished succeeded",
+	}
+	if !slices.Equal(rec.events, want) {
+		t.Errorf("events = %q, want %q", rec.events, want)
+	}
+	if rec.counts["succeeded"] != 1 || rec.counts["skipped"] != 1 {
+		t.Errorf("counts = %v, want 1 succeeded and 1 skipped", rec.counts)
+	}
+}
+
+func TestFlowListenerFromContext(t *testing.T) {
+	rec := &recordingListener{}
+	flow := NewFlow("test")
+	flow.AddTask("fail", func(context.Context) error { return Permanent(errors.New("bad input")) })
+
+	ctx := WithListeners(context.Background(), rec)
+	if err := flow.Run(ctx); err == nil {
+		t.Fatal("Run() expected error")
+	}
+
+	want := []string{
+		"flow_started [fail]",
+		"started fail",
+		"finished fail failed",
+		"flow_finished failed",
+	}
+	if !slices.Equal(rec.events, want) {
+		t.Errorf("events = %q, want %q", rec.events, want)
+	}
+	if rec.counts["failed"] != 1 {
+		t.Errorf("counts = %v, want 1 failed", rec.counts)
+	}
+}
+
+func TestFlowListenerRunHistory(t *testing.T) {
+	m := NewRunManager(10, nil)
+	flow := NewFlow("test").WithRetry("fetch", RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond})
+	attempts := 0
+	flow.AddTask("fetch", func(context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	run, err := m.Submit(context.Background(), RunSpec{ID: "run-1", Pipeline: "test"}, flow.Run)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	run = waitForRun(t, m, run.ID)
+	if run.Status != StatusSucceeded {
+		t.Fatalf("run status = %s (%s), want succeeded", run.Status, run.Error)
+	}
+	if st := run.Steps[0]; st.Status != StatusSucceeded || st.Attempts != 3 {
+		t.Errorf("fetch = %+v, want succeeded after 3 attempts", st)
+	}
+}
+
+// spanRecorder is a SpanExporter that keeps the spans it is given.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (r *spanRecorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(context.Context) error { return nil }
+
+func TestFlowTracing(t *testing.T) {
+	rec := &spanRecorder{}
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	attempts := 0
+	flow := NewFlow("test").WithRetry("fetch", RetryPolicy{MaxAttempts: 2})
+	flow.AddTask("fetch", func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("boom")
+		}
+		_, span := tracing.StartClient(ctx, "directus.QueryItems")
+		span.End()
+		return nil
+	})
+	flow.AddTask("notify", func(context.Context) error { return nil }, "fetch")
+
+	ctx := context.WithValue(context.Background(), SkipStepsKey, []string{"notify"})
+	if err := flow.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range rec.spans {
+		spans[s.Name()] = s
+	}
+	root, step, client := spans["flow test"], spans["step fetch"], spans["directus.QueryItems"]
+	if root == nil || step == nil || client == nil {
+		t.Fatalf("spans = %v, want flow, step and client spans", slices.Collect(maps.Keys(spans)))
+	}
+	if step.Parent().SpanID() != root.SpanContext().SpanID() || client.Parent().SpanID() != step.SpanContext().SpanID() {
+		t.Error("want client span under step span under flow span")
+	}
+	if _, ok := spans["step notify"]; ok {
+		t.Error("skipped step should not have a span")
+	}
+
+	events := func(s sdktrace.ReadOnlySpan) []string {
+		var names []string
+		for _, e := range s.Events() {
+			names = append(names, e.Name)
+		}
+		return names
+	}
+	if got := events(step); !slices.Equal(got, []string{"retry"}) {
+		t.Errorf("step events = %v, want [retry]", got)
+	}
+	if got := events(root); !slices.Equal(got, []string{"step skipped"}) {
+		t.Errorf("flow events = %v, want [step skipped]", got)
+	}
+}
@@ -0,0 +1,397 @@
+package pipelines
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/trackvision/tv-shared-go/logger"
+	"go.uber.org/zap"
+)
+
+// Lease is a per-pipeline lock held by a single run until it expires.
+type Lease struct {
+	Pipeline   string    `json:"pipeline" db:"pipeline"`
+	RunID      string    `json:"run_id" db:"run_id"`
+	Holder     string    `json:"holder" db:"holder"`
+	AcquiredAt time.Time `json:"acquired_at" db:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// LockHeldError is returned by Acquire when another run holds the lease.
+type LockHeldError struct {
+	Lease Lease
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("pipeline %s is locked by run %s (expires %s)",
+		e.Lease.Pipeline, e.Lease.RunID, e.Lease.ExpiresAt.Format(time.RFC3339))
+}
+
+// ErrLeaseLost is returned by Renew when the lease expired or was released.
+var ErrLeaseLost = errors.New("lease lost")
+
+// LockStore persists pipeline leases.
+type LockStore interface {
+	// Acquire takes the lease for pipeline, or returns *LockHeldError.
+	Acquire(ctx context.Context, pipeline, runID string, ttl time.Duration) (Lease, error)
+	// Renew extends a held lease, or returns ErrLeaseLost.
+	Renew(ctx context.Context, pipeline, runID string, ttl time.Duration) error
+	// Release drops the lease if runID still holds it.
+	Release(ctx context.Context, pipeline, runID string) error
+	// ForceRelease drops the lease regardless of holder and returns it.
+	ForceRelease(ctx context.Context, pipeline string) (Lease, bool, error)
+	// List returns all unexpired leases.
+	List(ctx context.Context) ([]Lease, error)
+}
+
+// instanceName identifies this process in leases and schedule claims.
+func instanceName() string {
+	instance, _ := os.Hostname()
+	if rev := os.Getenv("K_REVISION"); rev != "" {
+		instance = rev + "/" + instance
+	}
+	return instance
+}
+
+// PipelineLocker takes pipeline leases and keeps them alive while a run
+// executes, so overlapping runs (on this or another instance) are rejected.
+type PipelineLocker struct {
+	store LockStore
+	ttl   time.Duration
+}
+
+// NewPipelineLocker creates a locker with the given lease TTL. Leases are
+// renewed every ttl/3 while held.
+func NewPipelineLocker(store LockStore, ttl time.Duration) *PipelineLocker {
+	if ttl <= 0 {
+		ttl = 2 * time.Minute
+	}
+	return &PipelineLocker{store: store, ttl: ttl}
+}
+
+// Acquire takes the lease for pipeline on behalf of runID.
+func (l *PipelineLocker) Acquire(ctx context.Context, pipeline, runID string) (*HeldLease, error) {
+	start := time.Now()
+	lease, err := l.store.Acquire(ctx, pipeline, runID, l.ttl)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Pipeline lock acquired",
+		zap.String("pipeline", pipeline),
+		zap.String("run_id", runID),
+		zap.Time("expires_at", lease.ExpiresAt))
+	return &HeldLease{Lease: lease, locker: l, expires: start.Add(l.ttl)}, nil
+}
+
+// HeldLease is a lease owned by this process.
+type HeldLease struct {
+	Lease  Lease
+	locker *PipelineLocker
+	// expires is when the lease lapses by this process's clock, counted
+	// from before the last successful acquire or renew was sent
+	expires time.Time
+}
+
+// Keepalive renews the lease until ctx is done. If the lease is lost (for
+// example after a force-release), or renewals keep failing until it
+// expires, onLost is called once and renewal stops.
+func (h *HeldLease) Keepalive(ctx context.Context, onLost func()) {
+	ticker := time.NewTicker(h.locker.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		err := h.locker.store.Renew(ctx, h.Lease.Pipeline, h.Lease.RunID, h.locker.ttl)
+		if err == nil {
+			h.expires = start.Add(h.locker.ttl)
+			continue
+		}
+		if errors.Is(err, ErrLeaseLost) {
+			logger.Error("Pipeline lock lost, cancelling run",
+				zap.String("pipeline", h.Lease.Pipeline),
+				zap.String("run_id", h.Lease.RunID))
+			onLost()
+			return
+		}
+		if time.Now().After(h.expires) {
+			// Another instance may take the lease from here
+			logger.Error("Pipeline lock expired while renewals failed, cancelling run",
+				zap.String("pipeline", h.Lease.Pipeline),
+				zap.String("run_id", h.Lease.RunID),
+				zap.Time("expired_at", h.expires),
+				zap.Error(err))
+			onLost()
+			return
+		}
+		// Transient; the lease stays valid until it expires
+		logger.Warn("Failed to renew pipeline lock",
+			zap.String("pipeline", h.Lease.Pipeline),
+			zap.String("run_id", h.Lease.RunID),
+			zap.Time("expires_at", h.expires),
+			zap.Error(err))
+	}
+}
+
+// Release drops the lease. It uses a fresh context so the lease is released
+// even when the run was cancelled.
+func (h *HeldLease) Release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.locker.store.Release(ctx, h.Lease.Pipeline, h.Lease.RunID); err != nil {
+		logger.Warn("Failed to release pipeline lock",
+			zap.String("pipeline", h.Lease.Pipeline),
+			zap.String("run_id", h.Lease.RunID),
+			zap.Error(err))
+		return
+	}
+	logger.Info("Pipeline lock released",
+		zap.String("pipeline", h.Lease.Pipeline),
+		zap.String("run_id", h.Lease.RunID))
+}
+
+// MemoryLockStore keeps leases in process memory. It only prevents overlap
+// within a single instance.
+type MemoryLockStore struct {
+	mu     sync.Mutex
+	leases map[string]Lease
+	holder string
+}
+
+// NewMemoryLockStore creates an in-memory lock store.
+func NewMemoryLockStore() *MemoryLockStore {
+	return &MemoryLockStore{leases: make(map[string]Lease), holder: instanceName()}
+}
+
+// Acquire takes the lease if it is free or expired.
+func (s *MemoryLockStore) Acquire(ctx context.Context, pipeline, runID string, ttl time.Duration) (Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if lease, ok := s.leases[pipeline]; ok && lease.ExpiresAt.After(now) {
+		return Lease{}, &LockHeldError{Lease: lease}
+	}
+	lease := Lease{
+		Pipeline:   pipeline,
+		RunID:      runID,
+		Holder:     s.holder,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	s.leases[pipeline] = lease
+	return lease, nil
+}
+
+// Renew extends the lease if runID still holds it.
+func (s *MemoryLockStore) Renew(ctx context.Context, pipeline, runID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lease, ok := s.leases[pipeline]
+	if !ok || lease.RunID != runID {
+		return ErrLeaseLost
+	}
+	lease.ExpiresAt = time.Now().Add(ttl)
+	s.leases[pipeline] = lease
+	return nil
+}
+
+// Release drops the lease if runID still holds it.
+func (s *MemoryLockStore) Release(ctx context.Context, pipeline, runID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lease, ok := s.leases[pipeline]; ok && lease.RunID == runID {
+		delete(s.leases, pipeline)
+	}
+	return nil
+}
+
+// ForceRelease drops the lease regardless of holder.
+func (s *MemoryLockStore) ForceRelease(ctx context.Context, pipeline string) (Lease, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lease, ok := s.leases[pipeline]
+	delete(s.leases, pipeline)
+	return lease, ok, nil
+}
+
+// List returns all unexpired leases sorted by pipeline.
+func (s *MemoryLockStore) List(ctx context.Context) ([]Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	leases := make([]Lease, 0, len(s.leases))
+	for _, lease := range s.leases {
+		if lease.ExpiresAt.After(now) {
+			leases = append(leases, lease)
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Pipeline < leases[j].Pipeline })
+	return leases, nil
+}
+
+// pipelineLocksTable holds one lease row per locked pipeline.
+const pipelineLocksTable = "pipeline_locks"
+
+// SQLLockStore keeps leases in a TiDB/MySQL table so they are shared by all
+// instances. A lease is a row keyed by pipeline; expired rows are replaced.
+type SQLLockStore struct {
+	db     *sqlx.DB
+	holder string
+
+	mu      sync.Mutex
+	ensured bool
+}
+
+// NewSQLLockStore creates a lock store backed by db.
+func NewSQLLockStore(db *sqlx.DB) *SQLLockStore {
+	return &SQLLockStore{db: db, holder: instanceName()}
+}
+
+// ensureTable creates the locks table on first use.
+func (s *SQLLockStore) ensureTable(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ensured {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+pipelineLocksTable+` (
+		pipeline VARCHAR(100) NOT NULL PRIMARY KEY,
+		run_id VARCHAR(255) NOT NULL,
+		holder VARCHAR(255) NOT NULL,
+		acquired_at DATETIME(6) NOT NULL,
+		expires_at DATETIME(6) NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating %s table: %w", pipelineLocksTable, err)
+	}
+	s.ensured = true
+	return nil
+}
+
+// Acquire clears an expired lease, then inserts a new one. The primary key
+// ensures only one concurrent insert succeeds.
+func (s *SQLLockStore) Acquire(ctx context.Context, pipeline, runID string, ttl time.Duration) (Lease, error) {
+	if err := s.ensureTable(ctx); err != nil {
+		return Lease{}, err
+	}
+
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM `+pipelineLocksTable+` WHERE pipeline = ? AND expires_at < ?`,
+		pipeline, now); err != nil {
+		return Lease{}, fmt.Errorf("clearing expired lease: %w", err)
+	}
+
+	lease := Lease{
+		Pipeline:   pipeline,
+		RunID:      runID,
+		Holder:     s.holder,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	result, err := s.db.ExecContext(ctx,
+		`INSERT IGNORE INTO `+pipelineLocksTable+` (pipeline, run_id, holder, acquired_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		lease.Pipeline, lease.RunID, lease.Holder, lease.AcquiredAt, lease.ExpiresAt)
+	if err != nil {
+		return Lease{}, fmt.Errorf("inserting lease: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return Lease{}, fmt.Errorf("reading lease result: %w", err)
+	}
+	if rows == 1 {
+		return lease, nil
+	}
+
+	var current Lease
+	err = s.db.GetContext(ctx, &current,
+		`SELECT pipeline, run_id, holder, acquired_at, expires_at FROM `+pipelineLocksTable+` WHERE pipeline = ?`,
+		pipeline)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released between our insert and select; let the caller retry
+		return Lease{}, fmt.Errorf("lease for %s changed hands, retry", pipeline)
+	}
+	if err != nil {
+		return Lease{}, fmt.Errorf("reading current lease: %w", err)
+	}
+	return Lease{}, &LockHeldError{Lease: current}
+}
+
+// Renew extends the lease if runID still holds it.
+func (s *SQLLockStore) Renew(ctx context.Context, pipeline, runID string, ttl time.Duration) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE `+pipelineLocksTable+` SET expires_at = ? WHERE pipeline = ? AND run_id = ?`,
+		time.Now().UTC().Add(ttl), pipeline, runID)
+	if err != nil {
+		return fmt.Errorf("renewing lease: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("reading renew result: %w", err)
+	}
+	if rows == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Release drops the lease if runID still holds it.
+func (s *SQLLockStore) Release(ctx context.Context, pipeline, runID string) error {
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM `+pipelineLocksTable+` WHERE pipeline = ? AND run_id = ?`,
+		pipeline, runID); err != nil {
+		return fmt.Errorf("releasing lease: %w", err)
+	}
+	return nil
+}
+
+// ForceRelease drops the lease regardless of holder.
+func (s *SQLLockStore) ForceRelease(ctx context.Context, pipeline string) (Lease, bool, error) {
+	if err := s.ensureTable(ctx); err != nil {
+		return Lease{}, false, err
+	}
+
+	var lease Lease
+	err := s.db.GetContext(ctx, &lease,
+		`SELECT pipeline, run_id, holder, acquired_at, expires_at FROM `+pipelineLocksTable+` WHERE pipeline = ?`,
+		pipeline)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Lease{}, false, nil
+	}
+	if err != nil {
+		return Lease{}, false, fmt.Errorf("reading lease: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM `+pipelineLocksTable+` WHERE pipeline = ?`, pipeline); err != nil {
+		return Lease{}, false, fmt.Errorf("deleting lease: %w", err)
+	}
+	return lease, true, nil
+}
+
+// List returns all unexpired leases sorted by pipeline.
+func (s *SQLLockStore) List(ctx context.Context) ([]Lease, error) {
+	if err := s.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	var leases []Lease
+	if err := s.db.SelectContext(ctx, &leases,
+		`SELECT pipeline, run_id, holder, acquired_at, expires_at FROM `+pipelineLocksTable+` WHERE expires_at >= ? ORDER BY pipeline`,
+		time.Now().UTC()); err != nil {
+		return nil, fmt.Errorf("listing leases: %w", err)
+	}
+	return leases, nil
+}
@@ -0,0 +1,201 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestMemoryLockStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryLockStore()
+
+	if _, err := store.Acquire(ctx, "outbound", "run-1", time.Minute); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	_, err := store.Acquire(ctx, "outbound", "run-2", time.Minute)
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("Acquire() error = %v, want LockHeldError", err)
+	}
+	if held.Lease.RunID != "run-1" {
+		t.Errorf("holder = %s, want run-1", held.Lease.RunID)
+	}
+
+	// Other pipelines are independent
+	if _, err := store.Acquire(ctx, "inbound", "run-3", time.Minute); err != nil {
+		t.Errorf("Acquire(inbound) error = %v", err)
+	}
+
+	if err := store.Renew(ctx, "outbound", "run-2", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Renew() by non-holder error = %v, want ErrLeaseLost", err)
+	}
+	if err := store.Renew(ctx, "outbound", "run-1", time.Minute); err != nil {
+		t.Errorf("Renew() error = %v", err)
+	}
+
+	locks, _ := store.List(ctx)
+	if len(locks) != 2 || locks[0].Pipeline != "inbound" {
+		t.Errorf("List() = %+v, want inbound and outbound", locks)
+	}
+
+	lease, ok, _ := store.ForceRelease(ctx, "outbound")
+	if !ok || lease.RunID != "run-1" {
+		t.Errorf("ForceRelease() = %+v, %v", lease, ok)
+	}
+	if err := store.Renew(ctx, "outbound", "run-1", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Renew() after force release error = %v, want ErrLeaseLost", err)
+	}
+	if _, err := store.Acquire(ctx, "outbound", "run-2", time.Minute); err != nil {
+		t.Errorf("Acquire() after release error = %v", err)
+	}
+}
+
+func TestMemoryLockStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryLockStore()
+
+	if _, err := store.Acquire(ctx, "inbound", "run-1", time.Millisecond); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := store.Acquire(ctx, "inbound", "run-2", time.Minute); err != nil {
+		t.Errorf("Acquire() over expired lease error = %v", err)
+	}
+}
+
+func TestHeldLeaseKeepaliveLost(t *testing.T) {
+	store := NewMemoryLockStore()
+	locker := NewPipelineLocker(store, 30*time.Millisecond)
+
+	lease, err := locker.Acquire(context.Background(), "outbound", "run-1")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	lost := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lease.Keepalive(ctx, func() { close(lost) })
+
+	_, _, _ = store.ForceRelease(context.Background(), "outbound")
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("Keepalive did not report the lost lease")
+	}
+}
+
+// unreachableLockStore acquires leases but fails every renewal transiently.
+type unreachableLockStore struct {
+	*MemoryLockStore
+}
+
+func (unreachableLockStore) Renew(context.Context, string, string, time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func TestHeldLeaseKeepaliveExpired(t *testing.T) {
+	locker := NewPipelineLocker(unreachableLockStore{NewMemoryLockStore()}, 30*time.Millisecond)
+
+	lease, err := locker.Acquire(context.Background(), "outbound", "run-1")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	lost := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	go lease.Keepalive(ctx, func() { close(lost) })
+
+	select {
+	case <-lost:
+		if time.Since(start) < 30*time.Millisecond {
+			t.Errorf("Keepalive gave up after %s, before the lease expired", time.Since(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Keepalive did not report the lease expiring while renewals failed")
+	}
+}
+
+func TestSQLLockStoreAcquire(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLLockStore(sqlx.NewDb(db, "sqlmock"))
+	ctx := context.Background()
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS pipeline_locks").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM pipeline_locks WHERE pipeline = \\? AND expires_at").
+		WithArgs("outbound", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT IGNORE INTO pipeline_locks").
+		WithArgs("outbound", "run-1", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	lease, err := store.Acquire(ctx, "outbound", "run-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if lease.RunID != "run-1" {
+		t.Errorf("RunID = %s, want run-1", lease.RunID)
+	}
+
+	// Second run: the insert is ignored and the current holder is reported
+	acquired := time.Now().UTC()
+	mock.ExpectExec("DELETE FROM pipeline_locks WHERE pipeline = \\? AND expires_at").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT IGNORE INTO pipeline_locks").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT pipeline, run_id, holder, acquired_at, expires_at FROM pipeline_locks").
+		WithArgs("outbound").
+		WillReturnRows(sqlmock.NewRows([]string{"pipeline", "run_id", "holder", "acquired_at", "expires_at"}).
+			AddRow("outbound", "run-1", "host-a", acquired, acquired.Add(time.Minute)))
+
+	_, err = store.Acquire(ctx, "outbound", "run-2", time.Minute)
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("Acquire() error = %v, want LockHeldError", err)
+	}
+	if held.Lease.RunID != "run-1" || held.Lease.Holder != "host-a" {
+		t.Errorf("holder = %+v, want run-1 on host-a", held.Lease)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSQLLockStoreRenewLost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSQLLockStore(sqlx.NewDb(db, "sqlmock"))
+
+	mock.ExpectExec("UPDATE pipeline_locks SET expires_at").
+		WithArgs(sqlmock.AnyArg(), "outbound", "run-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := store.Renew(context.Background(), "outbound", "run-1", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Renew() error = %v, want ErrLeaseLost", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -5,212 +5,328 @@ import (
 	"encoding/json"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/trackvision/tv-pipelines-hudsci/configs"
 	"github.com/trackvision/tv-pipelines-hudsci/pipelines"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
 	"github.com/trackvision/tv-pipelines-hudsci/tasks"
-	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 )
 
-// Steps lists all task names in this pipeline (for API discovery).
-var Steps = []string{
-	"poll_approved_shipments",
-	"query_shipment_events",
-	"build_epcis_documents",
-	"add_xml_headers",
+// Pipeline queries approved shipments, builds EPCIS documents, and
+// dispatches them via TrustMed mTLS.
+var Pipeline = pipelines.Pipeline{
+	Name:        "outbound",
+	Description: "Dispatch approved shipments to TrustMed as EPCIS documents",
+	Flow:        newFlow,
+	Prepare:     prepare,
+	Params: []pipelines.ParamSpec{
+		{Name: "capture_ids", Type: pipelines.ParamStringList, Description: "Dispatch only these approved shipments by capture ID, bypassing batching"},
+		{Name: "shipping_operation_ids", Type: pipelines.ParamStringList, Description: "Dispatch only these approved shipments by shipping operation ID, bypassing batching"},
+		{Name: "dry_run", Type: pipelines.ParamBool, Description: "Build documents and return them as run artifacts without creating dispatch records or calling TrustMed"},
+		pipelines.OnlyStepsSpec,
+	},
+	Dependencies: []pipelines.Dependency{pipelines.DependencyDB, pipelines.DependencyTrustMed, pipelines.DependencyConverter},
+}
+
+func init() {
+	pipelines.Register(Pipeline)
+
+	pipelines.RegisterSource("poll_approved_shipments", "Poll approved shipments from Directus (or those named by the capture_ids/shipping_operation_ids params)", pollApprovedShipments)
+	pipelines.RegisterTask("query_shipment_events", "Query each shipment's EPCIS events from TiDB", func(ctx context.Context, env pipelines.Env, shipments []tasks.ApprovedShipment) ([]tasks.ShipmentWithEvents, error) {
+		return queryShipmentEvents(ctx, env.DB, shipments), nil
+	}, pipelines.DependencyDB)
+	pipelines.RegisterTask("build_epcis_documents", "Build EPCIS 2.0 JSON-LD documents and convert them to XML via the converter service", buildEPCISDocuments, pipelines.DependencyConverter)
+	pipelines.RegisterTask("add_xml_headers", "Add SBDH headers, DSCSA statements and the VocabularyList to EPCIS XML", addXMLHeaders)
+	pipelines.RegisterTask("manage_dispatch_records", "Create or update dispatch records and upload their files to Directus", manageDispatchRecords)
+	pipelines.RegisterTask("dispatch_via_trustmed", "Send documents to the TrustMed Partner API over mTLS", dispatchViaTrustMed, pipelines.DependencyTrustMed)
+	pipelines.RegisterSink("poll_dispatch_confirmation", "Poll TrustMed Dashboard for delivery confirmation", pollDispatchConfirmation, pipelines.DependencyTrustMed)
+	pipelines.RegisterSink("notify_on_errors", "Log and notify on permanent dispatch failures", notifyOnErrors)
+}
+
+// DryRunSkippedSteps are the steps a dry run skips: everything that writes
+// to Directus or calls TrustMed.
+var DryRunSkippedSteps = []string{
 	"manage_dispatch_records",
 	"dispatch_via_trustmed",
 	"poll_dispatch_confirmation",
 	"notify_on_errors",
 }
 
-// Run executes the outbound shipments received pipeline.
-// This pipeline queries approved shipments, builds EPCIS documents,
-// and dispatches them via TrustMed mTLS.
-func Run(ctx context.Context, db *sqlx.DB, cms *tasks.DirectusClient, cfg *configs.Config, id string) error {
-	// Shared state via closures
-	var approvedShipments []tasks.ApprovedShipment
-	var shipmentsWithEvents []tasks.ShipmentWithEvents
-	var epcisDocuments []tasks.EPCISDocumentWithMetadata
-	var enhancedDocuments []tasks.EnhancedDocument
-	var dispatchRecords []tasks.DispatchRecordWithFiles
-	var dispatchResults []tasks.DispatchResult
+// dryRun reports whether the run is a dry run, in which the steps in
+// DryRunSkippedSteps do nothing. prepare skips them, but they check for
+// themselves too: pipeline definitions composed from the catalogue have no
+// Prepare and may declare dry_run.
+func dryRun(ctx context.Context, step string) bool {
+	if !pipelines.ParamsFromContext(ctx).Bool("dry_run") {
+		return false
+	}
+	runlog.Info(ctx, "Dry run: step does nothing", zap.String("step", step))
+	return true
+}
 
-	flow := pipelines.NewFlow("outbound")
+// prepare skips the steps a dry run leaves out.
+func prepare(ctx context.Context) context.Context {
+	if !pipelines.ParamsFromContext(ctx).Bool("dry_run") {
+		return ctx
+	}
+	skip, _ := ctx.Value(pipelines.SkipStepsKey).([]string)
+	skip = append(append([]string{}, skip...), DryRunSkippedSteps...)
+	runlog.Info(ctx, "Dry run: documents will be returned as run artifacts and not dispatched")
+	return context.WithValue(ctx, pipelines.SkipStepsKey, skip)
+}
+
+// newFlow builds the outbound flow. Each step consumes the previous step's
+// output.
+func newFlow(ctx context.Context, env pipelines.Env) *pipelines.Flow {
+	approvedShipments := pipelines.NewOutput[[]tasks.ApprovedShipment]("approved_shipments")
+	shipmentsWithEvents := pipelines.NewOutput[[]tasks.ShipmentWithEvents]("shipments_with_events")
+	epcisDocuments := pipelines.NewOutput[[]tasks.EPCISDocumentWithMetadata]("epcis_documents")
+	enhancedDocuments := pipelines.NewOutput[[]tasks.EnhancedDocument]("enhanced_documents")
+	dispatchRecords := pipelines.NewOutput[[]tasks.DispatchRecordWithFiles]("dispatch_records")
+	dispatchResults := pipelines.NewOutput[[]tasks.DispatchResult]("dispatch_results")
+
+	flow := pipelines.NewFlow("outbound").
+		WithConcurrency(env.Config.StepConcurrency).
+		WithRetryOverrides(env.Config.RetryPolicies)
 
 	// Task 1: Poll approved shipments from Directus
-	flow.AddTask("poll_approved_shipments", func() error {
-		logger.Info("Polling approved shipments", zap.String("id", id))
-		var err error
-		approvedShipments, err = tasks.PollApprovedShipments(ctx, cms, cfg)
-		if err != nil {
-			return err
-		}
-		logger.Info("Polled approved shipments", zap.Int("count", len(approvedShipments)))
-		return nil
+	pipelines.Source(flow, "poll_approved_shipments", approvedShipments, func(ctx context.Context) ([]tasks.ApprovedShipment, error) {
+		return pollApprovedShipments(ctx, env)
 	})
 
 	// Task 2: Query related events from TiDB (CTE for hierarchy)
-	flow.AddTask("query_shipment_events", func() error {
-		logger.Info("Querying shipment events", zap.Int("shipment_count", len(approvedShipments)))
+	pipelines.Task(flow, "query_shipment_events", approvedShipments, shipmentsWithEvents, func(ctx context.Context, shipments []tasks.ApprovedShipment) ([]tasks.ShipmentWithEvents, error) {
+		return queryShipmentEvents(ctx, env.DB, shipments), nil
+	})
 
-		if len(approvedShipments) == 0 {
-			logger.Info("No approved shipments to query events for")
-			shipmentsWithEvents = []tasks.ShipmentWithEvents{}
-			return nil
-		}
+	// Task 3: Build EPCIS 2.0 JSON-LD documents
+	pipelines.Task(flow, "build_epcis_documents", shipmentsWithEvents, epcisDocuments, func(ctx context.Context, shipments []tasks.ShipmentWithEvents) ([]tasks.EPCISDocumentWithMetadata, error) {
+		return buildEPCISDocuments(ctx, env, shipments)
+	})
 
-		// Query events for each approved shipment
-		shipmentsWithEvents = make([]tasks.ShipmentWithEvents, 0, len(approvedShipments))
-		for _, shipment := range approvedShipments {
-			events, err := tasks.QueryShipmentEventsByCaptureID(ctx, db, shipment.CaptureID)
-			if err != nil {
-				logger.Warn("Failed to query events for shipment",
-					zap.String("capture_id", shipment.CaptureID),
-					zap.Error(err),
-				)
-				continue
-			}
+	// Task 4: Add SBDH headers, DSCSA statements, VocabularyList
+	pipelines.Task(flow, "add_xml_headers", epcisDocuments, enhancedDocuments, func(ctx context.Context, docs []tasks.EPCISDocumentWithMetadata) ([]tasks.EnhancedDocument, error) {
+		return addXMLHeaders(ctx, env, docs)
+	})
 
-			if len(events) == 0 {
-				logger.Info("No events found for shipment",
-					zap.String("capture_id", shipment.CaptureID),
-				)
-				continue
-			}
+	// Task 5: Create/update dispatch records, upload files to Directus
+	pipelines.Task(flow, "manage_dispatch_records", enhancedDocuments, dispatchRecords, func(ctx context.Context, docs []tasks.EnhancedDocument) ([]tasks.DispatchRecordWithFiles, error) {
+		return manageDispatchRecords(ctx, env, docs)
+	})
 
-			// Convert EventRow to map[string]interface{} for the Events field
-			eventMaps := make([]map[string]interface{}, len(events))
-			eventIDs := make([]string, len(events))
-			for i, event := range events {
-				eventIDs[i] = event.EventID
-				// Parse event body JSON into map
-				var eventMap map[string]interface{}
-				if err := json.Unmarshal([]byte(event.EventBody), &eventMap); err != nil {
-					logger.Warn("Failed to parse event body",
-						zap.String("event_id", event.EventID),
-						zap.Error(err),
-					)
-					continue
-				}
-				eventMaps[i] = eventMap
-
-				// Debug: Log event details to verify all expected events are present
-				eventType, _ := eventMap["type"].(string)
-				bizStep, _ := eventMap["bizStep"].(string)
-				bizLocation := ""
-				if bl, ok := eventMap["bizLocation"].(map[string]interface{}); ok {
-					bizLocation, _ = bl["id"].(string)
-				}
-				disposition, _ := eventMap["disposition"].(string)
-
-				logger.Info("Parsed event from TiDB",
-					zap.Int("index", i),
-					zap.String("capture_id", shipment.CaptureID),
-					zap.String("event_id", event.EventID),
-					zap.String("event_type", eventType),
-					zap.String("bizStep", bizStep),
-					zap.String("bizLocation", bizLocation),
-					zap.String("disposition", disposition),
-				)
-			}
+	// Task 6: Dispatch via TrustMed Partner API (mTLS)
+	pipelines.Task(flow, "dispatch_via_trustmed", dispatchRecords, dispatchResults, func(ctx context.Context, records []tasks.DispatchRecordWithFiles) ([]tasks.DispatchResult, error) {
+		return dispatchViaTrustMed(ctx, env, records)
+	})
 
-			shipmentsWithEvents = append(shipmentsWithEvents, tasks.ShipmentWithEvents{
-				ShippingOperationID: shipment.ShippingOperationID,
-				CaptureID:           shipment.CaptureID,
-				DispatchRecordID:    shipment.DispatchRecordID,
-				EventIDs:            eventIDs,
-				Events:              eventMaps,
-			})
-		}
+	// Task 7: Poll TrustMed Dashboard for delivery confirmation
+	pipelines.Sink(flow, "poll_dispatch_confirmation", dispatchResults, func(ctx context.Context, results []tasks.DispatchResult) error {
+		return pollDispatchConfirmation(ctx, env, results)
+	})
 
-		logger.Info("Queried shipment events", zap.Int("with_events", len(shipmentsWithEvents)))
-		return nil
-	}, "poll_approved_shipments")
+	// Task 8: Log and notify on permanent failures
+	pipelines.Sink(flow, "notify_on_errors", dispatchResults, func(ctx context.Context, results []tasks.DispatchResult) error {
+		return notifyOnErrors(ctx, env, results)
+	}, "poll_dispatch_confirmation")
 
-	// Task 3: Build EPCIS 2.0 JSON-LD documents
-	flow.AddTask("build_epcis_documents", func() error {
-		logger.Info("Building EPCIS documents", zap.Int("shipment_count", len(shipmentsWithEvents)))
-		if len(shipmentsWithEvents) == 0 {
-			logger.Info("No shipments with events to build documents for")
-			epcisDocuments = []tasks.EPCISDocumentWithMetadata{}
-			return nil
-		}
-		var err error
-		epcisDocuments, err = tasks.BuildEPCISDocuments(ctx, cfg, shipmentsWithEvents)
-		if err != nil {
-			return err
+	return flow
+}
+
+// pollApprovedShipments polls approved shipments from Directus. Explicit
+// shipment IDs in the run params replace the batched poll.
+func pollApprovedShipments(ctx context.Context, env pipelines.Env) ([]tasks.ApprovedShipment, error) {
+	params := pipelines.ParamsFromContext(ctx)
+	captureIDs := params.Strings("capture_ids")
+	shipOpIDs := params.Strings("shipping_operation_ids")
+
+	runlog.Info(ctx, "Polling approved shipments")
+	var shipments []tasks.ApprovedShipment
+	var err error
+	if len(captureIDs) > 0 || len(shipOpIDs) > 0 {
+		shipments, err = tasks.PollShipmentsByID(ctx, env.CMS, env.Config, captureIDs, shipOpIDs)
+	} else {
+		shipments, err = tasks.PollApprovedShipments(ctx, env.CMS, env.Config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	runlog.Info(ctx, "Polled approved shipments", zap.Int("count", len(shipments)))
+	return shipments, nil
+}
+
+// buildEPCISDocuments builds an EPCIS 2.0 JSON-LD document per shipment,
+// with its XML form.
+func buildEPCISDocuments(ctx context.Context, env pipelines.Env, shipments []tasks.ShipmentWithEvents) ([]tasks.EPCISDocumentWithMetadata, error) {
+	runlog.Info(ctx, "Building EPCIS documents", zap.Int("shipment_count", len(shipments)))
+	if len(shipments) == 0 {
+		runlog.Info(ctx, "No shipments with events to build documents for")
+		return []tasks.EPCISDocumentWithMetadata{}, nil
+	}
+	docs, err := tasks.BuildEPCISDocuments(ctx, env.Config, shipments)
+	if err != nil {
+		return nil, err
+	}
+	runlog.Info(ctx, "Built EPCIS documents", zap.Int("count", len(docs)))
+	return docs, nil
+}
+
+// addXMLHeaders adds SBDH headers to the documents' XML. A dry run
+// records both forms of each document as run artifacts.
+func addXMLHeaders(ctx context.Context, env pipelines.Env, docs []tasks.EPCISDocumentWithMetadata) ([]tasks.EnhancedDocument, error) {
+	runlog.Info(ctx, "Adding XML headers", zap.Int("document_count", len(docs)))
+	if len(docs) == 0 {
+		runlog.Info(ctx, "No EPCIS documents to enhance")
+		return []tasks.EnhancedDocument{}, nil
+	}
+	enhanced, err := tasks.AddXMLHeaders(ctx, env.CMS, env.Config, docs)
+	if err != nil {
+		return nil, err
+	}
+	runlog.Info(ctx, "Added XML headers", zap.Int("count", len(enhanced)))
+
+	// Dry run: return what would be sent, per shipment
+	if pipelines.ParamsFromContext(ctx).Bool("dry_run") {
+		for _, doc := range enhanced {
+			pipelines.RecordArtifact(ctx, doc.CaptureID+".jsonld", "application/ld+json", doc.EPCISJSONContent)
+			pipelines.RecordArtifact(ctx, doc.CaptureID+".xml", "application/xml", doc.EnhancedXML)
 		}
-		logger.Info("Built EPCIS documents", zap.Int("count", len(epcisDocuments)))
+	}
+	return enhanced, nil
+}
+
+// manageDispatchRecords creates or updates the dispatch record of each
+// document and uploads its files to Directus.
+func manageDispatchRecords(ctx context.Context, env pipelines.Env, docs []tasks.EnhancedDocument) ([]tasks.DispatchRecordWithFiles, error) {
+	runlog.Info(ctx, "Managing dispatch records", zap.Int("document_count", len(docs)))
+	if dryRun(ctx, "manage_dispatch_records") {
+		return []tasks.DispatchRecordWithFiles{}, nil
+	}
+	if len(docs) == 0 {
+		runlog.Info(ctx, "No enhanced documents to manage")
+		return []tasks.DispatchRecordWithFiles{}, nil
+	}
+	records, err := tasks.ManageDispatchRecords(ctx, env.CMS, env.Config, docs)
+	if err != nil {
+		return nil, err
+	}
+	runlog.Info(ctx, "Managed dispatch records", zap.Int("count", len(records)))
+	return records, nil
+}
+
+// dispatchViaTrustMed sends the dispatch records to TrustMed.
+func dispatchViaTrustMed(ctx context.Context, env pipelines.Env, records []tasks.DispatchRecordWithFiles) ([]tasks.DispatchResult, error) {
+	runlog.Info(ctx, "Dispatching via TrustMed", zap.Int("record_count", len(records)))
+	if dryRun(ctx, "dispatch_via_trustmed") {
+		return []tasks.DispatchResult{}, nil
+	}
+	if len(records) == 0 {
+		runlog.Info(ctx, "No dispatch records to send")
+		return []tasks.DispatchResult{}, nil
+	}
+	results, err := tasks.DispatchViaTrustMed(ctx, env.CMS, env.Config, records)
+	if err != nil {
+		return nil, err
+	}
+	runlog.Info(ctx, "Dispatched via TrustMed", zap.Int("count", len(results)))
+	return results, nil
+}
+
+// pollDispatchConfirmation polls TrustMed Dashboard until the dispatched
+// files are confirmed delivered.
+func pollDispatchConfirmation(ctx context.Context, env pipelines.Env, results []tasks.DispatchResult) error {
+	runlog.Info(ctx, "Polling dispatch confirmation", zap.Int("result_count", len(results)))
+	if dryRun(ctx, "poll_dispatch_confirmation") {
 		return nil
-	}, "query_shipment_events")
+	}
+	if len(results) == 0 {
+		runlog.Info(ctx, "No dispatch results to poll")
+		return nil
+	}
+	return tasks.PollDispatchConfirmation(ctx, env.CMS, env.Config, results)
+}
 
-	// Task 4: Add SBDH headers, DSCSA statements, VocabularyList
-	flow.AddTask("add_xml_headers", func() error {
-		logger.Info("Adding XML headers", zap.Int("document_count", len(epcisDocuments)))
-		if len(epcisDocuments) == 0 {
-			logger.Info("No EPCIS documents to enhance")
-			enhancedDocuments = []tasks.EnhancedDocument{}
-			return nil
-		}
-		var err error
-		enhancedDocuments, err = tasks.AddXMLHeaders(ctx, cms, cfg, epcisDocuments)
-		if err != nil {
-			return err
-		}
-		logger.Info("Added XML headers", zap.Int("count", len(enhancedDocuments)))
+// notifyOnErrors logs and notifies on permanently failed dispatches.
+func notifyOnErrors(ctx context.Context, env pipelines.Env, results []tasks.DispatchResult) error {
+	runlog.Info(ctx, "Checking for errors to notify", zap.Int("result_count", len(results)))
+	if dryRun(ctx, "notify_on_errors") {
 		return nil
-	}, "build_epcis_documents")
+	}
+	return tasks.NotifyOnErrors(ctx, env.CMS, env.Config, results)
+}
 
-	// Task 5: Create/update dispatch records, upload files to Directus
-	flow.AddTask("manage_dispatch_records", func() error {
-		logger.Info("Managing dispatch records", zap.Int("document_count", len(enhancedDocuments)))
-		if len(enhancedDocuments) == 0 {
-			logger.Info("No enhanced documents to manage")
-			dispatchRecords = []tasks.DispatchRecordWithFiles{}
-			return nil
-		}
-		var err error
-		dispatchRecords, err = tasks.ManageDispatchRecords(ctx, cms, cfg, enhancedDocuments)
+// queryShipmentEvents fetches the EPCIS events of each shipment from TiDB.
+// Shipments whose events cannot be queried or that have none are left out.
+func queryShipmentEvents(ctx context.Context, db *sqlx.DB, approvedShipments []tasks.ApprovedShipment) []tasks.ShipmentWithEvents {
+	runlog.Info(ctx, "Querying shipment events", zap.Int("shipment_count", len(approvedShipments)))
+
+	if len(approvedShipments) == 0 {
+		runlog.Info(ctx, "No approved shipments to query events for")
+		return []tasks.ShipmentWithEvents{}
+	}
+
+	// Query events for each approved shipment
+	shipmentsWithEvents := make([]tasks.ShipmentWithEvents, 0, len(approvedShipments))
+	for _, shipment := range approvedShipments {
+		events, err := tasks.QueryShipmentEventsByCaptureID(ctx, db, shipment.CaptureID)
 		if err != nil {
-			return err
+			runlog.Warn(ctx, "Failed to query events for shipment",
+				zap.String("capture_id", shipment.CaptureID),
+				zap.Error(err),
+			)
+			continue
 		}
-		logger.Info("Managed dispatch records", zap.Int("count", len(dispatchRecords)))
-		return nil
-	}, "add_xml_headers")
 
-	// Task 6: Dispatch via TrustMed Partner API (mTLS)
-	flow.AddTask("dispatch_via_trustmed", func() error {
-		logger.Info("Dispatching via TrustMed", zap.Int("record_count", len(dispatchRecords)))
-		if len(dispatchRecords) == 0 {
-			logger.Info("No dispatch records to send")
-			dispatchResults = []tasks.DispatchResult{}
-			return nil
-		}
-		var err error
-		dispatchResults, err = tasks.DispatchViaTrustMed(ctx, cms, cfg, dispatchRecords)
-		if err != nil {
-			return err
+		if len(events) == 0 {
+			runlog.Info(ctx, "No events found for shipment",
+				zap.String("capture_id", shipment.CaptureID),
+			)
+			continue
 		}
-		logger.Info("Dispatched via TrustMed", zap.Int("count", len(dispatchResults)))
-		return nil
-	}, "manage_dispatch_records")
 
-	// Task 7: Poll TrustMed Dashboard for delivery confirmation
-	flow.AddTask("poll_dispatch_confirmation", func() error {
-		logger.Info("Polling dispatch confirmation", zap.Int("result_count", len(dispatchResults)))
-		if len(dispatchResults) == 0 {
-			logger.Info("No dispatch results to poll")
-			return nil
+		// Convert EventRow to map[string]interface{} for the Events field
+		eventMaps := make([]map[string]interface{}, len(events))
+		eventIDs := make([]string, len(events))
+		for i, event := range events {
+			eventIDs[i] = event.EventID
+			// Parse event body JSON into map
+			var eventMap map[string]interface{}
+			if err := json.Unmarshal([]byte(event.EventBody), &eventMap); err != nil {
+				runlog.Warn(ctx, "Failed to parse event body",
+					zap.String("event_id", event.EventID),
+					zap.Error(err),
+				)
+				continue
+			}
+			eventMaps[i] = eventMap
+
+			// Debug: Log event details to verify all expected events are present
+			eventType, _ := eventMap["type"].(string)
+			bizStep, _ := eventMap["bizStep"].(string)
+			bizLocation := ""
+			if bl, ok := eventMap["bizLocation"].(map[string]interface{}); ok {
+				bizLocation, _ = bl["id"].(string)
+			}
+			disposition, _ := eventMap["disposition"].(string)
+
+			runlog.Info(ctx, "Parsed event from TiDB",
+				zap.Int("index", i),
+				zap.String("capture_id", shipment.CaptureID),
+				zap.String("event_id", event.EventID),
+				zap.String("event_type", eventType),
+				zap.String("bizStep", bizStep),
+				zap.String("bizLocation", bizLocation),
+				zap.String("disposition", disposition),
+			)
 		}
-		return tasks.PollDispatchConfirmation(ctx, cms, cfg, dispatchResults)
-	}, "dispatch_via_trustmed")
 
-	// Task 8: Log and notify on permanent failures
-	flow.AddTask("notify_on_errors", func() error {
-		logger.Info("Checking for errors to notify", zap.Int("result_count", len(dispatchResults)))
-		return tasks.NotifyOnErrors(ctx, cms, cfg, dispatchResults)
-	}, "poll_dispatch_confirmation")
+		shipmentsWithEvents = append(shipmentsWithEvents, tasks.ShipmentWithEvents{
+			ShippingOperationID: shipment.ShippingOperationID,
+			CaptureID:           shipment.CaptureID,
+			DispatchRecordID:    shipment.DispatchRecordID,
+			EventIDs:            eventIDs,
+			Events:              eventMaps,
+		})
+	}
 
-	return flow.Run(ctx)
+	runlog.Info(ctx, "Queried shipment events", zap.Int("with_events", len(shipmentsWithEvents)))
+	return shipmentsWithEvents
 }
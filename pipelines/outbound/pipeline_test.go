@@ -2,9 +2,11 @@ package outbound
 
 import (
 	"context"
+	"slices"
 	"testing"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/pipelines"
 	"github.com/trackvision/tv-pipelines-hudsci/tasks"
 )
 
@@ -24,8 +26,45 @@ func TestRun(t *testing.T) {
 
 	// For now, just verify the pipeline runs without error
 	// It will likely return early if there are no approved shipments
-	err = Run(ctx, nil, cms, cfg, "test-id")
+	err = Pipeline.Run(ctx, pipelines.Env{ID: "test-id", Config: cfg, CMS: cms})
 	if err != nil {
 		t.Logf("Pipeline run error (may be expected): %v", err)
 	}
 }
+
+func TestFlow(t *testing.T) {
+	flow := Pipeline.Discover()
+	if err := flow.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	steps := flow.Steps()
+	if len(steps) != 8 || steps[0] != "poll_approved_shipments" || steps[7] != "notify_on_errors" {
+		t.Errorf("flow steps = %v", steps)
+	}
+	for _, step := range DryRunSkippedSteps {
+		if !slices.Contains(steps, step) {
+			t.Errorf("DryRunSkippedSteps: unknown step %q", step)
+		}
+	}
+}
+
+func TestDryRunTasks(t *testing.T) {
+	// No CMS or TrustMed client: any call out would panic
+	ctx := context.WithValue(context.Background(), pipelines.ParamsKey, pipelines.Params{"dry_run": true})
+	env := pipelines.Env{Config: &configs.Config{}}
+
+	records, err := manageDispatchRecords(ctx, env, []tasks.EnhancedDocument{{CaptureID: "CAP-1"}})
+	if err != nil || len(records) != 0 {
+		t.Errorf("manageDispatchRecords() = %v, %v, want nothing done", records, err)
+	}
+	results, err := dispatchViaTrustMed(ctx, env, []tasks.DispatchRecordWithFiles{{}})
+	if err != nil || len(results) != 0 {
+		t.Errorf("dispatchViaTrustMed() = %v, %v, want nothing sent", results, err)
+	}
+	if err := pollDispatchConfirmation(ctx, env, []tasks.DispatchResult{{}}); err != nil {
+		t.Errorf("pollDispatchConfirmation() error = %v", err)
+	}
+	if err := notifyOnErrors(ctx, env, []tasks.DispatchResult{{}}); err != nil {
+		t.Errorf("notifyOnErrors() error = %v", err)
+	}
+}
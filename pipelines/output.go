@@ -0,0 +1,113 @@
+package pipelines
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// Output is a named, typed value one step produces and later steps consume.
+// Steps added with Source, Task and Sink declare their inputs and output as
+// Outputs; the flow derives their dependencies from them, checkpoints the
+// value for resumed runs and lists it on the step's run record.
+type Output[T any] struct {
+	name  string
+	value T
+}
+
+// NewOutput creates an Output named name. Names are unique within a flow and
+// appear in run records, e.g. "approved_shipments".
+func NewOutput[T any](name string) *Output[T] {
+	return &Output[T]{name: name}
+}
+
+// Name returns the output's name.
+func (o *Output[T]) Name() string { return o.name }
+
+// Value returns the produced value, or the zero value if its step has not
+// succeeded (or was skipped).
+func (o *Output[T]) Value() T { return o.value }
+
+// Source adds a step with no inputs that produces out.
+// Example: pipelines.Source(flow, "poll", files, pollFiles)
+func Source[Out any](f *Flow, name string, out *Output[Out], fn func(ctx context.Context) (Out, error), after ...string) *Flow {
+	f.addOutput(name, out.name, &out.value)
+	return f.addTask(name, func(ctx context.Context) error {
+		v, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+		out.value = v
+		recordLen(ctx, name, v)
+		return nil
+	}, after, nil, out.name)
+}
+
+// Task adds a step that computes out from in. It runs after in's producer
+// and any steps named in after.
+// Example: pipelines.Task(flow, "convert", files, converted, convertFiles)
+func Task[In, Out any](f *Flow, name string, in *Output[In], out *Output[Out], fn func(ctx context.Context, in In) (Out, error), after ...string) *Flow {
+	f.addOutput(name, out.name, &out.value)
+	return f.addTask(name, func(ctx context.Context) error {
+		v, err := fn(ctx, in.value)
+		if err != nil {
+			return err
+		}
+		out.value = v
+		recordLen(ctx, name, v)
+		return nil
+	}, after, []string{in.name}, out.name)
+}
+
+// Sink adds a step that consumes in without producing an output.
+// Example: pipelines.Sink(flow, "notify", results, notify)
+func Sink[In any](f *Flow, name string, in *Output[In], fn func(ctx context.Context, in In) error, after ...string) *Flow {
+	return f.addTask(name, func(ctx context.Context) error {
+		return fn(ctx, in.value)
+	}, after, []string{in.name}, "")
+}
+
+// addOutput registers output name as produced by step and checkpoints the
+// value ptr points to. Validate reports an output produced twice.
+func (f *Flow) addOutput(step, name string, ptr any) {
+	if prev, ok := f.producers[name]; ok {
+		f.errs = append(f.errs, fmt.Errorf("step %q: output %q is already produced by %q", step, name, prev))
+		return
+	}
+	f.producers[name] = step
+	f.values[name] = ptr
+	f.checkpoints[step] = ptr
+}
+
+// addTask adds a typed step, depending on the producers of its inputs and
+// on after.
+func (f *Flow) addTask(name string, fn TaskFunc, after, inputs []string, output string) *Flow {
+	deps := append([]string{}, after...)
+	for _, in := range inputs {
+		producer, ok := f.producers[in]
+		if !ok {
+			f.errs = append(f.errs, fmt.Errorf("step %q: input %q is not produced by any earlier step", name, in))
+			continue
+		}
+		if !slices.Contains(deps, producer) {
+			deps = append(deps, producer)
+		}
+	}
+	if len(inputs) > 0 {
+		f.inputs[name] = inputs
+	}
+	if output != "" {
+		f.outputs[name] = output
+	}
+	return f.AddTask(name, fn, deps...)
+}
+
+// recordLen records the length of a slice or map output as the step's item
+// count.
+func recordLen(ctx context.Context, step string, v any) {
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		RecordItems(ctx, step, rv.Len())
+	}
+}
@@ -0,0 +1,210 @@
+package pipelines
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFlowTypedOutputs(t *testing.T) {
+	m := NewRunManager(10, nil)
+
+	files := NewOutput[[]string]("files")
+	sizes := NewOutput[map[string]int]("sizes")
+	var total int
+
+	flow := NewFlow("test")
+	Source(flow, "poll", files, func(context.Context) ([]string, error) {
+		return []string{"a.xml", "bb.xml"}, nil
+	})
+	Task(flow, "measure", files, sizes, func(_ context.Context, in []string) (map[string]int, error) {
+		out := make(map[string]int, len(in))
+		for _, f := range in {
+			out[f] = len(f)
+		}
+		return out, nil
+	})
+	Sink(flow, "sum", sizes, func(_ context.Context, in map[string]int) error {
+		for _, n := range in {
+			total += n
+		}
+		return nil
+	})
+
+	run, err := m.Submit(context.Background(), RunSpec{ID: "run-1", Pipeline: "test"}, flow.Run)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	run = waitForRun(t, m, run.ID)
+
+	if run.Status != StatusSucceeded {
+		t.Fatalf("run status = %s (%s), want succeeded", run.Status, run.Error)
+	}
+	if total != len("a.xml")+len("bb.xml") {
+		t.Errorf("total = %d, want %d", total, len("a.xml")+len("bb.xml"))
+	}
+	if got := files.Value(); !slices.Equal(got, []string{"a.xml", "bb.xml"}) {
+		t.Errorf("files.Value() = %v", got)
+	}
+
+	steps := make(map[string]StepRun)
+	for _, st := range run.Steps {
+		steps[st.Name] = st
+	}
+	if st := steps["poll"]; st.Output != "files" || st.Inputs != nil || st.Items == nil || *st.Items != 2 {
+		t.Errorf("poll = %+v, want output files with 2 items", st)
+	}
+	if st := steps["measure"]; st.Output != "sizes" || !slices.Equal(st.Inputs, []string{"files"}) || st.Items == nil || *st.Items != 2 {
+		t.Errorf("measure = %+v, want files -> sizes with 2 items", st)
+	}
+	if st := steps["sum"]; st.Output != "" || !slices.Equal(st.Inputs, []string{"sizes"}) || st.Items != nil {
+		t.Errorf("sum = %+v, want input sizes and no output", st)
+	}
+}
+
+func TestFlowTypedOutputsWiring(t *testing.T) {
+	a := NewOutput[int]("a")
+	b := NewOutput[int]("b")
+
+	flow := NewFlow("test")
+	Source(flow, "one", a, func(context.Context) (int, error) { return 1, nil })
+	Source(flow, "two", b, func(context.Context) (int, error) { return 2, nil })
+	Task(flow, "sum", b, NewOutput[int]("c"), func(_ context.Context, in int) (int, error) {
+		return a.Value() + in, nil
+	}, "one")
+
+	if deps := flow.deps["sum"]; !slices.Equal(deps, []string{"one", "two"}) {
+		t.Errorf("sum deps = %v, want [one two]", deps)
+	}
+	if err := flow.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+}
+
+func TestFlowValidate(t *testing.T) {
+	files := NewOutput[[]string]("files")
+	late := NewOutput[[]string]("late")
+
+	flow := NewFlow("test")
+	Source(flow, "poll", files, func(context.Context) ([]string, error) { return nil, nil })
+	Source(flow, "poll_again", NewOutput[[]string]("files"), func(context.Context) ([]string, error) { return nil, nil })
+	Sink(flow, "upload", late, func(context.Context, []string) error {
+		t.Error("upload should not run")
+		return nil
+	})
+	Sink(flow, "notify", files, func(context.Context, []string) error { return nil }, "missing")
+	Source(flow, "late", late, func(context.Context) ([]string, error) { return nil, nil })
+
+	err := flow.Validate()
+	if err == nil {
+		t.Fatal("Validate() expected error")
+	}
+	for _, want := range []string{
+		`output "files" is already produced by "poll"`,
+		`step "upload": input "late" is not produced by any earlier step`,
+		`depends on unknown step "missing"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %v, want it to mention %s", err, want)
+		}
+	}
+	if runErr := flow.Run(context.Background()); runErr == nil || runErr.Error() != err.Error() {
+		t.Errorf("Run() error = %v, want the validation error", runErr)
+	}
+}
+
+func TestFlowSkipCascade(t *testing.T) {
+	m := NewRunManager(10, nil)
+
+	files := NewOutput[[]string]("files")
+	converted := NewOutput[[]string]("converted")
+	var executed []string
+	record := func(name string) { executed = append(executed, name) }
+
+	flow := NewFlow("test")
+	Source(flow, "poll", files, func(context.Context) ([]string, error) {
+		record("poll")
+		return []string{"a.xml"}, nil
+	})
+	Task(flow, "convert", files, converted, func(_ context.Context, in []string) ([]string, error) {
+		record("convert")
+		return in, nil
+	})
+	Sink(flow, "upload", converted, func(context.Context, []string) error {
+		record("upload")
+		return nil
+	})
+	flow.AddTask("notify", func(context.Context) error {
+		record("notify")
+		return nil
+	}, "convert")
+
+	// Skipping poll leaves convert without input, and so upload; notify
+	// only runs after convert and does not consume its output.
+	ctx := context.WithValue(context.Background(), SkipStepsKey, []string{"poll"})
+	run, err := m.Submit(ctx, RunSpec{ID: "run-1", Pipeline: "test"}, flow.Run)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	run = waitForRun(t, m, run.ID)
+	if run.Status != StatusSucceeded {
+		t.Fatalf("run status = %s (%s), want succeeded", run.Status, run.Error)
+	}
+	if !slices.Equal(executed, []string{"notify"}) {
+		t.Errorf("executed = %v, want [notify]", executed)
+	}
+	for _, st := range run.Steps {
+		want := StatusSkipped
+		if st.Name == "notify" {
+			want = StatusSucceeded
+		}
+		if st.Status != want {
+			t.Errorf("step %s = %s, want %s", st.Name, st.Status, want)
+		}
+	}
+}
+
+func TestFlowInputOverride(t *testing.T) {
+	newFlow := func(uploaded *[]string) *Flow {
+		files := NewOutput[[]string]("files")
+		flow := NewFlow("test")
+		Source(flow, "poll", files, func(context.Context) ([]string, error) {
+			t.Error("poll should be skipped")
+			return nil, nil
+		})
+		return Sink(flow, "upload", files, func(_ context.Context, in []string) error {
+			*uploaded = in
+			return nil
+		})
+	}
+	withInputs := func(skip []string, inputs map[string]json.RawMessage) context.Context {
+		ctx := context.WithValue(context.Background(), SkipStepsKey, skip)
+		return context.WithValue(ctx, InputsKey, inputs)
+	}
+
+	var uploaded []string
+	ctx := withInputs([]string{"poll"}, map[string]json.RawMessage{"files": json.RawMessage(`["a.xml","b.xml"]`)})
+	if err := newFlow(&uploaded).Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !slices.Equal(uploaded, []string{"a.xml", "b.xml"}) {
+		t.Errorf("uploaded = %v, want the override", uploaded)
+	}
+
+	for name, tc := range map[string]struct {
+		skip   []string
+		inputs map[string]json.RawMessage
+		want   string
+	}{
+		"unknown output": {[]string{"poll"}, map[string]json.RawMessage{"other": json.RawMessage(`[]`)}, "no step produces it"},
+		"not skipped":    {nil, map[string]json.RawMessage{"files": json.RawMessage(`[]`)}, "poll is not skipped"},
+		"wrong type":     {[]string{"poll"}, map[string]json.RawMessage{"files": json.RawMessage(`{}`)}, `input "files"`},
+	} {
+		err := newFlow(&uploaded).Run(withInputs(tc.skip, tc.inputs))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Run() error = %v, want %q", name, err, tc.want)
+		}
+	}
+}
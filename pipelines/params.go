@@ -0,0 +1,174 @@
+package pipelines
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ParamsKey is the context key for validated run parameters.
+const ParamsKey ContextKey = "params"
+
+// Parameter types published in a pipeline's schema.
+const (
+	ParamString     = "string"
+	ParamBool       = "bool"
+	ParamStringList = "string_list"
+	ParamTime       = "time" // RFC 3339
+)
+
+// OnlyStepsParam restricts a run to the listed steps. Every pipeline accepts it.
+const OnlyStepsParam = "only_steps"
+
+// ParamSpec describes one run parameter a pipeline accepts.
+type ParamSpec struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Requires    string `json:"requires,omitempty"` // parameter that must also be set
+}
+
+// OnlyStepsSpec is the schema entry for OnlyStepsParam.
+var OnlyStepsSpec = ParamSpec{
+	Name:        OnlyStepsParam,
+	Type:        ParamStringList,
+	Description: "Run only these steps; every other step is skipped",
+}
+
+// Params holds validated run parameters: string, bool, []string or
+// time.Time values keyed by parameter name.
+type Params map[string]any
+
+// ParseParams decodes raw request parameters against schema. Unknown names
+// and values of the wrong type are errors. only_steps entries must name one
+// of steps.
+func ParseParams(schema []ParamSpec, steps []string, raw map[string]json.RawMessage) (Params, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := make(Params, len(raw))
+	for _, name := range names {
+		i := slices.IndexFunc(schema, func(s ParamSpec) bool { return s.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+		value, err := parseParam(schema[i].Type, raw[name])
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", name, err)
+		}
+		if value != nil {
+			params[name] = value
+		}
+	}
+
+	for _, spec := range schema {
+		if _, ok := params[spec.Name]; ok && spec.Requires != "" {
+			if _, ok := params[spec.Requires]; !ok {
+				return nil, fmt.Errorf("parameter %q requires %q", spec.Name, spec.Requires)
+			}
+		}
+	}
+
+	for _, step := range params.Strings(OnlyStepsParam) {
+		if !slices.Contains(steps, step) {
+			return nil, fmt.Errorf("parameter %q: unknown step %q", OnlyStepsParam, step)
+		}
+	}
+	return params, nil
+}
+
+func parseParam(typ string, raw json.RawMessage) (any, error) {
+	switch typ {
+	case ParamString:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("want a string")
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			return nil, nil
+		}
+		return s, nil
+
+	case ParamBool:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, fmt.Errorf("want true or false")
+		}
+		if !b {
+			return nil, nil
+		}
+		return true, nil
+
+	case ParamStringList:
+		var list []string
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("want a list of strings")
+		}
+		values := make([]string, 0, len(list))
+		for _, v := range list {
+			if v = strings.TrimSpace(v); v != "" && !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values, nil
+
+	case ParamTime:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("want an RFC 3339 timestamp")
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			return nil, nil
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("want an RFC 3339 timestamp: %w", err)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("unsupported parameter type %q", typ)
+}
+
+// String returns a string parameter, or "" if unset.
+func (p Params) String(name string) string {
+	s, _ := p[name].(string)
+	return s
+}
+
+// Bool returns a bool parameter, or false if unset.
+func (p Params) Bool(name string) bool {
+	b, _ := p[name].(bool)
+	return b
+}
+
+// Strings returns a string list parameter, or nil if unset.
+func (p Params) Strings(name string) []string {
+	list, _ := p[name].([]string)
+	return list
+}
+
+// Time returns a time parameter and whether it was set.
+func (p Params) Time(name string) (time.Time, bool) {
+	t, ok := p[name].(time.Time)
+	return t, ok
+}
+
+// ParamsFromContext returns the run parameters carried by ctx, or nil.
+func ParamsFromContext(ctx context.Context) Params {
+	params, _ := ctx.Value(ParamsKey).(Params)
+	return params
+}
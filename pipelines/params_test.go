@@ -0,0 +1,57 @@
+package pipelines
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseParams(t *testing.T) {
+	schema := []ParamSpec{
+		{Name: "capture_ids", Type: ParamStringList},
+		{Name: "since", Type: ParamTime},
+		{Name: "until", Type: ParamTime, Requires: "since"},
+		{Name: "note", Type: ParamString},
+		{Name: "dry_run", Type: ParamBool},
+		OnlyStepsSpec,
+	}
+	steps := []string{"poll", "dispatch"}
+
+	raw := map[string]json.RawMessage{
+		"capture_ids": json.RawMessage(`["c1", " c2 ", "c1", ""]`),
+		"since":       json.RawMessage(`"2026-10-01T00:00:00Z"`),
+		"note":        json.RawMessage(`"  "`),
+		"dry_run":     json.RawMessage(`true`),
+		"only_steps":  json.RawMessage(`["dispatch"]`),
+	}
+	params, err := ParseParams(schema, steps, raw)
+	if err != nil {
+		t.Fatalf("ParseParams() error = %v", err)
+	}
+	if got := params.Strings("capture_ids"); len(got) != 2 || got[0] != "c1" || got[1] != "c2" {
+		t.Errorf("capture_ids = %v, want [c1 c2]", got)
+	}
+	if since, ok := params.Time("since"); !ok || !since.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("since = %v, %v", since, ok)
+	}
+	if !params.Bool("dry_run") {
+		t.Error("Expected dry_run to be true")
+	}
+	if _, ok := params["note"]; ok {
+		t.Error("Expected blank string parameter to be dropped")
+	}
+
+	bad := []map[string]json.RawMessage{
+		{"unknown": json.RawMessage(`"x"`)},
+		{"capture_ids": json.RawMessage(`"c1"`)},
+		{"dry_run": json.RawMessage(`"yes"`)},
+		{"since": json.RawMessage(`"yesterday"`)},
+		{"only_steps": json.RawMessage(`["missing"]`)},
+		{"until": json.RawMessage(`"2026-10-02T00:00:00Z"`)},
+	}
+	for _, raw := range bad {
+		if _, err := ParseParams(schema, steps, raw); err == nil {
+			t.Errorf("ParseParams(%v) expected error", raw)
+		}
+	}
+}
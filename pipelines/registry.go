@@ -0,0 +1,124 @@
+package pipelines
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/tasks"
+)
+
+// Dependency is an external service a pipeline needs to run.
+type Dependency string
+
+const (
+	DependencyDB        Dependency = "db"        // TiDB; opened for each run
+	DependencyTrustMed  Dependency = "trustmed"  // TrustMed Partner API and Dashboard
+	DependencyConverter Dependency = "converter" // EPCIS converter service
+)
+
+// Env is what a pipeline's flow is built from for one run.
+type Env struct {
+	ID     string // run ID
+	Config *configs.Config
+	CMS    *tasks.DirectusClient
+	DB     *sqlx.DB // nil unless the pipeline depends on DependencyDB
+}
+
+// Pipeline declares a pipeline. The API, the UI, run validation and the
+// scheduler are all derived from its declaration.
+type Pipeline struct {
+	Name        string
+	Description string
+	// Flow builds the pipeline's flow for env and the run options and
+	// parameters in ctx. For discovery of steps and graph it is called with
+	// a background context and an Env with an empty Config and no clients,
+	// so it must not use them until a step runs.
+	Flow func(ctx context.Context, env Env) *Flow
+	// Prepare, if set, adjusts the run's context before the flow is built,
+	// e.g. to skip the steps a parameter implies.
+	Prepare func(ctx context.Context) context.Context
+	// Schedule is the default cron schedule; PIPELINE_SCHEDULES overrides
+	// it. Empty means the pipeline only runs when triggered.
+	Schedule     string
+	Params       []ParamSpec
+	Dependencies []Dependency
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Pipeline)
+)
+
+// Register adds a pipeline. Pipelines register themselves from init, so it
+// panics on an incomplete declaration or a name registered twice.
+func Register(p Pipeline) {
+	if p.Name == "" || p.Flow == nil {
+		panic("pipelines: Register needs a name and a flow builder")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[p.Name]; ok {
+		panic(fmt.Sprintf("pipelines: %s registered twice", p.Name))
+	}
+	registry[p.Name] = p
+}
+
+// Lookup returns the registered pipeline called name.
+func Lookup(name string) (Pipeline, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	p, ok := registry[name]
+	return p, ok
+}
+
+// Registered returns the registered pipelines sorted by name.
+func Registered() []Pipeline {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	ps := make([]Pipeline, 0, len(registry))
+	for _, p := range registry {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Name < ps[j].Name })
+	return ps
+}
+
+// ScheduleFor returns the pipeline's schedule: the one configured in
+// PIPELINE_SCHEDULES, else its default, else ManualSchedule.
+func (p Pipeline) ScheduleFor(cfg *configs.Config) string {
+	return cfg.Schedule(p.Name, p.Schedule)
+}
+
+// Needs reports whether the pipeline declared dependency d.
+func (p Pipeline) Needs(d Dependency) bool {
+	return slices.Contains(p.Dependencies, d)
+}
+
+// Build builds the pipeline's flow for a run.
+func (p Pipeline) Build(ctx context.Context, env Env) *Flow {
+	return p.Flow(ctx, env).WithSchedule(p.ScheduleFor(env.Config))
+}
+
+// Discover builds the pipeline's flow without run options or clients, for
+// listing its steps and graph.
+func (p Pipeline) Discover() *Flow {
+	return p.Build(context.Background(), Env{Config: &configs.Config{}})
+}
+
+// Steps returns the pipeline's step names in the order they are added.
+func (p Pipeline) Steps() []string {
+	return p.Discover().Steps()
+}
+
+// Run builds the pipeline's flow for env and runs it.
+func (p Pipeline) Run(ctx context.Context, env Env) error {
+	if p.Prepare != nil {
+		ctx = p.Prepare(ctx)
+	}
+	return p.Build(ctx, env).Run(ctx)
+}
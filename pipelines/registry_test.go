@@ -0,0 +1,65 @@
+package pipelines
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+)
+
+func TestRegister(t *testing.T) {
+	var ran []string
+	p := Pipeline{
+		Name:     "registry-test",
+		Schedule: "@hourly",
+		Flow: func(ctx context.Context, env Env) *Flow {
+			flow := NewFlow("registry-test")
+			flow.AddTask("fetch", func(context.Context) error { ran = append(ran, "fetch:"+env.ID); return nil })
+			flow.AddTask("notify", func(context.Context) error { ran = append(ran, "notify"); return nil }, "fetch")
+			return flow
+		},
+		Prepare: func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, SkipStepsKey, []string{"notify"})
+		},
+		Dependencies: []Dependency{DependencyDB},
+	}
+	Register(p)
+
+	got, ok := Lookup("registry-test")
+	if !ok || got.Name != p.Name {
+		t.Fatalf("Lookup() = %v, %v; want the registered pipeline", got.Name, ok)
+	}
+	if !slices.ContainsFunc(Registered(), func(r Pipeline) bool { return r.Name == p.Name }) {
+		t.Error("Registered() does not list the pipeline")
+	}
+	if steps := got.Steps(); !slices.Equal(steps, []string{"fetch", "notify"}) {
+		t.Errorf("Steps() = %v", steps)
+	}
+	if !got.Needs(DependencyDB) || got.Needs(DependencyTrustMed) {
+		t.Errorf("Needs() does not match Dependencies %v", got.Dependencies)
+	}
+
+	cfg := &configs.Config{}
+	if err := got.Run(context.Background(), Env{ID: "run-1", Config: cfg}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !slices.Equal(ran, []string{"fetch:run-1"}) {
+		t.Errorf("ran %v, want fetch only (notify skipped by Prepare)", ran)
+	}
+
+	if s := got.ScheduleFor(cfg); s != "@hourly" {
+		t.Errorf("ScheduleFor() = %q, want the default @hourly", s)
+	}
+	cfg.Schedules = map[string]string{"registry-test": "*/5 * * * *"}
+	if s := got.ScheduleFor(cfg); s != "*/5 * * * *" {
+		t.Errorf("ScheduleFor() = %q, want the configured schedule", s)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() of a taken name should panic")
+		}
+	}()
+	Register(p)
+}
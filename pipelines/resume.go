@@ -0,0 +1,56 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ResumeKey is the context key for the run a new run resumes from.
+const ResumeKey ContextKey = "resume"
+
+// ResumedFromParam is the run parameter recording which run a resumed run
+// continues.
+const ResumedFromParam = "resumed_from"
+
+// ErrNotResumable is returned by NewResume for runs that did not fail.
+var ErrNotResumable = errors.New("only failed or cancelled runs can be resumed")
+
+// Resume describes an earlier run to continue. Steps it completed are not
+// run again: their checkpointed outputs are restored instead.
+type Resume struct {
+	RunID       string
+	Completed   []string          // steps that succeeded or were restored
+	Checkpoints map[string][]byte // step -> saved output
+}
+
+// NewResume loads what is needed to resume run. Only failed or cancelled
+// runs can be resumed.
+func (m *RunManager) NewResume(ctx context.Context, run Run) (*Resume, error) {
+	if run.Status != StatusFailed && run.Status != StatusCancelled {
+		return nil, fmt.Errorf("%w: run %s is %s", ErrNotResumable, run.ID, run.Status)
+	}
+	checkpoints, err := m.store.GetCheckpoints(ctx, run.ID)
+	if err != nil {
+		return nil, fmt.Errorf("loading checkpoints for run %s: %w", run.ID, err)
+	}
+	resume := &Resume{RunID: run.ID, Checkpoints: checkpoints}
+	for _, step := range run.Steps {
+		if step.Status == StatusSucceeded || step.Status == StatusRestored {
+			resume.Completed = append(resume.Completed, step.Name)
+		}
+	}
+	return resume, nil
+}
+
+// completed reports whether the resumed run finished step.
+func (r *Resume) completed(step string) bool {
+	return r != nil && slices.Contains(r.Completed, step)
+}
+
+// resumeFromContext returns the run being resumed, or nil.
+func resumeFromContext(ctx context.Context) *Resume {
+	r, _ := ctx.Value(ResumeKey).(*Resume)
+	return r
+}
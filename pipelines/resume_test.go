@@ -0,0 +1,94 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunManagerResume(t *testing.T) {
+	m := NewRunManager(10, nil)
+
+	// newFlow builds the same three-step flow for both runs: poll produces
+	// files, convert fails until fixed, upload consumes both outputs.
+	fixed := false
+	polls := 0
+	var uploaded []string
+	newFlow := func() *Flow {
+		var files, converted []string
+		flow := NewFlow("test")
+		flow.AddTask("poll", func(context.Context) error {
+			polls++
+			files = []string{"a.xml", "b.xml"}
+			return nil
+		})
+		flow.AddTask("convert", func(context.Context) error {
+			if !fixed {
+				return errors.New("converter unavailable")
+			}
+			for _, f := range files {
+				converted = append(converted, f+".json")
+			}
+			return nil
+		}, "poll")
+		flow.AddTask("upload", func(context.Context) error {
+			uploaded = converted
+			return nil
+		}, "convert")
+		flow.WithRetry("convert", RetryPolicy{MaxAttempts: 1})
+		return flow.Checkpoint("poll", &files).Checkpoint("convert", &converted)
+	}
+	steps := []string{"poll", "convert", "upload"}
+
+	if _, err := m.Submit(context.Background(), RunSpec{Pipeline: "test", ID: "run-1", Steps: steps}, func(ctx context.Context) error {
+		return newFlow().Run(ctx)
+	}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	failed := waitForRun(t, m, "run-1")
+	if failed.Status != StatusFailed {
+		t.Fatalf("run-1 status = %s, want failed", failed.Status)
+	}
+
+	resume, err := m.NewResume(context.Background(), failed)
+	if err != nil {
+		t.Fatalf("NewResume() error = %v", err)
+	}
+	if len(resume.Completed) != 1 || resume.Completed[0] != "poll" {
+		t.Errorf("Completed = %v, want [poll]", resume.Completed)
+	}
+
+	fixed = true
+	ctx := context.WithValue(context.Background(), ResumeKey, resume)
+	if _, err := m.Submit(ctx, RunSpec{Pipeline: "test", ID: "run-2", Steps: steps}, func(ctx context.Context) error {
+		return newFlow().Run(ctx)
+	}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	resumed := waitForRun(t, m, "run-2")
+	if resumed.Status != StatusSucceeded {
+		t.Fatalf("run-2 status = %s (%s), want succeeded", resumed.Status, resumed.Error)
+	}
+	if polls != 1 {
+		t.Errorf("poll ran %d times, want 1 (restored on resume)", polls)
+	}
+	if resumed.Steps[0].Status != StatusRestored {
+		t.Errorf("poll status = %s, want %s", resumed.Steps[0].Status, StatusRestored)
+	}
+	if len(uploaded) != 2 || uploaded[0] != "a.xml.json" {
+		t.Errorf("uploaded = %v, want the restored files converted", uploaded)
+	}
+
+	// The resumed run carries checkpoints forward and cannot itself be
+	// resumed once it succeeded.
+	checkpoints, _ := m.store.GetCheckpoints(context.Background(), "run-2")
+	if _, ok := checkpoints["poll"]; !ok {
+		t.Error("run-2 is missing the restored poll checkpoint")
+	}
+	if _, ok := checkpoints["convert"]; !ok {
+		t.Error("run-2 is missing the convert checkpoint")
+	}
+	if _, err := m.NewResume(context.Background(), resumed); !errors.Is(err, ErrNotResumable) {
+		t.Errorf("NewResume() succeeded run error = %v, want ErrNotResumable", err)
+	}
+}
@@ -0,0 +1,157 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how often a step is attempted and how long to wait
+// between attempts.
+type RetryPolicy struct {
+	MaxAttempts  int           // attempts including the first; at least 1
+	InitialDelay time.Duration // wait before the second attempt
+	MaxDelay     time.Duration // cap on the wait between attempts; 0 for none
+	Multiplier   float64       // growth of the wait per attempt; 1 keeps it constant
+	Jitter       float64       // randomizes each wait by up to ±Jitter (0 to 1)
+	Timeout      time.Duration // per-attempt deadline; 0 for none
+}
+
+// DefaultRetryPolicy applies to steps without a policy of their own: three
+// attempts, waiting about 5s and then 10s.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  3,
+	InitialDelay: 5 * time.Second,
+	MaxDelay:     time.Minute,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
+// ErrStepTimeout is the cause of an attempt that exceeded its policy's
+// Timeout.
+var ErrStepTimeout = errors.New("step attempt timed out")
+
+// delay returns the wait before retry n (1 for the second attempt).
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := float64(p.InitialDelay)
+	if p.Multiplier > 1 {
+		d *= math.Pow(p.Multiplier, float64(n-1))
+	}
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// ParseRetryPolicy applies an override such as
+// "attempts:5,delay:10s,max_delay:2m,multiplier:2,jitter:0.1,timeout:5m" to
+// base. Fields not named keep their base value.
+func ParseRetryPolicy(base RetryPolicy, spec string) (RetryPolicy, error) {
+	p := base
+	for _, field := range strings.Split(spec, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || value == "" {
+			return base, fmt.Errorf("retry policy field %q: want key:value", field)
+		}
+
+		var err error
+		switch key {
+		case "attempts":
+			p.MaxAttempts, err = strconv.Atoi(value)
+			if err == nil && p.MaxAttempts < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "delay":
+			p.InitialDelay, err = time.ParseDuration(value)
+		case "max_delay":
+			p.MaxDelay, err = time.ParseDuration(value)
+		case "multiplier":
+			p.Multiplier, err = strconv.ParseFloat(value, 64)
+		case "jitter":
+			p.Jitter, err = strconv.ParseFloat(value, 64)
+			if err == nil && (p.Jitter < 0 || p.Jitter > 1) {
+				err = errors.New("must be between 0 and 1")
+			}
+		case "timeout":
+			p.Timeout, err = time.ParseDuration(value)
+		default:
+			err = errors.New("unknown field")
+		}
+		if err != nil {
+			return base, fmt.Errorf("retry policy field %q: %w", key, err)
+		}
+	}
+	return p, nil
+}
+
+// ValidateRetryPolicies checks retry overrides keyed by "pipeline" or
+// "pipeline.step" (see configs.Config.RetryPolicies) against the known
+// pipelines and their steps.
+func ValidateRetryPolicies(overrides map[string]string, steps map[string][]string) error {
+	for key, spec := range overrides {
+		pipeline, step, hasStep := strings.Cut(key, ".")
+		known, ok := steps[pipeline]
+		if !ok {
+			return fmt.Errorf("retry policy %q: unknown pipeline %q", key, pipeline)
+		}
+		if hasStep && !slices.Contains(known, step) {
+			return fmt.Errorf("retry policy %q: unknown step %q", key, step)
+		}
+		if _, err := ParseRetryPolicy(DefaultRetryPolicy, spec); err != nil {
+			return fmt.Errorf("retry policy %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// permanentError marks an error that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string   { return e.err.Error() }
+func (e *permanentError) Unwrap() error   { return e.err }
+func (e *permanentError) Permanent() bool { return true }
+
+// Permanent wraps err so the step fails at once instead of being retried.
+// Use it for errors such as invalid input or missing configuration.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err should not be retried: some error in its
+// chain was wrapped with Permanent or has a Permanent() bool method that
+// returns true. Packages that do not import pipelines classify their errors
+// by implementing that method.
+func IsPermanent(err error) bool {
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// attempt runs fn once, with the policy's per-attempt deadline if set.
+func (p RetryPolicy) attempt(ctx context.Context, fn TaskFunc) error {
+	if p.Timeout <= 0 {
+		return fn(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeoutCause(ctx, p.Timeout, ErrStepTimeout)
+	defer cancel()
+	err := fn(attemptCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(context.Cause(attemptCtx), ErrStepTimeout) {
+		return fmt.Errorf("%w after %s: %w", ErrStepTimeout, p.Timeout, err)
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	p, err := ParseRetryPolicy(DefaultRetryPolicy, "attempts:5, delay:30s,max_delay:5m,timeout:10m")
+	if err != nil {
+		t.Fatalf("ParseRetryPolicy() error = %v", err)
+	}
+	want := RetryPolicy{
+		MaxAttempts:  5,
+		InitialDelay: 30 * time.Second,
+		MaxDelay:     5 * time.Minute,
+		Multiplier:   DefaultRetryPolicy.Multiplier,
+		Jitter:       DefaultRetryPolicy.Jitter,
+		Timeout:      10 * time.Minute,
+	}
+	if p != want {
+		t.Errorf("ParseRetryPolicy() = %+v, want %+v", p, want)
+	}
+
+	for _, spec := range []string{"attempts:0", "delay", "delay:soon", "jitter:2", "retries:3"} {
+		if _, err := ParseRetryPolicy(DefaultRetryPolicy, spec); err == nil {
+			t.Errorf("ParseRetryPolicy(%q) expected error", spec)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		if got := p.delay(n); got != want {
+			t.Errorf("delay(%d) = %v, want %v", n, got, want)
+		}
+	}
+
+	p.Jitter = 0.5
+	for range 100 {
+		if got := p.delay(1); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("delay(1) with jitter = %v, want within 500ms..1.5s", got)
+		}
+	}
+}
+
+func TestFlowRetryPolicyOverrides(t *testing.T) {
+	flow := NewFlow("outbound").
+		WithRetry("dispatch", RetryPolicy{MaxAttempts: 2, InitialDelay: time.Second}).
+		WithRetryOverrides(map[string]string{
+			"outbound":          "attempts:4,timeout:1m",
+			"outbound.dispatch": "attempts:6",
+			"inbound.dispatch":  "attempts:9",
+		})
+
+	p := flow.retryPolicy("dispatch")
+	if p.MaxAttempts != 6 || p.InitialDelay != time.Second || p.Timeout != time.Minute {
+		t.Errorf("retryPolicy(dispatch) = %+v, want 6 attempts, 1s delay, 1m timeout", p)
+	}
+	p = flow.retryPolicy("poll")
+	if p.MaxAttempts != 4 || p.InitialDelay != DefaultRetryPolicy.InitialDelay {
+		t.Errorf("retryPolicy(poll) = %+v, want 4 attempts with the default delay", p)
+	}
+}
+
+func TestFlowPermanentErrorNotRetried(t *testing.T) {
+	attempts := 0
+	flow := NewFlow("test")
+	flow.AddTask("task1", func(context.Context) error {
+		attempts++
+		return fmt.Errorf("loading: %w", Permanent(errors.New("bad input")))
+	})
+	flow.WithRetry("task1", RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond})
+
+	err := flow.Run(context.Background())
+	if err == nil || !IsPermanent(err) {
+		t.Fatalf("Run() error = %v, want permanent error", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestFlowStepTimeout(t *testing.T) {
+	attempts := 0
+	flow := NewFlow("test")
+	flow.AddTask("slow", func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	flow.WithRetry("slow", RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond, Timeout: 20 * time.Millisecond})
+
+	if err := flow.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v, want the retry to succeed", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+
+	// Without a retry left, the step fails with ErrStepTimeout.
+	attempts = 0
+	flow.WithRetry("slow", RetryPolicy{MaxAttempts: 1, Timeout: 20 * time.Millisecond})
+	if err := flow.Run(context.Background()); !errors.Is(err, ErrStepTimeout) {
+		t.Errorf("Run() error = %v, want ErrStepTimeout", err)
+	}
+}
+
+func TestValidateRetryPolicies(t *testing.T) {
+	steps := map[string][]string{"outbound": {"poll", "dispatch"}}
+
+	valid := map[string]string{"outbound": "attempts:2", "outbound.dispatch": "timeout:5m"}
+	if err := ValidateRetryPolicies(valid, steps); err != nil {
+		t.Errorf("ValidateRetryPolicies() error = %v", err)
+	}
+
+	for key, spec := range map[string]string{
+		"inbound":           "attempts:2",
+		"outbound.send":     "attempts:2",
+		"outbound.dispatch": "attempts:none",
+	} {
+		err := ValidateRetryPolicies(map[string]string{key: spec}, steps)
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("ValidateRetryPolicies(%s=%s) error = %v, want error naming the key", key, spec, err)
+		}
+	}
+}
@@ -0,0 +1,677 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
+	"github.com/trackvision/tv-shared-go/logger"
+	"go.uber.org/zap"
+)
+
+// RunStatus is the lifecycle state of a pipeline run or step.
+type RunStatus string
+
+// Run and step statuses.
+const (
+	StatusQueued    RunStatus = "queued"
+	StatusRunning   RunStatus = "running"
+	StatusSucceeded RunStatus = "succeeded"
+	StatusPartial   RunStatus = "partial" // run finished but left work for a later run (see MarkPartial)
+	StatusFailed    RunStatus = "failed"
+	StatusCancelled RunStatus = "cancelled"
+	StatusSkipped   RunStatus = "skipped"
+	StatusRestored  RunStatus = "restored" // step output restored from the run being resumed
+	StatusPending   RunStatus = "pending"
+)
+
+// Run triggers.
+const (
+	TriggerAPI      = "api"
+	TriggerSchedule = "schedule"
+)
+
+// StepRun records the execution of a single step within a run.
+type StepRun struct {
+	Name       string     `json:"name"`
+	Status     RunStatus  `json:"status"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Duration   float64    `json:"duration,omitempty"` // seconds
+	Attempts   int        `json:"attempts,omitempty"`
+	Items      *int       `json:"items,omitempty"`  // items produced, if reported
+	Inputs     []string   `json:"inputs,omitempty"` // Outputs the step consumes
+	Output     string     `json:"output,omitempty"` // Output the step produces
+	Error      string     `json:"error,omitempty"`
+}
+
+// Artifact is a named output a run produced, such as a generated document.
+// Content is served separately from the run record.
+type Artifact struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Content     []byte `json:"-"`
+}
+
+// Run records the execution of a pipeline.
+type Run struct {
+	ID         string         `json:"id"`
+	Pipeline   string         `json:"pipeline"`
+	Trigger    string         `json:"trigger,omitempty"`
+	CalledBy   string         `json:"called_by,omitempty"` // API key name or "scheduler"
+	Params     map[string]any `json:"params,omitempty"`
+	Status     RunStatus      `json:"status"`
+	Steps      []StepRun      `json:"steps"`
+	Artifacts  []Artifact     `json:"artifacts,omitempty"`
+	QueuedAt   time.Time      `json:"queued_at"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Duration   float64        `json:"duration,omitempty"` // seconds
+	Error      string         `json:"error,omitempty"`
+}
+
+// Done reports whether the run has reached a terminal status.
+func (r *Run) Done() bool {
+	return r.Status == StatusSucceeded || r.Status == StatusPartial || r.Status == StatusFailed || r.Status == StatusCancelled
+}
+
+// RunSpec describes a run to submit.
+type RunSpec struct {
+	Pipeline string
+	ID       string
+	Trigger  string         // TriggerAPI or TriggerSchedule
+	CalledBy string         // identity of the caller that requested the run
+	Params   map[string]any // request parameters, recorded on the run
+	Steps    []string       // seeds the step list so queued runs show every step
+}
+
+// runState is the mutable, lock-protected state behind a Run.
+type runState struct {
+	mu  sync.Mutex
+	run Run
+
+	// store receives a snapshot after every change. saveMu serialises
+	// saves so an older snapshot never overwrites a newer one.
+	store  RunStore
+	saveMu sync.Mutex
+
+	// cancel stops the run's context with a cause; set once the run is
+	// submitted.
+	cancel context.CancelCauseFunc
+
+	// subs receive run events as they happen.
+	subs []chan RunEvent
+
+	// partial lists why the run left work undone (see MarkPartial).
+	partial []string
+}
+
+func (s *runState) snapshot() Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshotLocked()
+}
+
+// snapshotLocked copies the run. Caller holds mu.
+func (s *runState) snapshotLocked() Run {
+	out := s.run
+	out.Steps = append([]StepRun(nil), s.run.Steps...)
+	out.Artifacts = append([]Artifact(nil), s.run.Artifacts...)
+	return out
+}
+
+// persist writes the current snapshot to the store. Failures are logged
+// and do not affect the run.
+func (s *runState) persist() {
+	if s.store == nil {
+		return
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	run := s.snapshot()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.store.Save(ctx, run); err != nil {
+		logger.Warn("Failed to save run record",
+			zap.String("pipeline", run.Pipeline),
+			zap.String("run_id", run.ID),
+			zap.Error(err))
+	}
+}
+
+// step returns the step record for name, appending one if the flow has
+// steps that were not declared when the run was queued. Caller holds mu.
+func (s *runState) step(name string) *StepRun {
+	for i := range s.run.Steps {
+		if s.run.Steps[i].Name == name {
+			return &s.run.Steps[i]
+		}
+	}
+	s.run.Steps = append(s.run.Steps, StepRun{Name: name, Status: StatusPending})
+	return &s.run.Steps[len(s.run.Steps)-1]
+}
+
+func (s *runState) stepStarted(name string) {
+	s.mu.Lock()
+	now := time.Now()
+	st := s.step(name)
+	st.Status = StatusRunning
+	st.StartedAt = &now
+	s.publishLocked(RunEvent{Type: EventStepStarted, Step: name})
+	s.mu.Unlock()
+	s.persist()
+}
+
+func (s *runState) stepAttempt(name string, attempt int) {
+	s.mu.Lock()
+	s.step(name).Attempts = attempt
+	if attempt > 1 {
+		s.publishLocked(RunEvent{Type: EventStepRetrying, Step: name, Attempt: attempt})
+	}
+	s.mu.Unlock()
+	if attempt > 1 {
+		s.persist()
+	}
+}
+
+func (s *runState) stepFinished(name string, err error) {
+	s.mu.Lock()
+	now := time.Now()
+	st := s.step(name)
+	st.FinishedAt = &now
+	if st.StartedAt != nil {
+		st.Duration = now.Sub(*st.StartedAt).Seconds()
+	}
+	if err != nil {
+		st.Status = StatusFailed
+		st.Error = err.Error()
+		s.publishLocked(RunEvent{Type: EventStepFailed, Step: name, Attempt: st.Attempts, Error: st.Error})
+	} else {
+		st.Status = StatusSucceeded
+		s.publishLocked(RunEvent{Type: EventStepCompleted, Step: name, Attempt: st.Attempts})
+	}
+	s.mu.Unlock()
+	s.persist()
+}
+
+func (s *runState) stepCancelled(name string, err error) {
+	s.mu.Lock()
+	now := time.Now()
+	st := s.step(name)
+	st.Status = StatusCancelled
+	st.FinishedAt = &now
+	if st.StartedAt != nil {
+		st.Duration = now.Sub(*st.StartedAt).Seconds()
+	}
+	st.Error = err.Error()
+	s.publishLocked(RunEvent{Type: EventStepCancelled, Step: name, Error: st.Error})
+	s.mu.Unlock()
+	s.persist()
+}
+
+func (s *runState) stepSkipped(name, reason string) {
+	s.mu.Lock()
+	s.step(name).Status = StatusSkipped
+	s.publishLocked(RunEvent{Type: EventStepSkipped, Step: name, Message: reason})
+	s.mu.Unlock()
+	s.persist()
+}
+
+func (s *runState) stepRestored(name, from string) {
+	s.mu.Lock()
+	s.step(name).Status = StatusRestored
+	s.publishLocked(RunEvent{Type: EventStepRestored, Step: name, Message: "restored from " + from})
+	s.mu.Unlock()
+	s.persist()
+}
+
+func (s *runState) stepItems(name string, n int) {
+	s.mu.Lock()
+	s.step(name).Items = &n
+	s.mu.Unlock()
+}
+
+// stepData records the typed inputs and output of a step. The next update
+// persists them.
+func (s *runState) stepData(name string, inputs []string, output string) {
+	s.mu.Lock()
+	st := s.step(name)
+	st.Inputs, st.Output = inputs, output
+	s.mu.Unlock()
+}
+
+// addArtifact saves the artifact content to the store and lists it on the run.
+func (s *runState) addArtifact(a Artifact) {
+	if s.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := s.store.SaveArtifact(ctx, s.run.ID, a)
+		cancel()
+		if err != nil {
+			logger.Warn("Failed to save run artifact",
+				zap.String("run_id", s.run.ID),
+				zap.String("artifact", a.Name),
+				zap.Error(err))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	meta := Artifact{Name: a.Name, ContentType: a.ContentType, Size: len(a.Content)}
+	replaced := false
+	for i := range s.run.Artifacts {
+		if s.run.Artifacts[i].Name == a.Name {
+			s.run.Artifacts[i] = meta
+			replaced = true
+		}
+	}
+	if !replaced {
+		s.run.Artifacts = append(s.run.Artifacts, meta)
+	}
+	s.mu.Unlock()
+	s.persist()
+}
+
+// saveCheckpoint stores a step's serialized output for resuming the run.
+// Failures are logged; a resumed run then runs the step again.
+func (s *runState) saveCheckpoint(step string, data []byte) {
+	if s.store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.store.SaveCheckpoint(ctx, s.run.ID, step, data); err != nil {
+		logger.Warn("Failed to save step checkpoint",
+			zap.String("run_id", s.run.ID),
+			zap.String("step", step),
+			zap.Error(err))
+	}
+}
+
+type runStateKey struct{}
+
+// withRunState attaches run state to ctx so Flow can report step progress.
+func withRunState(ctx context.Context, s *runState) context.Context {
+	return context.WithValue(ctx, runStateKey{}, s)
+}
+
+// runStateFromContext returns the run state attached to ctx, if any.
+func runStateFromContext(ctx context.Context) *runState {
+	s, _ := ctx.Value(runStateKey{}).(*runState)
+	return s
+}
+
+// RecordItems records how many items a step produced (shipments polled,
+// files converted, ...). The count is saved with the step when it finishes.
+// It is a no-op outside a managed run.
+func RecordItems(ctx context.Context, step string, n int) {
+	if state := runStateFromContext(ctx); state != nil {
+		state.stepItems(step, n)
+	}
+}
+
+// RecordArtifact attaches a named output (e.g. a generated document) to the
+// current run. Recording the same name again replaces it. It is a no-op
+// outside a managed run.
+func RecordArtifact(ctx context.Context, name, contentType string, content []byte) {
+	if state := runStateFromContext(ctx); state != nil {
+		state.addArtifact(Artifact{Name: name, ContentType: contentType, Size: len(content), Content: content})
+	}
+}
+
+// MarkPartial records that the current run left work for a later run, e.g.
+// because a search hit its page limit. A run that then finishes without
+// error is partial rather than succeeded, with the reasons as its error. It
+// is a no-op outside a managed run.
+func MarkPartial(ctx context.Context, reason string) {
+	runlog.Warn(ctx, "Run left work for a later run", zap.String("reason", reason))
+	if state := runStateFromContext(ctx); state != nil {
+		state.mu.Lock()
+		state.partial = append(state.partial, reason)
+		state.mu.Unlock()
+	}
+}
+
+// RunFunc executes a pipeline for a queued run.
+type RunFunc func(ctx context.Context) error
+
+// ErrRunExists is returned by Submit when the run ID is already in use.
+var ErrRunExists = errors.New("run already exists")
+
+// Cancellation errors. A run whose context is cancelled with a cause
+// wrapping ErrRunCancelled finishes as cancelled rather than failed.
+var (
+	ErrRunCancelled = errors.New("run cancelled")
+	ErrRunNotActive = errors.New("run is not active on this instance")
+	ErrRunFinished  = errors.New("run already finished")
+	errShutdown     = errors.New("service shutting down")
+)
+
+// RunManager executes pipeline runs in the background. Recent runs are kept
+// in memory so callers can poll for the outcome, and every change is written
+// to a RunStore for history.
+type RunManager struct {
+	mu         sync.Mutex
+	runs       map[string]*runState
+	order      []string // run IDs, oldest first
+	maxHistory int
+	store      RunStore
+
+	baseCtx context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewRunManager creates a run manager that keeps up to maxHistory runs in
+// memory and records runs in store. A nil store keeps history in memory only.
+func NewRunManager(maxHistory int, store RunStore) *RunManager {
+	if maxHistory <= 0 {
+		maxHistory = 100
+	}
+	if store == nil {
+		store = NewMemoryRunStore(maxHistory)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RunManager{
+		runs:       make(map[string]*runState),
+		maxHistory: maxHistory,
+		store:      store,
+		baseCtx:    ctx,
+		cancel:     cancel,
+	}
+}
+
+// Submit queues a run and executes fn in the background. The context passed
+// to fn carries skip steps and other values from ctx, but is detached from
+// its cancellation so runs outlive HTTP requests.
+func (m *RunManager) Submit(ctx context.Context, spec RunSpec, fn RunFunc) (Run, error) {
+	id := spec.ID
+	state := &runState{store: m.store, run: Run{
+		ID:       id,
+		Pipeline: spec.Pipeline,
+		Trigger:  spec.Trigger,
+		CalledBy: spec.CalledBy,
+		Params:   spec.Params,
+		Status:   StatusQueued,
+		Steps:    make([]StepRun, 0, len(spec.Steps)),
+		QueuedAt: time.Now(),
+	}}
+	for _, name := range spec.Steps {
+		state.run.Steps = append(state.run.Steps, StepRun{Name: name, Status: StatusPending})
+	}
+
+	// Keep request values (skip steps, etc.) but not the request's
+	// cancellation; runs are cancelled only by Cancel or on shutdown.
+	runCtx, cancel := context.WithCancelCause(withRunState(context.WithoutCancel(ctx), state))
+	state.cancel = cancel
+
+	if m.store != nil {
+		if _, found, err := m.store.Get(ctx, id); err != nil {
+			logger.Warn("Failed to look up run record", zap.String("run_id", id), zap.Error(err))
+		} else if found {
+			cancel(nil)
+			return Run{}, fmt.Errorf("%w: %s", ErrRunExists, id)
+		}
+	}
+
+	m.mu.Lock()
+	if _, exists := m.runs[id]; exists {
+		m.mu.Unlock()
+		cancel(nil)
+		return Run{}, fmt.Errorf("%w: %s", ErrRunExists, id)
+	}
+	m.runs[id] = state
+	m.order = append(m.order, id)
+	m.pruneLocked()
+	m.mu.Unlock()
+
+	// Runs pruned from memory or started by other instances are only in the
+	// store. Get catches most of them; Create closes the race with another
+	// instance submitting the same ID.
+	if err := m.create(state); errors.Is(err, ErrRunExists) {
+		m.mu.Lock()
+		m.removeLocked(id)
+		m.mu.Unlock()
+		cancel(nil)
+		return Run{}, err
+	}
+
+	stop := context.AfterFunc(m.baseCtx, func() { cancel(errShutdown) })
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer stop()
+		defer cancel(nil)
+		m.execute(runCtx, state, fn)
+	}()
+
+	return state.snapshot(), nil
+}
+
+// create inserts the queued run into the store. Failures other than a taken
+// ID are logged and do not affect the run; later saves retry the write.
+func (m *RunManager) create(state *runState) error {
+	if m.store == nil {
+		return nil
+	}
+	state.saveMu.Lock()
+	defer state.saveMu.Unlock()
+
+	run := state.snapshot()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := m.store.Create(ctx, run)
+	if err != nil && !errors.Is(err, ErrRunExists) {
+		logger.Warn("Failed to save run record",
+			zap.String("pipeline", run.Pipeline),
+			zap.String("run_id", run.ID),
+			zap.Error(err))
+	}
+	return err
+}
+
+// removeLocked forgets run id. Caller holds mu.
+func (m *RunManager) removeLocked(id string) {
+	delete(m.runs, id)
+	for i, o := range m.order {
+		if o == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// execute runs fn and records the outcome on state.
+func (m *RunManager) execute(ctx context.Context, state *runState, fn RunFunc) {
+	state.mu.Lock()
+	start := time.Now()
+	state.run.Status = StatusRunning
+	state.run.StartedAt = &start
+	pipeline, id := state.run.Pipeline, state.run.ID
+	state.publishLocked(RunEvent{Type: EventRunStarted, Status: StatusRunning})
+	state.mu.Unlock()
+	state.persist()
+
+	ctx = runlog.With(ctx, zap.String("run_id", id), zap.String("pipeline", pipeline))
+	ctx, span := tracing.Start(ctx, "run "+pipeline, tracing.Pipeline.String(pipeline), tracing.RunID.String(id))
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return fn(ctx)
+	}()
+	tracing.End(span, err)
+
+	state.mu.Lock()
+	end := time.Now()
+	state.run.FinishedAt = &end
+	state.run.Duration = end.Sub(start).Seconds()
+	if err != nil && isCancelled(ctx) {
+		state.run.Status = StatusCancelled
+		state.run.Error = err.Error()
+	} else if err != nil {
+		state.run.Status = StatusFailed
+		state.run.Error = err.Error()
+	} else if len(state.partial) > 0 {
+		state.run.Status = StatusPartial
+		state.run.Error = strings.Join(state.partial, "; ")
+	} else {
+		state.run.Status = StatusSucceeded
+	}
+	status, reason := state.run.Status, state.run.Error
+	state.publishLocked(RunEvent{Type: EventRunFinished, Status: state.run.Status, Error: state.run.Error})
+	state.closeSubscribersLocked()
+	state.mu.Unlock()
+	state.persist()
+
+	if err != nil && isCancelled(ctx) {
+		runlog.Warn(ctx, "Pipeline cancelled",
+			zap.NamedError("cause", context.Cause(ctx)),
+			zap.Error(err))
+		return
+	}
+	if err != nil {
+		runlog.Error(ctx, "Pipeline failed", zap.Error(err))
+		return
+	}
+	if status == StatusPartial {
+		runlog.Warn(ctx, "Pipeline completed partially", zap.String("reason", reason))
+		return
+	}
+	runlog.Info(ctx, "Pipeline completed")
+}
+
+// isCancelled reports whether ctx was cancelled on request (see
+// ErrRunCancelled), as opposed to by shutdown.
+func isCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrRunCancelled)
+}
+
+// pruneLocked drops the oldest finished runs beyond maxHistory. Caller holds mu.
+func (m *RunManager) pruneLocked() {
+	if len(m.order) <= m.maxHistory {
+		return
+	}
+	kept := m.order[:0]
+	excess := len(m.order) - m.maxHistory
+	for _, id := range m.order {
+		if excess > 0 {
+			state := m.runs[id]
+			state.mu.Lock()
+			done := state.run.Done()
+			state.mu.Unlock()
+			if done {
+				delete(m.runs, id)
+				excess--
+				continue
+			}
+		}
+		kept = append(kept, id)
+	}
+	m.order = kept
+}
+
+// Cancel cancels an active run's context. The flow stops at its next
+// checkpoint, records the step in progress as cancelled and the run finishes
+// with status cancelled. Only runs executing on this instance can be
+// cancelled (ErrRunNotActive otherwise).
+func (m *RunManager) Cancel(id string) (Run, error) {
+	m.mu.Lock()
+	state, ok := m.runs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Run{}, fmt.Errorf("%w: %s", ErrRunNotActive, id)
+	}
+
+	state.mu.Lock()
+	done, cancel := state.run.Done(), state.cancel
+	state.mu.Unlock()
+	if done {
+		return state.snapshot(), fmt.Errorf("%w: %s", ErrRunFinished, id)
+	}
+
+	logger.Info("Cancelling pipeline run", zap.String("run_id", id))
+	cancel(ErrRunCancelled)
+	return state.snapshot(), nil
+}
+
+// Get returns a snapshot of the run with the given ID.
+func (m *RunManager) Get(id string) (Run, bool) {
+	m.mu.Lock()
+	state, ok := m.runs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Run{}, false
+	}
+	return state.snapshot(), true
+}
+
+// List returns snapshots of known runs, newest first. An empty pipeline
+// returns runs for all pipelines.
+func (m *RunManager) List(pipeline string) []Run {
+	m.mu.Lock()
+	states := make([]*runState, 0, len(m.order))
+	for _, id := range m.order {
+		states = append(states, m.runs[id])
+	}
+	m.mu.Unlock()
+
+	runs := make([]Run, 0, len(states))
+	for _, state := range states {
+		run := state.snapshot()
+		if pipeline != "" && run.Pipeline != pipeline {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].QueuedAt.After(runs[j].QueuedAt)
+	})
+	return runs
+}
+
+// Lookup returns a run from memory or, if it has been pruned or ran on
+// another instance, from the run store.
+func (m *RunManager) Lookup(ctx context.Context, id string) (Run, bool, error) {
+	if run, ok := m.Get(id); ok {
+		return run, true, nil
+	}
+	return m.store.Get(ctx, id)
+}
+
+// Artifact returns the content of a run artifact from the run store.
+func (m *RunManager) Artifact(ctx context.Context, runID, name string) (Artifact, bool, error) {
+	return m.store.GetArtifact(ctx, runID, name)
+}
+
+// History returns runs from the run store, newest first.
+func (m *RunManager) History(ctx context.Context, filter RunFilter) ([]Run, error) {
+	return m.store.List(ctx, filter)
+}
+
+// Shutdown cancels in-flight runs and waits for them to return, or until
+// ctx is done.
+func (m *RunManager) Shutdown(ctx context.Context) error {
+	m.cancel()
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
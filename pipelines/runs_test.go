@@ -0,0 +1,247 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForRun polls the manager until the run reaches a terminal status.
+func waitForRun(t *testing.T, m *RunManager, id string) Run {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		run, ok := m.Get(id)
+		if !ok {
+			t.Fatalf("run %s not found", id)
+		}
+		if run.Done() {
+			return run
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("run %s did not finish", id)
+	return Run{}
+}
+
+func TestRunManagerSubmitSuccess(t *testing.T) {
+	m := NewRunManager(10, nil)
+
+	run, err := m.Submit(context.Background(), RunSpec{Pipeline: "test", ID: "run-1", Steps: []string{"task1", "task2", "task3"}}, func(ctx context.Context) error {
+		flow := NewFlow("test")
+		flow.AddTask("task1", func(context.Context) error { return nil })
+		flow.AddTask("task2", func(context.Context) error { return nil }, "task1")
+		flow.AddTask("task3", func(context.Context) error { return nil }, "task2")
+		return flow.Run(context.WithValue(ctx, SkipStepsKey, []string{"task2"}))
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if run.ID != "run-1" || run.Pipeline != "test" {
+		t.Errorf("Submit() returned %s/%s, want test/run-1", run.Pipeline, run.ID)
+	}
+
+	done := waitForRun(t, m, "run-1")
+	if done.Status != StatusSucceeded {
+		t.Fatalf("Status = %s, want %s (error: %s)", done.Status, StatusSucceeded, done.Error)
+	}
+	if done.StartedAt == nil || done.FinishedAt == nil {
+		t.Error("expected start and finish times to be set")
+	}
+
+	want := map[string]RunStatus{
+		"task1": StatusSucceeded,
+		"task2": StatusSkipped,
+		"task3": StatusSucceeded,
+	}
+	if len(done.Steps) != len(want) {
+		t.Fatalf("Expected %d steps, got %d: %+v", len(want), len(done.Steps), done.Steps)
+	}
+	for _, step := range done.Steps {
+		if step.Status != want[step.Name] {
+			t.Errorf("step %s status = %s, want %s", step.Name, step.Status, want[step.Name])
+		}
+	}
+	if done.Steps[0].Attempts != 1 {
+		t.Errorf("task1 attempts = %d, want 1", done.Steps[0].Attempts)
+	}
+}
+
+func TestRunManagerSubmitFailure(t *testing.T) {
+	m := NewRunManager(10, nil)
+
+	_, err := m.Submit(context.Background(), RunSpec{Pipeline: "test", ID: "run-1"}, func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	done := waitForRun(t, m, "run-1")
+	if done.Status != StatusFailed {
+		t.Errorf("Status = %s, want %s", done.Status, StatusFailed)
+	}
+	if done.Error != "boom" {
+		t.Errorf("Error = %q, want %q", done.Error, "boom")
+	}
+}
+
+func TestRunManagerSubmitPartial(t *testing.T) {
+	m := NewRunManager(10, nil)
+
+	_, err := m.Submit(context.Background(), RunSpec{Pipeline: "test", ID: "run-1"}, func(ctx context.Context) error {
+		MarkPartial(ctx, "page limit reached")
+		MarkPartial(ctx, "2 files queued")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	done := waitForRun(t, m, "run-1")
+	if done.Status != StatusPartial {
+		t.Errorf("Status = %s, want %s", done.Status, StatusPartial)
+	}
+	if done.Error != "page limit reached; 2 files queued" {
+		t.Errorf("Error = %q, want the reasons", done.Error)
+	}
+}
+
+func TestRunManagerDuplicateID(t *testing.T) {
+	m := NewRunManager(10, nil)
+	noop := func(ctx context.Context) error { return nil }
+
+	if _, err := m.Submit(context.Background(), RunSpec{Pipeline: "test", ID: "run-1"}, noop); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	_, err := m.Submit(context.Background(), RunSpec{Pipeline: "test", ID: "run-1"}, noop)
+	if !errors.Is(err, ErrRunExists) {
+		t.Errorf("Submit() duplicate error = %v, want ErrRunExists", err)
+	}
+}
+
+func TestRunManagerDetachesRequestCancellation(t *testing.T) {
+	m := NewRunManager(10, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	release := make(chan struct{})
+	_, err := m.Submit(ctx, RunSpec{Pipeline: "test", ID: "run-1"}, func(runCtx context.Context) error {
+		<-release
+		return runCtx.Err()
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	cancel() // Simulate the HTTP request finishing
+	close(release)
+
+	done := waitForRun(t, m, "run-1")
+	if done.Status != StatusSucceeded {
+		t.Errorf("Status = %s, want %s (error: %s)", done.Status, StatusSucceeded, done.Error)
+	}
+}
+
+func TestRunManagerCancel(t *testing.T) {
+	m := NewRunManager(10, nil)
+	started := make(chan struct{})
+
+	_, err := m.Submit(context.Background(), RunSpec{Pipeline: "test", ID: "run-1", Steps: []string{"scan", "upload"}}, func(ctx context.Context) error {
+		flow := NewFlow("test")
+		flow.AddTask("scan", func(context.Context) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		flow.AddTask("upload", func(context.Context) error { return nil }, "scan")
+		return flow.Run(ctx)
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	<-started
+	if _, err := m.Cancel("run-1"); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+
+	done := waitForRun(t, m, "run-1")
+	if done.Status != StatusCancelled {
+		t.Errorf("Status = %s, want %s (error: %s)", done.Status, StatusCancelled, done.Error)
+	}
+	if done.Steps[0].Status != StatusCancelled || done.Steps[1].Status != StatusPending {
+		t.Errorf("steps = %+v, want scan cancelled and upload pending", done.Steps)
+	}
+	if done.Steps[0].Attempts != 1 {
+		t.Errorf("scan attempts = %d, want 1 (no retry after cancel)", done.Steps[0].Attempts)
+	}
+
+	if _, err := m.Cancel("run-1"); !errors.Is(err, ErrRunFinished) {
+		t.Errorf("Cancel() finished run error = %v, want ErrRunFinished", err)
+	}
+	if _, err := m.Cancel("missing"); !errors.Is(err, ErrRunNotActive) {
+		t.Errorf("Cancel() unknown run error = %v, want ErrRunNotActive", err)
+	}
+}
+
+func TestRunManagerListAndPrune(t *testing.T) {
+	m := NewRunManager(2, nil)
+	noop := func(ctx context.Context) error { return nil }
+
+	if _, err := m.Submit(context.Background(), RunSpec{Pipeline: "inbound", ID: "run-1"}, noop); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitForRun(t, m, "run-1")
+	if _, err := m.Submit(context.Background(), RunSpec{Pipeline: "outbound", ID: "run-2"}, noop); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitForRun(t, m, "run-2")
+	if _, err := m.Submit(context.Background(), RunSpec{Pipeline: "outbound", ID: "run-3"}, noop); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitForRun(t, m, "run-3")
+
+	if _, ok := m.Get("run-1"); ok {
+		t.Error("Expected oldest run to be pruned")
+	}
+
+	all := m.List("")
+	if len(all) != 2 {
+		t.Fatalf("List() returned %d runs, want 2", len(all))
+	}
+	if all[0].ID != "run-3" {
+		t.Errorf("List() first run = %s, want run-3 (newest first)", all[0].ID)
+	}
+
+	outbound := m.List("outbound")
+	if len(outbound) != 2 {
+		t.Errorf("List(outbound) returned %d runs, want 2", len(outbound))
+	}
+	if len(m.List("inbound")) != 0 {
+		t.Error("List(inbound) expected no runs after pruning")
+	}
+}
+
+func TestRunManagerShutdown(t *testing.T) {
+	m := NewRunManager(10, nil)
+
+	_, err := m.Submit(context.Background(), RunSpec{Pipeline: "test", ID: "run-1"}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	run, _ := m.Get("run-1")
+	if run.Status != StatusFailed {
+		t.Errorf("Status = %s, want %s after shutdown", run.Status, StatusFailed)
+	}
+}
@@ -0,0 +1,363 @@
+package pipelines
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/robfig/cron/v3"
+	"github.com/trackvision/tv-shared-go/logger"
+	"go.uber.org/zap"
+)
+
+// ManualSchedule marks a pipeline that only runs when triggered via the API.
+const ManualSchedule = "@manual"
+
+// TriggerFunc starts a scheduled run of a pipeline with the given run ID.
+type TriggerFunc func(ctx context.Context, pipeline, runID string) error
+
+// TickClaimer ensures only one instance fires a given schedule tick.
+// Claim returns true if the caller won the tick and should start the run.
+type TickClaimer interface {
+	Claim(ctx context.Context, pipeline string, tick time.Time) (bool, error)
+}
+
+// ScheduleInfo describes a scheduled pipeline for API and UI display.
+type ScheduleInfo struct {
+	Pipeline  string     `json:"pipeline"`
+	Schedule  string     `json:"schedule"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastRunID string     `json:"last_run_id,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// scheduleEntry is a pipeline registered with the scheduler.
+type scheduleEntry struct {
+	pipeline string
+	spec     string
+	schedule cron.Schedule
+
+	mu        sync.Mutex
+	next      time.Time
+	last      time.Time
+	lastRunID string
+	lastError string
+}
+
+func (e *scheduleEntry) info() ScheduleInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	info := ScheduleInfo{
+		Pipeline:  e.pipeline,
+		Schedule:  e.spec,
+		LastRunID: e.lastRunID,
+		LastError: e.lastError,
+	}
+	if !e.next.IsZero() {
+		next := e.next
+		info.NextRun = &next
+	}
+	if !e.last.IsZero() {
+		last := e.last
+		info.LastRun = &last
+	}
+	return info
+}
+
+// Scheduler fires registered pipelines on their cron schedules. Each tick is
+// claimed through a TickClaimer first, so when several instances run the
+// same schedule only the one that wins the claim starts the pipeline.
+type Scheduler struct {
+	trigger TriggerFunc
+	claimer TickClaimer
+
+	mu      sync.Mutex
+	entries map[string]*scheduleEntry
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler that calls trigger for claimed ticks.
+func NewScheduler(trigger TriggerFunc, claimer TickClaimer) *Scheduler {
+	return &Scheduler{
+		trigger: trigger,
+		claimer: claimer,
+		entries: make(map[string]*scheduleEntry),
+	}
+}
+
+// ParseSchedule validates a cron expression. Standard five-field specs and
+// descriptors such as "@hourly" or "@every 15m" are accepted. "@every" ticks
+// fall on multiples of the interval rather than counting from when the
+// instance started, so every instance claims the same ticks.
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	if every, ok := schedule.(cron.ConstantDelaySchedule); ok {
+		return alignedSchedule{every.Delay}, nil
+	}
+	return schedule, nil
+}
+
+// alignedSchedule fires at every multiple of interval since the zero time.
+type alignedSchedule struct {
+	interval time.Duration
+}
+
+func (s alignedSchedule) Next(t time.Time) time.Time {
+	return t.Truncate(s.interval).Add(s.interval)
+}
+
+// Add registers a pipeline schedule. Empty and "@manual" specs are ignored.
+// Add must be called before Start.
+func (s *Scheduler) Add(pipeline, spec string) error {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == ManualSchedule {
+		return nil
+	}
+
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for %s: %w", spec, pipeline, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[pipeline] = &scheduleEntry{
+		pipeline: pipeline,
+		spec:     spec,
+		schedule: schedule,
+	}
+	return nil
+}
+
+// Info returns the schedule for a pipeline. ok is false for manual pipelines.
+func (s *Scheduler) Info(pipeline string) (ScheduleInfo, bool) {
+	s.mu.Lock()
+	entry, ok := s.entries[pipeline]
+	s.mu.Unlock()
+	if !ok {
+		return ScheduleInfo{Pipeline: pipeline, Schedule: ManualSchedule}, false
+	}
+	return entry.info(), true
+}
+
+// List returns all scheduled pipelines sorted by name.
+func (s *Scheduler) List() []ScheduleInfo {
+	s.mu.Lock()
+	entries := make([]*scheduleEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	infos := make([]ScheduleInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, e.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Pipeline < infos[j].Pipeline })
+	return infos
+}
+
+// Start begins firing schedules in the background until Stop is called.
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.cancel = cancel
+	for _, entry := range s.entries {
+		s.wg.Add(1)
+		go func(e *scheduleEntry) {
+			defer s.wg.Done()
+			s.loop(ctx, e)
+		}(entry)
+	}
+	count := len(s.entries)
+	s.mu.Unlock()
+
+	logger.Info("Scheduler started", zap.Int("schedules", count))
+}
+
+// Stop halts the scheduler and waits for pending triggers to return.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	s.wg.Wait()
+}
+
+// loop sleeps until each scheduled tick and fires it.
+func (s *Scheduler) loop(ctx context.Context, e *scheduleEntry) {
+	for {
+		next := e.schedule.Next(time.Now())
+		e.mu.Lock()
+		e.next = next
+		e.mu.Unlock()
+
+		logger.Info("Next scheduled run",
+			zap.String("pipeline", e.pipeline),
+			zap.String("schedule", e.spec),
+			zap.Time("next_run", next))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.fire(ctx, e, next)
+	}
+}
+
+// fire claims the tick and triggers the pipeline if this instance won it.
+func (s *Scheduler) fire(ctx context.Context, e *scheduleEntry, tick time.Time) {
+	claimed, err := s.claimer.Claim(ctx, e.pipeline, tick)
+	if err != nil {
+		// Skip rather than risk a duplicate run on every instance
+		logger.Error("Failed to claim scheduled tick, skipping",
+			zap.String("pipeline", e.pipeline),
+			zap.Time("tick", tick),
+			zap.Error(err))
+		e.mu.Lock()
+		e.lastError = fmt.Sprintf("claim failed: %v", err)
+		e.mu.Unlock()
+		return
+	}
+	if !claimed {
+		logger.Info("Scheduled tick claimed by another instance",
+			zap.String("pipeline", e.pipeline),
+			zap.Time("tick", tick))
+		e.mu.Lock()
+		e.last = tick
+		e.lastRunID = ""
+		e.lastError = ""
+		e.mu.Unlock()
+		return
+	}
+
+	runID := ScheduledRunID(e.pipeline, tick)
+	logger.Info("Firing scheduled run",
+		zap.String("pipeline", e.pipeline),
+		zap.String("run_id", runID),
+		zap.Time("tick", tick))
+
+	err = s.trigger(ctx, e.pipeline, runID)
+
+	e.mu.Lock()
+	e.last = tick
+	e.lastRunID = runID
+	e.lastError = ""
+	if err != nil {
+		e.lastError = err.Error()
+	}
+	e.mu.Unlock()
+
+	if err != nil {
+		logger.Error("Failed to start scheduled run",
+			zap.String("pipeline", e.pipeline),
+			zap.String("run_id", runID),
+			zap.Error(err))
+	}
+}
+
+// ScheduledRunID returns the deterministic run ID for a schedule tick.
+func ScheduledRunID(pipeline string, tick time.Time) string {
+	return fmt.Sprintf("sched-%s-%s", pipeline, tick.UTC().Format("20060102T150405Z"))
+}
+
+// LocalTickClaimer claims every tick. Use it only when a single instance runs.
+type LocalTickClaimer struct{}
+
+// Claim always wins.
+func (LocalTickClaimer) Claim(ctx context.Context, pipeline string, tick time.Time) (bool, error) {
+	return true, nil
+}
+
+// scheduleClaimsTable records which instance fired each schedule tick.
+const scheduleClaimsTable = "pipeline_schedule_claims"
+
+// SQLTickClaimer claims ticks by inserting a row keyed by (pipeline, tick)
+// into TiDB/MySQL. The primary key makes the insert succeed on exactly one
+// instance; the others see a duplicate key and skip the tick.
+type SQLTickClaimer struct {
+	db        *sqlx.DB
+	instance  string
+	retention time.Duration
+
+	mu        sync.Mutex
+	ensuredDB bool
+}
+
+// NewSQLTickClaimer creates a claimer backed by db. Claims older than
+// retention are pruned opportunistically.
+func NewSQLTickClaimer(db *sqlx.DB, retention time.Duration) *SQLTickClaimer {
+	if retention <= 0 {
+		retention = 7 * 24 * time.Hour
+	}
+	return &SQLTickClaimer{db: db, instance: instanceName(), retention: retention}
+}
+
+// ensureTable creates the claims table on first use.
+func (c *SQLTickClaimer) ensureTable(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ensuredDB {
+		return nil
+	}
+	_, err := c.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+scheduleClaimsTable+` (
+		pipeline VARCHAR(100) NOT NULL,
+		tick_at DATETIME NOT NULL,
+		instance VARCHAR(255) NOT NULL,
+		claimed_at DATETIME NOT NULL,
+		PRIMARY KEY (pipeline, tick_at)
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating %s table: %w", scheduleClaimsTable, err)
+	}
+	c.ensuredDB = true
+	return nil
+}
+
+// Claim inserts the claim row and reports whether this instance won it.
+func (c *SQLTickClaimer) Claim(ctx context.Context, pipeline string, tick time.Time) (bool, error) {
+	if err := c.ensureTable(ctx); err != nil {
+		return false, err
+	}
+
+	tickAt := tick.UTC().Truncate(time.Second)
+	result, err := c.db.ExecContext(ctx,
+		`INSERT IGNORE INTO `+scheduleClaimsTable+` (pipeline, tick_at, instance, claimed_at) VALUES (?, ?, ?, ?)`,
+		pipeline, tickAt, c.instance, time.Now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("inserting claim: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("reading claim result: %w", err)
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	// Best-effort cleanup of old claims
+	if _, err := c.db.ExecContext(ctx,
+		`DELETE FROM `+scheduleClaimsTable+` WHERE tick_at < ?`,
+		time.Now().UTC().Add(-c.retention)); err != nil {
+		logger.Warn("Failed to prune schedule claims", zap.Error(err))
+	}
+
+	return true, nil
+}
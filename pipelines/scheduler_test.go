@@ -0,0 +1,186 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeClaimer returns a fixed claim result and records claimed ticks.
+type fakeClaimer struct {
+	mu     sync.Mutex
+	result bool
+	err    error
+	ticks  []time.Time
+}
+
+func (c *fakeClaimer) Claim(ctx context.Context, pipeline string, tick time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ticks = append(c.ticks, tick)
+	return c.result, c.err
+}
+
+func TestSchedulerAdd(t *testing.T) {
+	s := NewScheduler(func(ctx context.Context, pipeline, runID string) error { return nil }, LocalTickClaimer{})
+
+	if err := s.Add("inbound", "*/15 * * * *"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := s.Add("outbound", ManualSchedule); err != nil {
+		t.Fatalf("Add(@manual) error = %v", err)
+	}
+	if err := s.Add("broken", "not a cron"); err == nil {
+		t.Error("Add() expected error for invalid spec")
+	}
+
+	info, ok := s.Info("inbound")
+	if !ok || info.Schedule != "*/15 * * * *" {
+		t.Errorf("Info(inbound) = %+v, %v", info, ok)
+	}
+	info, ok = s.Info("outbound")
+	if ok || info.Schedule != ManualSchedule {
+		t.Errorf("Info(outbound) = %+v, %v; want @manual", info, ok)
+	}
+	if len(s.List()) != 1 {
+		t.Errorf("List() returned %d schedules, want 1", len(s.List()))
+	}
+}
+
+func TestParseScheduleAlignsEvery(t *testing.T) {
+	schedule, err := ParseSchedule("@every 10m")
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+	// Instances started at different times agree on the next tick
+	for _, now := range []time.Time{
+		time.Date(2025, 1, 25, 12, 3, 27, 0, time.UTC),
+		time.Date(2025, 1, 25, 12, 9, 59, 500, time.UTC),
+	} {
+		if got, want := schedule.Next(now), time.Date(2025, 1, 25, 12, 10, 0, 0, time.UTC); !got.Equal(want) {
+			t.Errorf("Next(%s) = %s, want %s", now, got, want)
+		}
+	}
+}
+
+func TestSchedulerFire(t *testing.T) {
+	tick := time.Date(2025, 1, 25, 10, 15, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		claimer     *fakeClaimer
+		wantTrigger bool
+		wantRunID   string
+		wantError   bool
+	}{
+		{"claimed", &fakeClaimer{result: true}, true, "sched-inbound-20250125T101500Z", false},
+		{"claimed elsewhere", &fakeClaimer{result: false}, false, "", false},
+		{"claim error", &fakeClaimer{err: errors.New("db down")}, false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var triggered []string
+			s := NewScheduler(func(ctx context.Context, pipeline, runID string) error {
+				triggered = append(triggered, runID)
+				return nil
+			}, tt.claimer)
+			if err := s.Add("inbound", "@hourly"); err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+
+			s.fire(context.Background(), s.entries["inbound"], tick)
+
+			if tt.wantTrigger != (len(triggered) == 1) {
+				t.Fatalf("triggered = %v, want trigger %v", triggered, tt.wantTrigger)
+			}
+			info, _ := s.Info("inbound")
+			if info.LastRunID != tt.wantRunID {
+				t.Errorf("LastRunID = %q, want %q", info.LastRunID, tt.wantRunID)
+			}
+			if (info.LastError != "") != tt.wantError {
+				t.Errorf("LastError = %q, want error %v", info.LastError, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestSchedulerStartStop(t *testing.T) {
+	fired := make(chan string, 1)
+	s := NewScheduler(func(ctx context.Context, pipeline, runID string) error {
+		select {
+		case fired <- runID:
+		default:
+		}
+		return nil
+	}, LocalTickClaimer{})
+	if err := s.Add("inbound", "@every 1s"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	s.Start()
+	defer s.Stop()
+
+	select {
+	case runID := <-fired:
+		if runID == "" {
+			t.Error("expected a run ID")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("scheduled run did not fire")
+	}
+
+	info, _ := s.Info("inbound")
+	if info.NextRun == nil {
+		t.Error("expected NextRun to be set while running")
+	}
+}
+
+func TestSQLTickClaimer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	claimer := NewSQLTickClaimer(sqlx.NewDb(db, "sqlmock"), time.Hour)
+	tick := time.Date(2025, 1, 25, 10, 15, 0, 0, time.UTC)
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS pipeline_schedule_claims").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT IGNORE INTO pipeline_schedule_claims").
+		WithArgs("inbound", tick, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM pipeline_schedule_claims").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	claimed, err := claimer.Claim(context.Background(), "inbound", tick)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if !claimed {
+		t.Error("Claim() = false, want true for first claim")
+	}
+
+	// Second instance: the insert is ignored as a duplicate
+	mock.ExpectExec("INSERT IGNORE INTO pipeline_schedule_claims").
+		WithArgs("inbound", tick, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	claimed, err = claimer.Claim(context.Background(), "inbound", tick)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if claimed {
+		t.Error("Claim() = true, want false for duplicate tick")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
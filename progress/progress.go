@@ -0,0 +1,23 @@
+// Package progress lets long-running tasks report item-level progress
+// ("dispatching 3/10") to whoever is watching the run, without depending on
+// the pipelines package. Reports made outside a running step are dropped.
+package progress
+
+import "context"
+
+// Func receives a progress report. total is 0 when it is not known.
+type Func func(done, total int, message string)
+
+type reporterKey struct{}
+
+// WithReporter returns a context whose progress reports go to fn.
+func WithReporter(ctx context.Context, fn Func) context.Context {
+	return context.WithValue(ctx, reporterKey{}, fn)
+}
+
+// Report sends a progress report to the reporter attached to ctx, if any.
+func Report(ctx context.Context, done, total int, message string) {
+	if fn, ok := ctx.Value(reporterKey{}).(Func); ok && fn != nil {
+		fn(done, total, message)
+	}
+}
@@ -0,0 +1,23 @@
+package progress
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	// No reporter attached: a no-op
+	Report(context.Background(), 1, 2, "ignored")
+
+	var got []string
+	ctx := WithReporter(context.Background(), func(done, total int, message string) {
+		got = append(got, message)
+		if done != 3 || total != 10 {
+			t.Errorf("Report() = %d/%d, want 3/10", done, total)
+		}
+	})
+	Report(ctx, 3, 10, "dispatching")
+	if len(got) != 1 || got[0] != "dispatching" {
+		t.Errorf("reports = %v, want [dispatching]", got)
+	}
+}
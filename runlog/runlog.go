@@ -0,0 +1,69 @@
+// Package runlog carries log fields for a run in the context, so every line
+// a task logs says which run, pipeline, step and business object it belongs
+// to. RunManager adds run_id and pipeline, Flow adds step, and tasks add keys
+// such as capture_id while working on one item. Lines go through the shared
+// logger.
+package runlog
+
+import (
+	"context"
+
+	"github.com/trackvision/tv-shared-go/logger"
+	"go.uber.org/zap"
+)
+
+type fieldsKey struct{}
+
+// With returns a context whose log lines carry fields. A field replaces one
+// with the same key already in ctx.
+func With(ctx context.Context, fields ...zap.Field) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, merge(Fields(ctx), fields))
+}
+
+// Fields returns the fields in ctx.
+func Fields(ctx context.Context) []zap.Field {
+	fields, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+	return fields
+}
+
+// merge returns base with extra appended, dropping fields of base that extra
+// has a key for, so no key is logged twice.
+func merge(base, extra []zap.Field) []zap.Field {
+	if len(base) == 0 {
+		return extra
+	}
+	out := make([]zap.Field, 0, len(base)+len(extra))
+	for _, f := range base {
+		replaced := false
+		for _, e := range extra {
+			if e.Key == f.Key {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			out = append(out, f)
+		}
+	}
+	return append(out, extra...)
+}
+
+// Debug logs at debug level with the fields in ctx.
+func Debug(ctx context.Context, msg string, fields ...zap.Field) {
+	logger.Debug(msg, merge(Fields(ctx), fields)...)
+}
+
+// Info logs at info level with the fields in ctx.
+func Info(ctx context.Context, msg string, fields ...zap.Field) {
+	logger.Info(msg, merge(Fields(ctx), fields)...)
+}
+
+// Warn logs at warning level with the fields in ctx.
+func Warn(ctx context.Context, msg string, fields ...zap.Field) {
+	logger.Warn(msg, merge(Fields(ctx), fields)...)
+}
+
+// Error logs at error level with the fields in ctx.
+func Error(ctx context.Context, msg string, fields ...zap.Field) {
+	logger.Error(msg, merge(Fields(ctx), fields)...)
+}
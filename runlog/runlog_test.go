@@ -0,0 +1,49 @@
+package runlog
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func keys(fields []zap.Field) string {
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f.Key + "=" + f.String + " ")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func TestWith(t *testing.T) {
+	if got := Fields(context.Background()); got != nil {
+		t.Errorf("Fields() = %v, want none", got)
+	}
+
+	ctx := With(context.Background(), zap.String("run_id", "run-1"), zap.String("pipeline", "outbound"))
+	step := With(ctx, zap.String("step", "dispatch"))
+	item := With(step, zap.String("capture_id", "C-1"))
+	next := With(item, zap.String("capture_id", "C-2"))
+
+	if got, want := keys(Fields(ctx)), "run_id=run-1 pipeline=outbound"; got != want {
+		t.Errorf("run fields = %q, want %q", got, want)
+	}
+	if got, want := keys(Fields(item)), "run_id=run-1 pipeline=outbound step=dispatch capture_id=C-1"; got != want {
+		t.Errorf("item fields = %q, want %q", got, want)
+	}
+	if got, want := keys(Fields(next)), "run_id=run-1 pipeline=outbound step=dispatch capture_id=C-2"; got != want {
+		t.Errorf("replaced fields = %q, want %q", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := []zap.Field{zap.String("pipeline", "outbound"), zap.String("shipping_operation_id", "op-1")}
+	got := merge(base, []zap.Field{zap.String("shipping_operation_id", "op-2"), zap.Int("count", 3)})
+	if got, want := keys(got), "pipeline=outbound shipping_operation_id=op-2 count="; got != want {
+		t.Errorf("merge() = %q, want %q", got, want)
+	}
+	if len(base) != 2 || base[1].String != "op-1" {
+		t.Errorf("merge() modified base: %v", base)
+	}
+}
@@ -0,0 +1,241 @@
+package tasks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/metrics"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// BucketClient reads objects from an S3-compatible bucket: AWS S3, Google
+// Cloud Storage through its XML API with HMAC keys, or a local emulator such
+// as MinIO. Requests use path-style URLs and AWS Signature Version 4.
+type BucketClient struct {
+	endpoint   string
+	bucket     string
+	prefix     string
+	region     string
+	accessKey  string
+	secretKey  string
+	httpClient *http.Client
+}
+
+// BucketObject is an object listed in a bucket.
+type BucketObject struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// listBucketResult is a ListObjectsV2 response page.
+type listBucketResult struct {
+	Contents              []BucketObject `xml:"Contents"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken"`
+}
+
+// NewBucketClient creates a client for the inbound bucket in cfg.
+func NewBucketClient(cfg *configs.Config) *BucketClient {
+	return &BucketClient{
+		endpoint:  strings.TrimSuffix(cfg.InboundBucketEndpoint, "/"),
+		bucket:    cfg.InboundBucket,
+		prefix:    cfg.InboundBucketPrefix,
+		region:    cfg.InboundBucketRegion,
+		accessKey: cfg.InboundBucketAccessKey,
+		secretKey: cfg.InboundBucketSecretKey,
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: metrics.InstrumentTransport("bucket", nil, bucketRoute),
+		},
+	}
+}
+
+// bucketRoute labels bucket requests as listings or object reads.
+func bucketRoute(req *http.Request) string {
+	if req.URL.Query().Has("list-type") {
+		return "list"
+	}
+	return "object"
+}
+
+// ListObjects lists every object under the client's prefix.
+func (c *BucketClient) ListObjects(ctx context.Context) (_ []BucketObject, err error) {
+	ctx, span := tracing.StartClient(ctx, "bucket.ListObjects", attribute.String("bucket", c.bucket))
+	defer func() { tracing.End(span, err) }()
+
+	var objects []BucketObject
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if c.prefix != "" {
+			query.Set("prefix", c.prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		body, err := c.get(ctx, "", query)
+		if err != nil {
+			return nil, fmt.Errorf("listing bucket %s: %w", c.bucket, err)
+		}
+		var page listBucketResult
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("decoding bucket listing: %w", err)
+		}
+		objects = append(objects, page.Contents...)
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// GetObject downloads an object's content.
+func (c *BucketClient) GetObject(ctx context.Context, key string) (_ []byte, err error) {
+	ctx, span := tracing.StartClient(ctx, "bucket.GetObject", attribute.String("bucket", c.bucket))
+	defer func() { tracing.End(span, err) }()
+
+	content, err := c.get(ctx, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting object %s: %w", key, err)
+	}
+	return content, nil
+}
+
+// get sends a signed GET for key in the bucket (the bucket itself if key is
+// empty) and returns the response body.
+func (c *BucketClient) get(ctx context.Context, key string, query url.Values) ([]byte, error) {
+	path := "/" + uriEncode(c.bucket, true)
+	if key != "" {
+		path += "/" + uriEncode(key, true)
+	}
+	rawURL := c.endpoint + path
+	if q := canonicalQuery(query); q != "" {
+		rawURL += "?" + q
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	signV4(req, c.accessKey, c.secretKey, c.region, time.Now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("GET failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// signV4 signs a bodyless request for the s3 service with AWS Signature
+// Version 4, signing the host and every header already set.
+func signV4(req *http.Request, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as signed.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name, false)+"="+uriEncode(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but RFC 3986 unreserved characters,
+// keeping "/" in paths.
+func uriEncode(s string, path bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', path && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -9,9 +9,13 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
+	"strings"
 	"time"
 
-	"github.com/trackvision/tv-shared-go/logger"
+	"github.com/trackvision/tv-pipelines-hudsci/metrics"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -27,18 +31,33 @@ func NewDirectusClient(baseURL, apiKey string) *DirectusClient {
 	return &DirectusClient{
 		BaseURL: baseURL,
 		APIKey:  apiKey,
-		Client:  &http.Client{Timeout: 30 * time.Second},
+		Client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: metrics.InstrumentTransport("directus", nil, directusRoute),
+		},
 	}
 }
 
+// directusRoute reduces a Directus request path to a metrics label, keeping
+// the collection name but dropping item and file IDs.
+func directusRoute(req *http.Request) string {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "items" {
+		return "items/" + parts[1]
+	}
+	return parts[0]
+}
+
 // DirectusResponse wraps the Directus API response
 type DirectusResponse struct {
 	Data json.RawMessage `json:"data"`
 }
 
 // PostItem creates an item in a collection and returns the created item
-func (d *DirectusClient) PostItem(ctx context.Context, collection string, item any) (map[string]any, error) {
-	logger.Info("Creating Directus item", zap.String("collection", collection))
+func (d *DirectusClient) PostItem(ctx context.Context, collection string, item any) (_ map[string]any, err error) {
+	ctx, span := tracing.StartClient(ctx, "directus.PostItem", tracing.Collection.String(collection))
+	defer func() { tracing.End(span, err) }()
+	runlog.Info(ctx, "Creating Directus item", zap.String("collection", collection))
 
 	body, err := json.Marshal(item)
 	if err != nil {
@@ -60,7 +79,7 @@ func (d *DirectusClient) PostItem(ctx context.Context, collection string, item a
 	}
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
-			logger.Warn("Failed to close response body", zap.Error(cerr))
+			runlog.Warn(ctx, "Failed to close response body", zap.Error(cerr))
 		}
 	}()
 
@@ -79,13 +98,16 @@ func (d *DirectusClient) PostItem(ctx context.Context, collection string, item a
 		return nil, fmt.Errorf("unmarshaling data: %w", err)
 	}
 
-	logger.Info("Item created", zap.Any("id", result["id"]))
+	runlog.Info(ctx, "Item created", zap.Any("id", result["id"]))
 	return result, nil
 }
 
-// QueryItems queries items from a collection with filters
-func (d *DirectusClient) QueryItems(ctx context.Context, collection string, filter map[string]interface{}, fields []string, limit int) ([]map[string]interface{}, error) {
-	logger.Info("Querying Directus items", zap.String("collection", collection), zap.Int("limit", limit))
+// QueryItems queries items from a collection with filters. A negative limit
+// returns every matching item.
+func (d *DirectusClient) QueryItems(ctx context.Context, collection string, filter map[string]interface{}, fields []string, limit int) (_ []map[string]interface{}, err error) {
+	ctx, span := tracing.StartClient(ctx, "directus.QueryItems", tracing.Collection.String(collection))
+	defer func() { tracing.End(span, err) }()
+	runlog.Info(ctx, "Querying Directus items", zap.String("collection", collection), zap.Int("limit", limit))
 
 	url := fmt.Sprintf("%s/items/%s", d.BaseURL, collection)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -107,7 +129,7 @@ func (d *DirectusClient) QueryItems(ctx context.Context, collection string, filt
 			q.Add("fields[]", field)
 		}
 	}
-	if limit > 0 {
+	if limit != 0 {
 		q.Add("limit", fmt.Sprintf("%d", limit))
 	}
 	req.URL.RawQuery = q.Encode()
@@ -120,7 +142,7 @@ func (d *DirectusClient) QueryItems(ctx context.Context, collection string, filt
 	}
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
-			logger.Warn("Failed to close response body", zap.Error(cerr))
+			runlog.Warn(ctx, "Failed to close response body", zap.Error(cerr))
 		}
 	}()
 
@@ -139,13 +161,13 @@ func (d *DirectusClient) QueryItems(ctx context.Context, collection string, filt
 		return nil, fmt.Errorf("unmarshaling data: %w", err)
 	}
 
-	logger.Info("Query completed", zap.Int("count", len(results)))
+	runlog.Info(ctx, "Query completed", zap.Int("count", len(results)))
 	return results, nil
 }
 
 // GetFileContent downloads the content of a file by its ID
 func (d *DirectusClient) GetFileContent(ctx context.Context, fileID string) ([]byte, error) {
-	logger.Info("Getting file content", zap.String("file_id", fileID))
+	runlog.Info(ctx, "Getting file content", zap.String("file_id", fileID))
 
 	url := fmt.Sprintf("%s/assets/%s", d.BaseURL, fileID)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -161,7 +183,7 @@ func (d *DirectusClient) GetFileContent(ctx context.Context, fileID string) ([]b
 	}
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
-			logger.Warn("Failed to close response body", zap.Error(cerr))
+			runlog.Warn(ctx, "Failed to close response body", zap.Error(cerr))
 		}
 	}()
 
@@ -175,13 +197,13 @@ func (d *DirectusClient) GetFileContent(ctx context.Context, fileID string) ([]b
 		return nil, fmt.Errorf("reading content: %w", err)
 	}
 
-	logger.Info("File content retrieved", zap.Int("size", len(content)))
+	runlog.Info(ctx, "File content retrieved", zap.Int("size", len(content)))
 	return content, nil
 }
 
 // PatchItem updates an item in a collection
 func (d *DirectusClient) PatchItem(ctx context.Context, collection, id string, updates map[string]any) error {
-	logger.Info("Updating Directus item", zap.String("collection", collection), zap.String("id", id))
+	runlog.Info(ctx, "Updating Directus item", zap.String("collection", collection), zap.String("id", id))
 
 	body, err := json.Marshal(updates)
 	if err != nil {
@@ -203,7 +225,7 @@ func (d *DirectusClient) PatchItem(ctx context.Context, collection, id string, u
 	}
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
-			logger.Warn("Failed to close response body", zap.Error(cerr))
+			runlog.Warn(ctx, "Failed to close response body", zap.Error(cerr))
 		}
 	}()
 
@@ -212,7 +234,7 @@ func (d *DirectusClient) PatchItem(ctx context.Context, collection, id string, u
 		return fmt.Errorf("PATCH failed with status %d: %s", resp.StatusCode, string(respBody))
 	}
 
-	logger.Info("Item updated")
+	runlog.Info(ctx, "Item updated")
 	return nil
 }
 
@@ -231,8 +253,10 @@ type UploadFileResult struct {
 }
 
 // UploadFile uploads a file to Directus
-func (d *DirectusClient) UploadFile(ctx context.Context, params UploadFileParams) (*UploadFileResult, error) {
-	logger.Info("Uploading file to Directus",
+func (d *DirectusClient) UploadFile(ctx context.Context, params UploadFileParams) (_ *UploadFileResult, err error) {
+	ctx, span := tracing.StartClient(ctx, "directus.UploadFile", attribute.String("file.name", params.Filename))
+	defer func() { tracing.End(span, err) }()
+	runlog.Info(ctx, "Uploading file to Directus",
 		zap.String("filename", params.Filename),
 		zap.Int("size", len(params.Content)),
 	)
@@ -289,7 +313,7 @@ func (d *DirectusClient) UploadFile(ctx context.Context, params UploadFileParams
 	}
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
-			logger.Warn("Failed to close response body", zap.Error(cerr))
+			runlog.Warn(ctx, "Failed to close response body", zap.Error(cerr))
 		}
 	}()
 
@@ -308,6 +332,6 @@ func (d *DirectusClient) UploadFile(ctx context.Context, params UploadFileParams
 		return nil, fmt.Errorf("unmarshaling file data: %w", err)
 	}
 
-	logger.Info("File uploaded", zap.String("fileID", result.ID))
+	runlog.Info(ctx, "File uploaded", zap.String("fileID", result.ID))
 	return &result, nil
 }
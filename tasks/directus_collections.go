@@ -8,7 +8,7 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/trackvision/tv-shared-go/logger"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
 	"go.uber.org/zap"
 )
 
@@ -66,69 +66,25 @@ type GlobalConfigValue struct {
 
 // GetWatermark gets the watermark from Directus global_config collection
 func GetWatermark(ctx context.Context, cms *DirectusClient, key string) (*Watermark, error) {
-	logger.Info("Getting watermark", zap.String("key", key))
+	runlog.Info(ctx, "Getting watermark", zap.String("key", key))
 
-	url := fmt.Sprintf("%s/items/global_config", cms.BaseURL)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
-	}
-
-	// Query for the specific key
-	q := req.URL.Query()
-	q.Add("filter[key][_eq]", key)
-	q.Add("limit", "1")
-	req.URL.RawQuery = q.Encode()
-
-	req.Header.Set("Authorization", "Bearer "+cms.APIKey)
-
-	resp, err := cms.Client.Do(req)
+	config, err := getGlobalConfig(ctx, cms, key)
 	if err != nil {
-		return nil, fmt.Errorf("GET request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("GET failed with status %d: %s", resp.StatusCode, string(respBody))
-	}
-
-	var directusResp DirectusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&directusResp); err != nil {
-		return nil, fmt.Errorf("decoding response: %w", err)
-	}
-
-	var configs []GlobalConfigValue
-	if err := json.Unmarshal(directusResp.Data, &configs); err != nil {
-		return nil, fmt.Errorf("unmarshaling configs: %w", err)
+		return nil, err
 	}
 
 	// Return empty watermark if not found
-	if len(configs) == 0 {
-		logger.Info("No watermark found, returning zero value")
+	if config == nil {
+		runlog.Info(ctx, "No watermark found, returning zero value")
 		return &Watermark{}, nil
 	}
 
-	// Parse the value JSON
-	// Directus may return the value as either a JSON object or a JSON string containing the object
 	var watermark Watermark
-	valueBytes := configs[0].Value
-
-	// First try to unmarshal as a string (Directus sometimes double-encodes)
-	var valueStr string
-	if err := json.Unmarshal(valueBytes, &valueStr); err == nil {
-		// It was a string, now parse the inner JSON
-		if err := json.Unmarshal([]byte(valueStr), &watermark); err != nil {
-			return nil, fmt.Errorf("unmarshaling watermark from string value: %w", err)
-		}
-	} else {
-		// Not a string, try direct unmarshal
-		if err := json.Unmarshal(valueBytes, &watermark); err != nil {
-			return nil, fmt.Errorf("unmarshaling watermark value: %w", err)
-		}
+	if err := decodeConfigValue(config.Value, &watermark); err != nil {
+		return nil, fmt.Errorf("unmarshaling watermark value: %w", err)
 	}
 
-	logger.Info("Retrieved watermark",
+	runlog.Info(ctx, "Retrieved watermark",
 		zap.Time("timestamp", watermark.LastCheckTimestamp.Time),
 		zap.Int("total_processed", watermark.TotalProcessed),
 	)
@@ -138,7 +94,7 @@ func GetWatermark(ctx context.Context, cms *DirectusClient, key string) (*Waterm
 
 // UpdateWatermark updates the watermark in Directus global_config collection
 func UpdateWatermark(ctx context.Context, cms *DirectusClient, key string, timestamp time.Time, processedCount int) error {
-	logger.Info("Updating watermark",
+	runlog.Info(ctx, "Updating watermark",
 		zap.String("key", key),
 		zap.Time("timestamp", timestamp),
 		zap.Int("processed_count", processedCount),
@@ -147,7 +103,7 @@ func UpdateWatermark(ctx context.Context, cms *DirectusClient, key string, times
 	// Get current watermark to preserve total count
 	current, err := GetWatermark(ctx, cms, key)
 	if err != nil {
-		logger.Warn("Failed to get current watermark, will create new", zap.Error(err))
+		runlog.Warn(ctx, "Failed to get current watermark, will create new", zap.Error(err))
 		current = &Watermark{}
 	}
 
@@ -157,19 +113,27 @@ func UpdateWatermark(ctx context.Context, cms *DirectusClient, key string, times
 		TotalProcessed:     current.TotalProcessed + processedCount,
 	}
 
-	// Serialize watermark to JSON
-	valueJSON, err := json.Marshal(newWatermark)
-	if err != nil {
-		return fmt.Errorf("marshaling watermark: %w", err)
+	if err := saveGlobalConfig(ctx, cms, key, newWatermark); err != nil {
+		return err
 	}
 
-	// Check if config exists
+	runlog.Info(ctx, "Watermark updated",
+		zap.Int("total_processed", newWatermark.TotalProcessed),
+	)
+
+	return nil
+}
+
+// getGlobalConfig returns the global_config entry for key, or nil if there
+// is none.
+func getGlobalConfig(ctx context.Context, cms *DirectusClient, key string) (*GlobalConfigValue, error) {
 	url := fmt.Sprintf("%s/items/global_config", cms.BaseURL)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("creating request: %w", err)
+		return nil, fmt.Errorf("creating request: %w", err)
 	}
 
+	// Query for the specific key
 	q := req.URL.Query()
 	q.Add("filter[key][_eq]", key)
 	q.Add("limit", "1")
@@ -179,48 +143,74 @@ func UpdateWatermark(ctx context.Context, cms *DirectusClient, key string, times
 
 	resp, err := cms.Client.Do(req)
 	if err != nil {
-		return fmt.Errorf("GET request failed: %w", err)
+		return nil, fmt.Errorf("GET request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GET failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
 	var directusResp DirectusResponse
 	if err := json.NewDecoder(resp.Body).Decode(&directusResp); err != nil {
-		return fmt.Errorf("decoding response: %w", err)
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	var configs []GlobalConfigValue
+	if err := json.Unmarshal(directusResp.Data, &configs); err != nil {
+		return nil, fmt.Errorf("unmarshaling configs: %w", err)
+	}
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	return &configs[0], nil
+}
+
+// decodeConfigValue decodes a global_config value into v. Directus may
+// return the value as either a JSON object or a JSON string containing the
+// object.
+func decodeConfigValue(value json.RawMessage, v any) error {
+	// First try to unmarshal as a string (Directus sometimes double-encodes)
+	var valueStr string
+	if err := json.Unmarshal(value, &valueStr); err == nil {
+		// It was a string, now parse the inner JSON
+		return json.Unmarshal([]byte(valueStr), v)
 	}
+	return json.Unmarshal(value, v)
+}
 
-	var existingConfigs []GlobalConfigValue
-	if err := json.Unmarshal(directusResp.Data, &existingConfigs); err != nil {
-		return fmt.Errorf("unmarshaling configs: %w", err)
+// saveGlobalConfig creates or replaces the global_config entry for key with
+// v encoded as JSON.
+func saveGlobalConfig(ctx context.Context, cms *DirectusClient, key string, v any) error {
+	valueJSON, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshaling %s: %w", key, err)
 	}
 
-	// Create or update
-	configValue := GlobalConfigValue{
-		Key:   key,
-		Value: valueJSON,
+	// Check if config exists
+	existing, err := getGlobalConfig(ctx, cms, key)
+	if err != nil {
+		return err
 	}
 
-	if len(existingConfigs) == 0 {
+	if existing == nil {
 		// Create new config
-		logger.Info("Creating new watermark config")
-		_, err := cms.PostItem(ctx, "global_config", configValue)
-		if err != nil {
+		runlog.Info(ctx, "Creating new global config", zap.String("key", key))
+		if _, err := cms.PostItem(ctx, "global_config", GlobalConfigValue{Key: key, Value: valueJSON}); err != nil {
 			return fmt.Errorf("creating config: %w", err)
 		}
-	} else {
-		// Update existing config - global_config uses 'key' as primary key, not 'id'
-		logger.Info("Updating existing watermark config", zap.String("key", existingConfigs[0].Key))
-		updates := map[string]any{
-			"value": string(valueJSON), // Pass as string to avoid base64 encoding
-		}
-		if err := cms.PatchItem(ctx, "global_config", existingConfigs[0].Key, updates); err != nil {
-			return fmt.Errorf("updating config: %w", err)
-		}
+		return nil
 	}
 
-	logger.Info("Watermark updated",
-		zap.Int("total_processed", newWatermark.TotalProcessed),
-	)
-
+	// Update existing config - global_config uses 'key' as primary key, not 'id'
+	runlog.Info(ctx, "Updating existing global config", zap.String("key", existing.Key))
+	updates := map[string]any{
+		"value": string(valueJSON), // Pass as string to avoid base64 encoding
+	}
+	if err := cms.PatchItem(ctx, "global_config", existing.Key, updates); err != nil {
+		return fmt.Errorf("updating config: %w", err)
+	}
 	return nil
 }
 
@@ -236,39 +226,51 @@ type EPCISInboxItem struct {
 	RawMessage      string                 `json:"raw_message,omitempty"`
 	EPCISXMLFileID  string                 `json:"epcis_xml_file_id,omitempty"`
 	EPCISJSONFileID string                 `json:"epcis_json_file_id,omitempty"`
+	LogGuid         string                 `json:"log_guid,omitempty"`     // TrustMed document the item came from
+	ContentHash     string                 `json:"content_hash,omitempty"` // hex SHA-256 of the source XML
 	Products        []map[string]interface{} `json:"products,omitempty"`
 	Containers      []map[string]interface{} `json:"containers,omitempty"`
 }
 
 // InsertEPCISInbox inserts shipment records into the epcis_inbox collection.
-// It skips records that already exist (based on file_id in capture_message).
+// It skips records whose document is already in the inbox, by file_id in
+// capture_message, TrustMed log GUID or content hash (see InboundLedger).
 func InsertEPCISInbox(ctx context.Context, cms *DirectusClient, shipments []EPCISInboxItem) error {
 	if len(shipments) == 0 {
-		logger.Info("No shipments to insert")
+		runlog.Info(ctx, "No shipments to insert")
 		return nil
 	}
 
-	logger.Info("Inserting shipments to epcis_inbox", zap.Int("count", len(shipments)))
+	runlog.Info(ctx, "Inserting shipments to epcis_inbox", zap.Int("count", len(shipments)))
 
-	// Get existing file IDs to prevent duplicates
-	existingFileIDs, err := getExistingFileIDs(ctx, cms)
+	// Documents already in the inbox, to prevent duplicates. Several items
+	// may come from one document, so the ledger is not updated as they are
+	// inserted.
+	var keys LedgerKeys
+	for _, shipment := range shipments {
+		fileID, _ := shipment.CaptureMessage["file_id"].(string)
+		keys.FileIDs = append(keys.FileIDs, fileID)
+		keys.LogGuids = append(keys.LogGuids, shipment.LogGuid)
+		keys.Hashes = append(keys.Hashes, shipment.ContentHash)
+	}
+	ledger, err := LoadInboundLedger(ctx, cms, keys)
 	if err != nil {
-		logger.Warn("Failed to get existing file IDs, proceeding anyway", zap.Error(err))
-		existingFileIDs = make(map[string]bool)
+		runlog.Warn(ctx, "Failed to load inbound ledger, proceeding anyway", zap.Error(err))
+		ledger = NewInboundLedger()
 	}
 
-	logger.Info("Found existing records", zap.Int("count", len(existingFileIDs)))
-
 	// Filter out duplicates
 	itemsToInsert := make([]EPCISInboxItem, 0, len(shipments))
 	skippedCount := 0
 
 	for i, shipment := range shipments {
-		fileID, ok := shipment.CaptureMessage["file_id"].(string)
-		if ok && existingFileIDs[fileID] {
-			logger.Info("Skipping duplicate",
+		if ledger.Has(shipment) {
+			fileID, _ := shipment.CaptureMessage["file_id"].(string)
+			runlog.Info(ctx, "Skipping duplicate",
 				zap.Int("index", i+1),
 				zap.String("file_id", fileID),
+				zap.String("log_guid", shipment.LogGuid),
+				zap.String("content_hash", shipment.ContentHash),
 			)
 			skippedCount++
 			continue
@@ -278,16 +280,16 @@ func InsertEPCISInbox(ctx context.Context, cms *DirectusClient, shipments []EPCI
 	}
 
 	if skippedCount > 0 {
-		logger.Info("Skipped duplicate records", zap.Int("count", skippedCount))
+		runlog.Info(ctx, "Skipped duplicate records", zap.Int("count", skippedCount))
 	}
 
 	if len(itemsToInsert) == 0 {
-		logger.Info("No new records to insert (all were duplicates)")
+		runlog.Info(ctx, "No new records to insert (all were duplicates)")
 		return nil
 	}
 
 	// Insert in batch
-	logger.Info("Inserting new records", zap.Int("count", len(itemsToInsert)))
+	runlog.Info(ctx, "Inserting new records", zap.Int("count", len(itemsToInsert)))
 
 	for i, item := range itemsToInsert {
 		_, err := cms.PostItem(ctx, "epcis_inbox", item)
@@ -295,7 +297,7 @@ func InsertEPCISInbox(ctx context.Context, cms *DirectusClient, shipments []EPCI
 			return fmt.Errorf("inserting item %d: %w", i, err)
 		}
 
-		logger.Info("Inserted record",
+		runlog.Info(ctx, "Inserted record",
 			zap.Int("index", i+1),
 			zap.Int("total", len(itemsToInsert)),
 			zap.String("seller", item.Seller),
@@ -303,70 +305,19 @@ func InsertEPCISInbox(ctx context.Context, cms *DirectusClient, shipments []EPCI
 		)
 	}
 
-	logger.Info("Successfully inserted all records")
+	runlog.Info(ctx, "Successfully inserted all records")
 	return nil
 }
 
-// getExistingFileIDs fetches all file_ids from epcis_inbox to prevent duplicates
-func getExistingFileIDs(ctx context.Context, cms *DirectusClient) (map[string]bool, error) {
-	url := fmt.Sprintf("%s/items/epcis_inbox", cms.BaseURL)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
-	}
-
-	q := req.URL.Query()
-	q.Add("fields", "capture_message")
-	q.Add("limit", "-1") // Get all records
-	req.URL.RawQuery = q.Encode()
-
-	req.Header.Set("Authorization", "Bearer "+cms.APIKey)
-
-	resp, err := cms.Client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("GET request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("GET failed with status %d: %s", resp.StatusCode, string(respBody))
-	}
-
-	var directusResp DirectusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&directusResp); err != nil {
-		return nil, fmt.Errorf("decoding response: %w", err)
-	}
-
-	var records []struct {
-		CaptureMessage map[string]interface{} `json:"capture_message"`
-	}
-	if err := json.Unmarshal(directusResp.Data, &records); err != nil {
-		return nil, fmt.Errorf("unmarshaling records: %w", err)
-	}
-
-	// Extract file IDs
-	fileIDs := make(map[string]bool)
-	for _, record := range records {
-		if record.CaptureMessage != nil {
-			if fileID, ok := record.CaptureMessage["file_id"].(string); ok && fileID != "" {
-				fileIDs[fileID] = true
-			}
-		}
-	}
-
-	return fileIDs, nil
-}
-
 // LinkJSONFilesToInbox updates epcis_inbox records with their corresponding JSON file IDs.
 // The fileIDMap maps XML file IDs to JSON file IDs.
 func LinkJSONFilesToInbox(ctx context.Context, cms *DirectusClient, fileIDMap map[string]string) error {
 	if len(fileIDMap) == 0 {
-		logger.Info("No JSON files to link to inbox records")
+		runlog.Info(ctx, "No JSON files to link to inbox records")
 		return nil
 	}
 
-	logger.Info("Linking JSON files to epcis_inbox records", zap.Int("count", len(fileIDMap)))
+	runlog.Info(ctx, "Linking JSON files to epcis_inbox records", zap.Int("count", len(fileIDMap)))
 
 	// Get all inbox records that match the XML file IDs
 	url := fmt.Sprintf("%s/items/epcis_inbox", cms.BaseURL)
@@ -419,7 +370,7 @@ func LinkJSONFilesToInbox(ctx context.Context, cms *DirectusClient, fileIDMap ma
 			"epcis_json_file_id": jsonFileID,
 		}
 		if err := cms.PatchItem(ctx, "epcis_inbox", record.ID, updates); err != nil {
-			logger.Warn("Failed to update epcis_inbox with JSON file ID",
+			runlog.Warn(ctx, "Failed to update epcis_inbox with JSON file ID",
 				zap.String("record_id", record.ID),
 				zap.Error(err),
 			)
@@ -427,13 +378,13 @@ func LinkJSONFilesToInbox(ctx context.Context, cms *DirectusClient, fileIDMap ma
 		}
 
 		updatedCount++
-		logger.Info("Linked JSON file to inbox record",
+		runlog.Info(ctx, "Linked JSON file to inbox record",
 			zap.String("record_id", record.ID),
 			zap.String("json_file_id", jsonFileID),
 		)
 	}
 
-	logger.Info("Finished linking JSON files to inbox records",
+	runlog.Info(ctx, "Finished linking JSON files to inbox records",
 		zap.Int("updated_count", updatedCount),
 		zap.Int("total_mappings", len(fileIDMap)),
 	)
@@ -183,3 +183,41 @@ func TestInsertEPCISInbox_SkipsDuplicates(t *testing.T) {
 	err := InsertEPCISInbox(context.Background(), cms, shipments)
 	require.NoError(t, err) // Should succeed but skip the duplicate
 }
+
+func TestInsertEPCISInbox_SkipsProcessedDocuments(t *testing.T) {
+	var inserted []EPCISInboxItem
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/items/epcis_inbox" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			return
+		}
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal([]map[string]interface{}{
+				{"capture_message": map[string]interface{}{"file_id": "xml-old"}, "log_guid": "guid-1", "content_hash": "hash-1"},
+			})})
+			return
+		}
+		var item EPCISInboxItem
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&item))
+		inserted = append(inserted, item)
+		json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal(map[string]interface{}{"id": "123"})})
+	}))
+	defer server.Close()
+
+	cms := NewDirectusClient(server.URL, "test-token")
+
+	shipments := []EPCISInboxItem{
+		{Status: "pending", CaptureMessage: map[string]interface{}{"file_id": "xml-2"}, LogGuid: "guid-1", ContentHash: "hash-2"}, // re-downloaded
+		{Status: "pending", CaptureMessage: map[string]interface{}{"file_id": "xml-3"}, LogGuid: "guid-3", ContentHash: "hash-1"}, // same content
+		{Status: "pending", CaptureMessage: map[string]interface{}{"file_id": "xml-4"}, LogGuid: "guid-4", ContentHash: "hash-4"},
+		{Status: "pending", CaptureMessage: map[string]interface{}{"file_id": "xml-4"}, LogGuid: "guid-4", ContentHash: "hash-4"}, // second event of the same file
+	}
+
+	err := InsertEPCISInbox(context.Background(), cms, shipments)
+	require.NoError(t, err)
+	require.Len(t, inserted, 2)
+	for _, item := range inserted {
+		assert.Equal(t, "guid-4", item.LogGuid)
+		assert.Equal(t, "hash-4", item.ContentHash)
+	}
+}
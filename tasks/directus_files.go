@@ -3,14 +3,18 @@ package tasks
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/metrics"
+	"github.com/trackvision/tv-pipelines-hudsci/progress"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
 	"github.com/trackvision/tv-pipelines-hudsci/types"
-	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 )
 
@@ -25,13 +29,22 @@ type DirectusFile struct {
 	ModifiedOn       time.Time `json:"modified_on"`
 }
 
-// PollXMLFiles polls Directus for new XML files since the watermark timestamp.
-// It fetches up to 50 files per run, downloads their content, and returns them.
-func PollXMLFiles(ctx context.Context, cms *DirectusClient, cfg *configs.Config) ([]types.XMLFile, error) {
-	logger.Info("Polling Directus for new XML files")
+// PollXMLFiles polls a Directus folder for XML files uploaded since the
+// watermark timestamp, re-checking cfg.InboundWatermarkOverlap seconds before
+// it. It pages through the files in upload order, downloads their content,
+// and advances the watermark to the last file fetched, or to the first that
+// failed so it is fetched again. Failed files are counted in the
+// inbound_directus_retry_queue entry; after cfg.InboundRetryMaxAttempts they
+// stay there and no longer hold the watermark back. Files already in
+// epcis_inbox are skipped. If the listing stops at its page limit, the files
+// found are returned with an error wrapping ErrSearchTruncated, and the
+// watermark advances to the last file listed.
+func PollXMLFiles(ctx context.Context, cms *DirectusClient, cfg *configs.Config, folderID string) ([]types.XMLFile, error) {
+	runlog.Info(ctx, "Polling Directus for new XML files")
 
 	// Get watermark
-	watermark, err := GetWatermark(ctx, cms, "inbound_shipment_received_watermark")
+	watermarkKey := "inbound_shipment_received_watermark"
+	watermark, err := GetWatermark(ctx, cms, watermarkKey)
 	if err != nil {
 		return nil, fmt.Errorf("getting watermark: %w", err)
 	}
@@ -41,113 +54,244 @@ func PollXMLFiles(ctx context.Context, cms *DirectusClient, cfg *configs.Config)
 	if watermark.LastCheckTimestamp.Time.IsZero() {
 		// No watermark - first run, go back 7 days
 		sinceDate = time.Now().Add(-7 * 24 * time.Hour)
-		logger.Info("No watermark found, using default lookback", zap.Time("since", sinceDate))
+		runlog.Info(ctx, "No watermark found, using default lookback", zap.Time("since", sinceDate))
 
 		// Create initial watermark
-		if err := UpdateWatermark(ctx, cms, "inbound_shipment_received_watermark", sinceDate, 0); err != nil {
-			logger.Warn("Failed to create initial watermark", zap.Error(err))
+		if err := UpdateWatermark(ctx, cms, watermarkKey, sinceDate, 0); err != nil {
+			runlog.Warn(ctx, "Failed to create initial watermark", zap.Error(err))
 		}
 	} else {
-		sinceDate = watermark.LastCheckTimestamp.Time
-		logger.Info("Using watermark", zap.Time("since", sinceDate))
+		sinceDate = watermark.LastCheckTimestamp.Time.Add(-time.Duration(cfg.InboundWatermarkOverlap) * time.Second)
+		runlog.Info(ctx, "Using watermark", zap.Time("since", sinceDate))
 	}
 
-	// Query Directus for files
-	url := fmt.Sprintf("%s/files", cms.BaseURL)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
+	xmlFiles, next, err := fetchXMLFiles(ctx, cms, cfg, folderID, sinceDate, time.Time{})
+	if err != nil && !errors.Is(err, ErrSearchTruncated) {
+		return nil, err
 	}
-
-	// Add query parameters
-	q := req.URL.Query()
-	q.Add("filter[uploaded_on][_gte]", sinceDate.Format(time.RFC3339))
-	if cfg.FolderInputXML != "" {
-		q.Add("filter[folder][_eq]", cfg.FolderInputXML)
+	if !next.IsZero() {
+		if err := UpdateWatermark(ctx, cms, watermarkKey, next, len(xmlFiles)); err != nil {
+			runlog.Warn(ctx, "Failed to update watermark", zap.Error(err))
+		}
 	}
-	q.Add("filter[filename_download][_ends_with]", ".xml") // Match XML files by extension
-	q.Add("sort", "uploaded_on")
-	q.Add("limit", "50")
-	req.URL.RawQuery = q.Encode()
-
-	req.Header.Set("Authorization", "Bearer "+cms.APIKey)
+	return xmlFiles, err
+}
 
-	resp, err := cms.Client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("GET request failed: %w", err)
+// PollXMLFilesWindow fetches the XML files uploaded to a Directus folder in
+// [since, until), leaving the watermark unchanged. Like PollXMLFiles, it
+// returns the files found with an error wrapping ErrSearchTruncated if the
+// listing stops at its page limit.
+func PollXMLFilesWindow(ctx context.Context, cms *DirectusClient, cfg *configs.Config, folderID string, since, until time.Time) ([]types.XMLFile, error) {
+	if !since.Before(until) {
+		return nil, fmt.Errorf("since %s must be before until %s", since.Format(time.RFC3339), until.Format(time.RFC3339))
 	}
-	defer resp.Body.Close()
+	runlog.Info(ctx, "Polling Directus for XML files in window (watermark unchanged)",
+		zap.Time("since", since),
+		zap.Time("until", until),
+	)
+	xmlFiles, _, err := fetchXMLFiles(ctx, cms, cfg, folderID, since, until)
+	return xmlFiles, err
+}
 
-	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("GET failed with status %d: %s", resp.StatusCode, string(respBody))
+// directusRetryQueueKey is the global_config entry holding the Directus
+// folder's failed files.
+const directusRetryQueueKey = "inbound_directus_retry_queue"
+
+// directusFilesPageSize is how many files each /files request lists.
+const directusFilesPageSize = 50
+
+// fetchXMLFiles fetches the XML files uploaded to the folder from since (and
+// before until, unless zero). It returns the upload time the watermark can
+// advance to, which is zero if no files were found. If the listing stops at
+// its page limit, it returns the files listed so far with an error wrapping
+// ErrSearchTruncated; they are the oldest, so the watermark stays valid.
+func fetchXMLFiles(ctx context.Context, cms *DirectusClient, cfg *configs.Config, folderID string, since, until time.Time) ([]types.XMLFile, time.Time, error) {
+	files, listErr := listXMLFiles(ctx, cms, folderID, since, until)
+	if listErr != nil && !errors.Is(listErr, ErrSearchTruncated) {
+		return nil, time.Time{}, listErr
 	}
 
-	var directusResp DirectusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&directusResp); err != nil {
-		return nil, fmt.Errorf("decoding response: %w", err)
-	}
+	runlog.Info(ctx, "Found XML files", zap.Int("count", len(files)))
 
-	var files []DirectusFile
-	if err := json.Unmarshal(directusResp.Data, &files); err != nil {
-		return nil, fmt.Errorf("unmarshaling files: %w", err)
+	if len(files) == 0 {
+		return []types.XMLFile{}, time.Time{}, nil
 	}
 
-	logger.Info("Found XML files", zap.Int("count", len(files)))
+	// Documents already processed
+	fileIDs := make([]string, len(files))
+	for i, file := range files {
+		fileIDs[i] = file.ID
+	}
+	ledger, err := LoadInboundLedger(ctx, cms, LedgerKeys{FileIDs: fileIDs})
+	if err != nil {
+		runlog.Warn(ctx, "Failed to load inbound ledger, fetching all files", zap.Error(err))
+		ledger = NewInboundLedger()
+	}
 
-	if len(files) == 0 {
-		return []types.XMLFile{}, nil
+	queue, err := loadRetryQueue(ctx, cms, directusRetryQueueKey)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
 
 	// Fetch content for each file
 	xmlFiles := make([]types.XMLFile, 0, len(files))
+	var firstFailed, firstRetry time.Time
 	failedCount := 0
 
 	for i, file := range files {
-		logger.Info("Fetching file content",
+		runlog.Info(ctx, "Fetching file content",
 			zap.Int("index", i+1),
 			zap.Int("total", len(files)),
 			zap.String("fileID", file.ID),
 			zap.String("filename", file.Filename),
 		)
+		progress.Report(ctx, i+1, len(files), "fetching")
+
+		if ledger.HasFileID(file.ID) {
+			runlog.Info(ctx, "Skipping file already processed", zap.String("fileID", file.ID))
+			queue.RemoveKey(file.ID)
+			continue
+		}
 
 		content, err := DownloadFileContent(ctx, cms, file.ID)
 		if err != nil {
-			logger.Error("Failed to download file content",
+			attempts := queue.FailedKey(file.ID, file.UploadedOn, err)
+			runlog.Error(ctx, "Failed to download file content",
 				zap.String("fileID", file.ID),
+				zap.Int("attempts", attempts),
 				zap.Error(err),
 			)
+			if failedCount == 0 {
+				firstFailed = file.UploadedOn
+			}
 			failedCount++
+			if attempts < cfg.InboundRetryMaxAttempts {
+				if firstRetry.IsZero() {
+					firstRetry = file.UploadedOn
+				}
+			} else {
+				runlog.Error(ctx, "Directus file reached the retry limit and will not be retried automatically",
+					zap.String("fileID", file.ID),
+					zap.Int("attempts", attempts),
+				)
+			}
 			continue
 		}
+		queue.RemoveKey(file.ID)
 
 		xmlFiles = append(xmlFiles, types.XMLFile{
-			ID:       file.ID,
-			Filename: file.Filename,
-			Content:  content,
-			Uploaded: file.UploadedOn,
+			ID:          file.ID,
+			Filename:    file.Filename,
+			Content:     content,
+			Uploaded:    file.UploadedOn,
+			ContentHash: ContentHash(content),
 		})
-		logger.Info("Successfully loaded file",
+		runlog.Info(ctx, "Successfully loaded file",
 			zap.String("filename", file.Filename),
 			zap.Int("size", len(content)),
 		)
 	}
 
+	queueErr := queue.Save(ctx, cms)
+	if queueErr != nil {
+		runlog.Warn(ctx, "Failed to save Directus retry queue", zap.Error(queueErr))
+	}
+
 	// Check failure threshold
-	if len(files) > 0 {
-		failureRate := float64(failedCount) / float64(len(files))
-		if failureRate > cfg.FailureThreshold {
-			return nil, fmt.Errorf("failure rate %.0f%% exceeds threshold %.0f%%",
-				failureRate*100, cfg.FailureThreshold*100)
-		}
+	failureRate := float64(failedCount) / float64(len(files))
+	if failureRate > cfg.FailureThreshold {
+		return nil, time.Time{}, fmt.Errorf("failure rate %.0f%% exceeds threshold %.0f%%",
+			failureRate*100, cfg.FailureThreshold*100)
 	}
 
-	logger.Info("Successfully loaded XML files",
+	runlog.Info(ctx, "Successfully loaded XML files",
 		zap.Int("successful", len(xmlFiles)),
 		zap.Int("failed", failedCount),
 	)
 
-	return xmlFiles, nil
+	// Hold the watermark at the first file still to be retried. Without a
+	// saved attempt count every failed file is retried.
+	next := files[len(files)-1].UploadedOn
+	switch {
+	case queueErr != nil && failedCount > 0:
+		next = firstFailed
+	case !firstRetry.IsZero():
+		next = firstRetry
+	}
+	return xmlFiles, next, listErr
+}
+
+// listXMLFiles lists the XML files uploaded to the folder in [since, until),
+// oldest first, a page at a time. If there are more than searchPageLimit
+// pages it returns the files listed with an error wrapping
+// ErrSearchTruncated.
+func listXMLFiles(ctx context.Context, cms *DirectusClient, folderID string, since, until time.Time) ([]DirectusFile, error) {
+	var files []DirectusFile
+	for page := 1; ; page++ {
+		// Query Directus for files
+		url := fmt.Sprintf("%s/files", cms.BaseURL)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("creating request: %w", err)
+		}
+
+		// Add query parameters
+		q := req.URL.Query()
+		q.Add("filter[uploaded_on][_gte]", since.Format(time.RFC3339))
+		if !until.IsZero() {
+			q.Add("filter[uploaded_on][_lt]", until.Format(time.RFC3339))
+		}
+		if folderID != "" {
+			q.Add("filter[folder][_eq]", folderID)
+		}
+		q.Add("filter[filename_download][_ends_with]", ".xml") // Match XML files by extension
+		q.Add("sort", "uploaded_on,id")
+		q.Add("limit", strconv.Itoa(directusFilesPageSize))
+		q.Add("page", strconv.Itoa(page))
+		req.URL.RawQuery = q.Encode()
+
+		req.Header.Set("Authorization", "Bearer "+cms.APIKey)
+
+		pageFiles, err := doListFiles(cms, req)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, pageFiles...)
+
+		if len(pageFiles) < directusFilesPageSize {
+			break
+		}
+		if page >= searchPageLimit {
+			runlog.Warn(ctx, "Reached pagination limit", zap.Int("pages", page))
+			return files, fmt.Errorf("%w after %d pages (%d files)", ErrSearchTruncated, page, len(files))
+		}
+	}
+	return files, nil
+}
+
+// doListFiles sends a /files request and decodes the files listed.
+func doListFiles(cms *DirectusClient, req *http.Request) ([]DirectusFile, error) {
+	resp, err := cms.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GET failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var directusResp DirectusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&directusResp); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	var files []DirectusFile
+	if err := json.Unmarshal(directusResp.Data, &files); err != nil {
+		return nil, fmt.Errorf("unmarshaling files: %w", err)
+	}
+	return files, nil
 }
 
 // DownloadFileContent downloads the content of a file by ID
@@ -182,18 +326,19 @@ func DownloadFileContent(ctx context.Context, cms *DirectusClient, fileID string
 // UploadJSONFiles uploads converted JSON files to Directus.
 // Returns a map of source XML file ID to uploaded JSON file ID for linking.
 func UploadJSONFiles(ctx context.Context, cms *DirectusClient, cfg *configs.Config, files []types.ConvertedFile) (map[string]string, error) {
-	logger.Info("Uploading JSON files to Directus", zap.Int("count", len(files)))
+	runlog.Info(ctx, "Uploading JSON files to Directus", zap.Int("count", len(files)))
 
 	// Map from source XML file ID to JSON file ID
 	fileIDMap := make(map[string]string)
 
 	for i, file := range files {
-		logger.Info("Uploading JSON file",
+		runlog.Info(ctx, "Uploading JSON file",
 			zap.Int("index", i+1),
 			zap.Int("total", len(files)),
 			zap.String("filename", file.Filename),
 			zap.String("source_id", file.SourceID),
 		)
+		progress.Report(ctx, i+1, len(files), "uploading")
 
 		params := UploadFileParams{
 			Filename:    file.Filename,
@@ -207,8 +352,9 @@ func UploadJSONFiles(ctx context.Context, cms *DirectusClient, cfg *configs.Conf
 		if err != nil {
 			return nil, fmt.Errorf("uploading file %s: %w", file.Filename, err)
 		}
+		metrics.InboundFiles.Inc("uploaded")
 
-		logger.Info("JSON file uploaded",
+		runlog.Info(ctx, "JSON file uploaded",
 			zap.String("fileID", result.ID),
 			zap.String("source_id", file.SourceID),
 		)
@@ -219,6 +365,6 @@ func UploadJSONFiles(ctx context.Context, cms *DirectusClient, cfg *configs.Conf
 		}
 	}
 
-	logger.Info("All JSON files uploaded successfully", zap.Int("mapped_count", len(fileIDMap)))
+	runlog.Info(ctx, "All JSON files uploaded successfully", zap.Int("mapped_count", len(fileIDMap)))
 	return fileIDMap, nil
 }
@@ -3,8 +3,11 @@ package tasks
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -66,7 +69,7 @@ func TestPollXMLFiles(t *testing.T) {
 		FailureThreshold: 0.5,
 	}
 
-	files, err := PollXMLFiles(context.Background(), cms, cfg)
+	files, err := PollXMLFiles(context.Background(), cms, cfg, "folder1")
 	require.NoError(t, err)
 	assert.Len(t, files, 1)
 	assert.Equal(t, "file1", files[0].ID)
@@ -74,6 +77,101 @@ func TestPollXMLFiles(t *testing.T) {
 	assert.Equal(t, []byte("<xml>test</xml>"), files[0].Content)
 }
 
+func TestPollXMLFilesRetryLimit(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	watermark, err := json.Marshal(Watermark{LastCheckTimestamp: WatermarkTime{Time: now.Add(-3 * time.Hour)}})
+	require.NoError(t, err)
+	store := &fakeGlobalConfig{values: map[string]json.RawMessage{"inbound_shipment_received_watermark": watermark}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/items/global_config"):
+			store.serve(w, r)
+		case r.URL.Path == "/items/epcis_inbox":
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal([]map[string]interface{}{})})
+		case r.URL.Path == "/files":
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal([]DirectusFile{
+				{ID: "bad", Filename: "bad.xml", UploadedOn: now.Add(-2 * time.Hour)},
+				{ID: "good", Filename: "good.xml", UploadedOn: now.Add(-time.Hour)},
+			})})
+		case r.URL.Path == "/assets/good":
+			w.Write([]byte("<good/>"))
+		default:
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	cms := NewDirectusClient(server.URL, "test-token")
+	cfg := &configs.Config{FailureThreshold: 0.5, InboundRetryMaxAttempts: 2}
+
+	// The failed file holds the watermark back so it is fetched again
+	files, err := PollXMLFiles(context.Background(), cms, cfg, "folder1")
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.True(t, store.watermarkAt(t, "inbound_shipment_received_watermark").Equal(now.Add(-2*time.Hour)))
+	queue := store.queueAt(t, directusRetryQueueKey)
+	require.Len(t, queue, 1)
+	assert.Equal(t, "bad", queue[0].Key)
+	assert.Equal(t, 1, queue[0].Attempts)
+
+	// At the attempt limit it stays queued but no longer holds the watermark
+	_, err = PollXMLFiles(context.Background(), cms, cfg, "folder1")
+	require.NoError(t, err)
+	assert.True(t, store.watermarkAt(t, "inbound_shipment_received_watermark").Equal(now.Add(-time.Hour)))
+	queue = store.queueAt(t, directusRetryQueueKey)
+	require.Len(t, queue, 1)
+	assert.Equal(t, 2, queue[0].Attempts)
+}
+
+func TestPollXMLFilesPages(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	watermark, err := json.Marshal(Watermark{LastCheckTimestamp: WatermarkTime{Time: now.Add(-3 * time.Hour)}})
+	require.NoError(t, err)
+	store := &fakeGlobalConfig{values: map[string]json.RawMessage{"inbound_shipment_received_watermark": watermark}}
+
+	var all []DirectusFile
+	for i := 0; i < directusFilesPageSize+10; i++ {
+		all = append(all, DirectusFile{
+			ID:         fmt.Sprintf("file%d", i),
+			Filename:   fmt.Sprintf("file%d.xml", i),
+			UploadedOn: now.Add(-2 * time.Hour).Add(time.Duration(i) * time.Second),
+		})
+	}
+	var pages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/items/global_config"):
+			store.serve(w, r)
+		case r.URL.Path == "/items/epcis_inbox":
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal([]map[string]interface{}{})})
+		case r.URL.Path == "/files":
+			page, err := strconv.Atoi(r.URL.Query().Get("page"))
+			require.NoError(t, err)
+			pages = append(pages, r.URL.Query().Get("page"))
+			start := min((page-1)*directusFilesPageSize, len(all))
+			end := min(start+directusFilesPageSize, len(all))
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal(all[start:end])})
+		case strings.HasPrefix(r.URL.Path, "/assets/"):
+			w.Write([]byte("<" + strings.TrimPrefix(r.URL.Path, "/assets/") + "/>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cms := NewDirectusClient(server.URL, "test-token")
+	cfg := &configs.Config{FailureThreshold: 0.5, InboundRetryMaxAttempts: 2}
+
+	// Every file past the first page is fetched, and the watermark moves to the last
+	files, err := PollXMLFiles(context.Background(), cms, cfg, "folder1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, pages)
+	require.Len(t, files, len(all))
+	assert.Equal(t, "file59", files[len(files)-1].ID)
+	assert.True(t, store.watermarkAt(t, "inbound_shipment_received_watermark").Equal(all[len(all)-1].UploadedOn))
+}
+
 func TestDownloadFileContent(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/assets/test123", r.URL.Path)
@@ -5,7 +5,10 @@ import (
 	"fmt"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
-	"github.com/trackvision/tv-shared-go/logger"
+	"github.com/trackvision/tv-pipelines-hudsci/metrics"
+	"github.com/trackvision/tv-pipelines-hudsci/progress"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
 	"go.uber.org/zap"
 )
 
@@ -20,7 +23,7 @@ type DispatchResult struct {
 
 // DispatchViaTrustMed dispatches EPCIS XML documents to TrustMed Partner API via mTLS
 func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.Config, dispatchRecords []DispatchRecordWithFiles) ([]DispatchResult, error) {
-	logger.Info("Dispatching to TrustMed", zap.Int("count", len(dispatchRecords)))
+	runlog.Info(ctx, "Dispatching to TrustMed", zap.Int("count", len(dispatchRecords)))
 
 	if len(dispatchRecords) == 0 {
 		return []DispatchResult{}, nil
@@ -35,16 +38,21 @@ func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.
 	results := make([]DispatchResult, 0, len(dispatchRecords))
 
 	for i, record := range dispatchRecords {
-		logger.Info("Dispatching shipment",
+		ctx := tracing.WithAttributes(ctx,
+			tracing.CaptureID.String(record.CaptureID),
+			tracing.ShippingOperationID.String(record.ShippingOperationID))
+		ctx = runlog.With(ctx, zap.String("capture_id", record.CaptureID), zap.String("shipping_operation_id", record.ShippingOperationID))
+		runlog.Info(ctx, "Dispatching shipment",
 			zap.Int("index", i+1),
 			zap.Int("total", len(dispatchRecords)),
 			zap.String("shipping_operation_id", record.ShippingOperationID),
 		)
+		progress.Report(ctx, i+1, len(dispatchRecords), "dispatching")
 
 		// Increment dispatch attempt count
 		attemptCount, err := IncrementDispatchAttempt(ctx, cms, record.DispatchRecordID)
 		if err != nil {
-			logger.Error("Failed to increment dispatch attempt",
+			runlog.Error(ctx, "Failed to increment dispatch attempt",
 				zap.String("dispatch_record_id", record.DispatchRecordID),
 				zap.Error(err),
 			)
@@ -57,7 +65,7 @@ func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.
 			continue
 		}
 
-		logger.Info("Dispatch attempt",
+		runlog.Info(ctx, "Dispatch attempt",
 			zap.String("dispatch_record_id", record.DispatchRecordID),
 			zap.Int("attempt_count", attemptCount),
 		)
@@ -65,7 +73,7 @@ func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.
 		// Read enhanced XML from Directus
 		xmlContent, err := cms.GetFileContent(ctx, record.EPCISXMLEnhancedFileID)
 		if err != nil {
-			logger.Error("Failed to read XML file",
+			runlog.Error(ctx, "Failed to read XML file",
 				zap.String("file_id", record.EPCISXMLEnhancedFileID),
 				zap.Error(err),
 			)
@@ -88,7 +96,7 @@ func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.
 			// Extract HTTP status code
 			httpStatus := trustmedClient.GetStatusCodeFromError(err)
 
-			logger.Error("TrustMed dispatch failed",
+			runlog.Error(ctx, "TrustMed dispatch failed",
 				zap.String("shipping_operation_id", record.ShippingOperationID),
 				zap.Int("http_status", httpStatus),
 				zap.Error(err),
@@ -98,7 +106,7 @@ func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.
 			finalStatus := "retrying"
 			if attemptCount >= cfg.DispatchMaxRetries {
 				finalStatus = "failed"
-				logger.Error("Max attempts reached",
+				runlog.Error(ctx, "Max attempts reached",
 					zap.String("shipping_operation_id", record.ShippingOperationID),
 					zap.Int("attempts", attemptCount),
 					zap.Int("max", cfg.DispatchMaxRetries),
@@ -121,7 +129,7 @@ func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.
 		}
 
 		// Success
-		logger.Info("TrustMed dispatch successful",
+		runlog.Info(ctx, "TrustMed dispatch successful",
 			zap.String("shipping_operation_id", record.ShippingOperationID),
 			zap.String("trustmed_uuid", resp.ID),
 		)
@@ -145,6 +153,7 @@ func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.
 	retryingCount := 0
 	failedCount := 0
 	for _, r := range results {
+		metrics.DispatchOutcomes.Inc(r.Status)
 		switch r.Status {
 		case "sent":
 			sentCount++
@@ -155,7 +164,7 @@ func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.
 		}
 	}
 
-	logger.Info("Dispatch summary",
+	runlog.Info(ctx, "Dispatch summary",
 		zap.Int("sent", sentCount),
 		zap.Int("retrying", retryingCount),
 		zap.Int("failed", failedCount),
@@ -166,7 +175,7 @@ func DispatchViaTrustMed(ctx context.Context, cms *DirectusClient, cfg *configs.
 
 // PollDispatchConfirmation polls TrustMed Dashboard API for delivery confirmation
 func PollDispatchConfirmation(ctx context.Context, cms *DirectusClient, cfg *configs.Config, dispatchResults []DispatchResult) error {
-	logger.Info("Polling dispatch confirmation", zap.Int("count", len(dispatchResults)))
+	runlog.Info(ctx, "Polling dispatch confirmation", zap.Int("count", len(dispatchResults)))
 
 	// Filter for successfully sent dispatches
 	var sentResults []DispatchResult
@@ -177,11 +186,11 @@ func PollDispatchConfirmation(ctx context.Context, cms *DirectusClient, cfg *con
 	}
 
 	if len(sentResults) == 0 {
-		logger.Info("No sent dispatches to check confirmation")
+		runlog.Info(ctx, "No sent dispatches to check confirmation")
 		return nil
 	}
 
-	logger.Info("Checking confirmation for sent dispatches", zap.Int("count", len(sentResults)))
+	runlog.Info(ctx, "Checking confirmation for sent dispatches", zap.Int("count", len(sentResults)))
 
 	// Initialize TrustMed Dashboard client
 	dashboardClient := NewTrustMedDashboardClient(cfg)
@@ -203,9 +212,9 @@ func PollDispatchConfirmation(ctx context.Context, cms *DirectusClient, cfg *con
 
 	acknowledgedRecords, err := cms.QueryItems(ctx, "EPCIS_outbound", filter, []string{"id", "trustmed_uuid", "shipping_operation_id"}, 50)
 	if err != nil {
-		logger.Error("Failed to query acknowledged records", zap.Error(err))
+		runlog.Error(ctx, "Failed to query acknowledged records", zap.Error(err))
 	} else {
-		logger.Info("Found previously acknowledged records to check", zap.Int("count", len(acknowledgedRecords)))
+		runlog.Info(ctx, "Found previously acknowledged records to check", zap.Int("count", len(acknowledgedRecords)))
 	}
 
 	// Combine current results with previous records, deduplicating by ID
@@ -243,14 +252,18 @@ func PollDispatchConfirmation(ctx context.Context, cms *DirectusClient, cfg *con
 	failedCount := 0
 
 	for _, result := range allToCheck {
-		logger.Info("Checking status",
+		ctx := tracing.WithAttributes(ctx,
+			tracing.ShippingOperationID.String(result.ShippingOperationID),
+			tracing.TrustMedUUID.String(result.TrustMedUUID))
+		ctx = runlog.With(ctx, zap.String("shipping_operation_id", result.ShippingOperationID), zap.String("trustmed_uuid", result.TrustMedUUID))
+		runlog.Info(ctx, "Checking status",
 			zap.String("shipping_operation_id", result.ShippingOperationID),
 			zap.String("trustmed_uuid", result.TrustMedUUID),
 		)
 
 		status, err := dashboardClient.PollDispatchConfirmation(ctx, result.TrustMedUUID)
 		if err != nil {
-			logger.Error("Failed to poll confirmation",
+			runlog.Error(ctx, "Failed to poll confirmation",
 				zap.String("trustmed_uuid", result.TrustMedUUID),
 				zap.Error(err),
 			)
@@ -269,7 +282,7 @@ func PollDispatchConfirmation(ctx context.Context, cms *DirectusClient, cfg *con
 
 		err = cms.PatchItem(ctx, "EPCIS_outbound", result.DispatchRecordID, updates)
 		if err != nil {
-			logger.Error("Failed to update confirmation status",
+			runlog.Error(ctx, "Failed to update confirmation status",
 				zap.String("dispatch_record_id", result.DispatchRecordID),
 				zap.Error(err),
 			)
@@ -283,14 +296,14 @@ func PollDispatchConfirmation(ctx context.Context, cms *DirectusClient, cfg *con
 			pendingCount++
 		}
 
-		logger.Info("Confirmation status",
+		runlog.Info(ctx, "Confirmation status",
 			zap.String("shipping_operation_id", result.ShippingOperationID),
 			zap.String("status", status.Status),
 			zap.Bool("delivered", status.IsDelivered),
 		)
 	}
 
-	logger.Info("Confirmation polling complete",
+	runlog.Info(ctx, "Confirmation polling complete",
 		zap.Int("confirmed", confirmedCount),
 		zap.Int("pending", pendingCount),
 		zap.Int("failed", failedCount),
@@ -301,7 +314,7 @@ func PollDispatchConfirmation(ctx context.Context, cms *DirectusClient, cfg *con
 
 // NotifyOnErrors logs and notifies on permanent dispatch failures
 func NotifyOnErrors(ctx context.Context, cms *DirectusClient, cfg *configs.Config, dispatchResults []DispatchResult) error {
-	logger.Info("Checking for permanent failures")
+	runlog.Info(ctx, "Checking for permanent failures")
 
 	// Find failed dispatches
 	var failedResults []DispatchResult
@@ -312,10 +325,10 @@ func NotifyOnErrors(ctx context.Context, cms *DirectusClient, cfg *configs.Confi
 	}
 
 	if len(failedResults) > 0 {
-		logger.Warn("Found permanently failed dispatches", zap.Int("count", len(failedResults)))
+		runlog.Warn(ctx, "Found permanently failed dispatches", zap.Int("count", len(failedResults)))
 
 		for _, result := range failedResults {
-			logger.Error("PERMANENT FAILURE",
+			runlog.Error(ctx, "PERMANENT FAILURE",
 				zap.String("shipping_operation_id", result.ShippingOperationID),
 				zap.String("dispatch_record_id", result.DispatchRecordID),
 				zap.String("error", result.ErrorMessage),
@@ -340,9 +353,9 @@ func NotifyOnErrors(ctx context.Context, cms *DirectusClient, cfg *configs.Confi
 
 	allFailedRecords, err := cms.QueryItems(ctx, "EPCIS_outbound", filter, []string{"id", "shipping_operation_id", "dispatch_attempt_count", "last_error_message"}, 100)
 	if err != nil {
-		logger.Error("Failed to query failed dispatches", zap.Error(err))
+		runlog.Error(ctx, "Failed to query failed dispatches", zap.Error(err))
 	} else if len(allFailedRecords) > 0 {
-		logger.Warn("Total failed dispatches needing notification", zap.Int("count", len(allFailedRecords)))
+		runlog.Warn(ctx, "Total failed dispatches needing notification", zap.Int("count", len(allFailedRecords)))
 
 		for _, rec := range allFailedRecords {
 			var id string
@@ -356,7 +369,7 @@ func NotifyOnErrors(ctx context.Context, cms *DirectusClient, cfg *configs.Confi
 			attempts, _ := rec["dispatch_attempt_count"].(float64)
 			lastError, _ := rec["last_error_message"].(string)
 
-			logger.Info("Failed dispatch",
+			runlog.Info(ctx, "Failed dispatch",
 				zap.String("dispatch_id", id),
 				zap.String("shipping_operation_id", shipOpID),
 				zap.Int("attempts", int(attempts)),
@@ -364,10 +377,10 @@ func NotifyOnErrors(ctx context.Context, cms *DirectusClient, cfg *configs.Confi
 			)
 		}
 	} else {
-		logger.Info("No failed dispatches requiring notification")
+		runlog.Info(ctx, "No failed dispatches requiring notification")
 	}
 
-	logger.Info("Error notification check complete")
+	runlog.Info(ctx, "Error notification check complete")
 	return nil
 }
 
@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
-	"github.com/trackvision/tv-shared-go/logger"
+	"github.com/trackvision/tv-pipelines-hudsci/progress"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
 	"go.uber.org/zap"
 )
 
@@ -26,7 +28,7 @@ type DispatchRecordWithFiles struct {
 // - Uploads EPCIS JSON and XML files to Directus
 // - Updates dispatch record with file IDs and status
 func ManageDispatchRecords(ctx context.Context, cms *DirectusClient, cfg *configs.Config, documents []EnhancedDocument) ([]DispatchRecordWithFiles, error) {
-	logger.Info("Managing dispatch records", zap.Int("count", len(documents)))
+	runlog.Info(ctx, "Managing dispatch records", zap.Int("count", len(documents)))
 
 	if len(documents) == 0 {
 		return []DispatchRecordWithFiles{}, nil
@@ -36,21 +38,26 @@ func ManageDispatchRecords(ctx context.Context, cms *DirectusClient, cfg *config
 	failedCount := 0
 
 	for i, doc := range documents {
-		logger.Info("Managing dispatch record",
+		ctx := tracing.WithAttributes(ctx,
+			tracing.CaptureID.String(doc.CaptureID),
+			tracing.ShippingOperationID.String(doc.ShippingOperationID))
+		ctx = runlog.With(ctx, zap.String("capture_id", doc.CaptureID), zap.String("shipping_operation_id", doc.ShippingOperationID))
+		runlog.Info(ctx, "Managing dispatch record",
 			zap.Int("index", i+1),
 			zap.Int("total", len(documents)),
 			zap.String("shipping_operation_id", doc.ShippingOperationID),
 		)
+		progress.Report(ctx, i+1, len(documents), "creating dispatch records")
 
 		var dispatchRecordID string
 		if doc.DispatchRecordID != nil && *doc.DispatchRecordID != "" {
 			dispatchRecordID = *doc.DispatchRecordID
-			logger.Info("Using existing dispatch record", zap.String("dispatch_record_id", dispatchRecordID))
+			runlog.Info(ctx, "Using existing dispatch record", zap.String("dispatch_record_id", dispatchRecordID))
 		} else {
 			// Create new dispatch record
 			id, err := CreateDispatchRecord(ctx, cms, doc.ShippingOperationID, doc.TargetGLN)
 			if err != nil {
-				logger.Error("Failed to create dispatch record",
+				runlog.Error(ctx, "Failed to create dispatch record",
 					zap.String("shipping_operation_id", doc.ShippingOperationID),
 					zap.Error(err),
 				)
@@ -58,7 +65,7 @@ func ManageDispatchRecords(ctx context.Context, cms *DirectusClient, cfg *config
 				continue
 			}
 			dispatchRecordID = id
-			logger.Info("Created dispatch record", zap.String("dispatch_record_id", dispatchRecordID))
+			runlog.Info(ctx, "Created dispatch record", zap.String("dispatch_record_id", dispatchRecordID))
 		}
 
 		// Upload EPCIS JSON file
@@ -72,14 +79,14 @@ func ManageDispatchRecords(ctx context.Context, cms *DirectusClient, cfg *config
 				ContentType: "application/json",
 			})
 			if err != nil {
-				logger.Error("Failed to upload JSON file",
+				runlog.Error(ctx, "Failed to upload JSON file",
 					zap.String("shipping_operation_id", doc.ShippingOperationID),
 					zap.Error(err),
 				)
 				// Continue even if JSON upload fails (XML is more important)
 			} else {
 				jsonFileID = result.ID
-				logger.Info("Uploaded JSON file", zap.String("file_id", jsonFileID))
+				runlog.Info(ctx, "Uploaded JSON file", zap.String("file_id", jsonFileID))
 			}
 		}
 
@@ -92,7 +99,7 @@ func ManageDispatchRecords(ctx context.Context, cms *DirectusClient, cfg *config
 			ContentType: "application/xml",
 		})
 		if err != nil {
-			logger.Error("Failed to upload XML file",
+			runlog.Error(ctx, "Failed to upload XML file",
 				zap.String("shipping_operation_id", doc.ShippingOperationID),
 				zap.Error(err),
 			)
@@ -104,7 +111,7 @@ func ManageDispatchRecords(ctx context.Context, cms *DirectusClient, cfg *config
 			continue
 		}
 		xmlFileID := result.ID
-		logger.Info("Uploaded XML file", zap.String("file_id", xmlFileID))
+		runlog.Info(ctx, "Uploaded XML file", zap.String("file_id", xmlFileID))
 
 		// Update dispatch record with file IDs and status
 		err = UpdateDispatchStatus(ctx, cms, dispatchRecordID, "Processing", UpdateDispatchStatusParams{
@@ -113,7 +120,7 @@ func ManageDispatchRecords(ctx context.Context, cms *DirectusClient, cfg *config
 			TargetGLN:       doc.TargetGLN,
 		})
 		if err != nil {
-			logger.Error("Failed to update dispatch status",
+			runlog.Error(ctx, "Failed to update dispatch status",
 				zap.String("dispatch_record_id", dispatchRecordID),
 				zap.Error(err),
 			)
@@ -131,7 +138,7 @@ func ManageDispatchRecords(ctx context.Context, cms *DirectusClient, cfg *config
 			EPCISXMLEnhancedFileID: xmlFileID, // Same as XML file ID
 		})
 
-		logger.Info("Successfully managed dispatch record",
+		runlog.Info(ctx, "Successfully managed dispatch record",
 			zap.String("shipping_operation_id", doc.ShippingOperationID),
 		)
 	}
@@ -145,7 +152,7 @@ func ManageDispatchRecords(ctx context.Context, cms *DirectusClient, cfg *config
 		}
 	}
 
-	logger.Info("Dispatch record management complete",
+	runlog.Info(ctx, "Dispatch record management complete",
 		zap.Int("successful", len(results)),
 		zap.Int("failed", failedCount),
 	)
@@ -165,7 +172,7 @@ type UpdateDispatchStatusParams struct {
 
 // CreateDispatchRecord creates a new EPCIS_outbound record
 func CreateDispatchRecord(ctx context.Context, cms *DirectusClient, shippingOpID string, targetGLN string) (string, error) {
-	logger.Info("Creating dispatch record",
+	runlog.Info(ctx, "Creating dispatch record",
 		zap.String("shipping_operation_id", shippingOpID),
 		zap.String("target_gln", targetGLN),
 	)
@@ -197,13 +204,13 @@ func CreateDispatchRecord(ctx context.Context, cms *DirectusClient, shippingOpID
 		return "", fmt.Errorf("invalid response: unexpected id type %T", result["id"])
 	}
 
-	logger.Info("Created dispatch record", zap.String("id", id))
+	runlog.Info(ctx, "Created dispatch record", zap.String("id", id))
 	return id, nil
 }
 
 // UpdateDispatchStatus updates a dispatch record with status and optional fields
 func UpdateDispatchStatus(ctx context.Context, cms *DirectusClient, dispatchID string, status string, params UpdateDispatchStatusParams) error {
-	logger.Info("Updating dispatch status",
+	runlog.Info(ctx, "Updating dispatch status",
 		zap.String("dispatch_id", dispatchID),
 		zap.String("status", status),
 	)
@@ -246,7 +253,7 @@ func UpdateDispatchStatus(ctx context.Context, cms *DirectusClient, dispatchID s
 		return fmt.Errorf("patching dispatch record: %w", err)
 	}
 
-	logger.Info("Updated dispatch status",
+	runlog.Info(ctx, "Updated dispatch status",
 		zap.String("dispatch_id", dispatchID),
 		zap.String("status", status),
 	)
@@ -283,7 +290,7 @@ func IncrementDispatchAttempt(ctx context.Context, cms *DirectusClient, dispatch
 		return 0, fmt.Errorf("updating dispatch attempt count: %w", err)
 	}
 
-	logger.Info("Incremented dispatch attempt",
+	runlog.Info(ctx, "Incremented dispatch attempt",
 		zap.String("dispatch_id", dispatchID),
 		zap.Int("attempt_count", newCount),
 	)
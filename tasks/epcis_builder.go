@@ -8,7 +8,8 @@ import (
 	"time"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
-	"github.com/trackvision/tv-shared-go/logger"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
 	"go.uber.org/zap"
 )
 
@@ -35,7 +36,7 @@ type EPCISDocumentWithMetadata struct {
 // BuildEPCISDocuments builds clean EPCIS 2.0 JSON-LD documents and converts them to XML.
 // This creates "base XML" without SBDH headers or master data - that's added later by AddXMLHeaders.
 func BuildEPCISDocuments(ctx context.Context, cfg *configs.Config, shipmentsWithEvents []ShipmentWithEvents) ([]EPCISDocumentWithMetadata, error) {
-	logger.Info("Building EPCIS documents", zap.Int("count", len(shipmentsWithEvents)))
+	runlog.Info(ctx, "Building EPCIS documents", zap.Int("count", len(shipmentsWithEvents)))
 
 	if len(shipmentsWithEvents) == 0 {
 		return []EPCISDocumentWithMetadata{}, nil
@@ -45,7 +46,11 @@ func BuildEPCISDocuments(ctx context.Context, cfg *configs.Config, shipmentsWith
 	failedCount := 0
 
 	for i, shipment := range shipmentsWithEvents {
-		logger.Info("Building EPCIS document",
+		ctx := tracing.WithAttributes(ctx,
+			tracing.CaptureID.String(shipment.CaptureID),
+			tracing.ShippingOperationID.String(shipment.ShippingOperationID))
+		ctx = runlog.With(ctx, zap.String("capture_id", shipment.CaptureID), zap.String("shipping_operation_id", shipment.ShippingOperationID))
+		runlog.Info(ctx, "Building EPCIS document",
 			zap.Int("index", i+1),
 			zap.Int("total", len(shipmentsWithEvents)),
 			zap.String("shipping_operation_id", shipment.ShippingOperationID),
@@ -54,7 +59,7 @@ func BuildEPCISDocuments(ctx context.Context, cfg *configs.Config, shipmentsWith
 
 		// Validate events
 		if len(shipment.Events) == 0 {
-			logger.Error("No events found for shipment",
+			runlog.Error(ctx, "No events found for shipment",
 				zap.String("shipping_operation_id", shipment.ShippingOperationID),
 			)
 			failedCount++
@@ -65,7 +70,7 @@ func BuildEPCISDocuments(ctx context.Context, cfg *configs.Config, shipmentsWith
 		epcisDoc := buildEPCISJSONDocument(shipment.Events)
 		epcisJSONBytes, err := json.Marshal(epcisDoc)
 		if err != nil {
-			logger.Error("Failed to marshal EPCIS JSON",
+			runlog.Error(ctx, "Failed to marshal EPCIS JSON",
 				zap.String("shipping_operation_id", shipment.ShippingOperationID),
 				zap.Error(err),
 			)
@@ -82,7 +87,7 @@ func BuildEPCISDocuments(ctx context.Context, cfg *configs.Config, shipmentsWith
 			jsonSample = jsonSample[:500] + "..."
 		}
 
-		logger.Info("Created EPCIS 2.0 JSON-LD document",
+		runlog.Info(ctx, "Created EPCIS 2.0 JSON-LD document",
 			zap.String("shipping_operation_id", shipment.ShippingOperationID),
 			zap.Int("json_size", len(epcisJSONBytes)),
 			zap.Int("event_count", eventCount),
@@ -92,7 +97,7 @@ func BuildEPCISDocuments(ctx context.Context, cfg *configs.Config, shipmentsWith
 		// Convert JSON to XML via converter service
 		xmlContent, err := ConvertJSONToXML(ctx, cfg, epcisJSONBytes)
 		if err != nil {
-			logger.Error("Failed to convert JSON to XML",
+			runlog.Error(ctx, "Failed to convert JSON to XML",
 				zap.String("shipping_operation_id", shipment.ShippingOperationID),
 				zap.Error(err),
 			)
@@ -100,7 +105,7 @@ func BuildEPCISDocuments(ctx context.Context, cfg *configs.Config, shipmentsWith
 			continue
 		}
 
-		logger.Info("Converted to EPCIS 1.2 XML",
+		runlog.Info(ctx, "Converted to EPCIS 1.2 XML",
 			zap.String("shipping_operation_id", shipment.ShippingOperationID),
 			zap.Int("xml_size", len(xmlContent)),
 		)
@@ -115,7 +120,7 @@ func BuildEPCISDocuments(ctx context.Context, cfg *configs.Config, shipmentsWith
 			Events:              shipment.Events, // Pass through for master data extraction
 		})
 
-		logger.Info("Successfully built EPCIS document",
+		runlog.Info(ctx, "Successfully built EPCIS document",
 			zap.String("shipping_operation_id", shipment.ShippingOperationID),
 		)
 	}
@@ -129,7 +134,7 @@ func BuildEPCISDocuments(ctx context.Context, cfg *configs.Config, shipmentsWith
 		}
 	}
 
-	logger.Info("EPCIS document building complete",
+	runlog.Info(ctx, "EPCIS document building complete",
 		zap.Int("successful", len(results)),
 		zap.Int("failed", failedCount),
 	)
@@ -11,8 +11,11 @@ import (
 	"time"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/metrics"
+	"github.com/trackvision/tv-pipelines-hudsci/progress"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
 	"github.com/trackvision/tv-pipelines-hudsci/types"
-	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 )
 
@@ -26,7 +29,10 @@ type EPCISConverterClient struct {
 func NewEPCISConverterClient(baseURL string) *EPCISConverterClient {
 	return &EPCISConverterClient{
 		BaseURL: baseURL,
-		Client:  &http.Client{Timeout: 30 * time.Second},
+		Client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: metrics.InstrumentTransport("epcis_converter", nil, nil),
+		},
 	}
 }
 
@@ -34,32 +40,35 @@ func NewEPCISConverterClient(baseURL string) *EPCISConverterClient {
 // It processes each XML file and returns the converted JSON files.
 func ConvertXMLToJSON(ctx context.Context, cfg *configs.Config, xmlFiles []types.XMLFile) ([]types.ConvertedFile, error) {
 	if len(xmlFiles) == 0 {
-		logger.Info("No XML files to convert")
+		runlog.Info(ctx, "No XML files to convert")
 		return []types.ConvertedFile{}, nil
 	}
 
-	logger.Info("Converting XML to JSON", zap.Int("count", len(xmlFiles)))
+	runlog.Info(ctx, "Converting XML to JSON", zap.Int("count", len(xmlFiles)))
 
 	client := NewEPCISConverterClient(cfg.EPCISConverterURL)
 	convertedFiles := make([]types.ConvertedFile, 0, len(xmlFiles))
 	failedCount := 0
 
 	for i, xmlFile := range xmlFiles {
-		logger.Info("Converting file",
+		runlog.Info(ctx, "Converting file",
 			zap.Int("index", i+1),
 			zap.Int("total", len(xmlFiles)),
 			zap.String("filename", xmlFile.Filename),
 		)
+		progress.Report(ctx, i+1, len(xmlFiles), "converting")
 
 		jsonData, err := client.ConvertToJSON(ctx, xmlFile.Content)
 		if err != nil {
-			logger.Error("Conversion failed",
+			runlog.Error(ctx, "Conversion failed",
 				zap.String("filename", xmlFile.Filename),
 				zap.Error(err),
 			)
 			failedCount++
+			metrics.InboundFiles.Inc("conversion_failed")
 			continue
 		}
+		metrics.InboundFiles.Inc("converted")
 
 		// Generate JSON filename from XML filename
 		jsonFilename := strings.TrimSuffix(xmlFile.Filename, filepath.Ext(xmlFile.Filename)) + ".json"
@@ -71,7 +80,7 @@ func ConvertXMLToJSON(ctx context.Context, cfg *configs.Config, xmlFiles []types
 			XMLContent: xmlFile.Content,
 		})
 
-		logger.Info("Conversion successful",
+		runlog.Info(ctx, "Conversion successful",
 			zap.String("filename", xmlFile.Filename),
 			zap.Int("json_size", len(jsonData)),
 		)
@@ -86,7 +95,7 @@ func ConvertXMLToJSON(ctx context.Context, cfg *configs.Config, xmlFiles []types
 		}
 	}
 
-	logger.Info("XML to JSON conversion complete",
+	runlog.Info(ctx, "XML to JSON conversion complete",
 		zap.Int("successful", len(convertedFiles)),
 		zap.Int("failed", failedCount),
 	)
@@ -95,7 +104,9 @@ func ConvertXMLToJSON(ctx context.Context, cfg *configs.Config, xmlFiles []types
 }
 
 // ConvertToJSON converts EPCIS XML to JSON-LD format using the converter service
-func (c *EPCISConverterClient) ConvertToJSON(ctx context.Context, xmlContent []byte) ([]byte, error) {
+func (c *EPCISConverterClient) ConvertToJSON(ctx context.Context, xmlContent []byte) (_ []byte, err error) {
+	ctx, span := tracing.StartClient(ctx, "converter.ConvertToJSON")
+	defer func() { tracing.End(span, err) }()
 	url := fmt.Sprintf("%s/api/convert/json/2.0", c.BaseURL)
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(xmlContent))
@@ -122,13 +133,15 @@ func (c *EPCISConverterClient) ConvertToJSON(ctx context.Context, xmlContent []b
 		return nil, fmt.Errorf("reading response: %w", err)
 	}
 
-	logger.Debug("Conversion successful", zap.Int("json_size", len(jsonData)))
+	runlog.Debug(ctx, "Conversion successful", zap.Int("json_size", len(jsonData)))
 	return jsonData, nil
 }
 
 // ConvertJSONToXML converts EPCIS 2.0 JSON-LD to XML format (for outbound pipeline)
-func ConvertJSONToXML(ctx context.Context, cfg *configs.Config, jsonContent []byte) ([]byte, error) {
-	logger.Info("Converting JSON to XML")
+func ConvertJSONToXML(ctx context.Context, cfg *configs.Config, jsonContent []byte) (_ []byte, err error) {
+	ctx, span := tracing.StartClient(ctx, "converter.ConvertJSONToXML")
+	defer func() { tracing.End(span, err) }()
+	runlog.Info(ctx, "Converting JSON to XML")
 
 	client := NewEPCISConverterClient(cfg.EPCISConverterURL)
 
@@ -167,7 +180,7 @@ func ConvertJSONToXML(ctx context.Context, cfg *configs.Config, jsonContent []by
 		xmlSample = xmlSample[:500] + "..."
 	}
 
-	logger.Info("JSON to XML conversion successful",
+	runlog.Info(ctx, "JSON to XML conversion successful",
 		zap.Int("xml_size", len(xmlData)),
 		zap.String("root_element", rootElement),
 		zap.String("xml_sample", xmlSample),
@@ -225,6 +238,6 @@ func (c *EPCISConverterClient) HealthCheck(ctx context.Context) error {
 		return fmt.Errorf("health check returned status %d", resp.StatusCode)
 	}
 
-	logger.Info("Converter service is healthy")
+	runlog.Info(ctx, "Converter service is healthy")
 	return nil
 }
@@ -10,7 +10,7 @@ import (
 	"github.com/beevik/etree"
 	"github.com/google/uuid"
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
-	"github.com/trackvision/tv-shared-go/logger"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
 	"go.uber.org/zap"
 )
 
@@ -51,7 +51,7 @@ type ProductMasterData struct {
 // AddXMLHeaders enhances EPCIS XML with SBDH headers, DSCSA statements, and VocabularyList.
 // This is a pure function with no side effects - file uploads happen later in ManageDispatchRecords.
 func AddXMLHeaders(ctx context.Context, cms *DirectusClient, cfg *configs.Config, documents []EPCISDocumentWithMetadata) ([]EnhancedDocument, error) {
-	logger.Info("Adding XML headers to EPCIS documents", zap.Int("count", len(documents)))
+	runlog.Info(ctx, "Adding XML headers to EPCIS documents", zap.Int("count", len(documents)))
 
 	if len(documents) == 0 {
 		return []EnhancedDocument{}, nil
@@ -61,7 +61,8 @@ func AddXMLHeaders(ctx context.Context, cms *DirectusClient, cfg *configs.Config
 	failedCount := 0
 
 	for i, doc := range documents {
-		logger.Info("Enhancing XML",
+		ctx := runlog.With(ctx, zap.String("capture_id", doc.CaptureID), zap.String("shipping_operation_id", doc.ShippingOperationID))
+		runlog.Info(ctx, "Enhancing XML",
 			zap.Int("index", i+1),
 			zap.Int("total", len(documents)),
 			zap.String("shipping_operation_id", doc.ShippingOperationID),
@@ -70,7 +71,7 @@ func AddXMLHeaders(ctx context.Context, cms *DirectusClient, cfg *configs.Config
 		// Extract master data from events
 		locations, products, err := extractMasterDataFromEvents(ctx, cms, doc.Events)
 		if err != nil {
-			logger.Error("Failed to extract master data",
+			runlog.Error(ctx, "Failed to extract master data",
 				zap.String("shipping_operation_id", doc.ShippingOperationID),
 				zap.Error(err),
 			)
@@ -78,19 +79,19 @@ func AddXMLHeaders(ctx context.Context, cms *DirectusClient, cfg *configs.Config
 			continue
 		}
 
-		logger.Info("Extracted master data",
+		runlog.Info(ctx, "Extracted master data",
 			zap.String("shipping_operation_id", doc.ShippingOperationID),
 			zap.Int("locations", len(locations)),
 			zap.Int("products", len(products)),
 		)
 
 		// Extract sender/receiver URNs from shipping event
-		senderURN, receiverURN := extractShippingURNs(doc.Events, cfg)
+		senderURN, receiverURN := extractShippingURNs(ctx, doc.Events, cfg)
 
 		// Enhance XML with SBDH, DSCSA, and VocabularyList
 		enhancedXML, err := enhanceEPCISXML(doc.BaseXMLContent, senderURN, receiverURN, locations, products)
 		if err != nil {
-			logger.Error("Failed to enhance XML",
+			runlog.Error(ctx, "Failed to enhance XML",
 				zap.String("shipping_operation_id", doc.ShippingOperationID),
 				zap.Error(err),
 			)
@@ -98,7 +99,7 @@ func AddXMLHeaders(ctx context.Context, cms *DirectusClient, cfg *configs.Config
 			continue
 		}
 
-		logger.Info("Enhanced XML",
+		runlog.Info(ctx, "Enhanced XML",
 			zap.String("shipping_operation_id", doc.ShippingOperationID),
 			zap.Int("xml_size", len(enhancedXML)),
 		)
@@ -118,7 +119,7 @@ func AddXMLHeaders(ctx context.Context, cms *DirectusClient, cfg *configs.Config
 			EPCISJSONContent:    doc.EPCISJSONContent,
 		})
 
-		logger.Info("Successfully enhanced EPCIS document",
+		runlog.Info(ctx, "Successfully enhanced EPCIS document",
 			zap.String("shipping_operation_id", doc.ShippingOperationID),
 		)
 	}
@@ -132,7 +133,7 @@ func AddXMLHeaders(ctx context.Context, cms *DirectusClient, cfg *configs.Config
 		}
 	}
 
-	logger.Info("XML enhancement complete",
+	runlog.Info(ctx, "XML enhancement complete",
 		zap.Int("successful", len(results)),
 		zap.Int("failed", failedCount),
 	)
@@ -144,7 +145,7 @@ func AddXMLHeaders(ctx context.Context, cms *DirectusClient, cfg *configs.Config
 // Handles multiple bizStep formats (short form, CBV URN, GS1 Digital Link).
 // IMPORTANT: Uses location type for SBDH sender/receiver (matching Mage behavior).
 // The location type points to physical location, owning_party points to legal entity.
-func extractShippingURNs(events []map[string]interface{}, cfg *configs.Config) (string, string) {
+func extractShippingURNs(ctx context.Context, events []map[string]interface{}, cfg *configs.Config) (string, string) {
 	for _, event := range events {
 		bizStep, ok := event["bizStep"].(string)
 		if !ok || !IsShippingBizStep(bizStep) {
@@ -200,7 +201,7 @@ func extractShippingURNs(events []map[string]interface{}, cfg *configs.Config) (
 		}
 
 		if senderURN != "" || receiverURN != "" {
-			logger.Info("Extracted URNs from shipping event",
+			runlog.Info(ctx, "Extracted URNs from shipping event",
 				zap.String("sender", senderURN),
 				zap.String("receiver", receiverURN),
 			)
@@ -209,7 +210,7 @@ func extractShippingURNs(events []map[string]interface{}, cfg *configs.Config) (
 	}
 
 	// Fallback to default GLNs if not found
-	logger.Warn("No shipping event found with sender/receiver URNs, using defaults")
+	runlog.Warn(ctx, "No shipping event found with sender/receiver URNs, using defaults")
 	return fmt.Sprintf("urn:epc:id:sgln:%s.0", cfg.DefaultSenderGLN),
 		fmt.Sprintf("urn:epc:id:sgln:%s.0", cfg.DefaultReceiverGLN)
 }
@@ -499,7 +500,7 @@ func extractMasterDataFromEvents(ctx context.Context, cms *DirectusClient, event
 		}
 	}
 
-	logger.Info("Querying master data",
+	runlog.Info(ctx, "Querying master data",
 		zap.Int("location_urns", len(orderedLocationURNs)),
 		zap.Int("product_urns", len(productURNs)),
 	)
@@ -523,7 +524,7 @@ func extractMasterDataFromEvents(ctx context.Context, cms *DirectusClient, event
 			}
 			items, err = cms.QueryItems(ctx, "organisation", filter, []string{"pgln", "organisation_name", "address", "city", "state", "postal_code", "country_code"}, 1)
 			if err != nil || len(items) == 0 {
-				logger.Warn("Location not found", zap.String("gln", gln))
+				runlog.Warn(ctx, "Location not found", zap.String("gln", gln))
 				continue
 			}
 			// Map organisation fields
@@ -573,7 +574,7 @@ func extractMasterDataFromEvents(ctx context.Context, cms *DirectusClient, event
 			"net_content_description", "dosage_form_type", "strength_description",
 		}, 1)
 		if err != nil || len(items) == 0 {
-			logger.Warn("Product not found", zap.String("urn", urn))
+			runlog.Warn(ctx, "Product not found", zap.String("urn", urn))
 			continue
 		}
 
@@ -7,8 +7,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
 	"github.com/trackvision/tv-pipelines-hudsci/types"
-	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 )
 
@@ -131,16 +131,16 @@ type Party struct {
 // It parses the XML, finds shipping events, and extracts seller, buyer, ship_from, ship_to, etc.
 func ExtractEPCISInboxData(ctx context.Context, cms *DirectusClient, xmlFiles []types.XMLFile) ([]EPCISInboxItem, error) {
 	if len(xmlFiles) == 0 {
-		logger.Info("No XML files to extract")
+		runlog.Info(ctx, "No XML files to extract")
 		return []EPCISInboxItem{}, nil
 	}
 
-	logger.Info("Extracting EPCIS inbox data", zap.Int("count", len(xmlFiles)))
+	runlog.Info(ctx, "Extracting EPCIS inbox data", zap.Int("count", len(xmlFiles)))
 
 	inboxItems := make([]EPCISInboxItem, 0)
 
 	for i, xmlFile := range xmlFiles {
-		logger.Info("Processing XML file",
+		runlog.Info(ctx, "Processing XML file",
 			zap.Int("index", i+1),
 			zap.Int("total", len(xmlFiles)),
 			zap.String("filename", xmlFile.Filename),
@@ -148,7 +148,7 @@ func ExtractEPCISInboxData(ctx context.Context, cms *DirectusClient, xmlFiles []
 
 		items, err := extractFromXML(ctx, xmlFile, cms)
 		if err != nil {
-			logger.Error("Failed to extract from XML",
+			runlog.Error(ctx, "Failed to extract from XML",
 				zap.String("filename", xmlFile.Filename),
 				zap.Error(err),
 			)
@@ -156,13 +156,13 @@ func ExtractEPCISInboxData(ctx context.Context, cms *DirectusClient, xmlFiles []
 		}
 
 		inboxItems = append(inboxItems, items...)
-		logger.Info("Extracted data",
+		runlog.Info(ctx, "Extracted data",
 			zap.String("filename", xmlFile.Filename),
 			zap.Int("events", len(items)),
 		)
 	}
 
-	logger.Info("Extraction complete", zap.Int("total_items", len(inboxItems)))
+	runlog.Info(ctx, "Extraction complete", zap.Int("total_items", len(inboxItems)))
 	return inboxItems, nil
 }
 
@@ -181,27 +181,32 @@ func extractFromXML(ctx context.Context, xmlFile types.XMLFile, cms *DirectusCli
 		for _, loc := range locations {
 			locationsByGLN[loc.GLN] = loc
 		}
-		logger.Info("Extracted location master data", zap.Int("count", len(locationsByGLN)))
+		runlog.Info(ctx, "Extracted location master data", zap.Int("count", len(locationsByGLN)))
 	}
 
 	// Find shipping events (passing full event list for product extraction)
 	shippingEvents := findShippingEvents(doc.EPCISBody.EventList)
 	if len(shippingEvents) == 0 {
-		logger.Warn("No shipping events found in file", zap.String("filename", xmlFile.Filename))
+		runlog.Warn(ctx, "No shipping events found in file", zap.String("filename", xmlFile.Filename))
 		return []EPCISInboxItem{}, nil
 	}
 
-	logger.Info("Found shipping events", zap.Int("count", len(shippingEvents)))
+	runlog.Info(ctx, "Found shipping events", zap.Int("count", len(shippingEvents)))
 
 	// Extract products and containers from ALL events in the document (matching Mage behavior)
 	products := extractProductsFromAllEvents(ctx, doc.EPCISBody.EventList, cms)
 	containers := extractContainersFromAllEvents(doc.EPCISBody.EventList)
 
-	logger.Info("Extracted from all events",
+	runlog.Info(ctx, "Extracted from all events",
 		zap.Int("products", len(products)),
 		zap.Int("containers", len(containers)),
 	)
 
+	// Files polled from Directus rather than TrustMed carry no hash yet
+	if xmlFile.ContentHash == "" {
+		xmlFile.ContentHash = ContentHash(xmlFile.Content)
+	}
+
 	// Extract inbox data from each shipping event
 	items := make([]EPCISInboxItem, 0, len(shippingEvents))
 
@@ -407,6 +412,8 @@ func extractInboxDataFromEvent(
 		CaptureMessage: map[string]interface{}{"file_id": xmlFile.ID},
 		RawMessage:     string(xmlFile.Content),
 		EPCISXMLFileID: xmlFile.ID,
+		LogGuid:        xmlFile.LogGuid,
+		ContentHash:    xmlFile.ContentHash,
 		Products:       products,
 		Containers:     containers,
 	}
@@ -490,7 +497,7 @@ func extractProductsFromAllEvents(ctx context.Context, eventList EventList, cms
 			if err == nil && len(items) > 0 {
 				if productName, ok := items[0]["product_name"].(string); ok && productName != "" {
 					gtinNames[gtin] = productName
-					logger.Info("Found product name",
+					runlog.Info(ctx, "Found product name",
 						zap.String("gtin", gtin),
 						zap.String("product_name", productName),
 					)
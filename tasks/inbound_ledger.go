@@ -0,0 +1,136 @@
+package tasks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// InboundLedger is the set of documents already processed into epcis_inbox,
+// keyed by Directus file ID, TrustMed log GUID and SHA-256 of the content.
+// The log GUID and hash survive a re-download, which uploads the document
+// again under a new file ID.
+type InboundLedger struct {
+	fileIDs  map[string]bool
+	logGuids map[string]bool
+	hashes   map[string]bool
+}
+
+// NewInboundLedger returns an empty ledger.
+func NewInboundLedger() *InboundLedger {
+	return &InboundLedger{
+		fileIDs:  make(map[string]bool),
+		logGuids: make(map[string]bool),
+		hashes:   make(map[string]bool),
+	}
+}
+
+// ledgerQueryBatch is how many keys one epcis_inbox query filters on, to
+// keep request URLs short.
+const ledgerQueryBatch = 100
+
+// LedgerKeys are the documents a ledger is loaded for: the Directus file
+// IDs, TrustMed log GUIDs and content hashes of the batch being processed.
+type LedgerKeys struct {
+	FileIDs  []string
+	LogGuids []string
+	Hashes   []string
+}
+
+// LoadInboundLedger reads the epcis_inbox records matching any of keys, so
+// the cost follows the batch rather than the inbox's history. Only documents
+// that produced inbox items are recorded: an archived file without shipping
+// events is not in the ledger and is fetched again if it is polled again.
+func LoadInboundLedger(ctx context.Context, cms *DirectusClient, keys LedgerKeys) (*InboundLedger, error) {
+	ledger := NewInboundLedger()
+	if err := ledger.Load(ctx, cms, keys); err != nil {
+		return nil, err
+	}
+	return ledger, nil
+}
+
+// Load adds the epcis_inbox records matching any of keys to the ledger.
+func (l *InboundLedger) Load(ctx context.Context, cms *DirectusClient, keys LedgerKeys) error {
+	for _, set := range []struct {
+		field  string
+		values []string
+	}{
+		{"epcis_xml_file_id", keys.FileIDs},
+		{"log_guid", keys.LogGuids},
+		{"content_hash", keys.Hashes},
+	} {
+		values := nonEmpty(set.values)
+		for start := 0; start < len(values); start += ledgerQueryBatch {
+			batch := values[start:min(start+ledgerQueryBatch, len(values))]
+			filter := map[string]interface{}{set.field: map[string]interface{}{"_in": batch}}
+			records, err := cms.QueryItems(ctx, "epcis_inbox", filter, []string{"epcis_xml_file_id", "capture_message", "log_guid", "content_hash"}, -1)
+			if err != nil {
+				return fmt.Errorf("loading inbound ledger: %w", err)
+			}
+			for _, record := range records {
+				logGuid, _ := record["log_guid"].(string)
+				hash, _ := record["content_hash"].(string)
+				l.Add(logGuid, hash)
+				fileID, _ := record["epcis_xml_file_id"].(string)
+				if msg, ok := record["capture_message"].(map[string]interface{}); ok && fileID == "" {
+					fileID, _ = msg["file_id"].(string)
+				}
+				if fileID != "" {
+					l.fileIDs[fileID] = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// nonEmpty returns values without empty strings or duplicates.
+func nonEmpty(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Add records a processed document. Empty keys are ignored.
+func (l *InboundLedger) Add(logGuid, hash string) {
+	if logGuid != "" {
+		l.logGuids[logGuid] = true
+	}
+	if hash != "" {
+		l.hashes[hash] = true
+	}
+}
+
+// HasFileID reports whether the Directus file fileID was processed.
+func (l *InboundLedger) HasFileID(fileID string) bool {
+	return fileID != "" && l.fileIDs[fileID]
+}
+
+// HasLogGuid reports whether the TrustMed document logGuid was processed.
+func (l *InboundLedger) HasLogGuid(logGuid string) bool {
+	return logGuid != "" && l.logGuids[logGuid]
+}
+
+// HasHash reports whether a document with this content hash was processed.
+func (l *InboundLedger) HasHash(hash string) bool {
+	return hash != "" && l.hashes[hash]
+}
+
+// Has reports whether the item's document was processed, by any key.
+func (l *InboundLedger) Has(item EPCISInboxItem) bool {
+	fileID, _ := item.CaptureMessage["file_id"].(string)
+	return l.HasFileID(fileID) || l.HasLogGuid(item.LogGuid) || l.HasHash(item.ContentHash)
+}
+
+// ContentHash returns the hex SHA-256 of a document's content.
+func ContentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
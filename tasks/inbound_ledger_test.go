@@ -0,0 +1,64 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInboundLedger(t *testing.T) {
+	ledger := NewInboundLedger()
+	ledger.Add("guid-1", "")
+	ledger.Add("", "hash-1")
+
+	assert.True(t, ledger.HasLogGuid("guid-1"))
+	assert.True(t, ledger.HasHash("hash-1"))
+	assert.False(t, ledger.HasLogGuid(""), "empty keys never match")
+	assert.False(t, ledger.HasHash(""))
+
+	assert.True(t, ledger.Has(EPCISInboxItem{LogGuid: "guid-1"}))
+	assert.True(t, ledger.Has(EPCISInboxItem{LogGuid: "guid-2", ContentHash: "hash-1"}))
+	assert.False(t, ledger.Has(EPCISInboxItem{LogGuid: "guid-2", ContentHash: "hash-2"}))
+}
+
+func TestContentHash(t *testing.T) {
+	// SHA-256 of "abc"
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", ContentHash([]byte("abc")))
+}
+
+func TestLoadInboundLedger(t *testing.T) {
+	var filters []map[string]map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var filter map[string]map[string][]string
+		require.NoError(t, json.Unmarshal([]byte(r.URL.Query().Get("filter")), &filter), "every query is filtered")
+		filters = append(filters, filter)
+		json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal([]map[string]interface{}{
+			{"epcis_xml_file_id": "file-1", "log_guid": "guid-1", "content_hash": "hash-1"},
+		})})
+	}))
+	defer server.Close()
+
+	hashes := make([]string, ledgerQueryBatch+1)
+	for i := range hashes {
+		hashes[i] = fmt.Sprintf("hash-%d", i)
+	}
+	ledger, err := LoadInboundLedger(context.Background(), NewDirectusClient(server.URL, "test-key"), LedgerKeys{
+		LogGuids: []string{"guid-1", "", "guid-1"},
+		Hashes:   hashes,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, filters, 3, "one query for the GUIDs and two batches of hashes")
+	assert.Equal(t, []string{"guid-1"}, filters[0]["log_guid"]["_in"], "empty and repeated keys are dropped")
+	assert.Len(t, filters[1]["content_hash"]["_in"], ledgerQueryBatch)
+	assert.Equal(t, []string{hashes[ledgerQueryBatch]}, filters[2]["content_hash"]["_in"])
+	assert.True(t, ledger.HasFileID("file-1"))
+	assert.True(t, ledger.HasLogGuid("guid-1"))
+	assert.True(t, ledger.HasHash("hash-1"))
+}
@@ -0,0 +1,133 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// inboundRetryQueueKey is the global_config entry holding the inbound retry
+// queue.
+const inboundRetryQueueKey = "trustmed_inbound_retry_queue"
+
+// RetryFile is an inbound file whose download or archiving failed: a
+// TrustMed file by log GUID, or a Directus file or stored object by key.
+type RetryFile struct {
+	LogGuid     string    `json:"log_guid,omitempty"`
+	Key         string    `json:"key,omitempty"`
+	DateCreated time.Time `json:"date_created"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	LastAttempt time.Time `json:"last_attempt"`
+}
+
+// RetryQueue is the durable list of an inbound source's failed files that
+// later polls download again, stored in global_config next to the
+// watermark. Files stay queued once they reach the attempt limit, so they
+// are never dropped silently; remove them from the entry once handled.
+type RetryQueue struct {
+	Files []RetryFile `json:"files"`
+
+	key     string
+	changed bool
+}
+
+// LoadRetryQueue reads the TrustMed retry queue. A missing entry is an empty
+// queue.
+func LoadRetryQueue(ctx context.Context, cms *DirectusClient) (*RetryQueue, error) {
+	return loadRetryQueue(ctx, cms, inboundRetryQueueKey)
+}
+
+// loadRetryQueue reads the retry queue in the global_config entry key.
+func loadRetryQueue(ctx context.Context, cms *DirectusClient, key string) (*RetryQueue, error) {
+	config, err := getGlobalConfig(ctx, cms, key)
+	if err != nil {
+		return nil, fmt.Errorf("loading retry queue: %w", err)
+	}
+	queue := &RetryQueue{key: key}
+	if config == nil {
+		return queue, nil
+	}
+	if err := decodeConfigValue(config.Value, queue); err != nil {
+		return nil, fmt.Errorf("unmarshaling retry queue: %w", err)
+	}
+	return queue, nil
+}
+
+// Save writes the queue back to global_config if it changed since loading.
+func (q *RetryQueue) Save(ctx context.Context, cms *DirectusClient) error {
+	if !q.changed {
+		return nil
+	}
+	if q.Files == nil {
+		q.Files = []RetryFile{}
+	}
+	if err := saveGlobalConfig(ctx, cms, q.key, q); err != nil {
+		return fmt.Errorf("saving retry queue: %w", err)
+	}
+	q.changed = false
+	return nil
+}
+
+// Due returns the queued files with fewer than maxAttempts attempts.
+func (q *RetryQueue) Due(maxAttempts int) []RetryFile {
+	var due []RetryFile
+	for _, f := range q.Files {
+		if f.Attempts < maxAttempts {
+			due = append(due, f)
+		}
+	}
+	return due
+}
+
+// Failed queues the TrustMed file, or counts another attempt if it is
+// queued, and returns its attempts so far.
+func (q *RetryQueue) Failed(record FileRecord, err error) int {
+	return q.failed(RetryFile{LogGuid: record.LogGuid, DateCreated: record.DateCreated}, err)
+}
+
+// FailedKey is Failed for a Directus file ID or stored object key, with the
+// file's upload or modification time.
+func (q *RetryQueue) FailedKey(key string, modified time.Time, err error) int {
+	return q.failed(RetryFile{Key: key, DateCreated: modified}, err)
+}
+
+func (q *RetryQueue) failed(file RetryFile, err error) int {
+	q.changed = true
+	now := time.Now()
+	for i := range q.Files {
+		if q.Files[i].LogGuid == file.LogGuid && q.Files[i].Key == file.Key {
+			q.Files[i].Attempts++
+			q.Files[i].LastError = err.Error()
+			q.Files[i].LastAttempt = now
+			return q.Files[i].Attempts
+		}
+	}
+	file.Attempts = 1
+	file.LastError = err.Error()
+	file.LastAttempt = now
+	q.Files = append(q.Files, file)
+	return 1
+}
+
+// Remove drops the TrustMed file from the queue, reporting whether it was
+// queued.
+func (q *RetryQueue) Remove(logGuid string) bool {
+	return q.remove(RetryFile{LogGuid: logGuid})
+}
+
+// RemoveKey is Remove for a Directus file ID or stored object key.
+func (q *RetryQueue) RemoveKey(key string) bool {
+	return q.remove(RetryFile{Key: key})
+}
+
+func (q *RetryQueue) remove(file RetryFile) bool {
+	for i := range q.Files {
+		if q.Files[i].LogGuid == file.LogGuid && q.Files[i].Key == file.Key {
+			q.Files = append(q.Files[:i], q.Files[i+1:]...)
+			q.changed = true
+			return true
+		}
+	}
+	return false
+}
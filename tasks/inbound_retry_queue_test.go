@@ -0,0 +1,37 @@
+package tasks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryQueue(t *testing.T) {
+	queue := &RetryQueue{}
+	created := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 1, queue.Failed(FileRecord{LogGuid: "guid-1", DateCreated: created}, errors.New("timeout")))
+	assert.Equal(t, 2, queue.Failed(FileRecord{LogGuid: "guid-1"}, errors.New("status 500")))
+	assert.Equal(t, 1, queue.Failed(FileRecord{LogGuid: "guid-2"}, errors.New("timeout")))
+
+	assert.Len(t, queue.Files, 2)
+	assert.Equal(t, created, queue.Files[0].DateCreated, "a retry keeps the original creation time")
+	assert.Equal(t, "status 500", queue.Files[0].LastError)
+
+	due := queue.Due(2)
+	assert.Len(t, due, 1, "files at the attempt limit are not due")
+	assert.Equal(t, "guid-2", due[0].LogGuid)
+
+	assert.True(t, queue.Remove("guid-2"))
+	assert.False(t, queue.Remove("guid-2"))
+	assert.Len(t, queue.Files, 1, "exhausted files stay queued")
+
+	// Files of other sources are keyed by file ID or object key
+	assert.Equal(t, 1, queue.FailedKey("guid-1", created, errors.New("timeout")), "keys and log GUIDs do not collide")
+	assert.Equal(t, 2, queue.FailedKey("guid-1", created, errors.New("timeout")))
+	assert.True(t, queue.RemoveKey("guid-1"))
+	assert.Len(t, queue.Files, 1)
+	assert.Equal(t, "guid-1", queue.Files[0].LogGuid)
+}
@@ -0,0 +1,412 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/progress"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/types"
+	"go.uber.org/zap"
+)
+
+// InboundSource is somewhere inbound EPCIS documents arrive. Each source
+// keeps its own watermark in global_config and returns documents archived in
+// Directus, so the rest of the inbound flow treats them alike.
+type InboundSource interface {
+	// Name identifies the source in INBOUND_SOURCES and logs.
+	Name() string
+	// Poll returns the documents received since the source's watermark and
+	// advances the watermark past them. The watermark is saved before the
+	// documents reach epcis_inbox, so documents whose insert fails are only
+	// recovered by a PollWindow over their window.
+	Poll(ctx context.Context) ([]types.XMLFile, error)
+	// PollWindow returns the documents received in [since, until), leaving
+	// the watermark unchanged.
+	PollWindow(ctx context.Context, since, until time.Time) ([]types.XMLFile, error)
+}
+
+// NewInboundSources returns the sources listed in cfg.InboundSources, in
+// order, defaulting to TrustMed alone. Sources missing required settings
+// fail here rather than mid-poll.
+func NewInboundSources(cfg *configs.Config, cms *DirectusClient) ([]InboundSource, error) {
+	names := cfg.InboundSources
+	if len(names) == 0 {
+		names = []string{"trustmed"}
+	}
+	var sources []InboundSource
+	for _, name := range names {
+		source, err := newInboundSource(name, cfg, cms)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+func newInboundSource(name string, cfg *configs.Config, cms *DirectusClient) (InboundSource, error) {
+	switch name {
+	case "trustmed":
+		return NewTrustMedSource(cfg, cms), nil
+	case "directus":
+		if cfg.InboundDirectusFolder == "" {
+			return nil, missingConfigError("INBOUND_DIRECTUS_FOLDER")
+		}
+		return &directusSource{cms: cms, cfg: cfg}, nil
+	case "directory":
+		if cfg.InboundDir == "" {
+			return nil, missingConfigError("INBOUND_DIR")
+		}
+		return &objectSource{name: name, store: dirStore(cfg.InboundDir), cms: cms, cfg: cfg}, nil
+	case "bucket":
+		if cfg.InboundBucket == "" {
+			return nil, missingConfigError("INBOUND_BUCKET")
+		}
+		return &objectSource{name: name, store: bucketStore{NewBucketClient(cfg)}, cms: cms, cfg: cfg}, nil
+	}
+	return nil, fmt.Errorf("unknown inbound source %q", name)
+}
+
+// trustMedSource is the TrustMed Dashboard: files sent to us by partners.
+type trustMedSource struct {
+	dashboard *TrustMedDashboardClient
+	cms       *DirectusClient
+	cfg       *configs.Config
+}
+
+// NewTrustMedSource returns the TrustMed Dashboard source.
+func NewTrustMedSource(cfg *configs.Config, cms *DirectusClient) InboundSource {
+	return &trustMedSource{dashboard: NewTrustMedDashboardClient(cfg), cms: cms, cfg: cfg}
+}
+
+func (s *trustMedSource) Name() string { return "trustmed" }
+
+func (s *trustMedSource) Poll(ctx context.Context) ([]types.XMLFile, error) {
+	return PollTrustMedFiles(ctx, s.dashboard, s.cms, s.cfg)
+}
+
+func (s *trustMedSource) PollWindow(ctx context.Context, since, until time.Time) ([]types.XMLFile, error) {
+	return PollTrustMedFilesWindow(ctx, s.dashboard, s.cms, s.cfg, since, until)
+}
+
+// directusSource is a Directus folder partners upload XML files to.
+type directusSource struct {
+	cms *DirectusClient
+	cfg *configs.Config
+}
+
+func (s *directusSource) Name() string { return "directus" }
+
+func (s *directusSource) Poll(ctx context.Context) ([]types.XMLFile, error) {
+	return PollXMLFiles(ctx, s.cms, s.cfg, s.cfg.InboundDirectusFolder)
+}
+
+func (s *directusSource) PollWindow(ctx context.Context, since, until time.Time) ([]types.XMLFile, error) {
+	return PollXMLFilesWindow(ctx, s.cms, s.cfg, s.cfg.InboundDirectusFolder, since, until)
+}
+
+// storedObject is a file in a directory or bucket.
+type storedObject struct {
+	Key      string
+	Modified time.Time
+}
+
+// objectStore lists and reads the files of a directory or bucket.
+type objectStore interface {
+	List(ctx context.Context) ([]storedObject, error)
+	Read(ctx context.Context, key string) ([]byte, error)
+}
+
+// dirStore is a local or mounted directory, including its subdirectories.
+type dirStore string
+
+func (d dirStore) List(ctx context.Context) ([]storedObject, error) {
+	var objects []storedObject
+	err := filepath.WalkDir(string(d), func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		key, err := filepath.Rel(string(d), p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, storedObject{Key: filepath.ToSlash(key), Modified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing directory %s: %w", string(d), err)
+	}
+	return objects, nil
+}
+
+func (d dirStore) Read(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), filepath.FromSlash(key)))
+}
+
+// bucketStore is an S3-compatible bucket.
+type bucketStore struct {
+	client *BucketClient
+}
+
+func (b bucketStore) List(ctx context.Context) ([]storedObject, error) {
+	listed, err := b.client.ListObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]storedObject, 0, len(listed))
+	for _, o := range listed {
+		objects = append(objects, storedObject{Key: o.Key, Modified: o.LastModified})
+	}
+	return objects, nil
+}
+
+func (b bucketStore) Read(ctx context.Context, key string) ([]byte, error) {
+	return b.client.GetObject(ctx, key)
+}
+
+// objectSource polls a directory or bucket for .xml files by modification
+// time and archives new ones to the Directus INPUT_XML folder. Its watermark
+// is inbound_<name>_watermark. Files modified exactly at the watermark or
+// within the overlap before it are listed again; the inbound ledger skips
+// those already processed. Failed files are counted in
+// inbound_<name>_retry_queue and hold the watermark back until they reach
+// cfg.InboundRetryMaxAttempts.
+type objectSource struct {
+	name  string
+	store objectStore
+	cms   *DirectusClient
+	cfg   *configs.Config
+}
+
+func (s *objectSource) Name() string { return s.name }
+
+func (s *objectSource) watermarkKey() string { return "inbound_" + s.name + "_watermark" }
+
+func (s *objectSource) retryQueueKey() string { return "inbound_" + s.name + "_retry_queue" }
+
+func (s *objectSource) Poll(ctx context.Context) ([]types.XMLFile, error) {
+	runlog.Info(ctx, "Polling inbound source", zap.String("source", s.name))
+
+	watermarkKey := s.watermarkKey()
+	watermark, err := GetWatermark(ctx, s.cms, watermarkKey)
+	if err != nil {
+		runlog.Warn(ctx, "Failed to get watermark, using default", zap.String("key", watermarkKey), zap.Error(err))
+	}
+
+	var since time.Time
+	if watermark == nil || watermark.LastCheckTimestamp.Time.IsZero() {
+		// No watermark - first run, go back 7 days
+		since = time.Now().Add(-7 * 24 * time.Hour)
+		runlog.Info(ctx, "No watermark found, using default lookback", zap.Time("since", since))
+	} else {
+		since = watermark.LastCheckTimestamp.Time.Add(-time.Duration(s.cfg.InboundWatermarkOverlap) * time.Second)
+		runlog.Info(ctx, "Using watermark", zap.Time("since", since))
+	}
+
+	files, next, err := s.fetch(ctx, since, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if !next.IsZero() {
+		if err := UpdateWatermark(ctx, s.cms, watermarkKey, next, len(files)); err != nil {
+			runlog.Warn(ctx, "Failed to update watermark", zap.String("key", watermarkKey), zap.Error(err))
+		}
+	}
+	return files, nil
+}
+
+func (s *objectSource) PollWindow(ctx context.Context, since, until time.Time) ([]types.XMLFile, error) {
+	if !since.Before(until) {
+		return nil, fmt.Errorf("since %s must be before until %s", since.Format(time.RFC3339), until.Format(time.RFC3339))
+	}
+	runlog.Info(ctx, "Polling inbound source in window (watermark unchanged)",
+		zap.String("source", s.name),
+		zap.Time("since", since),
+		zap.Time("until", until),
+	)
+	files, _, err := s.fetch(ctx, since, until)
+	return files, err
+}
+
+// fetch archives the .xml files modified in [since, until) (no upper bound
+// if until is zero) that are not already processed. It returns the time the
+// watermark can advance to: the newest file's, or the oldest failed file's
+// still under the attempt limit so it is listed again. The time is zero if
+// there were no files.
+func (s *objectSource) fetch(ctx context.Context, since, until time.Time) ([]types.XMLFile, time.Time, error) {
+	if s.cfg.FolderInputXML == "" {
+		return nil, time.Time{}, missingConfigError("DIRECTUS_FOLDER_INPUT_XML")
+	}
+
+	listed, err := s.store.List(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var objects []storedObject
+	for _, o := range listed {
+		if !strings.EqualFold(path.Ext(o.Key), ".xml") || o.Modified.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !o.Modified.Before(until) {
+			continue
+		}
+		objects = append(objects, o)
+	}
+	if len(objects) == 0 {
+		runlog.Info(ctx, "No new files found", zap.String("source", s.name))
+		return []types.XMLFile{}, time.Time{}, nil
+	}
+	slices.SortFunc(objects, func(a, b storedObject) int { return a.Modified.Compare(b.Modified) })
+	runlog.Info(ctx, "Found files", zap.String("source", s.name), zap.Int("count", len(objects)))
+
+	queue, err := loadRetryQueue(ctx, s.cms, s.retryQueueKey())
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var xmlFiles []types.XMLFile
+	var firstFailed, firstRetry time.Time
+	failedCount := 0
+	skippedCount := 0
+	fail := func(o storedObject, msg string, err error) {
+		attempts := queue.FailedKey(o.Key, o.Modified, err)
+		runlog.Error(ctx, msg, zap.String("key", o.Key), zap.Int("attempts", attempts), zap.Error(err))
+		failedCount++
+		if firstFailed.IsZero() || o.Modified.Before(firstFailed) {
+			firstFailed = o.Modified
+		}
+		if attempts < s.cfg.InboundRetryMaxAttempts {
+			if firstRetry.IsZero() || o.Modified.Before(firstRetry) {
+				firstRetry = o.Modified
+			}
+		} else {
+			runlog.Error(ctx, "File reached the retry limit and will not be retried automatically",
+				zap.String("key", o.Key),
+				zap.Int("attempts", attempts),
+			)
+		}
+	}
+
+	// Read each file, then skip documents already processed by content hash
+	type download struct {
+		object  storedObject
+		content []byte
+		hash    string
+	}
+	var downloads []download
+	for i, o := range objects {
+		if err := ctx.Err(); err != nil {
+			return nil, time.Time{}, fmt.Errorf("reading %s files: %w", s.name, err)
+		}
+		progress.Report(ctx, i+1, len(objects), "downloading")
+
+		content, err := s.store.Read(ctx, o.Key)
+		if err != nil {
+			fail(o, "Failed to read file", err)
+			continue
+		}
+		downloads = append(downloads, download{object: o, content: content, hash: ContentHash(content)})
+	}
+
+	hashes := make([]string, len(downloads))
+	for i, d := range downloads {
+		hashes[i] = d.hash
+	}
+	ledger, err := LoadInboundLedger(ctx, s.cms, LedgerKeys{Hashes: hashes})
+	if err != nil {
+		runlog.Warn(ctx, "Failed to load inbound ledger, archiving all files", zap.Error(err))
+		ledger = NewInboundLedger()
+	}
+
+	for i, d := range downloads {
+		if err := ctx.Err(); err != nil {
+			return nil, time.Time{}, fmt.Errorf("archiving %s files: %w", s.name, err)
+		}
+		o, content, hash := d.object, d.content, d.hash
+		progress.Report(ctx, i+1, len(downloads), "archiving")
+
+		if ledger.HasHash(hash) {
+			runlog.Info(ctx, "Skipping file with already processed content",
+				zap.String("key", o.Key),
+				zap.String("content_hash", hash),
+			)
+			queue.RemoveKey(o.Key)
+			skippedCount++
+			continue
+		}
+
+		filename := fmt.Sprintf("%s_%s", s.name, path.Base(o.Key))
+		result, err := s.cms.UploadFile(ctx, UploadFileParams{
+			Filename:    filename,
+			Content:     content,
+			FolderID:    s.cfg.FolderInputXML,
+			Title:       fmt.Sprintf("Inbound %s - %s", s.name, o.Key),
+			ContentType: "application/xml",
+		})
+		if err != nil {
+			fail(o, "Failed to upload file to Directus", err)
+			continue
+		}
+		queue.RemoveKey(o.Key)
+
+		xmlFiles = append(xmlFiles, types.XMLFile{
+			ID:          result.ID,
+			Filename:    filename,
+			Content:     content,
+			Uploaded:    time.Now(),
+			ContentHash: hash,
+		})
+		// Same document again later in this poll
+		ledger.Add("", hash)
+
+		runlog.Info(ctx, "Archived file to Directus",
+			zap.String("key", o.Key),
+			zap.String("directus_file_id", result.ID),
+		)
+	}
+
+	queueErr := queue.Save(ctx, s.cms)
+	if queueErr != nil {
+		runlog.Warn(ctx, "Failed to save retry queue", zap.String("source", s.name), zap.Error(queueErr))
+	}
+
+	// Check failure threshold
+	failureRate := float64(failedCount) / float64(len(objects))
+	if failureRate > s.cfg.FailureThreshold {
+		return nil, time.Time{}, fmt.Errorf("failure rate %.0f%% exceeds threshold %.0f%%",
+			failureRate*100, s.cfg.FailureThreshold*100)
+	}
+
+	runlog.Info(ctx, "Successfully polled inbound source",
+		zap.String("source", s.name),
+		zap.Int("archived", len(xmlFiles)),
+		zap.Int("failed", failedCount),
+		zap.Int("skipped", skippedCount),
+	)
+
+	// Without a saved attempt count every failed file is retried
+	next := objects[len(objects)-1].Modified
+	switch {
+	case queueErr != nil && failedCount > 0:
+		next = firstFailed
+	case !firstRetry.IsZero():
+		next = firstRetry
+	}
+	return xmlFiles, next, nil
+}
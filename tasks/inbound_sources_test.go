@@ -0,0 +1,236 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+)
+
+// newInboundDirectus serves global_config from store, an inbox holding one
+// processed document, and file uploads, recording the uploaded filenames.
+func newInboundDirectus(t *testing.T, store *fakeGlobalConfig, processed []byte) (*httptest.Server, *[]string) {
+	var uploaded []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/items/global_config"):
+			store.serve(w, r)
+		case r.URL.Path == "/items/epcis_inbox":
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal([]map[string]interface{}{
+				{"content_hash": ContentHash(processed)},
+			})})
+		case r.URL.Path == "/files":
+			require.NoError(t, r.ParseMultipartForm(1<<20))
+			_, header, err := r.FormFile("file")
+			require.NoError(t, err)
+			uploaded = append(uploaded, header.Filename)
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal(UploadFileResult{ID: "file-" + header.Filename})})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	return server, &uploaded
+}
+
+func TestDirectorySource(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	write := func(name, content string, modified time.Time) {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+		require.NoError(t, os.Chtimes(p, modified, modified))
+	}
+	write("old.xml", "<old/>", now.Add(-2*time.Hour))
+	write("processed.xml", "<processed/>", now.Add(-30*time.Minute))
+	write("new.xml", "<new/>", now.Add(-20*time.Minute))
+	write("partner/upload.XML", "<upload/>", now.Add(-10*time.Minute))
+	write("notes.txt", "not a document", now.Add(-10*time.Minute))
+
+	watermark, err := json.Marshal(Watermark{LastCheckTimestamp: WatermarkTime{Time: now.Add(-time.Hour)}})
+	require.NoError(t, err)
+	store := &fakeGlobalConfig{values: map[string]json.RawMessage{"inbound_directory_watermark": watermark}}
+	server, uploaded := newInboundDirectus(t, store, []byte("<processed/>"))
+	defer server.Close()
+
+	cfg := &configs.Config{
+		InboundSources:   []string{"directory"},
+		InboundDir:       dir,
+		FolderInputXML:   "folder-1",
+		FailureThreshold: 0.5,
+	}
+	sources, err := NewInboundSources(cfg, NewDirectusClient(server.URL, "test-key"))
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+	assert.Equal(t, "directory", sources[0].Name())
+
+	files, err := sources[0].Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"directory_new.xml", "directory_upload.XML"}, *uploaded)
+	require.Len(t, files, 2)
+	assert.Equal(t, "file-directory_new.xml", files[0].ID)
+	assert.Equal(t, ContentHash([]byte("<new/>")), files[0].ContentHash)
+	assert.True(t, store.watermarkAt(t, "inbound_directory_watermark").Equal(now.Add(-10*time.Minute)),
+		"watermark advances to the newest file")
+
+	// A window ignores the watermark, which stays put
+	*uploaded = nil
+	files, err = sources[0].PollWindow(context.Background(), now.Add(-3*time.Hour), now.Add(-90*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"directory_old.xml"}, *uploaded)
+	assert.Len(t, files, 1)
+	assert.True(t, store.watermarkAt(t, "inbound_directory_watermark").Equal(now.Add(-10*time.Minute)))
+}
+
+// fakeBucket serves a ListObjectsV2 listing, one object per page, and the
+// objects' content.
+type fakeBucket struct {
+	objects  map[string]string
+	modified map[string]time.Time
+	keys     []string
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-access/") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	if r.URL.Path == "/inbound" && r.URL.Query().Get("list-type") == "2" {
+		i := 0
+		if token := r.URL.Query().Get("continuation-token"); token != "" {
+			i = len(strings.Split(token, ","))
+		}
+		key := b.keys[i]
+		page := listBucketResult{
+			Contents:    []BucketObject{{Key: key, LastModified: b.modified[key]}},
+			IsTruncated: i+1 < len(b.keys),
+		}
+		if page.IsTruncated {
+			page.NextContinuationToken = strings.Join(b.keys[:i+1], ",")
+		}
+		xml.NewEncoder(w).Encode(page)
+		return
+	}
+	if content, ok := b.objects[strings.TrimPrefix(r.URL.Path, "/inbound/")]; ok {
+		w.Write([]byte(content))
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func TestBucketSource(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	bucket := &fakeBucket{
+		objects: map[string]string{
+			"partners/a file.xml": "<a/>",
+			"partners/b.xml":      "<b/>",
+			"partners/readme.md":  "not a document",
+		},
+		modified: map[string]time.Time{
+			"partners/a file.xml": now.Add(-2 * time.Hour),
+			"partners/b.xml":      now.Add(-time.Hour),
+			"partners/readme.md":  now.Add(-time.Hour),
+		},
+		keys: []string{"partners/a file.xml", "partners/b.xml", "partners/readme.md"},
+	}
+	s3 := httptest.NewServer(bucket)
+	defer s3.Close()
+
+	store := &fakeGlobalConfig{values: map[string]json.RawMessage{}}
+	server, uploaded := newInboundDirectus(t, store, nil)
+	defer server.Close()
+
+	cfg := &configs.Config{
+		InboundSources:         []string{"bucket"},
+		InboundBucketEndpoint:  s3.URL,
+		InboundBucket:          "inbound",
+		InboundBucketPrefix:    "partners/",
+		InboundBucketRegion:    "auto",
+		InboundBucketAccessKey: "test-access",
+		InboundBucketSecretKey: "test-secret",
+		FolderInputXML:         "folder-1",
+		FailureThreshold:       0.5,
+	}
+	sources, err := NewInboundSources(cfg, NewDirectusClient(server.URL, "test-key"))
+	require.NoError(t, err)
+
+	files, err := sources[0].Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bucket_a file.xml", "bucket_b.xml"}, *uploaded, "every page is listed, oldest first")
+	require.Len(t, files, 2)
+	assert.Equal(t, []byte("<a/>"), files[0].Content)
+	assert.True(t, store.watermarkAt(t, "inbound_bucket_watermark").Equal(now.Add(-time.Hour)))
+}
+
+func TestBucketSourceRetryLimit(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	bucket := &fakeBucket{
+		objects: map[string]string{"partners/good.xml": "<good/>"},
+		modified: map[string]time.Time{
+			"partners/bad.xml":  now.Add(-2 * time.Hour),
+			"partners/good.xml": now.Add(-time.Hour),
+		},
+		keys: []string{"partners/bad.xml", "partners/good.xml"},
+	}
+	s3 := httptest.NewServer(bucket)
+	defer s3.Close()
+
+	store := &fakeGlobalConfig{values: map[string]json.RawMessage{}}
+	server, _ := newInboundDirectus(t, store, nil)
+	defer server.Close()
+
+	cfg := &configs.Config{
+		InboundSources:          []string{"bucket"},
+		InboundBucketEndpoint:   s3.URL,
+		InboundBucket:           "inbound",
+		InboundBucketRegion:     "auto",
+		InboundBucketAccessKey:  "test-access",
+		InboundBucketSecretKey:  "test-secret",
+		FolderInputXML:          "folder-1",
+		FailureThreshold:        0.5,
+		InboundRetryMaxAttempts: 2,
+	}
+	sources, err := NewInboundSources(cfg, NewDirectusClient(server.URL, "test-key"))
+	require.NoError(t, err)
+
+	// The missing object holds the watermark back so it is listed again
+	files, err := sources[0].Poll(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.True(t, store.watermarkAt(t, "inbound_bucket_watermark").Equal(now.Add(-2*time.Hour)))
+	queue := store.queueAt(t, "inbound_bucket_retry_queue")
+	require.Len(t, queue, 1)
+	assert.Equal(t, "partners/bad.xml", queue[0].Key)
+	assert.Equal(t, 1, queue[0].Attempts)
+
+	// At the attempt limit it stays queued but no longer holds the watermark
+	_, err = sources[0].Poll(context.Background())
+	require.NoError(t, err)
+	assert.True(t, store.watermarkAt(t, "inbound_bucket_watermark").Equal(now.Add(-time.Hour)))
+	queue = store.queueAt(t, "inbound_bucket_retry_queue")
+	require.Len(t, queue, 1)
+	assert.Equal(t, 2, queue[0].Attempts)
+}
+
+func TestNewInboundSourcesMissingConfig(t *testing.T) {
+	for source, setting := range map[string]string{
+		"directus":  "INBOUND_DIRECTUS_FOLDER",
+		"directory": "INBOUND_DIR",
+		"bucket":    "INBOUND_BUCKET",
+	} {
+		_, err := NewInboundSources(&configs.Config{InboundSources: []string{source}}, nil)
+		assert.ErrorContains(t, err, setting, source)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
-	"github.com/trackvision/tv-shared-go/logger"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
 	"go.uber.org/zap"
 )
 
@@ -35,10 +35,9 @@ type DispatchRecord struct {
 // - Are not already successfully dispatched (not Acknowledged/Sent)
 // - Include failed records eligible for retry (attempt count < max)
 func PollApprovedShipments(ctx context.Context, cms *DirectusClient, cfg *configs.Config) ([]ApprovedShipment, error) {
-	logger.Info("Polling approved shipments for outbound dispatch")
+	runlog.Info(ctx, "Polling approved shipments for outbound dispatch")
 
 	batchSize := cfg.DispatchBatchSize
-	maxAttempts := cfg.DispatchMaxRetries
 
 	// Query approved shipments
 	filter := map[string]interface{}{
@@ -52,7 +51,67 @@ func PollApprovedShipments(ctx context.Context, cms *DirectusClient, cfg *config
 		return nil, fmt.Errorf("querying approved shipments: %w", err)
 	}
 
-	logger.Info("Found approved shipments", zap.Int("count", len(approvedShipments)))
+	runlog.Info(ctx, "Found approved shipments", zap.Int("count", len(approvedShipments)))
+
+	return selectForDispatch(ctx, cms, cfg, approvedShipments, batchSize)
+}
+
+// PollShipmentsByID loads specific approved shipments by capture ID or
+// shipping operation ID, bypassing the dispatch batch size. The same retry
+// rules as PollApprovedShipments apply, so already dispatched shipments are
+// skipped. IDs that match no approved shipment are logged and ignored.
+func PollShipmentsByID(ctx context.Context, cms *DirectusClient, cfg *configs.Config, captureIDs, shipOpIDs []string) ([]ApprovedShipment, error) {
+	runlog.Info(ctx, "Loading requested shipments for outbound dispatch",
+		zap.Strings("capture_ids", captureIDs),
+		zap.Strings("shipping_operation_ids", shipOpIDs),
+	)
+
+	var match []interface{}
+	if len(captureIDs) > 0 {
+		match = append(match, map[string]interface{}{"capture_id": map[string]interface{}{"_in": captureIDs}})
+	}
+	if len(shipOpIDs) > 0 {
+		match = append(match, map[string]interface{}{"id": map[string]interface{}{"_in": shipOpIDs}})
+	}
+	if len(match) == 0 {
+		return []ApprovedShipment{}, nil
+	}
+
+	filter := map[string]interface{}{
+		"_and": []interface{}{
+			map[string]interface{}{"status": map[string]interface{}{"_eq": "approved"}},
+			map[string]interface{}{"_or": match},
+		},
+	}
+
+	requested := len(captureIDs) + len(shipOpIDs)
+	shipments, err := cms.QueryItems(ctx, "shipping_scanning_operation", filter, []string{"id", "capture_id", "status"}, requested)
+	if err != nil {
+		return nil, fmt.Errorf("querying requested shipments: %w", err)
+	}
+
+	found := make(map[string]bool, len(shipments)*2)
+	for _, s := range shipments {
+		id, _ := s["id"].(string)
+		captureID, _ := s["capture_id"].(string)
+		found[id] = true
+		found[captureID] = true
+	}
+	for _, id := range append(append([]string{}, captureIDs...), shipOpIDs...) {
+		if !found[id] {
+			runlog.Warn(ctx, "Requested shipment not found or not approved", zap.String("id", id))
+		}
+	}
+
+	runlog.Info(ctx, "Found requested shipments", zap.Int("count", len(shipments)), zap.Int("requested", requested))
+
+	return selectForDispatch(ctx, cms, cfg, shipments, len(shipments))
+}
+
+// selectForDispatch looks up dispatch records for shipments and returns up to
+// limit shipments that still need a dispatch attempt.
+func selectForDispatch(ctx context.Context, cms *DirectusClient, cfg *configs.Config, approvedShipments []map[string]interface{}, limit int) ([]ApprovedShipment, error) {
+	maxAttempts := cfg.DispatchMaxRetries
 
 	if len(approvedShipments) == 0 {
 		return []ApprovedShipment{}, nil
@@ -76,7 +135,7 @@ func PollApprovedShipments(ctx context.Context, cms *DirectusClient, cfg *config
 		return nil, fmt.Errorf("querying dispatch records: %w", err)
 	}
 
-	logger.Info("Found dispatch records", zap.Int("count", len(dispatchRecords)))
+	runlog.Info(ctx, "Found dispatch records", zap.Int("count", len(dispatchRecords)))
 
 	// Build lookup map
 	dispatchLookup := make(map[string]DispatchRecord)
@@ -118,12 +177,12 @@ func PollApprovedShipments(ctx context.Context, cms *DirectusClient, cfg *config
 	for _, shipment := range approvedShipments {
 		shipOpID, ok := shipment["id"].(string)
 		if !ok || shipOpID == "" {
-			logger.Warn("Skipping shipment with missing ID")
+			runlog.Warn(ctx, "Skipping shipment with missing ID")
 			continue
 		}
 		captureID, _ := shipment["capture_id"].(string)
 		if captureID == "" {
-			logger.Warn("Skipping shipment with missing capture_id", zap.String("id", shipOpID))
+			runlog.Warn(ctx, "Skipping shipment with missing capture_id", zap.String("id", shipOpID))
 			continue
 		}
 
@@ -180,22 +239,22 @@ func PollApprovedShipments(ctx context.Context, cms *DirectusClient, cfg *config
 		}
 
 		// Stop if we have enough
-		if len(results) >= batchSize {
+		if len(results) >= limit {
 			break
 		}
 	}
 
 	// Log summary
 	if len(results) == 0 {
-		logger.Info("No shipments to process",
+		runlog.Info(ctx, "No shipments to process",
 			zap.Int("skipped_acknowledged", skippedAcknowledged),
 			zap.Int("skipped_sent", skippedSent),
 			zap.Int("skipped_max_retries", skippedMaxRetries),
 		)
 	} else {
-		logger.Info("Dispatching shipments",
+		runlog.Info(ctx, "Dispatching shipments",
 			zap.Int("count", len(results)),
-			zap.Int("batch_size", batchSize),
+			zap.Int("batch_size", limit),
 			zap.Int("skipped_acknowledged", skippedAcknowledged),
 			zap.Int("skipped_sent", skippedSent),
 			zap.Int("skipped_max_retries", skippedMaxRetries),
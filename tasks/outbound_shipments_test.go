@@ -2,6 +2,9 @@ package tasks
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -70,3 +73,37 @@ func TestPollApprovedShipments(t *testing.T) {
 	// Should return a list (may be empty)
 	t.Logf("Found %d approved shipments", len(shipments))
 }
+
+func TestPollShipmentsByIDBypassesBatchSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/items/shipping_scanning_operation":
+			filter := r.URL.Query().Get("filter")
+			if !strings.Contains(filter, `"capture_id":{"_in":["cap-1","cap-2"]}`) || !strings.Contains(filter, `"id":{"_in":["op-3"]}`) {
+				t.Errorf("unexpected filter %s", filter)
+			}
+			w.Write([]byte(`{"data": [
+				{"id": "op-1", "capture_id": "cap-1", "status": "approved"},
+				{"id": "op-2", "capture_id": "cap-2", "status": "approved"},
+				{"id": "op-3", "capture_id": "cap-3", "status": "approved"}
+			]}`))
+		case "/items/EPCIS_outbound":
+			w.Write([]byte(`{"data": [
+				{"id": 7, "shipping_operation_id": "op-2", "status": "Acknowledged", "dispatch_attempt_count": 1}
+			]}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	cms := NewDirectusClient(server.URL, "test-key")
+	cfg := &configs.Config{DispatchBatchSize: 1, DispatchMaxRetries: 3}
+
+	shipments, err := PollShipmentsByID(context.Background(), cms, cfg, []string{"cap-1", "cap-2"}, []string{"op-3"})
+	assert.NoError(t, err)
+	assert.Len(t, shipments, 2)
+	assert.Equal(t, "op-1", shipments[0].ShippingOperationID)
+	assert.Equal(t, "op-3", shipments[1].ShippingOperationID)
+}
@@ -0,0 +1,110 @@
+package tasks
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+)
+
+// CheckAuth verifies the Directus API key by fetching the current user.
+func (d *DirectusClient) CheckAuth(ctx context.Context) error {
+	url := fmt.Sprintf("%s/users/me", d.BaseURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+d.APIKey)
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("directus request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("directus rejected API key (status %d)", resp.StatusCode)
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("directus returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CheckAuth verifies the Dashboard credentials by obtaining (or reusing a
+// cached) JWT access token.
+func (c *TrustMedDashboardClient) CheckAuth(ctx context.Context) error {
+	_, err := c.getToken(ctx)
+	return err
+}
+
+// CertInfo describes the TrustMed mTLS client certificate and CA bundle.
+type CertInfo struct {
+	Subject         string    `json:"subject"`
+	Issuer          string    `json:"issuer"`
+	NotAfter        time.Time `json:"not_after"`
+	DaysUntilExpiry int       `json:"days_until_expiry"`
+	CACount         int       `json:"ca_count"`
+	CANotAfter      time.Time `json:"ca_not_after"`
+}
+
+// InspectTrustMedCerts loads the mTLS client certificate, key and CA the
+// same way NewTrustMedClient does and reports when they expire. It fails if
+// any file is missing or unparseable, the key does not match the
+// certificate, or the client certificate has already expired.
+func InspectTrustMedCerts(cfg *configs.Config, now time.Time) (*CertInfo, error) {
+	pair, err := tls.LoadX509KeyPair(cfg.TrustMedCertFile, cfg.TrustMedKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading client certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing client certificate: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(cfg.TrustMedCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificate: %w", err)
+	}
+	info := &CertInfo{
+		Subject:         leaf.Subject.String(),
+		Issuer:          leaf.Issuer.String(),
+		NotAfter:        leaf.NotAfter,
+		DaysUntilExpiry: int(leaf.NotAfter.Sub(now).Hours() / 24),
+	}
+	for rest := caPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing CA certificate: %w", err)
+		}
+		info.CACount++
+		if info.CANotAfter.IsZero() || ca.NotAfter.Before(info.CANotAfter) {
+			info.CANotAfter = ca.NotAfter
+		}
+	}
+	if info.CACount == 0 {
+		return nil, fmt.Errorf("no certificates found in CA file %s", cfg.TrustMedCAFile)
+	}
+
+	if now.After(leaf.NotAfter) {
+		return info, fmt.Errorf("client certificate expired on %s", leaf.NotAfter.Format(time.DateOnly))
+	}
+	return info, nil
+}
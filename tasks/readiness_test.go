@@ -0,0 +1,124 @@
+package tasks
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trackvision/tv-pipelines-hudsci/configs"
+)
+
+// writeTestCert writes a self-signed certificate and key valid until
+// notAfter and returns a config pointing at them (the cert doubles as CA).
+func writeTestCert(t *testing.T, notAfter time.Time) *configs.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hudsci-test"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	cfg := &configs.Config{
+		TrustMedCertFile: filepath.Join(dir, "client.crt"),
+		TrustMedKeyFile:  filepath.Join(dir, "client.key"),
+		TrustMedCAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for path, data := range map[string][]byte{
+		cfg.TrustMedCertFile: certPEM,
+		cfg.TrustMedKeyFile:  keyPEM,
+		cfg.TrustMedCAFile:   certPEM,
+	} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	return cfg
+}
+
+func TestInspectTrustMedCerts(t *testing.T) {
+	now := time.Now()
+	cfg := writeTestCert(t, now.Add(45*24*time.Hour+time.Hour))
+
+	info, err := InspectTrustMedCerts(cfg, now)
+	if err != nil {
+		t.Fatalf("InspectTrustMedCerts() error = %v", err)
+	}
+	if info.DaysUntilExpiry != 45 {
+		t.Errorf("DaysUntilExpiry = %d, want 45", info.DaysUntilExpiry)
+	}
+	if info.CACount != 1 || !strings.Contains(info.Subject, "hudsci-test") {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestInspectTrustMedCertsExpired(t *testing.T) {
+	now := time.Now()
+	cfg := writeTestCert(t, now.Add(-24*time.Hour))
+
+	info, err := InspectTrustMedCerts(cfg, now)
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("InspectTrustMedCerts() error = %v, want expired", err)
+	}
+	if info == nil || info.DaysUntilExpiry >= 0 {
+		t.Errorf("info = %+v, want negative days until expiry", info)
+	}
+}
+
+func TestInspectTrustMedCertsMissingFile(t *testing.T) {
+	cfg := writeTestCert(t, time.Now().Add(24*time.Hour))
+	cfg.TrustMedKeyFile = filepath.Join(t.TempDir(), "missing.key")
+
+	if _, err := InspectTrustMedCerts(cfg, time.Now()); err == nil {
+		t.Fatal("Expected error for missing key file")
+	}
+}
+
+func TestDirectusCheckAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/me" {
+			t.Errorf("path = %s, want /users/me", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"id":"u1"}}`))
+	}))
+	defer server.Close()
+
+	if err := NewDirectusClient(server.URL, "good-key").CheckAuth(context.Background()); err != nil {
+		t.Errorf("CheckAuth() with valid key error = %v", err)
+	}
+	err := NewDirectusClient(server.URL, "bad-key").CheckAuth(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("CheckAuth() with bad key error = %v, want rejected", err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
 	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 
@@ -60,7 +61,7 @@ func ConnectTiDB(cfg *configs.Config) (*sqlx.DB, error) {
 //
 // This query handles both direct shipping (item -> ship) and hierarchical shipping (item -> pack -> ship).
 func QueryShipmentEventsByCaptureID(ctx context.Context, db *sqlx.DB, captureID string) ([]EventRow, error) {
-	logger.Info("Querying shipment events", zap.String("capture_id", captureID))
+	runlog.Info(ctx, "Querying shipment events", zap.String("capture_id", captureID))
 
 	// Hierarchical CTE query from the migration plan
 	query := `
@@ -124,7 +125,7 @@ LIMIT 1000`
 		return nil, fmt.Errorf("querying shipment events: %w", err)
 	}
 
-	logger.Info("Found events",
+	runlog.Info(ctx, "Found events",
 		zap.String("capture_id", captureID),
 		zap.Int("count", len(events)),
 	)
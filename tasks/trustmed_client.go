@@ -9,10 +9,14 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/metrics"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
 	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 )
@@ -113,8 +117,10 @@ func NewTrustMedClient(cfg *configs.Config) (*TrustMedClient, error) {
 }
 
 // SubmitEPCIS submits an EPCIS XML document to TrustMed Partner API
-func (c *TrustMedClient) SubmitEPCIS(ctx context.Context, xmlContent string) (*TrustMedSubmitResponse, error) {
-	logger.Info("Submitting EPCIS XML to TrustMed",
+func (c *TrustMedClient) SubmitEPCIS(ctx context.Context, xmlContent string) (_ *TrustMedSubmitResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "trustmed.SubmitEPCIS")
+	defer func() { tracing.End(span, err) }()
+	runlog.Info(ctx, "Submitting EPCIS XML to TrustMed",
 		zap.String("endpoint", c.endpoint),
 		zap.Int("xml_size", len(xmlContent)),
 	)
@@ -130,12 +136,15 @@ func (c *TrustMedClient) SubmitEPCIS(ctx context.Context, xmlContent string) (*T
 	// Execute mTLS request
 	startTime := time.Now()
 	resp, err := c.httpClient.Do(req)
+	metrics.TrustMedSubmitDuration.Observe(time.Since(startTime).Seconds())
 	if err != nil {
+		metrics.TrustMedSubmissions.Inc("error")
 		return nil, fmt.Errorf("mTLS request failed: %w", err)
 	}
+	metrics.TrustMedSubmissions.Inc(strconv.Itoa(resp.StatusCode))
 	defer func() {
 		if cerr := resp.Body.Close(); cerr != nil {
-			logger.Warn("Failed to close response body", zap.Error(cerr))
+			runlog.Warn(ctx, "Failed to close response body", zap.Error(cerr))
 		}
 	}()
 
@@ -149,7 +158,7 @@ func (c *TrustMedClient) SubmitEPCIS(ctx context.Context, xmlContent string) (*T
 
 	// Handle non-2xx status codes
 	if resp.StatusCode >= 400 {
-		logger.Error("TrustMed submission failed",
+		runlog.Error(ctx, "TrustMed submission failed",
 			zap.Int("status", resp.StatusCode),
 			zap.String("response", string(body)),
 			zap.Duration("duration", duration),
@@ -163,7 +172,8 @@ func (c *TrustMedClient) SubmitEPCIS(ctx context.Context, xmlContent string) (*T
 		return nil, fmt.Errorf("parsing response JSON: %w", err)
 	}
 
-	logger.Info("Successfully submitted to TrustMed",
+	span.SetAttributes(tracing.TrustMedUUID.String(result.ID))
+	runlog.Info(ctx, "Successfully submitted to TrustMed",
 		zap.Int("status", resp.StatusCode),
 		zap.String("transaction_id", result.ID),
 		zap.Time("created_at", result.CreatedAt.Time),
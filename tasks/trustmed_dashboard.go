@@ -3,6 +3,7 @@ package tasks
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,7 +11,10 @@ import (
 	"time"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
+	"github.com/trackvision/tv-pipelines-hudsci/tracing"
 	"github.com/trackvision/tv-shared-go/logger"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -101,7 +105,7 @@ func (c *TrustMedDashboardClient) getToken(ctx context.Context) (string, error)
 		return c.token, nil
 	}
 
-	logger.Info("Requesting new JWT access token from TrustMed Dashboard")
+	runlog.Info(ctx, "Requesting new JWT access token from TrustMed Dashboard")
 
 	url := fmt.Sprintf("%s/token", c.dashboardURL)
 
@@ -144,7 +148,7 @@ func (c *TrustMedDashboardClient) getToken(ctx context.Context) (string, error)
 	c.token = tokenResp.AccessToken
 	c.tokenExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn-60) * time.Second)
 
-	logger.Info("Successfully obtained JWT access token",
+	runlog.Info(ctx, "Successfully obtained JWT access token",
 		zap.Time("expires_at", c.tokenExpiry),
 	)
 
@@ -164,8 +168,10 @@ func (c *TrustMedDashboardClient) getAuthHeaders(ctx context.Context) (map[strin
 }
 
 // SearchFiles searches for EPCIS files in the specified date range
-func (c *TrustMedDashboardClient) SearchFiles(ctx context.Context, startDate, endDate time.Time, page int) (*FileSearchResponse, error) {
-	logger.Info("Searching TrustMed Dashboard for files",
+func (c *TrustMedDashboardClient) SearchFiles(ctx context.Context, startDate, endDate time.Time, page int) (_ *FileSearchResponse, err error) {
+	ctx, span := tracing.StartClient(ctx, "dashboard.SearchFiles", attribute.Int("page", page))
+	defer func() { tracing.End(span, err) }()
+	runlog.Info(ctx, "Searching TrustMed Dashboard for files",
 		zap.Time("start_date", startDate),
 		zap.Time("end_date", endDate),
 		zap.Int("page", page),
@@ -209,7 +215,7 @@ func (c *TrustMedDashboardClient) SearchFiles(ctx context.Context, startDate, en
 		return nil, fmt.Errorf("decoding search response: %w", err)
 	}
 
-	logger.Info("Search completed",
+	runlog.Info(ctx, "Search completed",
 		zap.Int("count", searchResp.Count),
 		zap.Int("results", len(searchResp.Results)),
 	)
@@ -221,7 +227,7 @@ func (c *TrustMedDashboardClient) SearchFiles(ctx context.Context, startDate, en
 // The Partner API UUID appears in the source_file path: {uuid}/api-xml/{date}.xml
 // The Dashboard logGuid is different from the Partner API UUID
 func (c *TrustMedDashboardClient) GetFileStatus(ctx context.Context, partnerUUID string) (*FileRecord, error) {
-	logger.Info("Getting file status from TrustMed Dashboard",
+	runlog.Info(ctx, "Getting file status from TrustMed Dashboard",
 		zap.String("partner_uuid", partnerUUID),
 	)
 
@@ -241,7 +247,7 @@ func (c *TrustMedDashboardClient) GetFileStatus(ctx context.Context, partnerUUID
 		// source_file format: {partner_uuid}/api-xml/{date}.xml
 		for _, record := range searchResp.Results {
 			if strings.HasPrefix(record.SourceFile, partnerUUID+"/") {
-				logger.Info("Found file status",
+				runlog.Info(ctx, "Found file status",
 					zap.String("partner_uuid", partnerUUID),
 					zap.String("dashboard_log_guid", record.LogGuid),
 					zap.String("source_file", record.SourceFile),
@@ -266,7 +272,7 @@ func (c *TrustMedDashboardClient) GetFileStatus(ctx context.Context, partnerUUID
 // Returns DispatchStatus with mapped status from TrustMed Dashboard API
 // partnerUUID is the UUID returned by the Partner API (mTLS dispatch)
 func (c *TrustMedDashboardClient) PollDispatchConfirmation(ctx context.Context, partnerUUID string) (*DispatchStatus, error) {
-	logger.Info("Polling dispatch confirmation",
+	runlog.Info(ctx, "Polling dispatch confirmation",
 		zap.String("partner_uuid", partnerUUID),
 	)
 
@@ -279,7 +285,7 @@ func (c *TrustMedDashboardClient) PollDispatchConfirmation(ctx context.Context,
 	// Note: record.Status is numeric (e.g., 4=Complete), record.StatusMsg is string (e.g., "Complete")
 	status := mapTrustMedStatus(record.Status, record.StatusMsg)
 
-	logger.Info("Dispatch status retrieved",
+	runlog.Info(ctx, "Dispatch status retrieved",
 		zap.String("partner_uuid", partnerUUID),
 		zap.String("dashboard_log_guid", record.LogGuid),
 		zap.String("status", status.Status),
@@ -330,9 +336,20 @@ func mapTrustMedStatus(status int, statusMsg string) DispatchStatus {
 	return result
 }
 
-// SearchAllFiles searches for all EPCIS files in the date range, handling pagination
+// searchPageLimit caps the pages SearchAllFiles fetches, so a search that
+// never ends cannot loop forever.
+const searchPageLimit = 100
+
+// ErrSearchTruncated is returned by SearchAllFiles and the Directus file
+// listing, together with the results of the pages fetched, when they stop at
+// the page limit.
+var ErrSearchTruncated = errors.New("search stopped at the page limit")
+
+// SearchAllFiles searches for all EPCIS files in the date range, handling
+// pagination. If there are more than searchPageLimit pages it returns the
+// records found with an error wrapping ErrSearchTruncated.
 func (c *TrustMedDashboardClient) SearchAllFiles(ctx context.Context, startDate, endDate time.Time, receiverOnly bool) ([]FileRecord, error) {
-	logger.Info("Searching all TrustMed files with pagination",
+	runlog.Info(ctx, "Searching all TrustMed files with pagination",
 		zap.Time("start_date", startDate),
 		zap.Time("end_date", endDate),
 		zap.Bool("receiver_only", receiverOnly),
@@ -340,6 +357,7 @@ func (c *TrustMedDashboardClient) SearchAllFiles(ctx context.Context, startDate,
 
 	var allRecords []FileRecord
 	page := 1
+	truncated := false
 
 	for {
 		searchResp, err := c.SearchFiles(ctx, startDate, endDate, page)
@@ -360,26 +378,30 @@ func (c *TrustMedDashboardClient) SearchAllFiles(ctx context.Context, startDate,
 		if searchResp.Next == nil {
 			break
 		}
-		page++
 
 		// Safety limit to prevent infinite loops
-		if page > 100 {
-			logger.Warn("Reached pagination limit", zap.Int("pages", page))
+		if page >= searchPageLimit {
+			runlog.Warn(ctx, "Reached pagination limit", zap.Int("pages", page))
+			truncated = true
 			break
 		}
+		page++
 	}
 
-	logger.Info("Completed searching all files",
+	runlog.Info(ctx, "Completed searching all files",
 		zap.Int("total_records", len(allRecords)),
 		zap.Int("pages", page),
 	)
 
+	if truncated {
+		return allRecords, fmt.Errorf("%w after %d pages (%d records)", ErrSearchTruncated, page, len(allRecords))
+	}
 	return allRecords, nil
 }
 
 // GetDownloadURL gets a temporary download URL for a file (valid ~10 minutes)
 func (c *TrustMedDashboardClient) GetDownloadURL(ctx context.Context, logUUID string) (string, error) {
-	logger.Info("Getting download URL from TrustMed Dashboard",
+	runlog.Info(ctx, "Getting download URL from TrustMed Dashboard",
 		zap.String("log_uuid", logUUID),
 	)
 
@@ -419,7 +441,7 @@ func (c *TrustMedDashboardClient) GetDownloadURL(ctx context.Context, logUUID st
 	// Remove quotes from the URL string
 	downloadURL := strings.Trim(string(body), "\"")
 
-	logger.Info("Got temporary download URL",
+	runlog.Info(ctx, "Got temporary download URL",
 		zap.String("log_uuid", logUUID),
 	)
 
@@ -427,8 +449,10 @@ func (c *TrustMedDashboardClient) GetDownloadURL(ctx context.Context, logUUID st
 }
 
 // DownloadFile downloads file content using the two-step process
-func (c *TrustMedDashboardClient) DownloadFile(ctx context.Context, logUUID string) ([]byte, error) {
-	logger.Info("Downloading file from TrustMed",
+func (c *TrustMedDashboardClient) DownloadFile(ctx context.Context, logUUID string) (_ []byte, err error) {
+	ctx, span := tracing.StartClient(ctx, "dashboard.DownloadFile", tracing.TrustMedUUID.String(logUUID))
+	defer func() { tracing.End(span, err) }()
+	runlog.Info(ctx, "Downloading file from TrustMed",
 		zap.String("log_uuid", logUUID),
 	)
 
@@ -460,7 +484,7 @@ func (c *TrustMedDashboardClient) DownloadFile(ctx context.Context, logUUID stri
 		return nil, fmt.Errorf("reading file content: %w", err)
 	}
 
-	logger.Info("Successfully downloaded file",
+	runlog.Info(ctx, "Successfully downloaded file",
 		zap.String("log_uuid", logUUID),
 		zap.Int("size_bytes", len(content)),
 	)
@@ -2,13 +2,16 @@ package tasks
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/trackvision/tv-pipelines-hudsci/configs"
+	"github.com/trackvision/tv-pipelines-hudsci/progress"
+	"github.com/trackvision/tv-pipelines-hudsci/runlog"
 	"github.com/trackvision/tv-pipelines-hudsci/types"
-	"github.com/trackvision/tv-shared-go/logger"
 	"go.uber.org/zap"
 )
 
@@ -20,28 +23,45 @@ type TrustMedWatermark struct {
 }
 
 // PollTrustMedFiles polls the TrustMed Dashboard API for received XML files.
-// It downloads files that were sent TO us (inbound shipments) and archives them to Directus.
+// It downloads files that were sent TO us (inbound shipments) and archives
+// them to Directus, along with files that earlier polls queued for retry.
+//
+// Files that fail to download or archive go to the RetryQueue, and the
+// watermark only advances past files that were archived or queued. Each poll
+// searches from cfg.InboundWatermarkOverlap seconds before the watermark, to
+// allow for clock skew; files seen again are skipped by the inbound ledger.
+// If the search stops at its page limit, the files found are returned with
+// an error wrapping ErrSearchTruncated, and the watermark advances no further
+// than the search is known to be complete.
+//
+// The watermark is saved here, before insert_epcis_inbox runs. If the insert
+// then fails for good, the archived files are already behind the watermark
+// and out of the retry queue, so no later poll picks them up; re-pull them
+// with a since/until run over that window.
 func PollTrustMedFiles(ctx context.Context, dashboard *TrustMedDashboardClient, cms *DirectusClient, cfg *configs.Config) ([]types.XMLFile, error) {
-	logger.Info("Polling TrustMed Dashboard for received files")
+	runlog.Info(ctx, "Polling TrustMed Dashboard for received files")
 
 	// Get watermark from Directus
 	watermarkKey := "trustmed_inbound_watermark"
 	watermark, err := GetWatermark(ctx, cms, watermarkKey)
 	if err != nil {
-		logger.Warn("Failed to get TrustMed watermark, using default", zap.Error(err))
+		runlog.Warn(ctx, "Failed to get TrustMed watermark, using default", zap.Error(err))
 	}
 
 	// Determine start date from watermark
-	var startDate time.Time
+	var from, startDate time.Time
 	if watermark == nil || watermark.LastCheckTimestamp.Time.IsZero() {
 		// No watermark - first run, go back 7 days
-		startDate = time.Now().Add(-7 * 24 * time.Hour)
-		logger.Info("No TrustMed watermark found, using default lookback",
+		from = time.Now().Add(-7 * 24 * time.Hour)
+		startDate = from
+		runlog.Info(ctx, "No TrustMed watermark found, using default lookback",
 			zap.Time("since", startDate),
 		)
 	} else {
-		startDate = watermark.LastCheckTimestamp.Time
-		logger.Info("Using TrustMed watermark",
+		from = watermark.LastCheckTimestamp.Time
+		startDate = from.Add(-time.Duration(cfg.InboundWatermarkOverlap) * time.Second)
+		runlog.Info(ctx, "Using TrustMed watermark",
+			zap.Time("watermark", from),
 			zap.Time("since", startDate),
 			zap.Int("previous_total", watermark.TotalProcessed),
 		)
@@ -50,54 +70,236 @@ func PollTrustMedFiles(ctx context.Context, dashboard *TrustMedDashboardClient,
 	endDate := time.Now()
 
 	// Search for all received files (is_sender=false means WE received it)
-	records, err := dashboard.SearchAllFiles(ctx, startDate, endDate, true)
-	if err != nil {
+	records, searchErr := dashboard.SearchAllFiles(ctx, startDate, endDate, true)
+	if searchErr != nil && !errors.Is(searchErr, ErrSearchTruncated) {
 		// Don't update watermark on API error - files might be missed
-		logger.Error("Failed to search TrustMed files", zap.Error(err))
-		return nil, fmt.Errorf("searching TrustMed files: %w", err)
+		runlog.Error(ctx, "Failed to search TrustMed files", zap.Error(searchErr))
+		return nil, fmt.Errorf("searching TrustMed files: %w", searchErr)
 	}
 
-	if len(records) == 0 {
-		logger.Info("No new files found in TrustMed")
-		// Update watermark even if no files (to advance the timestamp)
-		if err := UpdateWatermark(ctx, cms, watermarkKey, endDate, 0); err != nil {
-			logger.Warn("Failed to update watermark", zap.Error(err))
+	queue, err := LoadRetryQueue(ctx, cms)
+	if err != nil {
+		// Failures could not be queued, so nothing is safe to skip past
+		return nil, err
+	}
+
+	// Retry queued files not found again by this search
+	found := make(map[string]bool, len(records))
+	for _, record := range records {
+		found[record.LogGuid] = true
+	}
+	retries := 0
+	for _, f := range queue.Due(cfg.InboundRetryMaxAttempts) {
+		if !found[f.LogGuid] {
+			records = append(records, FileRecord{LogGuid: f.LogGuid, DateCreated: f.DateCreated})
+			retries++
 		}
-		return []types.XMLFile{}, nil
+	}
+	if retries > 0 {
+		runlog.Info(ctx, "Retrying queued TrustMed files", zap.Int("count", retries))
 	}
 
-	logger.Info("Found received files in TrustMed", zap.Int("count", len(records)))
+	xmlFiles, failed, err := downloadTrustMedFiles(ctx, dashboard, cms, cfg, records, queue)
+	queueErr := queue.Save(ctx, cms)
+	if queueErr != nil {
+		runlog.Warn(ctx, "Failed to save TrustMed retry queue", zap.Error(queueErr))
+	}
+	if err != nil {
+		// Don't update watermark - the whole window is searched again
+		return nil, err
+	}
+
+	// Advance past every file archived or queued for retry. Files that
+	// failed without being queued are searched for again next time.
+	next := endDate
+	if searchErr != nil {
+		next = searchedThrough(records[:len(records)-retries], from)
+	}
+	if queueErr != nil {
+		for _, record := range failed {
+			if record.DateCreated.Before(next) {
+				next = record.DateCreated
+			}
+		}
+	}
+
+	// Update watermark with the new timestamp and count (even if no files,
+	// to advance the timestamp)
+	if err := UpdateWatermark(ctx, cms, watermarkKey, next, len(xmlFiles)); err != nil {
+		runlog.Warn(ctx, "Failed to update TrustMed watermark", zap.Error(err))
+	}
+
+	if searchErr != nil {
+		return xmlFiles, searchErr
+	}
+	return xmlFiles, nil
+}
+
+// searchedThrough returns how far a truncated search is known to be
+// complete: the newest record's creation time if the pages came oldest
+// first, otherwise from, as the missing pages could hold older files.
+func searchedThrough(records []FileRecord, from time.Time) time.Time {
+	byCreation := func(a, b FileRecord) int { return a.DateCreated.Compare(b.DateCreated) }
+	if len(records) == 0 || !slices.IsSortedFunc(records, byCreation) {
+		return from
+	}
+	return records[len(records)-1].DateCreated
+}
+
+// PollTrustMedFilesWindow downloads received files in an explicit [since,
+// until) window. It ignores the watermark and does not advance it, so ops can
+// re-pull a past window without affecting scheduled polling. Failures are
+// still queued for retry. Like PollTrustMedFiles, it returns the files found
+// with an error wrapping ErrSearchTruncated if the search stops at its page
+// limit.
+func PollTrustMedFilesWindow(ctx context.Context, dashboard *TrustMedDashboardClient, cms *DirectusClient, cfg *configs.Config, since, until time.Time) ([]types.XMLFile, error) {
+	if !since.Before(until) {
+		return nil, fmt.Errorf("since %s must be before until %s", since.Format(time.RFC3339), until.Format(time.RFC3339))
+	}
+	runlog.Info(ctx, "Polling TrustMed Dashboard for received files in window (watermark unchanged)",
+		zap.Time("since", since),
+		zap.Time("until", until),
+	)
+
+	records, searchErr := dashboard.SearchAllFiles(ctx, since, until, true)
+	if searchErr != nil && !errors.Is(searchErr, ErrSearchTruncated) {
+		runlog.Error(ctx, "Failed to search TrustMed files", zap.Error(searchErr))
+		return nil, fmt.Errorf("searching TrustMed files: %w", searchErr)
+	}
+	if len(records) == 0 {
+		runlog.Info(ctx, "No new files found in TrustMed")
+		return []types.XMLFile{}, searchErr
+	}
+
+	queue, err := LoadRetryQueue(ctx, cms)
+	if err != nil {
+		return nil, err
+	}
+	xmlFiles, _, err := downloadTrustMedFiles(ctx, dashboard, cms, cfg, records, queue)
+	if err := queue.Save(ctx, cms); err != nil {
+		runlog.Warn(ctx, "Failed to save TrustMed retry queue", zap.Error(err))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return xmlFiles, searchErr
+}
+
+// downloadTrustMedFiles downloads the received files and archives them to
+// Directus. Files already in epcis_inbox are skipped: by log GUID before
+// downloading, and by content hash before uploading. Files that fail are
+// queued for retry and returned as failed; archived files leave the queue.
+func downloadTrustMedFiles(ctx context.Context, dashboard *TrustMedDashboardClient, cms *DirectusClient, cfg *configs.Config, records []FileRecord, queue *RetryQueue) ([]types.XMLFile, []FileRecord, error) {
+	if len(records) == 0 {
+		runlog.Info(ctx, "No new files found in TrustMed")
+		return []types.XMLFile{}, nil, nil
+	}
+
+	runlog.Info(ctx, "Found received files in TrustMed", zap.Int("count", len(records)))
+
+	// Upload to Directus INPUT_XML folder (required)
+	if cfg.FolderInputXML == "" {
+		return nil, nil, missingConfigError("DIRECTUS_FOLDER_INPUT_XML")
+	}
+
+	// Documents already processed, by log GUID now and by content hash once
+	// downloaded; the inbox insert deduplicates again if this fails
+	guids := make([]string, len(records))
+	for i, record := range records {
+		guids[i] = record.LogGuid
+	}
+	ledger, err := LoadInboundLedger(ctx, cms, LedgerKeys{LogGuids: guids})
+	if err != nil {
+		runlog.Warn(ctx, "Failed to load inbound ledger, downloading all files", zap.Error(err))
+		ledger = NewInboundLedger()
+	}
 
-	// Download each file
 	var xmlFiles []types.XMLFile
+	var failed []FileRecord
 	var lastLogUUID string
-	failedCount := 0
+	skippedCount := 0
+
+	fail := func(ctx context.Context, record FileRecord, msg string, err error) {
+		attempts := queue.Failed(record, err)
+		runlog.Error(ctx, msg,
+			zap.String("log_uuid", record.LogGuid),
+			zap.Int("attempts", attempts),
+			zap.Error(err),
+		)
+		if attempts >= cfg.InboundRetryMaxAttempts {
+			runlog.Error(ctx, "TrustMed file reached the retry limit and will not be retried automatically",
+				zap.String("log_uuid", record.LogGuid),
+				zap.Int("attempts", attempts),
+			)
+		}
+		failed = append(failed, record)
+	}
 
+	// Download each file
+	type download struct {
+		record  FileRecord
+		content []byte
+		hash    string
+	}
+	var downloads []download
 	for i, record := range records {
-		logger.Info("Downloading file from TrustMed",
+		if err := ctx.Err(); err != nil {
+			return nil, nil, fmt.Errorf("downloading TrustMed files: %w", err)
+		}
+		ctx := runlog.With(ctx, zap.String("log_guid", record.LogGuid))
+
+		runlog.Info(ctx, "Downloading file from TrustMed",
 			zap.Int("index", i+1),
 			zap.Int("total", len(records)),
 			zap.String("log_uuid", record.LogGuid),
 		)
+		progress.Report(ctx, i+1, len(records), "downloading")
+
+		if ledger.HasLogGuid(record.LogGuid) {
+			runlog.Info(ctx, "Skipping TrustMed file already processed", zap.String("log_uuid", record.LogGuid))
+			queue.Remove(record.LogGuid)
+			skippedCount++
+			continue
+		}
 
 		content, err := dashboard.DownloadFile(ctx, record.LogGuid)
 		if err != nil {
-			logger.Error("Failed to download file from TrustMed",
+			fail(ctx, record, "Failed to download file from TrustMed", err)
+			continue
+		}
+		downloads = append(downloads, download{record: record, content: content, hash: ContentHash(content)})
+	}
+
+	hashes := make([]string, len(downloads))
+	for i, d := range downloads {
+		hashes[i] = d.hash
+	}
+	if err := ledger.Load(ctx, cms, LedgerKeys{Hashes: hashes}); err != nil {
+		runlog.Warn(ctx, "Failed to load inbound ledger, archiving all downloaded files", zap.Error(err))
+	}
+
+	// Archive each new document
+	for i, d := range downloads {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, fmt.Errorf("archiving TrustMed files: %w", err)
+		}
+		record, content, hash := d.record, d.content, d.hash
+		ctx := runlog.With(ctx, zap.String("log_guid", record.LogGuid))
+		progress.Report(ctx, i+1, len(downloads), "archiving")
+
+		if ledger.HasHash(hash) {
+			runlog.Info(ctx, "Skipping TrustMed file with already processed content",
 				zap.String("log_uuid", record.LogGuid),
-				zap.Error(err),
+				zap.String("content_hash", hash),
 			)
-			failedCount++
+			queue.Remove(record.LogGuid)
+			skippedCount++
 			continue
 		}
 
 		// Generate filename
 		filename := fmt.Sprintf("trustmed_%s.xml", record.LogGuid)
 
-		// Upload to Directus INPUT_XML folder (required)
-		if cfg.FolderInputXML == "" {
-			return nil, fmt.Errorf("DIRECTUS_FOLDER_INPUT_XML is required for inbound pipeline")
-		}
-
 		uploadParams := UploadFileParams{
 			Filename:    filename,
 			Content:     content,
@@ -108,22 +310,23 @@ func PollTrustMedFiles(ctx context.Context, dashboard *TrustMedDashboardClient,
 
 		result, err := cms.UploadFile(ctx, uploadParams)
 		if err != nil {
-			logger.Error("Failed to upload file to Directus",
-				zap.String("log_uuid", record.LogGuid),
-				zap.Error(err),
-			)
-			failedCount++
+			fail(ctx, record, "Failed to upload file to Directus", err)
 			continue
 		}
 
 		xmlFiles = append(xmlFiles, types.XMLFile{
-			ID:       result.ID,
-			Filename: filename,
-			Content:  content,
-			Uploaded: time.Now(),
+			ID:          result.ID,
+			Filename:    filename,
+			Content:     content,
+			Uploaded:    time.Now(),
+			LogGuid:     record.LogGuid,
+			ContentHash: hash,
 		})
+		// Same document again later in this poll
+		ledger.Add(record.LogGuid, hash)
+		queue.Remove(record.LogGuid)
 
-		logger.Info("Archived TrustMed file to Directus",
+		runlog.Info(ctx, "Archived TrustMed file to Directus",
 			zap.String("log_uuid", record.LogGuid),
 			zap.String("directus_file_id", result.ID),
 		)
@@ -132,29 +335,21 @@ func PollTrustMedFiles(ctx context.Context, dashboard *TrustMedDashboardClient,
 	}
 
 	// Check failure threshold
-	if len(records) > 0 {
-		failureRate := float64(failedCount) / float64(len(records))
-		if failureRate > cfg.FailureThreshold {
-			return nil, fmt.Errorf("failure rate %.0f%% exceeds threshold %.0f%%",
-				failureRate*100, cfg.FailureThreshold*100)
-		}
+	failureRate := float64(len(failed)) / float64(len(records))
+	if failureRate > cfg.FailureThreshold {
+		return nil, nil, fmt.Errorf("failure rate %.0f%% exceeds threshold %.0f%%",
+			failureRate*100, cfg.FailureThreshold*100)
 	}
 
-	// Update watermark with current time and count
-	if err := UpdateWatermark(ctx, cms, watermarkKey, endDate, len(xmlFiles)); err != nil {
-		logger.Warn("Failed to update TrustMed watermark", zap.Error(err))
-	}
-
-	logger.Info("Successfully polled TrustMed files",
+	runlog.Info(ctx, "Successfully polled TrustMed files",
 		zap.Int("downloaded", len(xmlFiles)),
-		zap.Int("failed", failedCount),
+		zap.Int("failed", len(failed)),
+		zap.Int("skipped", skippedCount),
+		zap.Int("queued_for_retry", len(queue.Files)),
 		zap.String("last_log_uuid", lastLogUUID),
 	)
 
-	// Suppress unused variable warning
-	_ = lastLogUUID
-
-	return xmlFiles, nil
+	return xmlFiles, failed, nil
 }
 
 // FileRecord extension with additional fields for inbound
@@ -169,3 +364,13 @@ func IsXMLFile(content []byte) bool {
 	contentStr := strings.TrimSpace(string(content))
 	return strings.HasPrefix(contentStr, "<?xml") || strings.HasPrefix(contentStr, "<")
 }
+
+// missingConfigError reports a setting the inbound pipeline cannot run
+// without. Retrying cannot fix it, so it is permanent (pipelines.IsPermanent).
+type missingConfigError string
+
+func (e missingConfigError) Error() string {
+	return string(e) + " is required for inbound pipeline"
+}
+
+func (e missingConfigError) Permanent() bool { return true }
@@ -3,8 +3,10 @@ package tasks
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -93,6 +95,34 @@ func TestSearchAllFiles(t *testing.T) {
 	assert.False(t, records[0].IsSender)
 }
 
+func TestSearchAllFilesTruncated(t *testing.T) {
+	pages := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-token", TokenType: "Bearer", ExpiresIn: 600})
+			return
+		}
+		// Every page claims there is another
+		pages++
+		json.NewEncoder(w).Encode(FileSearchResponse{
+			Next:    strPtr("next"),
+			Results: []FileRecord{{LogGuid: fmt.Sprintf("uuid-%d", pages)}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewTrustMedDashboardClient(&configs.Config{
+		TrustMedDashboardURL: server.URL,
+		TrustMedCompanyID:    "37018",
+	})
+
+	records, err := client.SearchAllFiles(context.Background(), time.Now().Add(-time.Hour), time.Now(), true)
+	assert.ErrorIs(t, err, ErrSearchTruncated)
+	assert.Equal(t, searchPageLimit, pages)
+	assert.Len(t, records, searchPageLimit, "the records found are returned")
+}
+
 func TestGetDownloadURL(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/token" {
@@ -235,3 +265,263 @@ func TestIsXMLFile(t *testing.T) {
 func strPtr(s string) *string {
 	return &s
 }
+
+func TestPollTrustMedFilesWindowLeavesWatermark(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-token", TokenType: "Bearer", ExpiresIn: 600})
+		case "/de-status/company/37018/log/":
+			json.NewEncoder(w).Encode(FileSearchResponse{Results: []FileRecord{}})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &configs.Config{
+		TrustMedDashboardURL: server.URL,
+		TrustMedUsername:     "test-user",
+		TrustMedPassword:     "test-pass",
+		TrustMedClientID:     "37018",
+		TrustMedCompanyID:    "37018",
+	}
+	dashboard := NewTrustMedDashboardClient(cfg)
+	cms := NewDirectusClient(server.URL, "test-key")
+
+	until := time.Now()
+	files, err := PollTrustMedFilesWindow(context.Background(), dashboard, cms, cfg, until.Add(-24*time.Hour), until)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	_, err = PollTrustMedFilesWindow(context.Background(), dashboard, cms, cfg, until, until.Add(-time.Hour))
+	assert.Error(t, err)
+}
+
+func TestPollTrustMedFilesWindowSkipsProcessedFiles(t *testing.T) {
+	processed := []byte("<epcis>processed</epcis>")
+	fresh := []byte("<epcis>fresh</epcis>")
+	contents := map[string][]byte{
+		"guid-reuploaded": processed, // new GUID, content already in the inbox
+		"guid-new":        fresh,
+		"guid-copy":       fresh, // same content later in the same poll
+	}
+
+	var downloaded, uploaded []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/token":
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-token", TokenType: "Bearer", ExpiresIn: 600})
+		case r.URL.Path == "/items/global_config" && r.Method == "GET":
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal([]GlobalConfigValue{})})
+		case r.URL.Path == "/de-status/company/37018/log/":
+			json.NewEncoder(w).Encode(FileSearchResponse{Results: []FileRecord{
+				{LogGuid: "guid-seen"}, {LogGuid: "guid-reuploaded"}, {LogGuid: "guid-new"}, {LogGuid: "guid-copy"},
+			}})
+		case r.URL.Path == "/items/epcis_inbox":
+			assert.Equal(t, "-1", r.URL.Query().Get("limit"))
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal([]map[string]interface{}{
+				{"log_guid": "guid-seen", "content_hash": "other"},
+				{"log_guid": "guid-old", "content_hash": ContentHash(processed)},
+			})})
+		case strings.HasPrefix(r.URL.Path, "/de-status/log/"):
+			guid := strings.Split(r.URL.Path, "/")[3]
+			w.Write([]byte(`"http://` + r.Host + `/download/` + guid + `"`))
+		case strings.HasPrefix(r.URL.Path, "/download/"):
+			guid := strings.TrimPrefix(r.URL.Path, "/download/")
+			downloaded = append(downloaded, guid)
+			w.Write(contents[guid])
+		case r.URL.Path == "/files":
+			uploaded = append(uploaded, r.URL.Path)
+			json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal(UploadFileResult{ID: "file-1"})})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &configs.Config{
+		TrustMedDashboardURL: server.URL,
+		TrustMedUsername:     "test-user",
+		TrustMedPassword:     "test-pass",
+		TrustMedClientID:     "37018",
+		TrustMedCompanyID:    "37018",
+		FolderInputXML:       "folder-1",
+		FailureThreshold:     0.5,
+	}
+	dashboard := NewTrustMedDashboardClient(cfg)
+	cms := NewDirectusClient(server.URL, "test-key")
+
+	until := time.Now()
+	files, err := PollTrustMedFilesWindow(context.Background(), dashboard, cms, cfg, until.Add(-24*time.Hour), until)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"guid-reuploaded", "guid-new", "guid-copy"}, downloaded, "known GUIDs are not downloaded")
+	assert.Len(t, uploaded, 1, "known content is not uploaded")
+	require.Len(t, files, 1)
+	assert.Equal(t, "guid-new", files[0].LogGuid)
+	assert.Equal(t, ContentHash(fresh), files[0].ContentHash)
+}
+
+// fakeGlobalConfig serves the global_config collection from memory, storing
+// values as Directus returns them. Writes to the keys in failSaves fail.
+type fakeGlobalConfig struct {
+	values    map[string]json.RawMessage
+	failSaves map[string]bool
+}
+
+func (f *fakeGlobalConfig) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		key := r.URL.Query().Get("filter[key][_eq]")
+		configs := []GlobalConfigValue{}
+		if value, ok := f.values[key]; ok {
+			configs = append(configs, GlobalConfigValue{Key: key, Value: value})
+		}
+		json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal(configs)})
+		return
+	}
+
+	var body GlobalConfigValue
+	json.NewDecoder(r.Body).Decode(&body)
+	if r.Method == "PATCH" {
+		body.Key = strings.TrimPrefix(r.URL.Path, "/items/global_config/")
+	}
+	if f.failSaves[body.Key] {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	f.values[body.Key] = body.Value
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeGlobalConfig) watermark(t *testing.T) time.Time {
+	return f.watermarkAt(t, "trustmed_inbound_watermark")
+}
+
+func (f *fakeGlobalConfig) watermarkAt(t *testing.T, key string) time.Time {
+	var wm Watermark
+	require.NoError(t, decodeConfigValue(f.values[key], &wm))
+	return wm.LastCheckTimestamp.Time
+}
+
+func (f *fakeGlobalConfig) queue(t *testing.T) []RetryFile {
+	return f.queueAt(t, inboundRetryQueueKey)
+}
+
+func (f *fakeGlobalConfig) queueAt(t *testing.T, key string) []RetryFile {
+	queue := &RetryQueue{}
+	if value, ok := f.values[key]; ok {
+		require.NoError(t, decodeConfigValue(value, queue))
+	}
+	return queue.Files
+}
+
+func TestPollTrustMedFilesRetryQueue(t *testing.T) {
+	lastCheck := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	failedCreated := lastCheck.Add(10 * time.Minute)
+
+	newServer := func(t *testing.T, store *fakeGlobalConfig) (*httptest.Server, *[]string) {
+		var downloaded []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case r.URL.Path == "/token":
+				json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-token", TokenType: "Bearer", ExpiresIn: 600})
+			case strings.HasPrefix(r.URL.Path, "/items/global_config"):
+				store.serve(w, r)
+			case r.URL.Path == "/de-status/company/37018/log/":
+				// The search starts the overlap before the watermark
+				assert.Equal(t, lastCheck.Add(-5*time.Minute).Format("2006-01-02T15:04:05Z"), r.URL.Query().Get("start"))
+				json.NewEncoder(w).Encode(FileSearchResponse{Results: []FileRecord{
+					{LogGuid: "guid-ok", DateCreated: lastCheck.Add(5 * time.Minute)},
+					{LogGuid: "guid-broken", DateCreated: failedCreated},
+				}})
+			case r.URL.Path == "/items/epcis_inbox":
+				json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal([]map[string]interface{}{})})
+			case strings.HasPrefix(r.URL.Path, "/de-status/log/"):
+				guid := strings.Split(r.URL.Path, "/")[3]
+				if guid == "guid-broken" {
+					http.Error(w, "boom", http.StatusInternalServerError)
+					return
+				}
+				w.Write([]byte(`"http://` + r.Host + `/download/` + guid + `"`))
+			case strings.HasPrefix(r.URL.Path, "/download/"):
+				guid := strings.TrimPrefix(r.URL.Path, "/download/")
+				downloaded = append(downloaded, guid)
+				w.Write([]byte("<epcis>" + guid + "</epcis>"))
+			case r.URL.Path == "/files":
+				json.NewEncoder(w).Encode(DirectusResponse{Data: mustMarshal(UploadFileResult{ID: "file-1"})})
+			default:
+				t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				http.NotFound(w, r)
+			}
+		}))
+		return server, &downloaded
+	}
+
+	newStore := func(t *testing.T) *fakeGlobalConfig {
+		watermark, err := json.Marshal(Watermark{LastCheckTimestamp: WatermarkTime{Time: lastCheck}})
+		require.NoError(t, err)
+		queue, err := json.Marshal(RetryQueue{Files: []RetryFile{
+			{LogGuid: "guid-queued", DateCreated: lastCheck.Add(-time.Hour), Attempts: 1},
+			{LogGuid: "guid-exhausted", DateCreated: lastCheck.Add(-time.Hour), Attempts: 3},
+		}})
+		require.NoError(t, err)
+		return &fakeGlobalConfig{values: map[string]json.RawMessage{
+			"trustmed_inbound_watermark": watermark,
+			inboundRetryQueueKey:         queue,
+		}}
+	}
+
+	poll := func(t *testing.T, server *httptest.Server) []string {
+		cfg := &configs.Config{
+			TrustMedDashboardURL:    server.URL,
+			TrustMedCompanyID:       "37018",
+			FolderInputXML:          "folder-1",
+			FailureThreshold:        0.5,
+			InboundWatermarkOverlap: 300,
+			InboundRetryMaxAttempts: 3,
+		}
+		files, err := PollTrustMedFiles(context.Background(), NewTrustMedDashboardClient(cfg), NewDirectusClient(server.URL, "test-key"), cfg)
+		require.NoError(t, err)
+		var guids []string
+		for _, f := range files {
+			guids = append(guids, f.LogGuid)
+		}
+		return guids
+	}
+
+	t.Run("failed files are queued", func(t *testing.T) {
+		store := newStore(t)
+		server, downloaded := newServer(t, store)
+		defer server.Close()
+
+		start := time.Now()
+		assert.Equal(t, []string{"guid-ok", "guid-queued"}, poll(t, server))
+		assert.NotContains(t, *downloaded, "guid-exhausted", "files at the attempt limit are not retried")
+
+		queue := store.queue(t)
+		require.Len(t, queue, 2)
+		assert.Equal(t, "guid-exhausted", queue[0].LogGuid)
+		assert.Equal(t, "guid-broken", queue[1].LogGuid)
+		assert.Equal(t, 1, queue[1].Attempts)
+		assert.True(t, queue[1].DateCreated.Equal(failedCreated))
+
+		// The failure is queued, so the watermark can pass it
+		assert.False(t, store.watermark(t).Before(start.Truncate(time.Second)))
+	})
+
+	t.Run("unqueued failures hold the watermark", func(t *testing.T) {
+		store := newStore(t)
+		server, _ := newServer(t, store)
+		defer server.Close()
+
+		store.failSaves = map[string]bool{inboundRetryQueueKey: true}
+		assert.Equal(t, []string{"guid-ok", "guid-queued"}, poll(t, server))
+		assert.True(t, store.watermark(t).Equal(failedCreated), "watermark stops at the failed file")
+	})
+}
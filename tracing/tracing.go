@@ -0,0 +1,109 @@
+// Package tracing sets up OpenTelemetry tracing and provides the span
+// helpers used by pipelines and tasks. Until Setup installs an exporter,
+// spans are no-ops.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters accepted by Setup (TRACING_EXPORTER).
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"   // OTLP over HTTP; OTEL_EXPORTER_OTLP_* configure it
+	ExporterStdout = "stdout" // pretty-printed spans, for local debugging
+)
+
+// Span attributes shared by pipelines and tasks.
+const (
+	Pipeline            = attribute.Key("hudsci.pipeline")
+	Step                = attribute.Key("hudsci.step")
+	RunID               = attribute.Key("hudsci.run_id")
+	Collection          = attribute.Key("hudsci.directus.collection")
+	CaptureID           = attribute.Key("hudsci.capture_id")
+	ShippingOperationID = attribute.Key("hudsci.shipping_operation_id")
+	TrustMedUUID        = attribute.Key("hudsci.trustmed.uuid")
+)
+
+const tracerName = "github.com/trackvision/tv-pipelines-hudsci"
+
+// Setup installs the global tracer provider for exporter and returns a
+// function that flushes and stops it. ExporterNone, or "", leaves tracing
+// disabled.
+func Setup(ctx context.Context, exporter, serviceName string) (func(context.Context) error, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exp, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown exporter %q (want %s, %s or %s)", exporter, ExporterNone, ExporterOTLP, ExporterStdout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s exporter: %w", exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("creating resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartClient starts a span for a call to an external service, with the
+// attributes added to ctx by WithAttributes.
+func StartClient(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	inherited, _ := ctx.Value(attributesKey{}).([]attribute.KeyValue)
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithAttributes(inherited...),
+		trace.WithAttributes(attrs...),
+		trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// attributesKey is the context key for attributes added with WithAttributes.
+type attributesKey struct{}
+
+// WithAttributes returns a context whose client spans carry attrs, so a
+// loop over shipments can tag every call it makes with the shipment's IDs.
+func WithAttributes(ctx context.Context, attrs ...attribute.KeyValue) context.Context {
+	inherited, _ := ctx.Value(attributesKey{}).([]attribute.KeyValue)
+	return context.WithValue(ctx, attributesKey{}, append(append([]attribute.KeyValue{}, inherited...), attrs...))
+}
+
+// End records err, if any, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recorder is a SpanExporter that keeps the spans it is given.
+type recorder struct {
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (r *recorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *recorder) Shutdown(context.Context) error { return nil }
+
+func record(t *testing.T) *recorder {
+	t.Helper()
+	rec := &recorder{}
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]string {
+	m := make(map[attribute.Key]string)
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value.Emit()
+	}
+	return m
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), ExporterNone, "test")
+	if err != nil {
+		t.Fatalf("Setup(none) error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+	if _, err := Setup(context.Background(), "jaeger", "test"); err == nil {
+		t.Error("Setup(jaeger) expected error")
+	}
+}
+
+func TestStartClient(t *testing.T) {
+	rec := record(t)
+
+	ctx, parent := Start(context.Background(), "step dispatch")
+	ctx = WithAttributes(ctx, CaptureID.String("CAPTURE-1"))
+	ctx = WithAttributes(ctx, ShippingOperationID.String("op-1"))
+
+	_, span := StartClient(ctx, "trustmed.SubmitEPCIS", TrustMedUUID.String("uuid-1"))
+	End(span, errors.New("HTTP 500"))
+	End(parent, nil)
+
+	if len(rec.spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(rec.spans))
+	}
+	client := rec.spans[0]
+	if client.Parent().SpanID() != rec.spans[1].SpanContext().SpanID() {
+		t.Error("client span is not a child of the step span")
+	}
+	if client.SpanKind() != trace.SpanKindClient {
+		t.Errorf("kind = %s, want client", client.SpanKind())
+	}
+	got := attrs(client)
+	for key, want := range map[attribute.Key]string{
+		CaptureID:           "CAPTURE-1",
+		ShippingOperationID: "op-1",
+		TrustMedUUID:        "uuid-1",
+	} {
+		if got[key] != want {
+			t.Errorf("%s = %q, want %q", key, got[key], want)
+		}
+	}
+	if client.Status().Code != codes.Error || client.Status().Description != "HTTP 500" {
+		t.Errorf("status = %+v, want error", client.Status())
+	}
+	if _, ok := attrs(rec.spans[1])[CaptureID]; ok {
+		t.Error("WithAttributes should only apply to client spans started under it")
+	}
+}
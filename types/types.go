@@ -12,10 +12,12 @@ type PipelineResult struct {
 
 // XMLFile represents an XML file from Directus
 type XMLFile struct {
-	ID       string    `json:"id"`
-	Filename string    `json:"filename"`
-	Content  []byte    `json:"content"`
-	Uploaded time.Time `json:"uploaded"`
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	Content     []byte    `json:"content"`
+	Uploaded    time.Time `json:"uploaded"`
+	LogGuid     string    `json:"log_guid,omitempty"`     // TrustMed document, for files polled from TrustMed
+	ContentHash string    `json:"content_hash,omitempty"` // hex SHA-256 of Content
 }
 
 // ConvertedFile represents a converted JSON file